@@ -5,7 +5,12 @@ const (
 	ConsistentHashBalancer = "consistent-hash"
 	P2CBalancer            = "p2c"
 	RandomBalancer         = "random"
-	R2Balancer             = "round-robin"
+	RoundRobinBalancer     = "round-robin"
 	LeastLoadBalancer      = "least-load"
 	BoundedBalancer        = "bounded"
+	LeastConnBalancer      = "least-conn"
+	MaglevBalancer         = "maglev"
+
+	WeightedRoundRobinBalancer = "weighted-round-robin"
+	EWMABalancer               = "ewma"
 )
@@ -1,13 +1,25 @@
 package balancer
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 var (
-	NoHostError                = errors.New("no host")
+	// ErrNoHost 负载均衡器中没有任何主机
+	ErrNoHost = errors.New("no host")
+	// ErrAllHostsDown 负载均衡器中有主机，但所有主机都不可用(例如被健康检查摘除、处于维护状态或权重为0)
+	ErrAllHostsDown = errors.New("all hosts down")
+	// Deprecated: NoHostError 已废弃，请使用 ErrNoHost
+	NoHostError = ErrNoHost
+
 	ErrHostNotFound            = errors.New("host not found")
 	InvalidTargetHost          = errors.New("invalid target host")
 	ErrHostAlreadyExists       = errors.New("host already exists")
 	AlgorithmNotSupportedError = errors.New("algorithm not supported")
+	ErrInvalidWeight           = errors.New("weight must not be negative")
+	ErrWeightNotSupported      = errors.New("weight not supported by algorithm")
 )
 
 // Balancer interface is the load balancer for the reverse proxy
@@ -17,6 +29,65 @@ type Balancer interface {
 	Balance(string) (string, error)
 	Inc(string)
 	Done(string)
+	// Observe 上报请求主机的响应延迟，不使用延迟的算法实现为空方法
+	Observe(string, time.Duration)
+	// SetWeight 运行时调整主机权重，权重不能为负数，不支持权重的算法返回ErrWeightNotSupported
+	SetWeight(string, int) error
+	// Stats 返回各主机状态的快照，返回值为副本，调用方可以安全地遍历
+	Stats() []HostStat
+	// Drain 将主机置为维护状态，Balance不再选择该主机，主机的负载及已有连接不受影响
+	Drain(string)
+	// Undrain 取消主机的维护状态，主机重新参与负载均衡，不重置负载等统计
+	Undrain(string)
+}
+
+// ContextBalancer 支持通过context取消选择或携带请求信息的负载均衡器，例如需要阻塞探测主机的算法
+// 未实现该接口的负载均衡器通过BalanceCtx调用Balance
+type ContextBalancer interface {
+	BalanceCtx(ctx context.Context, key string) (string, error)
+}
+
+// BalanceCtx 为key选择主机，负载均衡器实现了ContextBalancer时调用其BalanceCtx，
+// 否则在ctx已取消或超时时返回ctx.Err()，未结束时调用Balance
+func BalanceCtx(ctx context.Context, b Balancer, key string) (string, error) {
+	if cb, ok := b.(ContextBalancer); ok {
+		return cb.BalanceCtx(ctx, key)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return b.Balance(key)
+}
+
+// HostStat 主机状态快照
+type HostStat struct {
+	// Name 主机名 ip:port
+	Name string
+	// Load 主机当前负载(正在处理的请求数)，不统计负载的算法始终为0
+	Load int64
+	// Alive 主机是否参与负载均衡，例如权重为0、处于维护状态或熔断器打开的主机不参与
+	Alive bool
+	// Drained 主机是否处于维护状态
+	Drained bool `json:",omitempty"`
+	// Breaker 主机熔断器的状态(closed/open/half-open)，未开启熔断时为空
+	Breaker string `json:",omitempty"`
+	// Group 按权重分配流量时主机所属的主机组，未分组时为空
+	Group string `json:",omitempty"`
+}
+
+// LoadOf 从Stats中获取主机当前的负载，主机不存在或算法不统计负载时为0
+func LoadOf(b Balancer, host string) int64 {
+	for _, stat := range b.Stats() {
+		if stat.Name == host {
+			return stat.Load
+		}
+	}
+	return 0
+}
+
+// ReplicaSetter 支持设置每个主机副本(虚拟节点)数量的负载均衡器
+type ReplicaSetter interface {
+	SetReplicas(int)
 }
 
 //HostLoad 主机负载  供其他需要使用的算法使用
@@ -27,11 +98,22 @@ type HostLoad struct {
 
 var factories = make(map[string]Factory)
 
+//weightedFactories 支持主机权重的负载均衡器工厂
+var weightedFactories = make(map[string]WeightedFactory)
+
 // Factory 是生成Balancer的工厂, 和工厂设计模式在这里使用
 type Factory func([]string) Balancer
 
+// WeightedFactory 是生成支持主机权重的Balancer的工厂，weights与hosts一一对应
+type WeightedFactory func([]string, []int) Balancer
+
 type Hash func(key string) uint64
 
+// UseClientIPKey 判断算法是否需要使用客户端IP作为Balance的key
+func UseClientIPKey(algorithm string) bool {
+	return algorithm == IPHashBalancer
+}
+
 // Build 根据算法生成相应的负载均衡器
 func Build(algorithm string, targetHosts []string) (Balancer, error) {
 	factory, ok := factories[algorithm]
@@ -39,4 +121,12 @@ func Build(algorithm string, targetHosts []string) (Balancer, error) {
 		return nil, AlgorithmNotSupportedError
 	}
 	return factory(targetHosts), nil
-}
\ No newline at end of file
+}
+
+// BuildWithWeights 根据算法及主机权重生成相应的负载均衡器，算法不支持权重时忽略权重
+func BuildWithWeights(algorithm string, targetHosts []string, weights []int) (Balancer, error) {
+	if factory, ok := weightedFactories[algorithm]; ok {
+		return factory(targetHosts, weights), nil
+	}
+	return Build(algorithm, targetHosts)
+}
@@ -0,0 +1,104 @@
+package balancer
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+)
+
+func init() {
+	factories[BoundedBalancer] = NewBoundedConsistentHash
+}
+
+//defaultBoundedEpsilon 默认的负载上限系数ε
+const defaultBoundedEpsilon = 0.25
+
+//ErrInvalidLoadFactor 负载上限系数不正确
+var ErrInvalidLoadFactor = errors.New("load factor must be greater than 0")
+
+// LoadFactorSetter 支持设置负载上限系数的负载均衡器
+type LoadFactorSetter interface {
+	SetLoadFactor(float64) error
+}
+
+//BoundedConsistentHash 有界负载的一致性哈希(consistent hashing with bounded loads)
+//按哈希环选择主机，主机的负载(正在处理的请求数)超过 ceil((1+ε)·(总负载+1)/主机数) 时顺着哈希环选择下一个主机，
+//既保持了相同key落在相同主机上的特性，又避免热点key压垮单个主机
+type BoundedConsistentHash struct {
+	*ConsistentHash
+	//epsilon 负载上限系数ε，越小负载越均衡，key的迁移越多
+	epsilon float64
+}
+
+// NewBoundedConsistentHash create new BoundedConsistentHash balancer
+func NewBoundedConsistentHash(hosts []string) Balancer {
+	b := &BoundedConsistentHash{
+		ConsistentHash: NewConsistent(defaultReplicaNum, defaultHashFunc),
+		epsilon:        defaultBoundedEpsilon,
+	}
+	for _, host := range hosts {
+		b.Add(host)
+	}
+	return b
+}
+
+//SetLoadFactor 设置负载上限系数ε，需要大于0
+func (b *BoundedConsistentHash) SetLoadFactor(epsilon float64) error {
+	if epsilon <= 0 || math.IsInf(epsilon, 0) || math.IsNaN(epsilon) {
+		return ErrInvalidLoadFactor
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.epsilon = epsilon
+	return nil
+}
+
+//Balance 从key在哈希环上的位置开始，选择第一个加上本次请求后不超过负载上限的主机
+func (b *BoundedConsistentHash) Balance(key string) (string, error) {
+	b.RLock()
+	defer b.RUnlock()
+	if len(b.hostMap) == 0 {
+		return "", ErrNoHost
+	}
+	capacity := b.capacity()
+	if capacity == 0 {
+		return "", ErrAllHostsDown
+	}
+	idx := b.searchKey(b.hashFunc(key))
+	//总负载小于 主机数×上限，至少有一个主机未达到上限，遍历一圈一定能找到
+	first := ""
+	for i := 0; i < len(b.sortedHostsHashSet); i++ {
+		host := b.replicaHostMap[b.sortedHostsHashSet[(idx+i)%len(b.sortedHostsHashSet)]]
+		if b.isDrained(host) {
+			continue
+		}
+		if first == "" {
+			first = host
+		}
+		if atomic.LoadInt64(&b.hostMap[host].LoadBound)+1 <= capacity {
+			return host, nil
+		}
+	}
+	return first, nil
+}
+
+//capacity 每个主机的负载上限 ceil((1+ε)·(总负载+1)/主机数)，主机数不包括维护中的主机，全部在维护中时返回0
+//调用方需要持有读锁
+func (b *BoundedConsistentHash) capacity() int64 {
+	total := atomic.LoadInt64(&b.totalLoad)
+	if total < 0 {
+		total = 0
+	}
+	n := len(b.hostMap)
+	if b.anyDrained() {
+		for name := range b.hostMap {
+			if b.isDrained(name) {
+				n--
+			}
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return int64(math.Ceil((1 + b.epsilon) * float64(total+1) / float64(n)))
+}
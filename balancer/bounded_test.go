@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestBoundedConsistentHash_SkewedKeys(t *testing.T) {
+	hosts := make([]string, 10)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("10.0.0.%d:80", i)
+	}
+	for _, epsilon := range []float64{0.1, 0.25, 1} {
+		b := NewBoundedConsistentHash(hosts).(*BoundedConsistentHash)
+		assert.NoError(t, b.SetLoadFactor(epsilon))
+
+		//90%的请求使用同一个热点key，请求不结束，负载持续累积
+		total := 0
+		for i := 0; i < 1000; i++ {
+			key := "hot"
+			if i%10 == 0 {
+				key = fmt.Sprintf("key-%d", i)
+			}
+			host, err := b.Balance(key)
+			assert.NoError(t, err)
+			b.Inc(host)
+			total++
+
+			bound := int64(math.Ceil((1 + epsilon) * float64(total) / float64(len(hosts))))
+			for name, load := range b.GetLoads() {
+				assert.LessOrEqual(t, load, bound, "epsilon=%v host=%s", epsilon, name)
+			}
+		}
+	}
+}
+
+func TestBoundedConsistentHash_KeepsAffinity(t *testing.T) {
+	b := NewBoundedConsistentHash([]string{"a", "b", "c"})
+	host, err := b.Balance("user-1")
+	assert.NoError(t, err)
+	//未超过负载上限时，相同的key总是选择相同的主机
+	for i := 0; i < 10; i++ {
+		h, _ := b.Balance("user-1")
+		assert.Equal(t, host, h)
+	}
+
+	//主机超过负载上限后选择哈希环上的下一个主机，负载降低后恢复
+	b.Inc(host)
+	b.Inc(host)
+	next, _ := b.Balance("user-1")
+	assert.NotEqual(t, host, next)
+	b.Done(host)
+	b.Done(host)
+	h, _ := b.Balance("user-1")
+	assert.Equal(t, host, h)
+}
+
+func TestBoundedConsistentHash_SetLoadFactor(t *testing.T) {
+	b := NewBoundedConsistentHash([]string{"a"}).(*BoundedConsistentHash)
+	assert.Equal(t, ErrInvalidLoadFactor, b.SetLoadFactor(0))
+	assert.Equal(t, ErrInvalidLoadFactor, b.SetLoadFactor(-1))
+	assert.NoError(t, b.SetLoadFactor(0.5))
+
+	b.Remove("a")
+	_, err := b.Balance("key")
+	assert.Equal(t, ErrNoHost, err)
+}
@@ -0,0 +1,123 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"proxy/breaker"
+	"sync"
+	"time"
+)
+
+// ResultReporter 需要根据请求结果调整主机选择的负载均衡器
+type ResultReporter interface {
+	// Report 上报主机的请求结果，failed 为 true 表示请求失败(5xx或连接失败)
+	Report(host string, failed bool)
+}
+
+// CircuitBreaker 为每个主机增加熔断器的负载均衡器，熔断器打开的主机不参与负载均衡
+type CircuitBreaker struct {
+	Balancer
+	mux              sync.RWMutex
+	breakers         map[string]*breaker.Breaker
+	failureThreshold uint
+	window           time.Duration
+	openTimeout      time.Duration
+}
+
+// NewCircuitBreaker 使用熔断器包装负载均衡器，window 时间内失败 failureThreshold 次后熔断，熔断 openTimeout 后允许探测请求
+func NewCircuitBreaker(inner Balancer, failureThreshold uint, window, openTimeout time.Duration) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		Balancer:         inner,
+		breakers:         make(map[string]*breaker.Breaker),
+		failureThreshold: failureThreshold,
+		window:           window,
+		openTimeout:      openTimeout,
+	}
+	for _, stat := range inner.Stats() {
+		cb.breakers[stat.Name] = breaker.New(failureThreshold, window, openTimeout)
+	}
+	return cb
+}
+
+// Unwrap 返回被包装的负载均衡器
+func (cb *CircuitBreaker) Unwrap() Balancer {
+	return cb.Balancer
+}
+
+// Add 添加主机，新主机的熔断器为关闭状态
+func (cb *CircuitBreaker) Add(host string) {
+	cb.mux.Lock()
+	if _, ok := cb.breakers[host]; !ok {
+		cb.breakers[host] = breaker.New(cb.failureThreshold, cb.window, cb.openTimeout)
+	}
+	cb.mux.Unlock()
+	cb.Balancer.Add(host)
+}
+
+// Remove 删除主机及其熔断器
+func (cb *CircuitBreaker) Remove(host string) {
+	cb.Balancer.Remove(host)
+	cb.mux.Lock()
+	delete(cb.breakers, host)
+	cb.mux.Unlock()
+}
+
+// Balance 选择熔断器允许通过的主机，所有主机的熔断器都打开时返回ErrAllHostsDown
+func (cb *CircuitBreaker) Balance(key string) (string, error) {
+	return cb.BalanceCtx(context.Background(), key)
+}
+
+// BalanceCtx 与Balance相同，将ctx传递给被包装的负载均衡器，ctx结束时停止选择
+func (cb *CircuitBreaker) BalanceCtx(ctx context.Context, key string) (string, error) {
+	cb.mux.RLock()
+	attempts := 2*len(cb.breakers) + 1
+	cb.mux.RUnlock()
+	for i := 0; i < attempts; i++ {
+		k := key
+		if i > 0 {
+			k = fmt.Sprintf("%s#breaker%d", key, i)
+		}
+		host, err := BalanceCtx(ctx, cb.Balancer, k)
+		if err != nil {
+			return "", err
+		}
+		if b := cb.breaker(host); b == nil || b.Allow() {
+			return host, nil
+		}
+	}
+	return "", ErrAllHostsDown
+}
+
+// Report 上报主机的请求结果
+func (cb *CircuitBreaker) Report(host string, failed bool) {
+	b := cb.breaker(host)
+	if b == nil {
+		return
+	}
+	if failed {
+		b.Failure()
+	} else {
+		b.Success()
+	}
+}
+
+// Stats 返回各主机状态的快照，包含熔断器状态，熔断器打开的主机不参与负载均衡
+func (cb *CircuitBreaker) Stats() []HostStat {
+	stats := cb.Balancer.Stats()
+	for i := range stats {
+		if b := cb.breaker(stats[i].Name); b != nil {
+			state := b.State()
+			stats[i].Breaker = state.String()
+			if state == breaker.Open {
+				stats[i].Alive = false
+			}
+		}
+	}
+	return stats
+}
+
+func (cb *CircuitBreaker) breaker(host string) *breaker.Breaker {
+	cb.mux.RLock()
+	defer cb.mux.RUnlock()
+	return cb.breakers[host]
+}
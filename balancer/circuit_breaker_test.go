@@ -0,0 +1,57 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_SkipsOpenHosts(t *testing.T) {
+	cb := NewCircuitBreaker(NewRoundRobin([]string{"a", "b"}), 2, time.Minute, time.Minute)
+
+	cb.Report("a", true)
+	cb.Report("a", true)
+	for i := 0; i < 10; i++ {
+		host, err := cb.Balance("")
+		assert.NoError(t, err)
+		assert.Equal(t, "b", host)
+	}
+
+	stats := cb.Stats()
+	assert.Equal(t, HostStat{Name: "a", Alive: false, Breaker: "open"}, stats[0])
+	assert.Equal(t, HostStat{Name: "b", Alive: true, Breaker: "closed"}, stats[1])
+
+	cb.Report("b", true)
+	cb.Report("b", true)
+	_, err := cb.Balance("")
+	assert.True(t, errors.Is(err, ErrAllHostsDown))
+}
+
+func TestCircuitBreaker_AddRemove(t *testing.T) {
+	cb := NewCircuitBreaker(NewRoundRobin([]string{"a"}), 1, time.Minute, time.Minute)
+
+	cb.Report("a", true)
+	cb.Remove("a")
+	cb.Add("a")
+	host, err := cb.Balance("")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", host)
+	assert.Equal(t, "closed", cb.Stats()[0].Breaker)
+}
+
+func TestCircuitBreaker_BalanceCtx(t *testing.T) {
+	cb := NewCircuitBreaker(NewRoundRobin([]string{"a"}), 1, time.Minute, time.Minute)
+
+	host, err := BalanceCtx(context.Background(), cb, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", host)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = BalanceCtx(ctx, cb, "")
+	assert.True(t, errors.Is(err, context.Canceled))
+	_, err = BalanceCtx(ctx, NewRoundRobin([]string{"a"}), "")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
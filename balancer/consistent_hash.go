@@ -8,14 +8,15 @@ import (
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 //hostReplicaFormat 主机副本名称的格式
 const hostReplicaFormat = `%s#%d`
 
 var (
-	//defaultReplicaNum 默认每个主机副本的数量
-	defaultReplicaNum = 10
+	//defaultReplicaNum 默认每个主机副本(虚拟节点)的数量
+	defaultReplicaNum = 100
 
 	//loadBoundFactor 负载因子
 	loadBoundFactor = 0.25
@@ -48,6 +49,7 @@ type ConsistentHash struct {
 	hostMap map[string]*ConsistentHashHost
 
 	sync.RWMutex
+	drainSet
 }
 
 type ConsistentHashHost struct {
@@ -93,6 +95,7 @@ func NewConsistent(replicaNum int, hash Hash) *ConsistentHash {
 	}
 }
 
+//Configuration 设置每个主机副本的数量及哈希函数，已添加的主机会按新的配置重建哈希环
 func (c *ConsistentHash) Configuration(replicaNum int,fn Hash) {
 	if replicaNum <= 0 {
 		replicaNum = defaultReplicaNum
@@ -100,8 +103,32 @@ func (c *ConsistentHash) Configuration(replicaNum int,fn Hash) {
 	if fn == nil {
 		fn = defaultHashFunc
 	}
+	c.Lock()
+	defer c.Unlock()
 	c.hashFunc = fn
 	c.replicaNum = replicaNum
+	c.rebuild()
+}
+
+//SetReplicas 设置每个主机副本(虚拟节点)的数量，已添加的主机会按新的数量重建哈希环
+func (c *ConsistentHash) SetReplicas(replicaNum int) {
+	c.Configuration(replicaNum, c.hashFunc)
+}
+
+//rebuild 按当前的副本数量及哈希函数重建哈希环，调用方需要持有写锁
+func (c *ConsistentHash) rebuild() {
+	c.replicaHostMap = make(map[uint64]string)
+	c.sortedHostsHashSet = make([]uint64, 0, len(c.hostMap)*c.replicaNum)
+	for hostName := range c.hostMap {
+		for i := 0; i < c.replicaNum; i++ {
+			hashedIdx := c.hashFunc(fmt.Sprintf(hostReplicaFormat, hostName, i))
+			c.replicaHostMap[hashedIdx] = hostName
+			c.sortedHostsHashSet = append(c.sortedHostsHashSet, hashedIdx)
+		}
+	}
+	sort.Slice(c.sortedHostsHashSet, func(i int, j int) bool {
+		return c.sortedHostsHashSet[i] < c.sortedHostsHashSet[j]
+	})
 }
 
 //Add 添加主机
@@ -151,29 +178,46 @@ func (c *ConsistentHash) Remove(hostName string) {
 	}
 }
 
-//Balance 通过key获取目标主机
+//Balance 通过key获取目标主机，主机处于维护状态时顺着哈希环选择下一个主机
 func (c *ConsistentHash) Balance(key string) (string, error) {
+	c.RLock()
+	defer c.RUnlock()
 	if len(c.hostMap) == 0 {
-		return "", NoHostError
+		return "", ErrNoHost
 	}
 	hashedKey := c.hashFunc(key)
 	idx := c.searchKey(hashedKey)
-	return c.replicaHostMap[c.sortedHostsHashSet[idx]], nil
+	for i := 0; i < len(c.sortedHostsHashSet); i++ {
+		host := c.replicaHostMap[c.sortedHostsHashSet[(idx+i)%len(c.sortedHostsHashSet)]]
+		if !c.isDrained(host) {
+			return host, nil
+		}
+	}
+	return "", ErrAllHostsDown
 }
 
 //Inc 主机负载增加1 应该只在通过GetLeast获取主机时使用
 func (c *ConsistentHash) Inc(hostName string) {
-	atomic.AddInt64(&c.hostMap[hostName].LoadBound, 1)
+	c.RLock()
+	defer c.RUnlock()
+	h, ok := c.hostMap[hostName]
+	if !ok {
+		return
+	}
+	atomic.AddInt64(&h.LoadBound, 1)
 	atomic.AddInt64(&c.totalLoad, 1)
 }
 
 //Done 将主机负载减1 应该只在通过GetLeast获取主机时使用
 func (c *ConsistentHash) Done(host string) {
-	if _, ok := c.hostMap[host]; !ok {
+	c.RLock()
+	defer c.RUnlock()
+	h, ok := c.hostMap[host]
+	if !ok {
 		return
 	}
 
-	atomic.AddInt64(&c.hostMap[host].LoadBound, -1)
+	atomic.AddInt64(&h.LoadBound, -1)
 	atomic.AddInt64(&c.totalLoad, -1)
 }
 
@@ -323,4 +367,25 @@ func (c *ConsistentHash) checkLoadCapacity(host string) (bool, error) {
 	return false, nil
 }
 
+// Observe 该算法不使用响应延迟
+func (c *ConsistentHash) Observe(_ string, _ time.Duration) {}
 
+// SetWeight 该算法不支持权重
+func (c *ConsistentHash) SetWeight(_ string, _ int) error {
+	return ErrWeightNotSupported
+}
+
+//Stats 返回各主机负载的快照，按主机名排序
+func (c *ConsistentHash) Stats() []HostStat {
+	c.RLock()
+	defer c.RUnlock()
+	stats := make([]HostStat, 0, len(c.hostMap))
+	for name, h := range c.hostMap {
+		drained := c.isDrained(name)
+		stats = append(stats, HostStat{Name: name, Load: atomic.LoadInt64(&h.LoadBound), Alive: !drained, Drained: drained})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
@@ -167,3 +167,47 @@ func TestConsistent_delHashIndex(t *testing.T) {
 
 	fmt.Printf("after deletions: %+v\n", c.sortedHostsHashSet)
 }
+
+func TestConsistent_RemoveRemapsAffectedKeysOnly(t *testing.T) {
+	hosts := []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003", "127.0.0.1:8004"}
+	c := NewConsistentHash(hosts)
+
+	const sample = 10000
+	before := make([]string, sample)
+	for i := 0; i < sample; i++ {
+		before[i], _ = c.Balance(fmt.Sprintf("/api/v1/item/%d", i))
+	}
+
+	removed := hosts[1]
+	c.Remove(removed)
+
+	remapped := 0
+	for i := 0; i < sample; i++ {
+		after, _ := c.Balance(fmt.Sprintf("/api/v1/item/%d", i))
+		if after == before[i] {
+			continue
+		}
+		remapped++
+		//只有原来落在被删除主机上的key才会被重新映射
+		if before[i] != removed {
+			t.Fatalf("key %d moved from %s to %s", i, before[i], after)
+		}
+	}
+	if remapped*100 >= sample*40 {
+		t.Fatalf("Expected fewer than 40%% of keys remapped, got %d/%d", remapped, sample)
+	}
+}
+
+func TestConsistent_SetReplicas(t *testing.T) {
+	c := NewConsistentHash([]string{"127.0.0.1:8001", "127.0.0.1:8002"}).(*ConsistentHash)
+
+	c.SetReplicas(20)
+	if len(c.sortedHostsHashSet) != 40 {
+		t.Fatalf("Expected 40 node in sortedHostsHashSet, got %d", len(c.sortedHostsHashSet))
+	}
+
+	c.Remove("127.0.0.1:8001")
+	if len(c.sortedHostsHashSet) != 20 {
+		t.Fatalf("Expected 20 node in sortedHostsHashSet, got %d", len(c.sortedHostsHashSet))
+	}
+}
@@ -0,0 +1,63 @@
+package balancer
+
+import "sync"
+
+// drainSet 维护中的主机集合，嵌入到各负载均衡器中实现Drain及Undrain
+// 维护状态与主机是否在负载均衡器中无关，主机被Remove后再Add仍保持维护状态
+type drainSet struct {
+	drainMux     sync.RWMutex
+	drainedHosts map[string]bool
+}
+
+// Drain 将主机置为维护状态，Balance不再选择该主机，主机的负载及已有连接不受影响
+func (d *drainSet) Drain(host string) {
+	d.drainMux.Lock()
+	defer d.drainMux.Unlock()
+	if d.drainedHosts == nil {
+		d.drainedHosts = make(map[string]bool)
+	}
+	d.drainedHosts[host] = true
+}
+
+// Undrain 取消主机的维护状态，主机重新参与负载均衡，不重置负载等统计
+func (d *drainSet) Undrain(host string) {
+	d.drainMux.Lock()
+	defer d.drainMux.Unlock()
+	delete(d.drainedHosts, host)
+}
+
+// isDrained 判断主机是否处于维护状态
+func (d *drainSet) isDrained(host string) bool {
+	d.drainMux.RLock()
+	defer d.drainMux.RUnlock()
+	return d.drainedHosts[host]
+}
+
+// anyDrained 判断是否有主机处于维护状态，没有时Balance可以跳过过滤
+func (d *drainSet) anyDrained() bool {
+	d.drainMux.RLock()
+	defer d.drainMux.RUnlock()
+	return len(d.drainedHosts) > 0
+}
+
+// available 过滤掉维护中的主机，没有主机处于维护状态时直接返回hosts
+func (d *drainSet) available(hosts []string) []string {
+	if !d.anyDrained() {
+		return hosts
+	}
+	result := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if !d.isDrained(h) {
+			result = append(result, h)
+		}
+	}
+	return result
+}
+
+// noHost 没有可选择的主机时返回的错误，负载均衡器中有主机但都在维护中时返回ErrAllHostsDown
+func noHost(total int) error {
+	if total > 0 {
+		return ErrAllHostsDown
+	}
+	return ErrNoHost
+}
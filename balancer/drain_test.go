@@ -0,0 +1,42 @@
+package balancer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDrain(t *testing.T) {
+	for algorithm := range factories {
+		t.Run(algorithm, func(t *testing.T) {
+			b, err := Build(algorithm, []string{"a", "b", "c"})
+			assert.NoError(t, err)
+			b.Inc("b")
+			before := b.Stats()
+
+			b.Drain("b")
+			for i := 0; i < 100; i++ {
+				host, err := b.Balance(fmt.Sprintf("10.0.0.%d", i))
+				assert.NoError(t, err)
+				assert.NotEqual(t, "b", host)
+			}
+			for _, stat := range b.Stats() {
+				assert.Equal(t, stat.Name == "b", stat.Drained, stat.Name)
+				assert.Equal(t, stat.Name != "b", stat.Alive, stat.Name)
+			}
+
+			b.Drain("a")
+			b.Drain("c")
+			_, err = b.Balance("key")
+			assert.Equal(t, ErrAllHostsDown, err)
+
+			//取消维护后不重置负载
+			b.Undrain("a")
+			b.Undrain("b")
+			b.Undrain("c")
+			assert.Equal(t, before, b.Stats())
+			_, err = b.Balance("key")
+			assert.NoError(t, err)
+		})
+	}
+}
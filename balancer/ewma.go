@@ -0,0 +1,185 @@
+package balancer
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+func init() {
+	factories[EWMABalancer] = NewEWMA
+}
+
+//defaultDecay EWMA的衰减时间常数，越大历史延迟的影响越久
+const defaultDecay = 10 * time.Second
+
+// EWMA 延迟感知算法：记录每个主机响应延迟的指数加权移动平均值，随机选取两个主机，
+// 选择 延迟平均值*(连接数+1) 较小的主机。长时间没有新的延迟样本时平均值会随时间衰减,
+// 使得从慢速状态恢复的主机能够重新被选中
+type EWMA struct {
+	mux     sync.Mutex
+	hosts   []*ewmaHost
+	hostMap map[string]*ewmaHost
+	rnd     *rand.Rand
+	decay   time.Duration
+	now     func() time.Time
+	drainSet
+}
+
+//ewmaHost 主机的延迟统计
+type ewmaHost struct {
+	name string
+	load uint64
+	//latency 延迟的指数加权移动平均值(纳秒)
+	latency float64
+	//updated 最近一次更新latency的时间
+	updated time.Time
+	//observed 是否已经有延迟样本
+	observed bool
+}
+
+// NewEWMA create new EWMA balancer
+func NewEWMA(hosts []string) Balancer {
+	e := &EWMA{
+		hosts:   []*ewmaHost{},
+		hostMap: make(map[string]*ewmaHost),
+		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		decay:   defaultDecay,
+		now:     time.Now,
+	}
+	for _, h := range hosts {
+		e.Add(h)
+	}
+	return e
+}
+
+// Add new host to the balancer
+func (e *EWMA) Add(hostName string) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if _, ok := e.hostMap[hostName]; ok {
+		return
+	}
+	h := &ewmaHost{name: hostName, updated: e.now()}
+	e.hosts = append(e.hosts, h)
+	e.hostMap[hostName] = h
+}
+
+// Remove host from the balancer
+func (e *EWMA) Remove(hostName string) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if _, ok := e.hostMap[hostName]; !ok {
+		return
+	}
+	delete(e.hostMap, hostName)
+	for i, h := range e.hosts {
+		if h.name == hostName {
+			e.hosts = append(e.hosts[:i], e.hosts[i+1:]...)
+			return
+		}
+	}
+}
+
+// Balance 随机选取两个主机，选择负载得分较低的主机
+func (e *EWMA) Balance(_ string) (string, error) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	hosts := e.hosts
+	if e.anyDrained() {
+		hosts = make([]*ewmaHost, 0, len(e.hosts))
+		for _, h := range e.hosts {
+			if !e.isDrained(h.name) {
+				hosts = append(hosts, h)
+			}
+		}
+	}
+	switch len(hosts) {
+	case 0:
+		return "", noHost(len(e.hosts))
+	case 1:
+		return hosts[0].name, nil
+	}
+
+	i := e.rnd.Intn(len(hosts))
+	j := e.rnd.Intn(len(hosts) - 1)
+	if j >= i {
+		j++
+	}
+	now := e.now()
+	h1, h2 := hosts[i], hosts[j]
+	if e.score(h1, now) <= e.score(h2, now) {
+		return h1.name, nil
+	}
+	return h2.name, nil
+}
+
+// Inc refers to the number of connections to the server `+1`
+func (e *EWMA) Inc(hostName string) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if h, ok := e.hostMap[hostName]; ok {
+		h.load++
+	}
+}
+
+// Done refers to the number of connections to the server `-1`
+func (e *EWMA) Done(hostName string) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if h, ok := e.hostMap[hostName]; ok && h.load > 0 {
+		h.load--
+	}
+}
+
+// Observe 记录主机的响应延迟，按距离上次更新的时间计算权重，时间越久旧值的权重越小
+func (e *EWMA) Observe(hostName string, d time.Duration) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	h, ok := e.hostMap[hostName]
+	if !ok {
+		return
+	}
+	now := e.now()
+	if !h.observed {
+		h.latency = float64(d)
+		h.observed = true
+	} else {
+		w := e.weight(h, now)
+		h.latency = h.latency*w + float64(d)*(1-w)
+	}
+	h.updated = now
+}
+
+// score 负载得分：衰减后的延迟平均值*(连接数+1)
+func (e *EWMA) score(h *ewmaHost, now time.Time) float64 {
+	return h.latency * e.weight(h, now) * float64(h.load+1)
+}
+
+// weight 旧值的权重 exp(-elapsed/decay)
+func (e *EWMA) weight(h *ewmaHost, now time.Time) float64 {
+	elapsed := now.Sub(h.updated)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Exp(-float64(elapsed) / float64(e.decay))
+}
+
+// SetWeight 该算法不支持权重
+func (e *EWMA) SetWeight(_ string, _ int) error {
+	return ErrWeightNotSupported
+}
+
+// Stats 返回各主机负载的快照
+func (e *EWMA) Stats() []HostStat {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	stats := make([]HostStat, 0, len(e.hosts))
+	for _, h := range e.hosts {
+		drained := e.isDrained(h.name)
+		stats = append(stats, HostStat{Name: h.name, Load: int64(h.load), Alive: !drained, Drained: drained})
+	}
+	return stats
+}
@@ -0,0 +1,41 @@
+package balancer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEWMA_PrefersFasterHost(t *testing.T) {
+	e := NewEWMA([]string{"fast", "slow"}).(*EWMA)
+	for i := 0; i < 10; i++ {
+		e.Observe("fast", 10*time.Millisecond)
+		e.Observe("slow", 500*time.Millisecond)
+	}
+
+	for i := 0; i < 100; i++ {
+		host, err := e.Balance("")
+		assert.NoError(t, err)
+		assert.Equal(t, "fast", host)
+	}
+}
+
+func TestEWMA_SlowHostRecovers(t *testing.T) {
+	now := time.Now()
+	e := NewEWMA([]string{"fast", "slow"}).(*EWMA)
+	e.now = func() time.Time { return now }
+	e.Observe("fast", 10*time.Millisecond)
+	e.Observe("slow", 500*time.Millisecond)
+
+	//慢速主机长时间没有新的样本后，延迟平均值衰减，慢速主机重新可以被选中
+	now = now.Add(time.Minute)
+	e.Observe("fast", 10*time.Millisecond)
+	selected := false
+	for i := 0; i < 100; i++ {
+		if host, _ := e.Balance(""); host == "slow" {
+			selected = true
+			break
+		}
+	}
+	assert.True(t, selected)
+}
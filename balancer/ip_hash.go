@@ -3,21 +3,24 @@ package balancer
 import (
 	"hash/crc32"
 	"sync"
+	"time"
 )
 
 //IPHash IP哈希算法：通过对请求的host进行hash运算后取模
 type IPHash struct {
 	hosts []string
 	mux   sync.RWMutex
+	drainSet
 }
 
 func init()  {
 	factories[IPHashBalancer] = NewIPHash
 }
 
+// NewIPHash 创建IP哈希负载均衡器，Balance的key应为客户端IP，同一个客户端总是访问同一个主机
 func NewIPHash(hosts []string)Balancer {
 	return &IPHash{
-		hosts: hosts,
+		hosts: append([]string{}, hosts...),
 	}
 }
 
@@ -41,16 +44,38 @@ func (h *IPHash) Remove(host string) {
 		}
 	}
 }
+// Balance 对key(客户端IP)做crc32哈希后按主机数量取模，主机被删除或处于维护状态时按剩余主机重新取模
 func (h *IPHash) Balance(ip string)(string,error) {
 	h.mux.RLock()
 	defer h.mux.RUnlock()
-	if len(h.hosts) == 0 {
-		return "", NoHostError
+	hosts := h.available(h.hosts)
+	if len(hosts) == 0 {
+		return "", noHost(len(h.hosts))
 	}
-	value := crc32.ChecksumIEEE([]byte(ip)) % uint32(len(h.hosts))
-	return h.hosts[value], nil
+	value := crc32.ChecksumIEEE([]byte(ip)) % uint32(len(hosts))
+	return hosts[value], nil
 }
 
 func (h *IPHash) Inc(_ string) {}
 
-func (h *IPHash) Done(_ string) {}
\ No newline at end of file
+func (h *IPHash) Done(_ string) {}
+
+// Observe 该算法不使用响应延迟
+func (h *IPHash) Observe(_ string, _ time.Duration) {}
+
+// SetWeight 该算法不支持权重
+func (h *IPHash) SetWeight(_ string, _ int) error {
+	return ErrWeightNotSupported
+}
+
+// Stats 返回各主机状态的快照，该算法不统计负载
+func (h *IPHash) Stats() []HostStat {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	stats := make([]HostStat, 0, len(h.hosts))
+	for _, host := range h.hosts {
+		drained := h.isDrained(host)
+		stats = append(stats, HostStat{Name: host, Alive: !drained, Drained: drained})
+	}
+	return stats
+}
@@ -0,0 +1,50 @@
+package balancer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIPHash_SameKeySameHost(t *testing.T) {
+	hosts := []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003", "127.0.0.1:8004"}
+	ipHash := NewIPHash(hosts)
+
+	for i := 0; i < 100; i++ {
+		ip := fmt.Sprintf("192.168.1.%d", i)
+		host, err := ipHash.Balance(ip)
+		assert.NoError(t, err)
+		for j := 0; j < 10; j++ {
+			again, _ := ipHash.Balance(ip)
+			assert.Equal(t, host, again)
+		}
+	}
+}
+
+func TestIPHash_RemoveHost(t *testing.T) {
+	hosts := []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"}
+	ipHash := NewIPHash(hosts)
+
+	ip := "10.0.0.1"
+	host, err := ipHash.Balance(ip)
+	assert.NoError(t, err)
+
+	//删除其他主机之前，同一个IP一直访问同一个主机
+	for i := 0; i < 10; i++ {
+		again, _ := ipHash.Balance(ip)
+		assert.Equal(t, host, again)
+	}
+
+	//删除该主机后，重新分配到剩余的主机上，并且之后保持不变
+	ipHash.Remove(host)
+	rebalanced, err := ipHash.Balance(ip)
+	assert.NoError(t, err)
+	assert.NotEqual(t, host, rebalanced)
+	for i := 0; i < 10; i++ {
+		again, _ := ipHash.Balance(ip)
+		assert.Equal(t, rebalanced, again)
+	}
+
+	//传入的主机列表不会被修改
+	assert.Equal(t, []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"}, hosts)
+}
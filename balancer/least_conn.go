@@ -0,0 +1,122 @@
+package balancer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+func init() {
+	factories[LeastConnBalancer] = NewLeastConn
+}
+
+// LeastConn 精确的最少连接数算法，每次遍历所有主机选择正在处理的请求数最少的主机，连接数相同时随机选择
+// 与P2C相比总能选中负载最小的主机，遍历的开销与主机数成正比，适合主机数较少的路由
+type LeastConn struct {
+	mux     sync.RWMutex
+	hosts   []*HostLoad
+	loadMap map[string]*HostLoad
+	drainSet
+}
+
+// NewLeastConn create new LeastConn balancer
+func NewLeastConn(hosts []string) Balancer {
+	l := &LeastConn{loadMap: make(map[string]*HostLoad)}
+	for _, h := range hosts {
+		l.Add(h)
+	}
+	return l
+}
+
+// Add new host to the balancer
+func (l *LeastConn) Add(hostName string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if _, ok := l.loadMap[hostName]; ok {
+		return
+	}
+	h := &HostLoad{name: hostName, load: 0}
+	l.hosts = append(l.hosts, h)
+	l.loadMap[hostName] = h
+}
+
+// Remove new host from the balancer
+func (l *LeastConn) Remove(host string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if _, ok := l.loadMap[host]; !ok {
+		return
+	}
+	delete(l.loadMap, host)
+	for i, h := range l.hosts {
+		if h.name == host {
+			l.hosts = append(l.hosts[:i], l.hosts[i+1:]...)
+			return
+		}
+	}
+}
+
+// Balance 选择连接数最少的主机，有多个时使用蓄水池抽样随机选择其中一个
+func (l *LeastConn) Balance(_ string) (string, error) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	var best *HostLoad
+	ties := 0
+	for _, h := range l.hosts {
+		if l.isDrained(h.name) {
+			continue
+		}
+		switch {
+		case best == nil || h.load < best.load:
+			best, ties = h, 1
+		case h.load == best.load:
+			ties++
+			//全局的rand是并发安全的，读锁下可以使用
+			if rand.Intn(ties) == 0 {
+				best = h
+			}
+		}
+	}
+	if best == nil {
+		return "", noHost(len(l.hosts))
+	}
+	return best.name, nil
+}
+
+// Inc refers to the number of connections to the server `+1`
+func (l *LeastConn) Inc(host string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if h, ok := l.loadMap[host]; ok {
+		h.load++
+	}
+}
+
+// Done refers to the number of connections to the server `-1`
+func (l *LeastConn) Done(host string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if h, ok := l.loadMap[host]; ok && h.load > 0 {
+		h.load--
+	}
+}
+
+// Observe 该算法不使用响应延迟
+func (l *LeastConn) Observe(_ string, _ time.Duration) {}
+
+// SetWeight 该算法不支持权重
+func (l *LeastConn) SetWeight(_ string, _ int) error {
+	return ErrWeightNotSupported
+}
+
+// Stats 返回各主机负载的快照
+func (l *LeastConn) Stats() []HostStat {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	stats := make([]HostStat, 0, len(l.hosts))
+	for _, h := range l.hosts {
+		drained := l.isDrained(h.name)
+		stats = append(stats, HostStat{Name: h.name, Load: int64(h.load), Alive: !drained, Drained: drained})
+	}
+	return stats
+}
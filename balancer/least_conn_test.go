@@ -0,0 +1,70 @@
+package balancer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLeastConn_Balance(t *testing.T) {
+	l := NewLeastConn([]string{"a", "b", "c"})
+	l.Inc("a")
+	l.Inc("a")
+	l.Inc("b")
+	host, err := l.Balance("")
+	assert.NoError(t, err)
+	assert.Equal(t, "c", host)
+
+	//连接数相同时随机选择
+	l.Inc("c")
+	selected := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		host, _ := l.Balance("")
+		selected[host] = true
+	}
+	assert.Equal(t, map[string]bool{"b": true, "c": true}, selected)
+
+	l.Done("a")
+	l.Done("a")
+	host, _ = l.Balance("")
+	assert.Equal(t, "a", host)
+	assert.Equal(t, []HostStat{
+		{Name: "a", Load: 0, Alive: true},
+		{Name: "b", Load: 1, Alive: true},
+		{Name: "c", Load: 1, Alive: true},
+	}, l.Stats())
+
+	l.Remove("a")
+	l.Remove("b")
+	l.Remove("c")
+	_, err = l.Balance("")
+	assert.Equal(t, ErrNoHost, err)
+}
+
+func benchmarkBalance(b *testing.B, factory Factory) {
+	for _, n := range []int{2, 10, 100} {
+		hosts := make([]string, n)
+		for i := range hosts {
+			hosts[i] = fmt.Sprintf("10.0.0.%d:80", i)
+		}
+		bl := factory(hosts)
+		for i, h := range hosts {
+			for j := 0; j < i%5; j++ {
+				bl.Inc(h)
+			}
+		}
+		b.Run(fmt.Sprintf("hosts=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _ = bl.Balance("")
+			}
+		})
+	}
+}
+
+func BenchmarkLeastConn_Balance(b *testing.B) {
+	benchmarkBalance(b, NewLeastConn)
+}
+
+func BenchmarkP2C_Balance(b *testing.B) {
+	benchmarkBalance(b, NewP2C)
+}
@@ -3,6 +3,7 @@ package balancer
 import (
 	fibHeap "github.com/starwander/GoFibonacciHeap"
 	"sync"
+	"time"
 )
 
 func init() {
@@ -19,6 +20,9 @@ func (h *HostLoad) Key() float64 { return float64(h.load) }
 type LeastLoad struct {
 	sync.RWMutex
 	heap *fibHeap.FibHeap
+	// hosts 按添加顺序保存的主机，用于输出状态快照
+	hosts []*HostLoad
+	drainSet
 }
 
 // NewLeastLoad create new LeastLoad balancer
@@ -37,7 +41,9 @@ func (l *LeastLoad) Add(hostName string) {
 	if ok := l.heap.GetValue(hostName); ok != nil {
 		return
 	}
-	_ = l.heap.InsertValue(&HostLoad{hostName, 0})
+	h := &HostLoad{hostName, 0}
+	_ = l.heap.InsertValue(h)
+	l.hosts = append(l.hosts, h)
 }
 
 // Remove new host from the balancer
@@ -48,6 +54,12 @@ func (l *LeastLoad) Remove(hostName string) {
 		return
 	}
 	_ = l.heap.Delete(hostName)
+	for i, h := range l.hosts {
+		if h.name == hostName {
+			l.hosts = append(l.hosts[:i], l.hosts[i+1:]...)
+			break
+		}
+	}
 }
 
 // Balance selects a suitable host according
@@ -55,9 +67,22 @@ func (l *LeastLoad) Balance(_ string) (string, error) {
 	l.RLock()
 	defer l.RUnlock()
 	if l.heap.Num() == 0 {
-		return "", NoHostError
+		return "", ErrNoHost
+	}
+	if !l.anyDrained() {
+		return l.heap.MinimumValue().Tag().(string), nil
+	}
+	//有主机处于维护状态时堆顶可能不可用，遍历选择负载最小的可用主机
+	var best *HostLoad
+	for _, h := range l.hosts {
+		if !l.isDrained(h.name) && (best == nil || h.load < best.load) {
+			best = h
+		}
+	}
+	if best == nil {
+		return "", ErrAllHostsDown
 	}
-	return l.heap.MinimumValue().Tag().(string), nil
+	return best.name, nil
 }
 
 // Inc refers to the number of connections to the server `+1`
@@ -82,4 +107,24 @@ func (l *LeastLoad) Done(hostName string) {
 	h := l.heap.GetValue(hostName)
 	h.(*HostLoad).load--
 	_ = l.heap.DecreaseKeyValue(h)
-}
\ No newline at end of file
+}
+
+// Observe 该算法不使用响应延迟
+func (l *LeastLoad) Observe(_ string, _ time.Duration) {}
+
+// SetWeight 该算法不支持权重
+func (l *LeastLoad) SetWeight(_ string, _ int) error {
+	return ErrWeightNotSupported
+}
+
+// Stats 返回各主机负载的快照
+func (l *LeastLoad) Stats() []HostStat {
+	l.RLock()
+	defer l.RUnlock()
+	stats := make([]HostStat, 0, len(l.hosts))
+	for _, h := range l.hosts {
+		drained := l.isDrained(h.name)
+		stats = append(stats, HostStat{Name: h.name, Load: int64(h.load), Alive: !drained, Drained: drained})
+	}
+	return stats
+}
@@ -0,0 +1,175 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+//defaultMaglevTableSize 默认查找表的大小，必须是质数且远大于主机数量，以保证各主机分到的表项数量接近
+const defaultMaglevTableSize = 65537
+
+func init() {
+	factories[MaglevBalancer] = NewMaglev
+}
+
+//Maglev Maglev一致性哈希算法：为每个主机生成查找表位置的排列，各主机轮流按排列占用查找表中的空位
+//各主机在查找表中占用的表项数量最多相差1，负载比哈希环更均匀，主机变动时只有少量key会改变映射
+type Maglev struct {
+	mux sync.RWMutex
+	//hosts 按名称排序的主机，使查找表只与主机集合有关而与添加顺序无关
+	hosts []string
+	//table 查找表，保存主机在hosts中的下标
+	table []int
+	size  uint64
+	drainSet
+}
+
+// NewMaglev create new Maglev balancer
+func NewMaglev(hosts []string) Balancer {
+	m := &Maglev{size: defaultMaglevTableSize}
+	for _, h := range hosts {
+		if !m.contains(h) {
+			m.hosts = append(m.hosts, h)
+		}
+	}
+	m.populate()
+	return m
+}
+
+//contains 判断主机是否已添加，调用方需要持有锁
+func (m *Maglev) contains(host string) bool {
+	for _, h := range m.hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Add 添加主机并重建查找表
+func (m *Maglev) Add(host string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.contains(host) {
+		return
+	}
+	m.hosts = append(m.hosts, host)
+	m.populate()
+}
+
+// Remove 删除主机并重建查找表
+func (m *Maglev) Remove(host string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for i, h := range m.hosts {
+		if h == host {
+			m.hosts = append(m.hosts[:i], m.hosts[i+1:]...)
+			m.populate()
+			return
+		}
+	}
+}
+
+// Drain 将主机置为维护状态并按剩余主机重建查找表，只有该主机上的key会改变映射
+func (m *Maglev) Drain(host string) {
+	m.drainSet.Drain(host)
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.populate()
+}
+
+// Undrain 取消主机的维护状态并重建查找表
+func (m *Maglev) Undrain(host string) {
+	m.drainSet.Undrain(host)
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.populate()
+}
+
+//populate 按Maglev论文中的方法生成查找表，维护中的主机不占用表项，调用方需要持有写锁
+//主机i的排列为 (offset + j*skip) mod size，size为质数时每个排列都会覆盖所有表项
+func (m *Maglev) populate() {
+	sort.Strings(m.hosts)
+	candidates := make([]int, 0, len(m.hosts))
+	for i, h := range m.hosts {
+		if !m.isDrained(h) {
+			candidates = append(candidates, i)
+		}
+	}
+	n := len(candidates)
+	if n == 0 {
+		m.table = nil
+		return
+	}
+	offsets := make([]uint64, n)
+	skips := make([]uint64, n)
+	next := make([]uint64, n)
+	for i, c := range candidates {
+		offsets[i] = maglevHash(m.hosts[c], "offset") % m.size
+		skips[i] = maglevHash(m.hosts[c], "skip")%(m.size-1) + 1
+	}
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	for filled := uint64(0); ; {
+		for i := 0; i < n; i++ {
+			c := (offsets[i] + next[i]*skips[i]) % m.size
+			for table[c] >= 0 {
+				next[i]++
+				c = (offsets[i] + next[i]*skips[i]) % m.size
+			}
+			table[c] = candidates[i]
+			next[i]++
+			filled++
+			if filled == m.size {
+				m.table = table
+				return
+			}
+		}
+	}
+}
+
+//maglevHash 使用FNV-1a计算主机名加盐后的哈希，offset及skip使用不同的盐以得到相互独立的哈希值
+func maglevHash(host, salt string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(salt))
+	_, _ = h.Write([]byte(host))
+	return h.Sum64()
+}
+
+// Balance 将key的哈希映射到查找表中的表项，返回表项对应的主机
+func (m *Maglev) Balance(key string) (string, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	if len(m.table) == 0 {
+		return "", noHost(len(m.hosts))
+	}
+	return m.hosts[m.table[defaultHashFunc(key)%m.size]], nil
+}
+
+func (m *Maglev) Inc(string) {}
+
+func (m *Maglev) Done(string) {}
+
+// Observe 该算法不使用响应延迟
+func (m *Maglev) Observe(_ string, _ time.Duration) {}
+
+// SetWeight 该算法不支持权重
+func (m *Maglev) SetWeight(_ string, _ int) error {
+	return ErrWeightNotSupported
+}
+
+// Stats 返回各主机状态的快照，按主机名排序，该算法不统计负载
+func (m *Maglev) Stats() []HostStat {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	stats := make([]HostStat, 0, len(m.hosts))
+	for _, host := range m.hosts {
+		drained := m.isDrained(host)
+		stats = append(stats, HostStat{Name: host, Alive: !drained, Drained: drained})
+	}
+	return stats
+}
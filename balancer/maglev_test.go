@@ -0,0 +1,61 @@
+package balancer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMaglev_TableBalanced(t *testing.T) {
+	m := NewMaglev([]string{"a", "b", "c", "d", "e"}).(*Maglev)
+
+	counts := make(map[int]int)
+	for _, i := range m.table {
+		counts[i]++
+	}
+	assert.Len(t, counts, 5)
+	for i, c := range counts {
+		//各主机占用的表项数量最多相差1
+		assert.InDelta(t, defaultMaglevTableSize/5, c, 1, "host %s", m.hosts[i])
+	}
+}
+
+func TestMaglev_Disruption(t *testing.T) {
+	hosts := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80", "10.0.0.5:80"}
+	m := NewMaglev(hosts)
+	const keys = 10000
+	before := make([]string, keys)
+	for i := range before {
+		host, err := m.Balance(fmt.Sprintf("key-%d", i))
+		assert.NoError(t, err)
+		before[i] = host
+	}
+
+	m.Remove(hosts[2])
+	moved, removed := 0, 0
+	for i := range before {
+		host, err := m.Balance(fmt.Sprintf("key-%d", i))
+		assert.NoError(t, err)
+		assert.NotEqual(t, hosts[2], host)
+		if before[i] == hosts[2] {
+			removed++
+		} else if host != before[i] {
+			moved++
+		}
+	}
+	//理论上只有被删除主机上的key(约1/5)需要改变映射，Maglev允许少量额外的变动
+	assert.InDelta(t, keys/5, removed, keys/50)
+	assert.Less(t, moved, keys/50, "moved %d keys that were not on the removed host", moved)
+
+	m.Add(hosts[2])
+	for i := range before {
+		host, _ := m.Balance(fmt.Sprintf("key-%d", i))
+		assert.Equal(t, before[i], host)
+	}
+}
+
+func TestMaglev_NoHost(t *testing.T) {
+	m := NewMaglev(nil)
+	_, err := m.Balance("key")
+	assert.Equal(t, ErrNoHost, err)
+}
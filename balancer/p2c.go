@@ -2,6 +2,7 @@ package balancer
 
 import (
 	"hash/crc32"
+	"math"
 	"math/rand"
 	"sync"
 	"time"
@@ -19,6 +20,9 @@ type P2C struct {
 	hosts   []*HostLoad
 	rnd     *rand.Rand
 	loadMap map[string]*HostLoad
+	//weights 通过SetWeight设置的主机权重，主机删除后保留，重新添加时恢复
+	weights map[string]int
+	drainSet
 }
 
 // NewP2C create new P2C balancer
@@ -26,6 +30,7 @@ func NewP2C(hosts []string) Balancer {
 	p := &P2C{
 		hosts:   []*HostLoad{},
 		loadMap: make(map[string]*HostLoad),
+		weights: make(map[string]int),
 		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 
@@ -100,27 +105,84 @@ func (p *P2C) Balance(key string) (string, error) {
 	p.mux.RLock()
 	defer p.mux.RUnlock()
 
-	if len(p.hosts) == 0 {
-		return "", NoHostError
+	hosts := p.available()
+	if len(hosts) == 0 {
+		return "", noHost(len(p.hosts))
 	}
 
-	n1, n2 := p.hash(key)
+	n1, n2 := p.hash(hosts, key)
 	host := n2
-	if p.loadMap[n1].load <= p.loadMap[n2].load {
+	if p.weightedLoad(p.loadMap[n1]) <= p.weightedLoad(p.loadMap[n2]) {
 		host = n1
 	}
 	return host, nil
 }
 
-func (p *P2C) hash(key string) (string, string) {
+// SetWeight 设置主机权重，作为选择时的相对偏好：比较的是 连接数/权重，权重为0时尽量不选择该主机
+func (p *P2C) SetWeight(host string, weight int) error {
+	if weight < 0 {
+		return ErrInvalidWeight
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if _, ok := p.loadMap[host]; !ok {
+		return ErrHostNotFound
+	}
+	p.weights[host] = weight
+	return nil
+}
+
+// weightedLoad 按权重换算后的负载 (连接数+1)/权重，未设置权重时权重为1
+func (p *P2C) weightedLoad(h *HostLoad) float64 {
+	weight, ok := p.weights[h.name]
+	if !ok {
+		weight = defaultWeight
+	}
+	if weight == 0 {
+		return math.Inf(1)
+	}
+	return float64(h.load+1) / float64(weight)
+}
+
+// available 过滤掉维护中的主机，没有主机处于维护状态时直接返回p.hosts
+func (p *P2C) available() []*HostLoad {
+	if !p.anyDrained() {
+		return p.hosts
+	}
+	hosts := make([]*HostLoad, 0, len(p.hosts))
+	for _, h := range p.hosts {
+		if !p.isDrained(h.name) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+func (p *P2C) hash(hosts []*HostLoad, key string) (string, string) {
 	var n1, n2 string
 	if len(key) > 0 {
 		saltKey := key + Salt
-		n1 = p.hosts[crc32.ChecksumIEEE([]byte(key))%uint32(len(p.hosts))].name
-		n2 = p.hosts[crc32.ChecksumIEEE([]byte(saltKey))%uint32(len(p.hosts))].name
+		n1 = hosts[crc32.ChecksumIEEE([]byte(key))%uint32(len(hosts))].name
+		n2 = hosts[crc32.ChecksumIEEE([]byte(saltKey))%uint32(len(hosts))].name
 		return n1, n2
 	}
-	n1 = p.hosts[p.rnd.Intn(len(p.hosts))].name
-	n2 = p.hosts[p.rnd.Intn(len(p.hosts))].name
+	n1 = hosts[p.rnd.Intn(len(hosts))].name
+	n2 = hosts[p.rnd.Intn(len(hosts))].name
 	return n1, n2
-}
\ No newline at end of file
+}
+
+// Observe 该算法不使用响应延迟
+func (p *P2C) Observe(_ string, _ time.Duration) {}
+
+// Stats 返回各主机负载的快照，权重为0的主机不参与负载均衡
+func (p *P2C) Stats() []HostStat {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	stats := make([]HostStat, 0, len(p.hosts))
+	for _, h := range p.hosts {
+		weight, ok := p.weights[h.name]
+		drained := p.isDrained(h.name)
+		stats = append(stats, HostStat{Name: h.name, Load: int64(h.load), Alive: (!ok || weight > 0) && !drained, Drained: drained})
+	}
+	return stats
+}
@@ -0,0 +1,37 @@
+package balancer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestP2C_Stats(t *testing.T) {
+	p := NewP2C([]string{"a", "b"})
+	p.Inc("a")
+	p.Inc("a")
+	p.Inc("b")
+	p.Done("b")
+
+	stats := p.Stats()
+	assert.Equal(t, []HostStat{
+		{Name: "a", Load: 2, Alive: true},
+		{Name: "b", Load: 0, Alive: true},
+	}, stats)
+
+	//快照为副本，后续的负载变化不影响已返回的结果
+	p.Inc("b")
+	assert.Equal(t, int64(0), stats[1].Load)
+
+	assert.NoError(t, p.SetWeight("b", 0))
+	assert.False(t, p.Stats()[1].Alive)
+}
+
+func TestP2C_RemoveKeepsWeight(t *testing.T) {
+	p := NewP2C([]string{"a", "b"})
+	assert.NoError(t, p.SetWeight("b", 0))
+
+	//健康检查摘除后恢复的主机保持通过SetWeight设置的权重
+	p.Remove("b")
+	p.Add("b")
+	assert.False(t, p.Stats()[1].Alive)
+}
@@ -10,18 +10,22 @@ func init() {
 	factories[RandomBalancer] = NewRandom
 }
 
-//Random 随机算法：根据host长度生成随机数，动态获取其中某一个host进行转发
+//Random 随机算法：根据host长度生成随机数，动态获取其中某一个host进行转发，与请求的key无关
 type Random struct {
 	mux   sync.RWMutex
 	hosts []string
 	rnd   *rand.Rand
+	drainSet
 }
 
+// NewRandom create new Random balancer
 func NewRandom(hosts []string) Balancer {
-	return &Random{
-		hosts: hosts,
-		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	r := &Random{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	//通过Add逐个添加，不与调用方共用切片，Remove时不会修改调用方的数据
+	for _, h := range hosts {
+		r.Add(h)
 	}
+	return r
 }
 
 func (r *Random) Add(host string)  {
@@ -41,17 +45,21 @@ func (r *Random) Remove(host string)  {
 	for i, h := range r.hosts {
 		if h == host {
 			r.hosts = append(r.hosts[:i], r.hosts[i+1:]...)
+			return
 		}
 	}
 }
 
+// Balance 均匀随机地选择一个不在维护中的主机
 func (r *Random) Balance(string) (string,error) {
-	r.mux.RLock()
-	defer r.mux.RUnlock()
-	if len(r.hosts) == 0 {
-		return "", NoHostError
+	//*rand.Rand不是并发安全的，生成随机数需要持有写锁
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	hosts := r.available(r.hosts)
+	if len(hosts) == 0 {
+		return "", noHost(len(r.hosts))
 	}
-	return r.hosts[r.rnd.Intn(len(r.hosts))], nil
+	return hosts[r.rnd.Intn(len(hosts))], nil
 }
 
 func (r *Random) Inc(string)  {
@@ -60,4 +68,24 @@ func (r *Random) Inc(string)  {
 
 func (r *Random) Done(string)  {
 
-}
\ No newline at end of file
+}
+
+// Observe 该算法不使用响应延迟
+func (r *Random) Observe(_ string, _ time.Duration) {}
+
+// SetWeight 该算法不支持权重
+func (r *Random) SetWeight(_ string, _ int) error {
+	return ErrWeightNotSupported
+}
+
+// Stats 返回各主机状态的快照，该算法不统计负载
+func (r *Random) Stats() []HostStat {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	stats := make([]HostStat, 0, len(r.hosts))
+	for _, host := range r.hosts {
+		drained := r.isDrained(host)
+		stats = append(stats, HostStat{Name: host, Alive: !drained, Drained: drained})
+	}
+	return stats
+}
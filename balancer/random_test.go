@@ -90,4 +90,51 @@ func BenchmarkRandom_Add(b *testing.B) {
 	fmt.Println(expected)
 	fmt.Println(rd.hosts)
 	assert.Equal(b, expected, rd.hosts)
-}
\ No newline at end of file
+}
+func TestNewRandom_CopiesHosts(t *testing.T) {
+	hosts := []string{"a", "b", "c"}
+	r := NewRandom(hosts)
+	r.Remove("a")
+	assert.Equal(t, []string{"a", "b", "c"}, hosts)
+	assert.Equal(t, []HostStat{{Name: "b", Alive: true}, {Name: "c", Alive: true}}, r.Stats())
+}
+
+func TestRandom_Balance(t *testing.T) {
+	r := NewRandom([]string{"a", "b", "c"})
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		host, err := r.Balance("same-key")
+		assert.NoError(t, err)
+		counts[host]++
+	}
+	//与key无关，每个主机都会被选中
+	for _, host := range []string{"a", "b", "c"} {
+		assert.InDelta(t, 1000, counts[host], 200, host)
+	}
+}
+
+func TestRandom_ConcurrentRemove(t *testing.T) {
+	hosts := make([]string, 100)
+	for i := range hosts {
+		hosts[i] = strconv.Itoa(i)
+	}
+	r := NewRandom(hosts)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if host, err := r.Balance(""); err == nil {
+					assert.NotEmpty(t, host)
+				}
+			}
+		}()
+	}
+	for _, h := range hosts {
+		r.Remove(h)
+	}
+	wg.Wait()
+	_, err := r.Balance("")
+	assert.Equal(t, ErrNoHost, err)
+}
@@ -1,27 +1,42 @@
 package balancer
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 /*
 RoundRobin 轮询算法：根据host长度模运算，依次获取下一个host进行转发
 优点：到后端应用的请求更加均匀，使得每个服务器基本均衡
 缺点：随着后端应用服务器的增加，缓存的命中率为下降，这种方式不会因为热点问题导致其中某一台
 服务器负载过重
+通过SetWeight设置权重后，按权重生成平滑的轮询顺序，权重越大的主机在一轮中出现的次数越多
  */
 type RoundRobin struct {
 	i     uint64
 	hosts []string
-	mux   sync.RWMutex
+	//weights 通过SetWeight设置的主机权重，未设置的主机权重为1，主机删除后保留，重新添加时恢复
+	weights map[string]int
+	//schedule 轮询顺序，所有主机权重相同时即为hosts
+	schedule []string
+	mux      sync.RWMutex
+	drainSet
 }
 
 func init()  {
-	factories[R2Balancer] = NewRoundRobin
+	factories[RoundRobinBalancer] = NewRoundRobin
 }
+
+// NewRoundRobin 创建轮询负载均衡器
 func NewRoundRobin(hosts []string) Balancer {
-	return &RoundRobin{
-		i:    0,
-		hosts: hosts,
+	r := &RoundRobin{
+		i:       0,
+		hosts:   append([]string{}, hosts...),
+		weights: make(map[string]int),
 	}
+	r.reschedule()
+	return r
 }
 
 func (r *RoundRobin) Add(host string) {
@@ -33,6 +48,7 @@ func (r *RoundRobin) Add(host string) {
 		}
 	}
 	r.hosts = append(r.hosts, host)
+	r.reschedule()
 }
 
 
@@ -42,22 +58,77 @@ func (r *RoundRobin) Remove(host string) {
 	for i, h := range r.hosts {
 		if h == host {
 			r.hosts = append(r.hosts[:i], r.hosts[i+1:]...)
+			r.reschedule()
 			return
 		}
 	}
 }
 
+// Balance 忽略key，依次返回下一个主机，Remove之后按当前主机数量取模，不会越界，维护中的主机被跳过
 func (r *RoundRobin) Balance(_ string)(string,error) {
 	r.mux.RLock()
 	defer r.mux.RUnlock()
-	if len(r.hosts) == 0 {
-		return "", NoHostError
+	for range r.schedule {
+		//读锁下会有多个goroutine同时选择主机，需要原子递增
+		i := atomic.AddUint64(&r.i, 1) - 1
+		if host := r.schedule[i%uint64(len(r.schedule))]; !r.isDrained(host) {
+			return host, nil
+		}
 	}
-	host := r.hosts[r.i%uint64(len(r.hosts))]
-	r.i++
-	return host, nil
+	return "", noHost(len(r.hosts))
 }
 
 func (r *RoundRobin) Inc(_ string)  {}
 
-func (r *RoundRobin) Done(_ string)  {}
\ No newline at end of file
+func (r *RoundRobin) Done(_ string)  {}
+
+// Observe 该算法不使用响应延迟
+func (r *RoundRobin) Observe(_ string, _ time.Duration) {}
+
+// SetWeight 设置主机权重，作为轮询时的相对选择比例，权重为0时不再分配请求
+func (r *RoundRobin) SetWeight(host string, weight int) error {
+	if weight < 0 {
+		return ErrInvalidWeight
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, h := range r.hosts {
+		if h == host {
+			r.weights[host] = weight
+			r.reschedule()
+			return nil
+		}
+	}
+	return ErrHostNotFound
+}
+
+// reschedule 按权重重新生成轮询顺序，调用方需要持有写锁
+func (r *RoundRobin) reschedule() {
+	weights := make([]int, len(r.hosts))
+	uniform := true
+	for i, h := range r.hosts {
+		weights[i] = defaultWeight
+		if w, ok := r.weights[h]; ok {
+			weights[i] = w
+			uniform = uniform && w == defaultWeight
+		}
+	}
+	if uniform {
+		r.schedule = append([]string{}, r.hosts...)
+		return
+	}
+	r.schedule = smoothSchedule(r.hosts, weights)
+}
+
+// Stats 返回各主机状态的快照，该算法不统计负载，权重为0的主机不参与负载均衡
+func (r *RoundRobin) Stats() []HostStat {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	stats := make([]HostStat, 0, len(r.hosts))
+	for _, host := range r.hosts {
+		weight, ok := r.weights[host]
+		drained := r.isDrained(host)
+		stats = append(stats, HostStat{Name: host, Alive: (!ok || weight > 0) && !drained, Drained: drained})
+	}
+	return stats
+}
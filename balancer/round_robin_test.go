@@ -29,3 +29,68 @@ func TestRoundRobin_Balance(t *testing.T) {
 		assert.Equal(t, index, i%6)
 	}
 }
+
+
+func TestRoundRobin_Distribution(t *testing.T) {
+	roundRobin := NewRoundRobin([]string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"})
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		host, err := roundRobin.Balance("")
+		assert.NoError(t, err)
+		counts[host]++
+	}
+	assert.Len(t, counts, 3)
+	for host, count := range counts {
+		assert.InDelta(t, 333, count, 1, "host %s", host)
+	}
+}
+
+func TestRoundRobin_RemoveWraps(t *testing.T) {
+	roundRobin := NewRoundRobin([]string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"})
+
+	_, _ = roundRobin.Balance("")
+	_, _ = roundRobin.Balance("")
+	roundRobin.Remove("127.0.0.1:8003")
+	for i := 0; i < 10; i++ {
+		host, err := roundRobin.Balance("")
+		assert.NoError(t, err)
+		assert.NotEqual(t, "127.0.0.1:8003", host)
+	}
+
+	roundRobin.Remove("127.0.0.1:8001")
+	roundRobin.Remove("127.0.0.1:8002")
+	_, err := roundRobin.Balance("")
+	assert.Equal(t, NoHostError, err)
+}
+
+func TestRoundRobin_SetWeight(t *testing.T) {
+	roundRobin := NewRoundRobin([]string{"127.0.0.1:8001", "127.0.0.1:8002"})
+
+	assert.Equal(t, ErrInvalidWeight, roundRobin.SetWeight("127.0.0.1:8001", -1))
+	assert.NoError(t, roundRobin.SetWeight("127.0.0.1:8001", 2))
+
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		host, _ := roundRobin.Balance("")
+		counts[host]++
+	}
+	assert.Equal(t, 200, counts["127.0.0.1:8001"])
+	assert.Equal(t, 100, counts["127.0.0.1:8002"])
+}
+
+func TestRoundRobin_RemoveKeepsWeight(t *testing.T) {
+	roundRobin := NewRoundRobin([]string{"127.0.0.1:8001", "127.0.0.1:8002"})
+	assert.NoError(t, roundRobin.SetWeight("127.0.0.1:8001", 3))
+
+	//健康检查摘除后恢复的主机保持通过SetWeight设置的权重
+	roundRobin.Remove("127.0.0.1:8001")
+	roundRobin.Add("127.0.0.1:8001")
+	counts := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		host, _ := roundRobin.Balance("")
+		counts[host]++
+	}
+	assert.Equal(t, 300, counts["127.0.0.1:8001"])
+	assert.Equal(t, 100, counts["127.0.0.1:8002"])
+}
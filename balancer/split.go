@@ -0,0 +1,250 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrGroupNotFound 主机组不存在
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrNoGroupWeight 所有主机组的权重都为0
+	ErrNoGroupWeight = errors.New("at least one group must have a positive weight")
+)
+
+// SplitGroup 按权重分配流量的主机组，组内使用相同的负载均衡算法
+type SplitGroup struct {
+	// Name 主机组名称，例如stable、canary
+	Name string
+	// Weight 主机组的流量权重，为0时不分配请求
+	Weight int
+	// Hosts 主机组中的主机
+	Hosts []string
+	// Weights 组内主机的权重，与Hosts一一对应
+	Weights []int
+}
+
+// GroupStat 主机组的流量权重快照
+type GroupStat struct {
+	Name   string
+	Weight int
+	// Percent 主机组分配的流量百分比
+	Percent float64
+}
+
+/*
+Split 按权重将流量分配到多个主机组(例如灰度发布时5%的请求分配到canary、95%分配到stable)，
+先按权重选择主机组，再由组内的负载均衡器选择主机，组内的负载均衡策略不受影响。
+选中的主机组没有可用主机时依次使用其他权重大于0的主机组
+*/
+type Split struct {
+	mux    sync.RWMutex
+	groups []*splitGroup
+	//hostGroup 主机所属的主机组，主机被Remove后保留，再次Add时回到原来的主机组
+	hostGroup map[string]*splitGroup
+}
+
+type splitGroup struct {
+	name   string
+	weight int
+	bl     Balancer
+}
+
+// NewSplit 创建按权重分配流量的负载均衡器，每个主机组使用algorithm创建组内的负载均衡器
+func NewSplit(algorithm string, groups []SplitGroup) (*Split, error) {
+	s := &Split{hostGroup: make(map[string]*splitGroup)}
+	total := 0
+	for _, g := range groups {
+		if g.Weight < 0 {
+			return nil, ErrInvalidWeight
+		}
+		bl, err := BuildWithWeights(algorithm, g.Hosts, g.Weights)
+		if err != nil {
+			return nil, err
+		}
+		group := &splitGroup{name: g.Name, weight: g.Weight, bl: bl}
+		s.groups = append(s.groups, group)
+		for _, h := range g.Hosts {
+			s.hostGroup[h] = group
+		}
+		total += g.Weight
+	}
+	if total == 0 {
+		return nil, ErrNoGroupWeight
+	}
+	return s, nil
+}
+
+// group 获取主机所属的主机组，未知的主机属于第一个主机组
+func (s *Split) group(host string) *splitGroup {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if g, ok := s.hostGroup[host]; ok {
+		return g
+	}
+	return s.groups[0]
+}
+
+// Add 添加主机，未知的主机添加到第一个主机组
+func (s *Split) Add(host string) {
+	s.mux.Lock()
+	g, ok := s.hostGroup[host]
+	if !ok {
+		g = s.groups[0]
+		s.hostGroup[host] = g
+	}
+	s.mux.Unlock()
+	g.bl.Add(host)
+}
+
+func (s *Split) Remove(host string) {
+	s.group(host).bl.Remove(host)
+}
+
+func (s *Split) Balance(key string) (string, error) {
+	return s.BalanceCtx(context.Background(), key)
+}
+
+// BalanceCtx 按权重选择主机组后由组内的负载均衡器选择主机
+func (s *Split) BalanceCtx(ctx context.Context, key string) (string, error) {
+	order := s.pick()
+	err := ErrNoHost
+	for _, g := range order {
+		host, e := BalanceCtx(ctx, g.bl, key)
+		if e == nil {
+			return host, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		//有主机组存在不可用的主机时返回ErrAllHostsDown
+		if errors.Is(e, ErrAllHostsDown) || !errors.Is(err, ErrAllHostsDown) {
+			err = e
+		}
+	}
+	return "", err
+}
+
+// pick 按权重随机选择主机组，返回选中的主机组及之后依次尝试的其他权重大于0的主机组
+func (s *Split) pick() []*splitGroup {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	total := 0
+	for _, g := range s.groups {
+		total += g.weight
+	}
+	order := make([]*splitGroup, 0, len(s.groups))
+	if total == 0 {
+		return order
+	}
+	n := rand.Intn(total)
+	chosen := -1
+	for i, g := range s.groups {
+		if n < g.weight {
+			chosen = i
+			order = append(order, g)
+			break
+		}
+		n -= g.weight
+	}
+	for i, g := range s.groups {
+		if i != chosen && g.weight > 0 {
+			order = append(order, g)
+		}
+	}
+	return order
+}
+
+func (s *Split) Inc(host string) {
+	s.group(host).bl.Inc(host)
+}
+
+func (s *Split) Done(host string) {
+	s.group(host).bl.Done(host)
+}
+
+func (s *Split) Observe(host string, latency time.Duration) {
+	s.group(host).bl.Observe(host, latency)
+}
+
+// SetWeight 设置主机在组内的权重
+func (s *Split) SetWeight(host string, weight int) error {
+	s.mux.RLock()
+	g, ok := s.hostGroup[host]
+	s.mux.RUnlock()
+	if !ok {
+		return ErrHostNotFound
+	}
+	return g.bl.SetWeight(host, weight)
+}
+
+// SetGroupWeight 运行时调整主机组的流量权重，例如逐步将canary的流量从5%增加到100%
+func (s *Split) SetGroupWeight(name string, weight int) error {
+	if weight < 0 {
+		return ErrInvalidWeight
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var target *splitGroup
+	total := weight
+	for _, g := range s.groups {
+		if g.name == name {
+			target = g
+			continue
+		}
+		total += g.weight
+	}
+	if target == nil {
+		return ErrGroupNotFound
+	}
+	if total == 0 {
+		return ErrNoGroupWeight
+	}
+	target.weight = weight
+	return nil
+}
+
+// Groups 返回各主机组的流量权重快照
+func (s *Split) Groups() []GroupStat {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	total := 0
+	for _, g := range s.groups {
+		total += g.weight
+	}
+	stats := make([]GroupStat, 0, len(s.groups))
+	for _, g := range s.groups {
+		stat := GroupStat{Name: g.name, Weight: g.weight}
+		if total > 0 {
+			stat.Percent = float64(g.weight) * 100 / float64(total)
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// Stats 返回所有主机组中主机状态的快照，Group为主机所属的主机组
+func (s *Split) Stats() []HostStat {
+	s.mux.RLock()
+	groups := append([]*splitGroup(nil), s.groups...)
+	s.mux.RUnlock()
+	var stats []HostStat
+	for _, g := range groups {
+		for _, stat := range g.bl.Stats() {
+			stat.Group = g.name
+			stats = append(stats, stat)
+		}
+	}
+	return stats
+}
+
+func (s *Split) Drain(host string) {
+	s.group(host).bl.Drain(host)
+}
+
+func (s *Split) Undrain(host string) {
+	s.group(host).bl.Undrain(host)
+}
@@ -0,0 +1,62 @@
+package balancer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSplit_Balance(t *testing.T) {
+	s, err := NewSplit(RoundRobinBalancer, []SplitGroup{
+		{Name: "stable", Weight: 95, Hosts: []string{"a", "b"}},
+		{Name: "canary", Weight: 5, Hosts: []string{"c"}},
+	})
+	assert.NoError(t, err)
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		host, err := s.Balance("")
+		assert.NoError(t, err)
+		counts[host]++
+	}
+	assert.InDelta(t, 500, counts["c"], 150)
+	assert.InDelta(t, counts["a"], counts["b"], 10)
+
+	//逐步增加灰度主机组的流量
+	assert.NoError(t, s.SetGroupWeight("canary", 100))
+	assert.NoError(t, s.SetGroupWeight("stable", 0))
+	for i := 0; i < 10; i++ {
+		host, _ := s.Balance("")
+		assert.Equal(t, "c", host)
+	}
+	assert.Equal(t, []GroupStat{{Name: "stable", Weight: 0, Percent: 0}, {Name: "canary", Weight: 100, Percent: 100}}, s.Groups())
+
+	assert.ErrorIs(t, s.SetGroupWeight("canary", 0), ErrNoGroupWeight)
+	assert.ErrorIs(t, s.SetGroupWeight("missing", 1), ErrGroupNotFound)
+	assert.ErrorIs(t, s.SetGroupWeight("canary", -1), ErrInvalidWeight)
+}
+
+func TestSplit_Fallback(t *testing.T) {
+	s, err := NewSplit(RoundRobinBalancer, []SplitGroup{
+		{Name: "stable", Weight: 50, Hosts: []string{"a"}},
+		{Name: "canary", Weight: 50, Hosts: []string{"c"}},
+	})
+	assert.NoError(t, err)
+
+	//主机组没有可用主机时使用其他主机组，恢复后回到原来的主机组
+	s.Remove("c")
+	for i := 0; i < 20; i++ {
+		host, err := s.Balance("")
+		assert.NoError(t, err)
+		assert.Equal(t, "a", host)
+	}
+	s.Add("c")
+	stats := s.Stats()
+	assert.Len(t, stats, 2)
+	assert.Equal(t, "canary", stats[1].Group)
+	assert.Equal(t, "c", stats[1].Name)
+
+	s.Drain("a")
+	s.Drain("c")
+	_, err = s.Balance("")
+	assert.ErrorIs(t, err, ErrAllHostsDown)
+}
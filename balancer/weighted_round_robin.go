@@ -0,0 +1,248 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+WeightedRoundRobin 平滑加权轮询算法(nginx)：每次选择时所有主机的当前权重加上各自的有效权重，
+选择当前权重最大的主机，并将其当前权重减去总权重，使得短时间内的请求也能按权重均匀分布，而不会集中在权重大的主机上
+*/
+type WeightedRoundRobin struct {
+	mux     sync.Mutex
+	hosts   []*weightedHost
+	hostMap map[string]*weightedHost
+	//removed 已删除主机的权重，健康检查或被动健康检查摘除后重新添加时恢复，不重置为默认权重
+	removed map[string]int
+	//slowStart 新添加的主机权重从slowStartMinPercent线性增加到配置权重的时长，为0时不开启
+	slowStart time.Duration
+	now       func() time.Time
+	drainSet
+}
+
+// SlowStartSetter 支持慢启动的负载均衡器，新添加(或恢复后重新添加)的主机在慢启动时长内逐渐增加分配的请求
+type SlowStartSetter interface {
+	SetSlowStart(time.Duration)
+}
+
+const (
+	//slowStartScale 慢启动时有效权重放大的倍数，使权重为1的主机也可以按比例分配请求
+	slowStartScale = 100
+	//slowStartMinPercent 慢启动开始时主机的权重占配置权重的百分比
+	slowStartMinPercent = 10
+)
+
+//weightedHost 加权主机
+type weightedHost struct {
+	name string
+	//weight 配置的权重
+	weight int
+	//effective 有效权重
+	effective int
+	//current 当前权重
+	current int
+	//added 通过Add添加的时间，创建负载均衡器时的主机为零值，不参与慢启动
+	added time.Time
+}
+
+//defaultWeight 未配置权重时主机的默认权重
+const defaultWeight = 1
+
+func init() {
+	factories[WeightedRoundRobinBalancer] = func(hosts []string) Balancer {
+		return NewWeightedRoundRobin(hosts, nil)
+	}
+	weightedFactories[WeightedRoundRobinBalancer] = NewWeightedRoundRobin
+}
+
+//NewWeightedRoundRobin 创建平滑加权轮询负载均衡器，weights与hosts一一对应，未配置的主机权重默认为1
+func NewWeightedRoundRobin(hosts []string, weights []int) Balancer {
+	w := &WeightedRoundRobin{
+		hosts:   []*weightedHost{},
+		hostMap: make(map[string]*weightedHost),
+		removed: make(map[string]int),
+		now:     time.Now,
+	}
+	for i, h := range hosts {
+		weight := defaultWeight
+		if i < len(weights) && weights[i] > 0 {
+			weight = weights[i]
+		}
+		w.add(h, weight)
+	}
+	return w
+}
+
+// Add 添加主机，删除后重新添加的主机恢复删除前的权重，其他主机权重默认为1
+func (w *WeightedRoundRobin) Add(host string) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if _, ok := w.hostMap[host]; ok {
+		return
+	}
+	weight, ok := w.removed[host]
+	if !ok {
+		weight = defaultWeight
+	}
+	delete(w.removed, host)
+	w.add(host, weight)
+	w.hostMap[host].added = w.now()
+}
+
+func (w *WeightedRoundRobin) add(host string, weight int) {
+	if _, ok := w.hostMap[host]; ok {
+		return
+	}
+	h := &weightedHost{name: host, weight: weight, effective: weight}
+	w.hosts = append(w.hosts, h)
+	w.hostMap[host] = h
+}
+
+// Remove 删除主机，删除后立即不再参与轮询，保留主机的权重用于重新添加
+func (w *WeightedRoundRobin) Remove(host string) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	h, ok := w.hostMap[host]
+	if !ok {
+		return
+	}
+	w.removed[host] = h.weight
+	delete(w.hostMap, host)
+	for i, h := range w.hosts {
+		if h.name == host {
+			w.hosts = append(w.hosts[:i], w.hosts[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetSlowStart 设置慢启动时长，之后通过Add添加的主机权重在该时长内从配置权重的10%线性增加到配置权重
+func (w *WeightedRoundRobin) SetSlowStart(d time.Duration) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.slowStart = d
+}
+
+// rampedWeight 主机当前的有效权重，放大slowStartScale倍，处于慢启动中的主机按已添加的时长折算
+func (w *WeightedRoundRobin) rampedWeight(h *weightedHost, now time.Time) int {
+	weight := h.effective * slowStartScale
+	if w.slowStart <= 0 || h.added.IsZero() {
+		return weight
+	}
+	elapsed := now.Sub(h.added)
+	if elapsed >= w.slowStart {
+		return weight
+	}
+	percent := slowStartMinPercent + int(int64(100-slowStartMinPercent)*int64(elapsed)/int64(w.slowStart))
+	if ramped := weight * percent / 100; ramped > 0 {
+		return ramped
+	}
+	return 1
+}
+
+// Balance 选择当前权重最大的主机
+func (w *WeightedRoundRobin) Balance(_ string) (string, error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	var best *weightedHost
+	total := 0
+	now := w.now()
+	for _, h := range w.hosts {
+		if h.effective <= 0 || w.isDrained(h.name) {
+			continue
+		}
+		weight := w.rampedWeight(h, now)
+		h.current += weight
+		total += weight
+		if best == nil || h.current > best.current {
+			best = h
+		}
+	}
+	if best == nil {
+		return "", noHost(len(w.hosts))
+	}
+	best.current -= total
+	return best.name, nil
+}
+
+func (w *WeightedRoundRobin) Inc(_ string) {}
+
+func (w *WeightedRoundRobin) Done(_ string) {}
+
+// Observe 该算法不使用响应延迟
+func (w *WeightedRoundRobin) Observe(_ string, _ time.Duration) {}
+
+// SetWeight 设置主机权重，权重为0时不再分配请求
+func (w *WeightedRoundRobin) SetWeight(host string, weight int) error {
+	if weight < 0 {
+		return ErrInvalidWeight
+	}
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	h, ok := w.hostMap[host]
+	if !ok {
+		return ErrHostNotFound
+	}
+	h.weight = weight
+	h.effective = weight
+	if weight == 0 {
+		h.current = 0
+	}
+	return nil
+}
+
+// smoothSchedule 按平滑加权轮询算法生成一轮完整的选择顺序，权重会先除以最大公约数以缩短一轮的长度
+func smoothSchedule(hosts []string, weights []int) []string {
+	divisor := 0
+	for _, weight := range weights {
+		divisor = gcd(divisor, weight)
+	}
+	if divisor == 0 {
+		return []string{}
+	}
+
+	total := 0
+	effective := make([]int, len(weights))
+	for i, weight := range weights {
+		effective[i] = weight / divisor
+		total += effective[i]
+	}
+	current := make([]int, len(weights))
+	schedule := make([]string, 0, total)
+	for n := 0; n < total; n++ {
+		best := -1
+		for i := range hosts {
+			if effective[i] == 0 {
+				continue
+			}
+			current[i] += effective[i]
+			if best == -1 || current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, hosts[best])
+	}
+	return schedule
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Stats 返回各主机状态的快照，该算法不统计负载，权重为0的主机不参与负载均衡
+func (w *WeightedRoundRobin) Stats() []HostStat {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	stats := make([]HostStat, 0, len(w.hosts))
+	for _, h := range w.hosts {
+		drained := w.isDrained(h.name)
+		stats = append(stats, HostStat{Name: h.name, Alive: h.weight > 0 && !drained, Drained: drained})
+	}
+	return stats
+}
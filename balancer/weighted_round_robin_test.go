@@ -0,0 +1,157 @@
+package balancer
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWeightedRoundRobin_Balance(t *testing.T) {
+	wrr := NewWeightedRoundRobin([]string{"a", "b", "c"}, []int{5, 1, 1})
+
+	//nginx平滑加权轮询的选择顺序
+	expected := []string{"a", "a", "b", "a", "c", "a", "a"}
+	for i := 0; i < 3; i++ {
+		for _, e := range expected {
+			host, err := wrr.Balance("")
+			assert.NoError(t, err)
+			assert.Equal(t, e, host)
+		}
+	}
+}
+
+func TestWeightedRoundRobin_DefaultWeight(t *testing.T) {
+	wrr := NewWeightedRoundRobin([]string{"a", "b"}, nil)
+
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		host, _ := wrr.Balance("")
+		counts[host]++
+	}
+	assert.Equal(t, 50, counts["a"])
+	assert.Equal(t, 50, counts["b"])
+}
+
+func TestWeightedRoundRobin_Remove(t *testing.T) {
+	wrr := NewWeightedRoundRobin([]string{"a", "b"}, []int{3, 1})
+
+	wrr.Remove("a")
+	for i := 0; i < 10; i++ {
+		host, err := wrr.Balance("")
+		assert.NoError(t, err)
+		assert.Equal(t, "b", host)
+	}
+
+	wrr.Remove("b")
+	_, err := wrr.Balance("")
+	assert.Equal(t, NoHostError, err)
+}
+
+func TestWeightedRoundRobin_RemoveKeepsWeight(t *testing.T) {
+	wrr := NewWeightedRoundRobin([]string{"a", "b"}, []int{9, 1})
+	count := func() int {
+		n := 0
+		for i := 0; i < 100; i++ {
+			if host, _ := wrr.Balance(""); host == "a" {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Equal(t, 90, count())
+
+	//健康检查摘除后恢复的主机保持配置的权重
+	wrr.Remove("a")
+	wrr.Add("a")
+	assert.Equal(t, 90, count())
+
+	//通过SetWeight调整的权重同样保留
+	assert.NoError(t, wrr.SetWeight("a", 3))
+	wrr.Remove("a")
+	wrr.Add("a")
+	assert.Equal(t, 75, count())
+
+	//新添加的主机使用默认权重
+	wrr.Add("c")
+	counts := make(map[string]int)
+	for i := 0; i < 500; i++ {
+		host, _ := wrr.Balance("")
+		counts[host]++
+	}
+	assert.Equal(t, 300, counts["a"])
+	assert.Equal(t, 100, counts["b"])
+	assert.Equal(t, 100, counts["c"])
+}
+
+func TestWeightedRoundRobin_SetWeight(t *testing.T) {
+	wrr := NewWeightedRoundRobin([]string{"a", "b"}, nil)
+
+	assert.Equal(t, ErrInvalidWeight, wrr.SetWeight("a", -1))
+	assert.Equal(t, ErrHostNotFound, wrr.SetWeight("c", 1))
+	assert.NoError(t, wrr.SetWeight("a", 3))
+
+	counts := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		host, _ := wrr.Balance("")
+		counts[host]++
+	}
+	assert.Equal(t, 300, counts["a"])
+	assert.Equal(t, 100, counts["b"])
+
+	assert.NoError(t, wrr.SetWeight("a", 0))
+	for i := 0; i < 10; i++ {
+		host, _ := wrr.Balance("")
+		assert.Equal(t, "b", host)
+	}
+}
+
+func TestWeightedRoundRobin_AllHostsDown(t *testing.T) {
+	wrr := NewWeightedRoundRobin([]string{"a", "b"}, nil)
+
+	assert.NoError(t, wrr.SetWeight("a", 0))
+	assert.NoError(t, wrr.SetWeight("b", 0))
+	_, err := wrr.Balance("")
+	assert.True(t, errors.Is(err, ErrAllHostsDown))
+	assert.False(t, errors.Is(err, ErrNoHost))
+
+	wrr.Remove("a")
+	wrr.Remove("b")
+	_, err = wrr.Balance("")
+	assert.True(t, errors.Is(err, ErrNoHost))
+	assert.True(t, errors.Is(err, NoHostError))
+}
+
+func TestWeightedRoundRobin_SlowStart(t *testing.T) {
+	now := time.Now()
+	wrr := NewWeightedRoundRobin([]string{"a"}, nil).(*WeightedRoundRobin)
+	wrr.now = func() time.Time { return now }
+	wrr.SetSlowStart(10 * time.Second)
+	wrr.Add("b")
+
+	share := func() int {
+		counts := make(map[string]int)
+		for i := 0; i < 1000; i++ {
+			host, err := wrr.Balance("")
+			assert.NoError(t, err)
+			counts[host]++
+		}
+		return counts["b"]
+	}
+	//刚添加的主机只分配少量请求，随时间线性增加，慢启动结束后与其他主机平分
+	var shares []int
+	for _, elapsed := range []time.Duration{0, 3 * time.Second, 6 * time.Second, 10 * time.Second} {
+		now = wrr.hostMap["b"].added.Add(elapsed)
+		shares = append(shares, share())
+	}
+	assert.InDelta(t, 1000*10/110, shares[0], 2)
+	for i := 1; i < len(shares); i++ {
+		assert.Greater(t, shares[i], shares[i-1])
+	}
+	assert.Equal(t, 500, shares[len(shares)-1])
+
+	//创建负载均衡器时的主机不参与慢启动
+	wrr = NewWeightedRoundRobin([]string{"a", "b"}, nil).(*WeightedRoundRobin)
+	wrr.SetSlowStart(10 * time.Second)
+	assert.Equal(t, 500, share())
+}
@@ -0,0 +1,137 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// State 熔断器状态
+type State int
+
+const (
+	// Closed 关闭状态，请求正常通过
+	Closed State = iota
+	// Open 打开状态，拒绝所有请求
+	Open
+	// HalfOpen 半开状态，只允许一个探测请求通过
+	HalfOpen
+)
+
+// String 返回熔断器状态的名称
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Breaker 熔断器：window 时间内失败 failureThreshold 次后打开，打开 openTimeout 后进入半开状态，
+// 半开状态下只允许一个探测请求，探测成功后关闭，失败后重新打开
+type Breaker struct {
+	mux              sync.Mutex
+	failureThreshold uint
+	window           time.Duration
+	openTimeout      time.Duration
+
+	state State
+	// failures 当前统计窗口内的失败次数
+	failures uint
+	// windowStart 当前统计窗口的开始时间
+	windowStart time.Time
+	// openedAt 熔断器打开的时间
+	openedAt time.Time
+	// probeAt 半开状态下探测请求的开始时间，为零值时表示没有进行中的探测请求
+	probeAt time.Time
+	// now 获取当前时间，便于测试
+	now func() time.Time
+}
+
+// New 创建熔断器
+func New(failureThreshold uint, window, openTimeout time.Duration) *Breaker {
+	return &Breaker{
+		failureThreshold: failureThreshold,
+		window:           window,
+		openTimeout:      openTimeout,
+		now:              time.Now,
+	}
+}
+
+// Allow 判断是否允许请求通过，打开状态到期后进入半开状态并允许一个探测请求通过
+func (b *Breaker) Allow() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := b.now()
+	switch b.state {
+	case Open:
+		if now.Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = HalfOpen
+		b.probeAt = now
+		return true
+	case HalfOpen:
+		//探测请求超过openTimeout仍未返回结果时，允许新的探测请求，避免一直停留在半开状态
+		if !b.probeAt.IsZero() && now.Sub(b.probeAt) < b.openTimeout {
+			return false
+		}
+		b.probeAt = now
+		return true
+	}
+	return true
+}
+
+// Success 记录一次成功的请求，半开状态下关闭熔断器
+func (b *Breaker) Success() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.state == HalfOpen {
+		b.reset(Closed)
+	}
+}
+
+// Failure 记录一次失败的请求，达到失败次数阈值或半开状态下探测失败时打开熔断器
+func (b *Breaker) Failure() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := b.now()
+	switch b.state {
+	case HalfOpen:
+		b.open(now)
+	case Closed:
+		if b.failures == 0 || now.Sub(b.windowStart) > b.window {
+			b.failures = 0
+			b.windowStart = now
+		}
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.open(now)
+		}
+	}
+}
+
+// State 获取熔断器当前状态
+func (b *Breaker) State() State {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.openTimeout {
+		return HalfOpen
+	}
+	return b.state
+}
+
+func (b *Breaker) open(now time.Time) {
+	b.reset(Open)
+	b.openedAt = now
+}
+
+func (b *Breaker) reset(state State) {
+	b.state = state
+	b.failures = 0
+	b.windowStart = time.Time{}
+	b.probeAt = time.Time{}
+}
@@ -0,0 +1,69 @@
+package breaker
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestBreaker(now *time.Time) *Breaker {
+	b := New(3, time.Second, 5*time.Second)
+	b.now = func() time.Time { return *now }
+	return b
+}
+
+func TestBreaker_OpensAfterThreshold(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTestBreaker(&now)
+
+	b.Failure()
+	b.Failure()
+	assert.Equal(t, Closed, b.State())
+	assert.True(t, b.Allow())
+
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.False(t, b.Allow())
+}
+
+func TestBreaker_WindowResetsFailures(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTestBreaker(&now)
+
+	b.Failure()
+	b.Failure()
+	now = now.Add(2 * time.Second)
+	b.Failure()
+	assert.Equal(t, Closed, b.State())
+}
+
+func TestBreaker_HalfOpenProbe(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTestBreaker(&now)
+	for i := 0; i < 3; i++ {
+		b.Failure()
+	}
+
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, HalfOpen, b.State())
+	//半开状态只允许一个探测请求
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	//探测失败后重新打开
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.False(t, b.Allow())
+
+	now = now.Add(5 * time.Second)
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, Closed, b.State())
+	assert.True(t, b.Allow())
+}
+
+func TestState_String(t *testing.T) {
+	assert.Equal(t, "closed", Closed.String())
+	assert.Equal(t, "open", Open.String())
+	assert.Equal(t, "half-open", HalfOpen.String())
+}
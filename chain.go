@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"proxy/config"
+	"proxy/middleware"
+	"proxy/util/logging"
+)
+
+//middlewareFactory 全局中间件的创建方法
+type middlewareFactory struct {
+	//enabled 根据配置判断中间件是否开启
+	enabled func(cfg *config.Config) bool
+	//create 根据配置创建中间件，中间件持有需要关闭的资源(例如采样记录的输出文件)时同时返回io.Closer，为nil时无需关闭
+	create func(cfg *config.Config) (mux.MiddlewareFunc, io.Closer, error)
+}
+
+//panicsMiddleware 异常恢复中间件的名称，未在middlewares中配置时始终位于最外层
+const panicsMiddleware = "panics"
+
+//defaultMiddlewareOrder 未配置middlewares时全局中间件的顺序
+//先限流再占用并发名额，避免被限流的请求占满并发
+var defaultMiddlewareOrder = []string{
+	panicsMiddleware, "request_id", "access_log", "metrics", "max_url_length", "compression", "rate_limit", "max_allowed", "sampling",
+}
+
+//always 总是开启的中间件
+func always(*config.Config) bool {
+	return true
+}
+
+//middlewareRegistry 可以通过middlewares配置顺序的全局中间件
+var middlewareRegistry = map[string]middlewareFactory{
+	panicsMiddleware: {
+		enabled: always,
+		create: func(cfg *config.Config) (mux.MiddlewareFunc, io.Closer, error) {
+			return middleware.PanicsHandlingMiddleware(cfg.Debug), nil, nil
+		},
+	},
+	"request_id": {
+		enabled: always,
+		create: func(cfg *config.Config) (mux.MiddlewareFunc, io.Closer, error) {
+			return middleware.RequestIDMiddleware, nil, nil
+		},
+	},
+	"access_log": {
+		enabled: func(cfg *config.Config) bool { return cfg.AccessLog },
+		create: func(cfg *config.Config) (mux.MiddlewareFunc, io.Closer, error) {
+			return middleware.AccessLogMiddleware(cfg.AccessLogFormat), nil, nil
+		},
+	},
+	"metrics": {
+		enabled: func(cfg *config.Config) bool { return cfg.Metrics.Enabled },
+		create: func(cfg *config.Config) (mux.MiddlewareFunc, io.Closer, error) {
+			return middleware.MetricsMiddleware, nil, nil
+		},
+	},
+	"max_url_length": {
+		enabled: func(cfg *config.Config) bool { return cfg.MaxURLLength > 0 },
+		create: func(cfg *config.Config) (mux.MiddlewareFunc, io.Closer, error) {
+			return middleware.MaxURLLengthMiddleware(cfg.MaxURLLength), nil, nil
+		},
+	},
+	"compression": {
+		enabled: func(cfg *config.Config) bool { return cfg.Compression.Enabled },
+		create: func(cfg *config.Config) (mux.MiddlewareFunc, io.Closer, error) {
+			return middleware.CompressionMiddleware(cfg.Compression.MinSize), nil, nil
+		},
+	},
+	"rate_limit": {
+		enabled: func(cfg *config.Config) bool { return cfg.RateLimit.RPS > 0 },
+		create: func(cfg *config.Config) (mux.MiddlewareFunc, io.Closer, error) {
+			return middleware.RateLimitMiddleware(cfg.RateLimit.RPS, cfg.RateLimit.Burst), nil, nil
+		},
+	},
+	"max_allowed": {
+		enabled: func(cfg *config.Config) bool { return cfg.MaxAllowed > 0 },
+		create: func(cfg *config.Config) (mux.MiddlewareFunc, io.Closer, error) {
+			return middleware.MaxAllowedMiddleware(cfg.MaxAllowed), nil, nil
+		},
+	},
+	"sampling": {
+		enabled: func(cfg *config.Config) bool { return cfg.Sampling.Rate > 0 },
+		create: func(cfg *config.Config) (mux.MiddlewareFunc, io.Closer, error) {
+			sink, err := middleware.NewFileSink(cfg.Sampling.File)
+			if err != nil {
+				return nil, nil, err
+			}
+			mw, closer := middleware.SamplingMiddleware(cfg.Sampling.Rate, cfg.Sampling.MaxBodyBytes, cfg.Sampling.RedactFields, sink)
+			return mw, closer, nil
+		},
+	},
+}
+
+//middlewareOrder 获取全局中间件的顺序，未配置时使用默认顺序
+//配置的名称必须在middlewareRegistry中且不能重复，未配置panics时将其放在最外层
+func middlewareOrder(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return defaultMiddlewareOrder, nil
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, ok := middlewareRegistry[name]; !ok {
+			return nil, fmt.Errorf("middlewares配置了不支持的中间件 \"%s\"", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("middlewares中的中间件 \"%s\" 重复", name)
+		}
+		seen[name] = true
+	}
+	if !seen[panicsMiddleware] {
+		names = append([]string{panicsMiddleware}, names...)
+	}
+	return names, nil
+}
+
+//useMiddlewares 按配置的顺序为路由器添加已开启的全局中间件，第一个中间件位于最外层，返回中间件持有的需要关闭的资源
+//已开启但未在middlewares中配置的中间件不会生效，这里给出警告
+func useMiddlewares(router *mux.Router, cfg *config.Config) ([]io.Closer, error) {
+	order, err := middlewareOrder(cfg.Middlewares)
+	if err != nil {
+		return nil, err
+	}
+	var closers []io.Closer
+	listed := make(map[string]bool, len(order))
+	for _, name := range order {
+		listed[name] = true
+		factory := middlewareRegistry[name]
+		if !factory.enabled(cfg) {
+			continue
+		}
+		mw, closer, err := factory.create(cfg)
+		if err != nil {
+			closeAll(closers)
+			return nil, err
+		}
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+		router.Use(mw)
+	}
+	//request_id总是开启，未配置时认为是有意不使用
+	for _, name := range defaultMiddlewareOrder {
+		if !listed[name] && name != "request_id" && middlewareRegistry[name].enabled(cfg) {
+			logging.Warnf("中间件 %s 已开启但未在middlewares中配置，不会生效", name)
+		}
+	}
+	return closers, nil
+}
+
+//closeAll 关闭中间件持有的资源，关闭失败时记录日志
+func closeAll(closers []io.Closer) {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			logging.Errorf("关闭中间件资源失败: %s", err)
+		}
+	}
+}
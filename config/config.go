@@ -1,30 +1,342 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/jinzhu/configor"
+	"net"
+	"proxy/util/logging"
+	"strconv"
+	"strings"
 )
 
-const Algorithms string = "ip-hash|consistent-hash|p2c|random|round-robin|least-load|bounded"
+const Algorithms string = "ip-hash|consistent-hash|p2c|random|round-robin|least-load|bounded|weighted-round-robin|ewma|least-conn|maglev"
 
 type Config struct {
-	Port                int       `yaml:"port" default:"8080"`
-	Schema              string    `yaml:"schema" default:"http"`
-	MaxAllowed          uint      `yaml:"max_allowed" default:"100"`
-	CertKey             string    `yaml:"cert_key"`
-	CertCrt             string    `yaml:"cert_crt"`
-	HealthCheck         bool      `yaml:"health_check"`
-	HealthCheckInterval uint      `yaml:"health_check_interval"`
-	Routes              []Routing `json:"ReRoutes"`
+	Port                     int         `json:"port" yaml:"port" default:"8080"`
+	Schema                   string      `json:"schema" yaml:"schema" default:"http"`
+	MaxAllowed               uint        `json:"max_allowed" yaml:"max_allowed" default:"100"`
+	AdminPort                int         `json:"admin_port" yaml:"admin_port"`
+	AdminAddress             string      `json:"admin_address" yaml:"admin_address"`
+	AdminSocket              string      `json:"admin_socket" yaml:"admin_socket"`
+	CertKey                  string      `json:"cert_key" yaml:"cert_key"`
+	CertCrt                  string      `json:"cert_crt" yaml:"cert_crt"`
+	Listeners                []Listener  `json:"listeners" yaml:"listeners"`
+	TLS                      TLS         `json:"tls" yaml:"tls"`
+	HealthCheck              bool        `json:"health_check" yaml:"health_check"`
+	HealthCheckInterval      uint        `json:"health_check_interval" yaml:"health_check_interval"`
+	HealthCheckWarmup        uint        `json:"health_check_warmup" yaml:"health_check_warmup"`
+	HealthCheckMaxConcurrent uint        `json:"health_check_max_concurrent" yaml:"health_check_max_concurrent"`
+	PrecheckBackends         bool        `json:"precheck_backends" yaml:"precheck_backends"`
+	PrecheckFailFast         bool        `json:"precheck_fail_fast" yaml:"precheck_fail_fast"`
+	DrainTimeout             uint        `json:"drain_timeout" yaml:"drain_timeout"`
+	ShutdownTimeout          uint        `json:"shutdown_timeout" yaml:"shutdown_timeout" default:"30"`
+	Sampling                 Sampling    `json:"sampling" yaml:"sampling"`
+	RateLimit                RateLimit   `json:"rate_limit" yaml:"rate_limit"`
+	JWT                      JWT         `json:"jwt" yaml:"jwt"`
+	AccessLog                bool        `json:"access_log" yaml:"access_log"`
+	AccessLogFormat          string      `json:"access_log_format" yaml:"access_log_format" default:"json"`
+	MaxBodySize              int64       `json:"max_body_size" yaml:"max_body_size"`
+	MaxHeaderBytes           int         `json:"max_header_bytes" yaml:"max_header_bytes"`
+	MaxURLLength             int         `json:"max_url_length" yaml:"max_url_length"`
+	MaxConnsPerHost          uint        `json:"max_conns_per_host" yaml:"max_conns_per_host"`
+	Debug                    bool        `json:"debug" yaml:"debug"`
+	TraceRequests            bool        `json:"trace_requests" yaml:"trace_requests"`
+	TrustedProxies           []string    `json:"trusted_proxies" yaml:"trusted_proxies"`
+	Middlewares              []string    `json:"middlewares" yaml:"middlewares"`
+	Compression              Compression `json:"compression" yaml:"compression"`
+	Cache                    Cache       `json:"cache" yaml:"cache"`
+	Metrics                  Metrics     `json:"metrics" yaml:"metrics"`
+	Tracing                  Tracing     `json:"tracing" yaml:"tracing"`
+	Log                      Log         `json:"log" yaml:"log"`
+	Pprof                    Pprof       `json:"pprof" yaml:"pprof"`
+	Routes                   []Routing   `json:"ReRoutes" yaml:"ReRoutes"`
+}
+
+//Listener 代理的监听地址，配置多个时共用同一份路由，例如同时监听HTTP及HTTPS端口
+type Listener struct {
+	//Address 监听地址，例如":80"或"10.0.0.1:8080"
+	Address string `json:"address" yaml:"address"`
+	//Schema 监听模式，支持http和https，默认为http
+	Schema string `json:"schema" yaml:"schema"`
+	//CertCrt https模式的证书，为空时使用全局的cert_crt
+	CertCrt string `json:"cert_crt" yaml:"cert_crt"`
+	//CertKey https模式的私钥，为空时使用全局的cert_key
+	CertKey string `json:"cert_key" yaml:"cert_key"`
+	//RedirectHTTPS http监听地址是否将所有请求重定向到https监听地址，不再转发
+	RedirectHTTPS bool `json:"redirect_https" yaml:"redirect_https"`
+}
+
+//ServerListeners 获取代理的监听地址，未配置listeners时使用port、schema及证书配置的单个监听地址
+func (c *Config) ServerListeners() []Listener {
+	if len(c.Listeners) == 0 {
+		return []Listener{{Address: ":" + strconv.Itoa(c.Port), Schema: c.Schema, CertCrt: c.CertCrt, CertKey: c.CertKey}}
+	}
+	listeners := make([]Listener, 0, len(c.Listeners))
+	for _, l := range c.Listeners {
+		if l.Schema == "" {
+			l.Schema = "http"
+		}
+		if l.CertCrt == "" && l.CertKey == "" {
+			l.CertCrt, l.CertKey = c.CertCrt, c.CertKey
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners
+}
+
+//AdminListenAddress 获取管理接口的监听地址，未配置admin_address时只监听本机回环地址127.0.0.1
+//管理接口没有认证，可以修改路由的主机，需要对外提供时应配合网络访问控制使用
+func (c *Config) AdminListenAddress() string {
+	host := c.AdminAddress
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(c.AdminPort))
+}
+
+//hasHTTPS 判断是否有https模式的监听地址
+func (c *Config) hasHTTPS() bool {
+	for _, l := range c.ServerListeners() {
+		if l.Schema == "https" {
+			return true
+		}
+	}
+	return false
+}
+
+//ValidationListeners 验证监听地址不重复、模式正确，https模式配置了证书，重定向到https时存在https监听地址
+func (c *Config) ValidationListeners() error {
+	seen := make(map[string]bool, len(c.Listeners))
+	for _, l := range c.ServerListeners() {
+		if l.Address == "" {
+			return errors.New("listeners中的监听地址address不能为空")
+		}
+		if seen[l.Address] {
+			return fmt.Errorf("listeners中的监听地址 \"%s\" 重复", l.Address)
+		}
+		seen[l.Address] = true
+		if l.Schema != "http" && l.Schema != "https" {
+			return fmt.Errorf("监听地址 \"%s\" 的模式 \"%s\" 不正确", l.Address, l.Schema)
+		}
+		if l.Schema == "https" && (l.CertCrt == "" || l.CertKey == "") {
+			return fmt.Errorf("监听地址 \"%s\" 为https模式, 需要配置cert_crt和cert_key", l.Address)
+		}
+		if l.RedirectHTTPS && l.Schema != "http" {
+			return fmt.Errorf("监听地址 \"%s\" 的redirect_https只适用于http模式", l.Address)
+		}
+		if l.RedirectHTTPS && !c.hasHTTPS() {
+			return fmt.Errorf("监听地址 \"%s\" 配置了redirect_https, 但没有https模式的监听地址", l.Address)
+		}
+	}
+	return nil
+}
+
+//TLS https监听地址的TLS配置，对所有https监听地址生效
+type TLS struct {
+	//MinVersion 最低的TLS版本，支持1.0、1.1、1.2、1.3，默认为1.2
+	MinVersion string `json:"min_version" yaml:"min_version" default:"1.2"`
+	//CipherSuites TLS 1.2及以下版本允许的加密套件名称(例如TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)，为空时使用Go的默认值
+	CipherSuites []string `json:"cipher_suites" yaml:"cipher_suites"`
+	//ClientCAFile 校验客户端证书的CA证书文件(PEM格式)
+	ClientCAFile string `json:"client_ca_file" yaml:"client_ca_file"`
+	//ClientAuth 客户端证书认证模式，支持none、request、require、verify_if_given、require_and_verify，默认为none
+	ClientAuth string `json:"client_auth" yaml:"client_auth" default:"none"`
+}
+
+//tlsVersions 支持配置的TLS版本
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//clientAuthTypes 支持配置的客户端证书认证模式
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+//MinTLSVersion 获取最低的TLS版本，未配置时为TLS 1.2
+func (t *TLS) MinTLSVersion() (uint16, error) {
+	if t.MinVersion == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[t.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("tls.min_version \"%s\" 不正确, 支持1.0、1.1、1.2、1.3", t.MinVersion)
+	}
+	return version, nil
+}
+
+//CipherSuiteIDs 获取配置的加密套件，只允许使用Go认为安全的加密套件，未配置时返回nil
+func (t *TLS) CipherSuiteIDs() ([]uint16, error) {
+	if len(t.CipherSuites) == 0 {
+		return nil, nil
+	}
+	supported := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		id, ok := supported[name]
+		if !ok {
+			return nil, fmt.Errorf("tls.cipher_suites中的加密套件 \"%s\" 不支持或不安全", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+//ClientAuthType 获取客户端证书认证模式，未配置时不要求客户端证书
+func (t *TLS) ClientAuthType() (tls.ClientAuthType, error) {
+	if t.ClientAuth == "" {
+		return tls.NoClientCert, nil
+	}
+	authType, ok := clientAuthTypes[t.ClientAuth]
+	if !ok {
+		return 0, fmt.Errorf("tls.client_auth \"%s\" 不正确, 支持none、request、require、verify_if_given、require_and_verify", t.ClientAuth)
+	}
+	return authType, nil
+}
+
+//ValidationTLS 验证TLS版本、加密套件及客户端证书认证模式，校验客户端证书时需要配置CA证书文件
+//路由开启客户端证书认证时，需要在TLS握手时校验客户端证书
+func (c *Config) ValidationTLS() error {
+	if _, err := c.TLS.MinTLSVersion(); err != nil {
+		return err
+	}
+	if _, err := c.TLS.CipherSuiteIDs(); err != nil {
+		return err
+	}
+	authType, err := c.TLS.ClientAuthType()
+	if err != nil {
+		return err
+	}
+	verify := authType == tls.VerifyClientCertIfGiven || authType == tls.RequireAndVerifyClientCert
+	if verify && c.TLS.ClientCAFile == "" {
+		return fmt.Errorf("tls.client_auth为%s时需要配置client_ca_file", c.TLS.ClientAuth)
+	}
+	for _, r := range c.Routes {
+		if r.ClientCert != nil && (!verify || !c.hasHTTPS()) {
+			return fmt.Errorf("路由 \"%s\" 配置了ClientCert, 需要https监听地址且tls.client_auth为verify_if_given或require_and_verify", r.UpstreamPathTemplate)
+		}
+	}
+	return nil
+}
+
+//Sampling 流量采样配置，按比例将完整的请求及响应异步写入采样文件用于离线分析
+type Sampling struct {
+	//Rate 采样比例(0~1)，为0时不采样
+	Rate float64 `json:"rate" yaml:"rate"`
+	//File 采样记录输出文件
+	File string `json:"file" yaml:"file" default:"./logs/sample.log"`
+	//MaxBodyBytes 请求及响应内容最多截取的字节数
+	MaxBodyBytes int `json:"max_body_bytes" yaml:"max_body_bytes" default:"4096"`
+	//RedactFields 需要脱敏的字段，作用于请求头、查询参数、JSON及表单内容
+	RedactFields []string `json:"redact_fields" yaml:"redact_fields"`
+}
+
+//RateLimit 按客户端IP限流配置
+type RateLimit struct {
+	//RPS 每个客户端每秒允许的请求数，为0时不限流
+	RPS int `json:"rps" yaml:"rps"`
+	//Burst 每个客户端允许的突发请求数，为0时等于RPS
+	Burst int `json:"burst" yaml:"burst"`
+}
+
+//Compression 响应压缩配置
+type Compression struct {
+	//Enabled 是否根据客户端的Accept-Encoding使用gzip或deflate压缩响应
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	//MinSize 压缩的最小响应大小(字节)，小于该大小的响应不压缩
+	MinSize int `json:"min_size" yaml:"min_size" default:"1024"`
+}
+
+//Cache 响应缓存配置，在内存中缓存GET请求的响应
+type Cache struct {
+	//Enabled 是否开启响应缓存
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	//MaxEntries 缓存的最大响应数，超过时淘汰最久未使用的响应
+	MaxEntries int `json:"max_entries" yaml:"max_entries" default:"1000"`
+	//DefaultTTL 响应未通过Cache-Control指定max-age时的缓存时间(秒)
+	DefaultTTL uint `json:"default_ttl" yaml:"default_ttl" default:"60"`
+	//MaxBodySize 可缓存的最大响应内容字节数，超过的响应不缓存
+	MaxBodySize int `json:"max_body_size" yaml:"max_body_size" default:"1048576"`
+}
+
+//Metrics Prometheus监控指标配置
+type Metrics struct {
+	//Enabled 是否开启监控指标
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	//Address 监控指标的独立监听地址，例如":9100"，为空时在代理端口上提供
+	Address string `json:"address" yaml:"address"`
+	//Path 监控指标的访问路径
+	Path string `json:"path" yaml:"path" default:"/metrics"`
+}
+
+//Tracing OpenTelemetry链路追踪配置，默认不开启
+type Tracing struct {
+	//Enabled 是否开启链路追踪
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	//Endpoint OTLP/HTTP导出器的地址
+	Endpoint string `json:"endpoint" yaml:"endpoint" default:"localhost:4318"`
+	//ServiceName 上报的服务名称
+	ServiceName string `json:"service_name" yaml:"service_name" default:"proxy"`
+	//Insecure 是否使用HTTP(不使用TLS)连接导出器
+	Insecure bool `json:"insecure" yaml:"insecure"`
+}
+
+//Pprof 性能分析接口配置，开启后在管理端口上提供/debug/pprof/，不会在代理端口上提供
+type Pprof struct {
+	//Enabled 是否开启，默认不开启
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	//Allow 允许访问的客户端IP或CIDR，为空时只允许本机访问
+	Allow []string `json:"allow" yaml:"allow"`
+	//Deny 拒绝访问的客户端IP或CIDR，优先于Allow
+	Deny []string `json:"deny" yaml:"deny"`
+}
+
+//Log 日志配置
+type Log struct {
+	//Level 日志级别，支持DEBUG/INFO/WARN/ERROR，默认为INFO
+	Level string `json:"level" yaml:"level" default:"INFO"`
+	//Format 日志格式，支持text及json，默认为text
+	Format string `json:"format" yaml:"format" default:"text"`
+	//Output 日志输出，stdout或stderr为控制台，其他值为日志文件路径(按小时分割)，为空时输出到控制台及./logs下的日志文件
+	Output string `json:"output" yaml:"output"`
+}
+
+//JWT 认证配置，配置了Key时所有路由都需要通过JWT认证
+type JWT struct {
+	//Algorithm 签名算法，支持HS256/HS384/HS512/RS256/RS384/RS512
+	Algorithm string `json:"algorithm" yaml:"algorithm" default:"HS256"`
+	//Key HMAC算法为密钥，RSA算法为PEM格式的公钥，为空时不开启JWT认证
+	Key string `json:"key" yaml:"key"`
+	//RequiredClaims 令牌中必须包含且值相等的声明，对所有路由生效
+	RequiredClaims map[string]string `json:"required_claims" yaml:"required_claims"`
+	//PublicPaths 不需要认证的路径前缀
+	PublicPaths []string `json:"public_paths" yaml:"public_paths"`
 }
 
 func Read(isValidation bool,files ...string) (*Config, error) {
 	if files == nil || len(files) == 0 {
 		return nil, fmt.Errorf("无效的配置文件路径")
 	}
+	//先替换配置文件中的环境变量占位符再解析
+	files, cleanup, err := expandEnvFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
 	cfg := &Config{}
-	err := configor.Load(cfg, files...)
+	err = configor.Load(cfg, files...)
 	if err != nil {
 		return nil, err
 	}
@@ -42,10 +354,130 @@ func (c *Config) Validation() error {
 		return errors.New("HTTPS代理需要ssl_certificate_key和ssl_certificate")
 	}
 	if len(c.Routes) == 0 {
-		return errors.New("路由配置不正确，至少要配置一个路由")
+		return errors.New("路由配置不正确，至少要配置一个路由，请检查路由配置文件中的ReRoutes是否为空或解析失败")
+	}
+	if err := c.ValidationListeners(); err != nil {
+		return err
+	}
+	if err := c.ValidationTLS(); err != nil {
+		return err
+	}
+	for _, r := range c.Routes {
+		if len(r.SNIHosts) > 0 && !c.hasHTTPS() {
+			return fmt.Errorf("路由 \"%s\" 配置了SNIHosts, SNI匹配仅适用于https模式", r.UpstreamPathTemplate)
+		}
+	}
+	if err := c.ValidationRoutes(); err != nil {
+		return err
+	}
+	if c.Sampling.Rate < 0 || c.Sampling.Rate > 1 {
+		return errors.New("采样比例必须在0到1之间")
+	}
+	if c.MaxHeaderBytes < 0 || c.MaxURLLength < 0 {
+		return errors.New("max_header_bytes和max_url_length不能为负数")
+	}
+	if c.RateLimit.RPS < 0 || c.RateLimit.Burst < 0 {
+		return errors.New("限流配置rps和burst不能为负数")
+	}
+	if c.Cache.MaxEntries < 0 || c.Cache.MaxBodySize < 0 {
+		return errors.New("响应缓存配置max_entries和max_body_size不能为负数")
+	}
+	if c.MaxBodySize < 0 {
+		return errors.New("请求内容大小限制max_body_size不能为负数")
+	}
+	if c.AccessLog && c.AccessLogFormat != "json" && c.AccessLogFormat != "logfmt" {
+		return fmt.Errorf("\"%s\" 访问日志格式不正确，支持json和logfmt", c.AccessLogFormat)
+	}
+	if c.Pprof.Enabled && c.AdminPort <= 0 {
+		return errors.New("开启pprof时需要配置管理端口admin_port")
+	}
+	if c.Tracing.Enabled && c.Tracing.Endpoint == "" {
+		return errors.New("开启链路追踪时需要配置导出器地址endpoint")
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		return err
+	}
+	if c.Log.Format != "" && c.Log.Format != "text" && c.Log.Format != "json" {
+		return fmt.Errorf("\"%s\" 日志格式不正确，支持text及json", c.Log.Format)
 	}
 	if c.HealthCheckInterval < 1 {
 		return errors.New("健康检查间隔时间必须大于0")
 	}
+	if c.PrecheckFailFast && !c.PrecheckBackends {
+		return errors.New("配置了precheck_fail_fast, 需要同时开启precheck_backends")
+	}
 	return nil
 }
+
+//HasGRPC 是否有gRPC路由
+func (c *Config) HasGRPC() bool {
+	for i := range c.Routes {
+		if c.Routes[i].GRPC {
+			return true
+		}
+	}
+	return false
+}
+
+//ValidationRoutes 验证每个路由的路径模板、下游主机及算法，并检查被之前的路由覆盖而永远不会被匹配的路由
+//路由按配置的顺序匹配，前缀相同或之前路由的前缀是当前路由前缀的前缀(例如/api与/api/v2)时，
+//如果之前的路由没有配置请求头或SNI匹配条件，并且允许当前路由的所有请求方法，当前路由永远不会被匹配
+//更具体的路由需要配置在前面
+func (c *Config) ValidationRoutes() error {
+	prefixes := make([]string, len(c.Routes))
+	for i := range c.Routes {
+		r := &c.Routes[i]
+		if !strings.HasPrefix(r.UpstreamPathTemplate, "/") {
+			return fmt.Errorf("路由 \"%s\" 的UpstreamPathTemplate必须以/开头", r.UpstreamPathTemplate)
+		}
+		if !strings.HasPrefix(r.DownstreamPathTemplate, "/") {
+			return fmt.Errorf("路由 \"%s\" 的DownstreamPathTemplate必须以/开头", r.UpstreamPathTemplate)
+		}
+		if len(r.DownstreamHosts) == 0 && len(r.BackendGroups) == 0 {
+			return fmt.Errorf("路由 \"%s\" 至少要配置一个下游主机", r.UpstreamPathTemplate)
+		}
+		if err := r.ValidationAlgorithm(); err != nil {
+			return fmt.Errorf("路由 \"%s\": %s", r.UpstreamPathTemplate, err)
+		}
+		if _, err := r.CompileMatch(); err != nil {
+			return err
+		}
+		prefixes[i] = r.UpstreamPathParse()
+
+		for j := 0; j < i; j++ {
+			earlier := &c.Routes[j]
+			if !strings.HasPrefix(prefixes[i], prefixes[j]) || earlier.HeaderMatch != nil || len(earlier.SNIHosts) > 0 {
+				continue
+			}
+			//无法判断正则表达式之间的覆盖关系；完整路径匹配的路由只会覆盖之后路径相同的完整路径匹配路由
+			if earlier.PathMatchType() == MatchRegex || r.PathMatchType() == MatchRegex {
+				continue
+			}
+			if earlier.PathMatchType() == MatchExact && (r.PathMatchType() != MatchExact || prefixes[i] != prefixes[j]) {
+				continue
+			}
+			if !coversMethods(earlier.UpstreamHTTPMethod, r.UpstreamHTTPMethod) {
+				continue
+			}
+			if prefixes[i] == prefixes[j] {
+				return fmt.Errorf("路由 \"%s\" 与路由 \"%s\" 的前缀 %s 重复，后面的路由永远不会被匹配", r.UpstreamPathTemplate, earlier.UpstreamPathTemplate, prefixes[i])
+			}
+			return fmt.Errorf("路由 \"%s\" 被之前的路由 \"%s\" 覆盖，永远不会被匹配，请将更具体的路由配置在前面", r.UpstreamPathTemplate, earlier.UpstreamPathTemplate)
+		}
+	}
+	return nil
+}
+
+//coversMethods 判断earlier允许的请求方法是否包含methods中的所有方法
+func coversMethods(earlier []string, methods []string) bool {
+	allowed := make(map[string]bool, len(earlier))
+	for _, m := range earlier {
+		allowed[strings.ToUpper(m)] = true
+	}
+	for _, m := range methods {
+		if !allowed[strings.ToUpper(m)] {
+			return false
+		}
+	}
+	return true
+}
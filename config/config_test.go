@@ -0,0 +1,181 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func route(upstream string, methods ...string) Routing {
+	return Routing{
+		UpstreamHTTPMethod:     methods,
+		UpstreamPathTemplate:   upstream,
+		Algorithm:              "round-robin",
+		DownstreamPathTemplate: "/{url}",
+		DownstreamHosts:        []string{"http://localhost:8001"},
+	}
+}
+
+func TestConfig_ValidationRoutes(t *testing.T) {
+	canary := route("/api/{url}", "GET")
+	canary.HeaderMatch = &HeaderPredicate{Header: "X-Canary"}
+	noHosts := route("/api/{url}", "GET")
+	noHosts.DownstreamHosts = nil
+	badAlgorithm := route("/api/{url}", "GET")
+	badAlgorithm.Algorithm = "unknown"
+	exact := route("/api/orders", "GET")
+	exact.MatchType = MatchExact
+	regex := route("/api/orders/[0-9]+", "GET")
+	regex.MatchType = MatchRegex
+	badRegex := route("/api/orders/[0-9", "GET")
+	badRegex.MatchType = MatchRegex
+
+	cases := []struct {
+		name   string
+		routes []Routing
+		valid  bool
+	}{
+		{"distinct prefixes", []Routing{route("/api/{url}", "GET"), route("/admin/{url}", "GET")}, true},
+		{"more specific first", []Routing{route("/api/v2/{url}", "GET"), route("/api/{url}", "GET")}, true},
+		{"duplicate prefix", []Routing{route("/api/{url}", "GET"), route("/api/{url}", "GET")}, false},
+		{"shadowed by shorter prefix", []Routing{route("/api/{url}", "GET", "POST"), route("/api/v2/{url}", "GET")}, false},
+		{"different methods", []Routing{route("/api/{url}", "GET"), route("/api/{url}", "POST")}, true},
+		{"earlier has header match", []Routing{canary, route("/api/{url}", "GET")}, true},
+		{"no downstream hosts", []Routing{noHosts}, false},
+		{"unknown algorithm", []Routing{badAlgorithm}, false},
+		{"missing leading slash", []Routing{route("api/{url}", "GET")}, false},
+		{"exact before prefix", []Routing{exact, route("/api/{url}", "GET")}, true},
+		{"exact shadowed by prefix", []Routing{route("/api/{url}", "GET"), exact}, false},
+		{"duplicate exact", []Routing{exact, exact}, false},
+		{"regex shadowing not checked", []Routing{route("/api/{url}", "GET"), regex}, true},
+		{"invalid regex", []Routing{badRegex}, false},
+	}
+	for _, c := range cases {
+		cfg := &Config{Schema: "http", HealthCheckInterval: 1, Routes: c.routes}
+		err := cfg.Validation()
+		if c.valid {
+			assert.NoError(t, err, c.name)
+		} else {
+			assert.Error(t, err, c.name)
+		}
+	}
+}
+
+func TestAPIKey_LoadKeys(t *testing.T) {
+	path := writeFile(t, "keys", "# 注释\nkey-from-file billing\n\nkey-inline reporting\n")
+	assert.NoError(t, os.Setenv("PROXY_TEST_KEYS_DIR", filepath.Dir(path)))
+	defer os.Unsetenv("PROXY_TEST_KEYS_DIR")
+
+	a := &APIKey{Keys: map[string]string{"key-inline": "inline"}, KeysFile: "${PROXY_TEST_KEYS_DIR}/keys"}
+	keys, err := a.LoadKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"key-from-file": "billing", "key-inline": "reporting"}, keys)
+
+	_, err = (&APIKey{KeysFile: writeFile(t, "bad", "only-key\n")}).LoadKeys()
+	assert.Error(t, err)
+	_, err = (&APIKey{KeysFile: filepath.Join(t.TempDir(), "missing")}).LoadKeys()
+	assert.Error(t, err)
+}
+
+func TestConfig_ValidationListeners(t *testing.T) {
+	cases := []struct {
+		name      string
+		listeners []Listener
+		valid     bool
+	}{
+		{"http and https", []Listener{{Address: ":80", RedirectHTTPS: true}, {Address: ":443", Schema: "https", CertCrt: "a.crt", CertKey: "a.key"}}, true},
+		{"https without cert", []Listener{{Address: ":443", Schema: "https"}}, false},
+		{"redirect without https", []Listener{{Address: ":80", RedirectHTTPS: true}}, false},
+		{"duplicate address", []Listener{{Address: ":80"}, {Address: ":80"}}, false},
+		{"unknown schema", []Listener{{Address: ":80", Schema: "tcp"}}, false},
+		{"empty address", []Listener{{Schema: "http"}}, false},
+	}
+	for _, c := range cases {
+		cfg := &Config{Schema: "http", HealthCheckInterval: 1, Routes: []Routing{route("/api/{url}", "GET")}, Listeners: c.listeners}
+		err := cfg.Validation()
+		if c.valid {
+			assert.NoError(t, err, c.name)
+		} else {
+			assert.Error(t, err, c.name)
+		}
+	}
+
+	//未配置listeners时使用port及schema
+	cfg := &Config{Port: 8080, Schema: "http"}
+	assert.Equal(t, []Listener{{Address: ":8080", Schema: "http"}}, cfg.ServerListeners())
+}
+
+func TestConfig_AdminListenAddress(t *testing.T) {
+	//未配置admin_address时只监听本机回环地址
+	cfg := &Config{AdminPort: 9091}
+	assert.Equal(t, "127.0.0.1:9091", cfg.AdminListenAddress())
+	cfg.AdminAddress = "0.0.0.0"
+	assert.Equal(t, "0.0.0.0:9091", cfg.AdminListenAddress())
+	cfg.AdminAddress = "::1"
+	assert.Equal(t, "[::1]:9091", cfg.AdminListenAddress())
+}
+
+func TestConfig_ValidationTLS(t *testing.T) {
+	cases := []struct {
+		name  string
+		tls   TLS
+		valid bool
+	}{
+		{"defaults", TLS{}, true},
+		{"tls 1.3", TLS{MinVersion: "1.3"}, true},
+		{"unknown version", TLS{MinVersion: "1.4"}, false},
+		{"secure cipher suite", TLS{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}, true},
+		{"insecure cipher suite", TLS{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, false},
+		{"verify without ca", TLS{ClientAuth: "require_and_verify"}, false},
+		{"verify with ca", TLS{ClientAuth: "require_and_verify", ClientCAFile: "ca.pem"}, true},
+		{"unknown client auth", TLS{ClientAuth: "always"}, false},
+	}
+	for _, c := range cases {
+		cfg := &Config{Schema: "http", HealthCheckInterval: 1, Routes: []Routing{route("/api/{url}", "GET")}, TLS: c.tls}
+		err := cfg.Validation()
+		if c.valid {
+			assert.NoError(t, err, c.name)
+		} else {
+			assert.Error(t, err, c.name)
+		}
+	}
+}
+
+func TestConfig_ValidationClientCert(t *testing.T) {
+	r := route("/internal/{url}", "GET")
+	r.ClientCert = &ClientCert{AllowedSubjects: []string{"svc-a"}}
+	cfg := &Config{Schema: "http", HealthCheckInterval: 1, Routes: []Routing{r}}
+	assert.Error(t, cfg.Validation())
+
+	cfg.Schema, cfg.CertCrt, cfg.CertKey = "https", "a.crt", "a.key"
+	assert.Error(t, cfg.Validation())
+	cfg.TLS = TLS{ClientAuth: "require_and_verify", ClientCAFile: "ca.pem"}
+	assert.NoError(t, cfg.Validation())
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	r := route("/api/{url}", "GET")
+	r.BasicAuth = &BasicAuth{Users: map[string]string{"admin": "$2y$05$hash"}}
+	r.APIKey = &APIKey{Keys: map[string]string{"key-b": "billing", "key-a": "app"}}
+	r.StickySession = &StickySession{Secret: "sticky-secret"}
+	r.RequestHeaders = &HeaderRules{Set: map[string]string{"authorization": "Bearer token", "X-Env": "prod"}}
+	c := &Config{Port: 8080, CertKey: "/etc/proxy/tls.key", JWT: JWT{Key: "jwt-secret"}, Routes: []Routing{r}}
+
+	redacted := c.Redacted()
+	assert.Equal(t, redactedValue, redacted.JWT.Key)
+	assert.Equal(t, "/etc/proxy/tls.key", redacted.CertKey)
+	out := redacted.Routes[0]
+	assert.Equal(t, map[string]string{"admin": redactedValue}, out.BasicAuth.Users)
+	assert.Equal(t, map[string]string{redactedValue + "-1": "app", redactedValue + "-2": "billing"}, out.APIKey.Keys)
+	assert.Equal(t, redactedValue, out.StickySession.Secret)
+	assert.Equal(t, map[string]string{"authorization": redactedValue, "X-Env": "prod"}, out.RequestHeaders.Set)
+	assert.Nil(t, out.ResponseHeaders)
+
+	//原配置不受影响
+	assert.Equal(t, "jwt-secret", c.JWT.Key)
+	assert.Equal(t, "$2y$05$hash", c.Routes[0].BasicAuth.Users["admin"])
+	assert.Equal(t, "app", c.Routes[0].APIKey.Keys["key-a"])
+	assert.Equal(t, "sticky-secret", c.Routes[0].StickySession.Secret)
+	assert.Equal(t, "Bearer token", c.Routes[0].RequestHeaders.Set["authorization"])
+}
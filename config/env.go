@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//envPattern 配置文件中的环境变量占位符，${NAME}或${NAME:-default}
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+//expandEnv 使用环境变量替换配置内容中的占位符，${NAME:-default}在环境变量未设置或为空时使用默认值
+//没有默认值且未设置的环境变量全部列在返回的错误中
+func expandEnv(data []byte) ([]byte, error) {
+	var missing []string
+	seen := make(map[string]bool)
+	expanded := envPattern.ReplaceAllFunc(data, func(placeholder []byte) []byte {
+		m := envPattern.FindSubmatch(placeholder)
+		name, hasDefault := string(m[1]), len(m[2]) > 0
+		value, ok := os.LookupEnv(name)
+		if hasDefault && value == "" {
+			return m[3]
+		}
+		if !ok && !seen[name] {
+			seen[name] = true
+			missing = append(missing, name)
+		}
+		return []byte(value)
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("环境变量未设置: %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+//expandEnvFiles 替换配置文件中的环境变量占位符，包含占位符的文件替换后写入临时目录，返回加载时使用的文件路径
+//不存在的文件原样返回，由加载时报错；cleanup用于删除临时目录
+func expandEnvFiles(files []string) (expandedFiles []string, cleanup func(), err error) {
+	cleanup = func() {}
+	expandedFiles = make([]string, len(files))
+	var dir string
+	for i, file := range files {
+		expandedFiles[i] = file
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if !envPattern.Match(data) {
+			continue
+		}
+		expanded, err := expandEnv(data)
+		if err != nil {
+			cleanup()
+			return nil, func() {}, fmt.Errorf("配置文件 \"%s\" 中的%s", file, err)
+		}
+		if dir == "" {
+			if dir, err = ioutil.TempDir("", "proxy-config"); err != nil {
+				return nil, func() {}, err
+			}
+			tmp := dir
+			cleanup = func() { _ = os.RemoveAll(tmp) }
+		}
+		//保留文件扩展名，加载时根据扩展名判断格式
+		expandedFiles[i] = filepath.Join(dir, fmt.Sprintf("%d-%s", i, filepath.Base(file)))
+		if err := ioutil.WriteFile(expandedFiles[i], expanded, 0600); err != nil {
+			cleanup()
+			return nil, func() {}, err
+		}
+	}
+	return expandedFiles, cleanup, nil
+}
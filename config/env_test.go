@@ -0,0 +1,77 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func setEnv(t *testing.T, name, value string) {
+	assert.NoError(t, os.Setenv(name, value))
+	t.Cleanup(func() {
+		_ = os.Unsetenv(name)
+	})
+}
+
+func TestExpandEnv(t *testing.T) {
+	setEnv(t, "PROXY_TEST_HOST", "backend:8001")
+	setEnv(t, "PROXY_TEST_EMPTY", "")
+
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		missing  string
+	}{
+		{name: "set", input: "http://${PROXY_TEST_HOST}/", expected: "http://backend:8001/"},
+		{name: "default unused", input: "${PROXY_TEST_HOST:-localhost}", expected: "backend:8001"},
+		{name: "default unset", input: "port: ${PROXY_TEST_PORT:-9090}", expected: "port: 9090"},
+		{name: "default empty", input: "${PROXY_TEST_EMPTY:-fallback}", expected: "fallback"},
+		{name: "empty default", input: "[${PROXY_TEST_PORT:-}]", expected: "[]"},
+		{name: "set empty", input: "[${PROXY_TEST_EMPTY}]", expected: "[]"},
+		{name: "regex replacement untouched", input: "RewriteReplacement: /v2/$1", expected: "RewriteReplacement: /v2/$1"},
+		{name: "missing", input: "${PROXY_TEST_A} ${PROXY_TEST_B} ${PROXY_TEST_A}", missing: "PROXY_TEST_A, PROXY_TEST_B"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out, err := expandEnv([]byte(c.input))
+			if c.missing != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), c.missing)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, string(out))
+		})
+	}
+}
+
+func TestRead_ExpandEnv(t *testing.T) {
+	setEnv(t, "PROXY_TEST_HOST", "http://backend:8001")
+	const content = `
+port: ${PROXY_TEST_PORT:-9090}
+cert_crt: ${PROXY_TEST_CERT:-/etc/proxy/server.crt}
+ReRoutes:
+  - UpstreamPathTemplate: /api/{url}
+    Algorithm: round-robin
+    DownstreamPathTemplate: /{url}
+    DownstreamHosts: [${PROXY_TEST_HOST}]
+`
+	path := writeFile(t, "config.yml", content)
+	cfg, err := Read(false, path)
+	assert.NoError(t, err)
+	assert.Equal(t, 9090, cfg.Port)
+	assert.Equal(t, "/etc/proxy/server.crt", cfg.CertCrt)
+	assert.Equal(t, []string{"http://backend:8001"}, cfg.Routes[0].DownstreamHosts)
+
+	fromLoad, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg.Routes[0].DownstreamHosts, fromLoad.Routes[0].DownstreamHosts)
+
+	//缺少的环境变量在错误中列出
+	_, err = Read(false, writeFile(t, "missing.yml", "cert_key: ${PROXY_TEST_KEY}\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "PROXY_TEST_KEY")
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+//HeaderPredicate 请求头匹配条件
+//All/Any 为组合条件，分别表示所有子条件都满足(AND)、任一子条件满足(OR)
+//Header 为叶子条件：配置了Equals时判断相等，配置了Regex时进行正则匹配，都不配置时只判断请求头是否存在
+type HeaderPredicate struct {
+	//All 所有子条件都满足时匹配
+	All []HeaderPredicate `json:"All" yaml:"All"`
+	//Any 任一子条件满足时匹配
+	Any []HeaderPredicate `json:"Any" yaml:"Any"`
+	//Header 请求头名称
+	Header string `json:"Header" yaml:"Header"`
+	//Equals 请求头的值需要与之相等
+	Equals string `json:"Equals" yaml:"Equals"`
+	//Regex 请求头的值需要匹配的正则表达式
+	Regex string `json:"Regex" yaml:"Regex"`
+}
+
+//HeaderMatcher 编译后的请求头匹配函数
+type HeaderMatcher func(r *http.Request) bool
+
+//Compile 验证并编译匹配条件
+func (p *HeaderPredicate) Compile() (HeaderMatcher, error) {
+	isGroup := len(p.All) > 0 || len(p.Any) > 0
+	isLeaf := p.Header != ""
+	if isGroup == isLeaf {
+		return nil, errors.New("请求头匹配条件必须且只能配置 All/Any 或 Header 其中之一")
+	}
+	if isGroup {
+		return p.compileGroup()
+	}
+	return p.compileLeaf()
+}
+
+//compileGroup 编译组合条件
+func (p *HeaderPredicate) compileGroup() (HeaderMatcher, error) {
+	if len(p.All) > 0 && len(p.Any) > 0 {
+		return nil, errors.New("请求头匹配条件不能同时配置 All 和 Any")
+	}
+	isAll := len(p.All) > 0
+	children := p.Any
+	if isAll {
+		children = p.All
+	}
+
+	matchers := make([]HeaderMatcher, 0, len(children))
+	for i := range children {
+		m, err := children[i].Compile()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+
+	return func(r *http.Request) bool {
+		for _, m := range matchers {
+			if m(r) != isAll {
+				return !isAll
+			}
+		}
+		return isAll
+	}, nil
+}
+
+//compileLeaf 编译叶子条件
+func (p *HeaderPredicate) compileLeaf() (HeaderMatcher, error) {
+	header := http.CanonicalHeaderKey(p.Header)
+	switch {
+	case p.Equals != "" && p.Regex != "":
+		return nil, fmt.Errorf("请求头 \"%s\" 的匹配条件不能同时配置 Equals 和 Regex", p.Header)
+	case p.Equals != "":
+		return func(r *http.Request) bool {
+			for _, v := range r.Header.Values(header) {
+				if v == p.Equals {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case p.Regex != "":
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("请求头 \"%s\" 的正则表达式 \"%s\" 不正确: %s", p.Header, p.Regex, err)
+		}
+		return func(r *http.Request) bool {
+			for _, v := range r.Header.Values(header) {
+				if re.MatchString(v) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	default:
+		return func(r *http.Request) bool {
+			_, ok := r.Header[header]
+			return ok
+		}, nil
+	}
+}
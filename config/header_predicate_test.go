@@ -0,0 +1,58 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeaderPredicate_Compile(t *testing.T) {
+	//(X-Env=canary 且 X-User匹配^beta-) 或 携带X-Debug
+	p := HeaderPredicate{Any: []HeaderPredicate{
+		{All: []HeaderPredicate{
+			{Header: "x-env", Equals: "canary"},
+			{Header: "X-User", Regex: "^beta-"},
+		}},
+		{Header: "X-Debug"},
+	}}
+	match, err := p.Compile()
+	if !assert.NoError(t, err) {
+		return
+	}
+	request := func(headers ...string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Add(headers[i], headers[i+1])
+		}
+		return r
+	}
+
+	assert.True(t, match(request("X-Env", "canary", "X-User", "beta-alice")))
+	assert.False(t, match(request("X-Env", "canary", "X-User", "alice")))
+	assert.False(t, match(request("X-Env", "prod", "X-User", "beta-alice")))
+	assert.False(t, match(request("X-User", "beta-alice")))
+	//只判断请求头是否存在，值可以为空
+	assert.True(t, match(request("X-Debug", "")))
+	assert.False(t, match(request()))
+	//多个值时任一值满足即可
+	assert.True(t, match(request("X-Env", "prod", "X-Env", "canary", "X-User", "beta-bob")))
+}
+
+func TestHeaderPredicate_CompileInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		p    HeaderPredicate
+	}{
+		{"empty", HeaderPredicate{}},
+		{"group and leaf", HeaderPredicate{Header: "X-Env", All: []HeaderPredicate{{Header: "X-User"}}}},
+		{"all and any", HeaderPredicate{All: []HeaderPredicate{{Header: "X-Env"}}, Any: []HeaderPredicate{{Header: "X-User"}}}},
+		{"equals and regex", HeaderPredicate{Header: "X-Env", Equals: "canary", Regex: "^c"}},
+		{"bad regex", HeaderPredicate{Header: "X-Env", Regex: "[a-"}},
+		{"bad child", HeaderPredicate{All: []HeaderPredicate{{Header: "X-Env"}, {}}}},
+	}
+	for _, c := range cases {
+		_, err := c.p.Compile()
+		assert.Error(t, err, c.name)
+	}
+}
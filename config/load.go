@@ -0,0 +1,52 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jinzhu/configor"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+//Load 根据文件扩展名加载单个配置文件，支持.yml/.yaml及.json，两种格式使用相同的字段名
+//返回的配置已填充默认值，但未进行验证，需要时调用Validation
+func Load(path string) (*Config, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return LoadYAML(path)
+	case ".json":
+		return LoadJSON(path)
+	}
+	return nil, fmt.Errorf("不支持的配置文件格式 \"%s\"，仅支持.yml/.yaml及.json", path)
+}
+
+//LoadYAML 加载YAML格式的配置文件
+func LoadYAML(path string) (*Config, error) {
+	return load(path, yaml.Unmarshal)
+}
+
+//LoadJSON 加载JSON格式的配置文件
+func LoadJSON(path string) (*Config, error) {
+	return load(path, json.Unmarshal)
+}
+
+//load 先填充默认值，再替换环境变量占位符并使用unmarshal解析配置文件，配置文件中的值会覆盖默认值
+func load(path string, unmarshal func([]byte, interface{}) error) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = expandEnv(data); err != nil {
+		return nil, fmt.Errorf("配置文件 \"%s\" 中的%s", path, err)
+	}
+	cfg := &Config{}
+	if err := configor.Load(cfg); err != nil {
+		return nil, err
+	}
+	if err := unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("解析配置文件 \"%s\" 失败: %s", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const yamlConfig = `
+port: 9090
+schema: http
+max_allowed: 50
+admin_port: 9091
+health_check: true
+health_check_interval: 5
+rate_limit:
+  rps: 10
+  burst: 20
+jwt:
+  key: secret
+  required_claims:
+    iss: proxy
+access_log: true
+access_log_format: logfmt
+metrics:
+  enabled: true
+  address: ":9100"
+ReRoutes:
+  - UpstreamHttpMethod: [GET, POST]
+    UpstreamPathTemplate: /api/{url}
+    Algorithm: weighted-round-robin
+    DownstreamPathTemplate: /v1/{url}
+    DownstreamHosts:
+      - http://localhost:8001
+      - http://localhost:8002
+    DownstreamWeights: [3, 1]
+    RequestTimeout: 1000
+    HeaderMatch:
+      Any:
+        - Header: X-Canary
+          Equals: "true"
+        - Header: X-Beta
+    CORS:
+      AllowedOrigins: ["*.example.com"]
+      MaxAge: 600
+    RequiredScopes: [read]
+  - UpstreamPathTemplate: /admin/{url}
+    Algorithm: round-robin
+    DownstreamPathTemplate: /{url}
+    DownstreamHosts: [http://localhost:8003]
+`
+
+const jsonConfig = `{
+  "port": 9090,
+  "schema": "http",
+  "max_allowed": 50,
+  "admin_port": 9091,
+  "health_check": true,
+  "health_check_interval": 5,
+  "rate_limit": {"rps": 10, "burst": 20},
+  "jwt": {"key": "secret", "required_claims": {"iss": "proxy"}},
+  "access_log": true,
+  "access_log_format": "logfmt",
+  "metrics": {"enabled": true, "address": ":9100"},
+  "ReRoutes": [
+    {
+      "UpstreamHttpMethod": ["GET", "POST"],
+      "UpstreamPathTemplate": "/api/{url}",
+      "Algorithm": "weighted-round-robin",
+      "DownstreamPathTemplate": "/v1/{url}",
+      "DownstreamHosts": ["http://localhost:8001", "http://localhost:8002"],
+      "DownstreamWeights": [3, 1],
+      "RequestTimeout": 1000,
+      "HeaderMatch": {"Any": [{"Header": "X-Canary", "Equals": "true"}, {"Header": "X-Beta"}]},
+      "CORS": {"AllowedOrigins": ["*.example.com"], "MaxAge": 600},
+      "RequiredScopes": ["read"]
+    },
+    {
+      "UpstreamPathTemplate": "/admin/{url}",
+      "Algorithm": "round-robin",
+      "DownstreamPathTemplate": "/{url}",
+      "DownstreamHosts": ["http://localhost:8003"]
+    }
+  ]
+}`
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoad_YAMLEqualsJSON(t *testing.T) {
+	fromYAML, err := Load(writeFile(t, "config.yaml", yamlConfig))
+	assert.NoError(t, err)
+	fromJSON, err := Load(writeFile(t, "config.json", jsonConfig))
+	assert.NoError(t, err)
+	assert.Equal(t, fromJSON, fromYAML)
+
+	assert.Equal(t, 9090, fromYAML.Port)
+	assert.Equal(t, []string{"http://localhost:8001", "http://localhost:8002"}, fromYAML.Routes[0].DownstreamHosts)
+	assert.Equal(t, "X-Canary", fromYAML.Routes[0].HeaderMatch.Any[0].Header)
+	assert.NoError(t, fromYAML.Validation())
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := LoadYAML(writeFile(t, "config.yml", "port: 9090\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 9090, cfg.Port)
+	assert.Equal(t, "http", cfg.Schema)
+	assert.Equal(t, uint(100), cfg.MaxAllowed)
+	assert.Equal(t, uint(30), cfg.ShutdownTimeout)
+	assert.Equal(t, "/metrics", cfg.Metrics.Path)
+	assert.Equal(t, "HS256", cfg.JWT.Algorithm)
+}
+
+func TestLoad_UnsupportedFormat(t *testing.T) {
+	_, err := Load(writeFile(t, "config.toml", "port = 9090"))
+	assert.Error(t, err)
+	_, err = LoadYAML(writeFile(t, "config.yml", "port: [1"))
+	assert.Error(t, err)
+}
+
+func TestLoad_ServerConfig(t *testing.T) {
+	cfg, err := Load("../config.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, cfg.Port)
+	assert.Equal(t, uint(10), cfg.DrainTimeout)
+	assert.Equal(t, uint(30), cfg.ShutdownTimeout)
+}
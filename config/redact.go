@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+//redactedValue 替换敏感字段的值
+const redactedValue = "[REDACTED]"
+
+//sensitiveHeaders 修改规则中值需要隐藏的请求头及响应头
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+//Redacted 获取隐藏了敏感字段的配置副本，用于管理接口输出当前生效的配置，不修改原配置
+//隐藏JWT密钥、BasicAuth的密码哈希、API Key、会话保持密钥及请求头修改规则中的认证信息，
+//证书等通过文件路径配置的字段只输出路径，不读取文件内容
+func (c *Config) Redacted() *Config {
+	out := *c
+	out.JWT.Key = redact(c.JWT.Key)
+	out.Routes = make([]Routing, len(c.Routes))
+	for i := range c.Routes {
+		out.Routes[i] = c.Routes[i].redacted()
+	}
+	return &out
+}
+
+//redacted 获取隐藏了敏感字段的路由配置副本
+func (r Routing) redacted() Routing {
+	if r.BasicAuth != nil {
+		auth := *r.BasicAuth
+		auth.Users = make(map[string]string, len(r.BasicAuth.Users))
+		for user := range r.BasicAuth.Users {
+			auth.Users[user] = redactedValue
+		}
+		r.BasicAuth = &auth
+	}
+	if r.APIKey != nil {
+		apiKey := *r.APIKey
+		//API Key是map的键，按客户端标识排序后编号，保证输出稳定且键不重复
+		clientIDs := make([]string, 0, len(r.APIKey.Keys))
+		for _, clientID := range r.APIKey.Keys {
+			clientIDs = append(clientIDs, clientID)
+		}
+		sort.Strings(clientIDs)
+		apiKey.Keys = make(map[string]string, len(clientIDs))
+		for i, clientID := range clientIDs {
+			apiKey.Keys[fmt.Sprintf("%s-%d", redactedValue, i+1)] = clientID
+		}
+		r.APIKey = &apiKey
+	}
+	if r.StickySession != nil {
+		sticky := *r.StickySession
+		sticky.Secret = redact(sticky.Secret)
+		r.StickySession = &sticky
+	}
+	r.RequestHeaders = r.RequestHeaders.redacted()
+	r.ResponseHeaders = r.ResponseHeaders.redacted()
+	return r
+}
+
+//redacted 获取隐藏了认证信息的头部修改规则副本
+func (h *HeaderRules) redacted() *HeaderRules {
+	if h == nil {
+		return nil
+	}
+	out := *h
+	out.Set = redactHeaders(h.Set)
+	out.Add = redactHeaders(h.Add)
+	return &out
+}
+
+//redactHeaders 隐藏认证相关头部的值
+func redactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	out := make(map[string]string, len(headers))
+	for name, value := range headers {
+		out[name] = value
+		for _, sensitive := range sensitiveHeaders {
+			if http.CanonicalHeaderKey(name) == sensitive {
+				out[name] = redact(value)
+			}
+		}
+	}
+	return out
+}
+
+//redact 隐藏非空的敏感值，空值原样返回，便于区分是否配置
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedValue
+}
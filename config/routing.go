@@ -2,23 +2,365 @@ package config
 
 import (
 	"fmt"
+	"golang.org/x/net/http/httpguts"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
 	"regexp"
 	"strings"
 )
 
 type Routing struct {
 	//UpstreamHTTPMethod 表示客户端请求到代理时，所允许HTTP请求的方法
-	UpstreamHTTPMethod []string `json:"UpstreamHttpMethod"`
+	UpstreamHTTPMethod []string `json:"UpstreamHttpMethod" yaml:"UpstreamHttpMethod"`
+	//AllowedMethods 允许转发的请求方法，其它方法返回405，为空时不限制
+	//与UpstreamHttpMethod不同，不参与路由匹配，不会让请求落到其它路由
+	AllowedMethods []string `json:"AllowedMethods" yaml:"AllowedMethods"`
+	//CacheMethods 开启响应缓存时可以缓存的请求方法，支持GET、HEAD及POST，为空时缓存GET及HEAD
+	//POST请求按请求内容的哈希区分，只应对只读的查询接口(例如GraphQL查询)开启，且下游主机需要通过Cache-Control指定max-age
+	CacheMethods []string `json:"CacheMethods" yaml:"CacheMethods"`
 	//UpstreamPathTemplate 客户端请求代理时的Url路径模板
-	UpstreamPathTemplate string `json:"UpstreamPathTemplate"`
+	UpstreamPathTemplate string `json:"UpstreamPathTemplate" yaml:"UpstreamPathTemplate"`
+	//MatchType UpstreamPathTemplate的匹配方式，支持prefix(前缀，默认)、exact(完整路径)及regex(正则表达式匹配完整路径)
+	//regex路由未配置RewriteRegex时原样转发请求路径，结合UpstreamHttpMethod可以将同一路径的读写请求转发到不同的下游主机
+	MatchType string `json:"MatchType" yaml:"MatchType"`
 	//Algorithm 使用的负载均衡算法
-	Algorithm string `json:"Algorithm"`
+	Algorithm string `json:"Algorithm" yaml:"Algorithm"`
+	//Replicas 一致性哈希每个主机副本(虚拟节点)的数量，默认为100
+	Replicas int `json:"Replicas" yaml:"Replicas"`
+	//LoadFactor bounded算法的负载上限系数ε，主机负载超过(1+ε)倍平均负载时选择哈希环上的下一个主机，默认为0.25
+	LoadFactor float64 `json:"LoadFactor" yaml:"LoadFactor"`
+	//SlowStartDuration weighted-round-robin算法新添加或恢复的主机权重从10%线性增加到配置权重的时长(毫秒)，为0时不开启
+	SlowStartDuration uint `json:"SlowStartDuration" yaml:"SlowStartDuration"`
+	//BalanceByClientIP 是否使用客户端IP作为负载均衡的key，ip-hash算法总是使用客户端IP
+	BalanceByClientIP bool `json:"BalanceByClientIP" yaml:"BalanceByClientIP"`
 	//UseServiceDiscovery 是否启用服务发现
-	UseServiceDiscovery bool `json:"UseServiceDiscovery"`
+	UseServiceDiscovery bool `json:"UseServiceDiscovery" yaml:"UseServiceDiscovery"`
 	//DownstreamPathTemplate 代理向目标转发时的Url路径模板
-	DownstreamPathTemplate string `json:"DownstreamPathTemplate"`
-	//DownstreamHostAndPorts 代理向下游转发地址集合
-	DownstreamHosts []string `json:"DownstreamHosts"`
+	DownstreamPathTemplate string `json:"DownstreamPathTemplate" yaml:"DownstreamPathTemplate"`
+	//RewriteRegex 重写转发路径的正则表达式，匹配请求的完整路径，配置后代替DownstreamPathTemplate的前缀替换
+	RewriteRegex string `json:"RewriteRegex" yaml:"RewriteRegex"`
+	//RewriteReplacement 重写后的路径，可以使用$1或${name}引用RewriteRegex的捕获组，包含"?"时之后的部分作为查询参数
+	RewriteReplacement string `json:"RewriteReplacement" yaml:"RewriteReplacement"`
+	//DownstreamHostAndPorts 代理向下游转发地址集合，例如http://127.0.0.1:8080，Unix域套接字主机为unix:///var/run/app.sock
+	DownstreamHosts []string `json:"DownstreamHosts" yaml:"DownstreamHosts"`
+	//DownstreamWeights 下游主机的权重，与DownstreamHosts一一对应，未配置时每个主机的权重为1
+	DownstreamWeights []int `json:"DownstreamWeights" yaml:"DownstreamWeights"`
+	//BackendGroups 按权重分配流量的主机组(例如灰度发布)，先按Weight选择主机组再由组内的负载均衡器选择主机
+	//配置后代替DownstreamHosts及DownstreamWeights，流量权重可以通过管理接口PUT /admin/groups调整
+	BackendGroups []BackendGroup `json:"BackendGroups" yaml:"BackendGroups"`
+	//HeaderMatch 请求头匹配条件，配置后只有满足条件的请求才会匹配该路由，不满足时继续匹配之后的路由
+	//路由按配置的顺序匹配，灰度路由(例如X-Canary: true)需要配置在相同路径的默认路由之前，否则永远不会被匹配
+	HeaderMatch *HeaderPredicate `json:"HeaderMatch" yaml:"HeaderMatch"`
+	//RequestTimeout 请求的默认超时时间(毫秒)，包括连接下游主机及读取响应，超时后返回504，为0时不限制
+	RequestTimeout uint `json:"RequestTimeout" yaml:"RequestTimeout"`
+	//MaxRequestTimeout 客户端通过TimeoutHeader可以指定的最大超时时间(毫秒)，为0时不允许客户端指定
+	MaxRequestTimeout uint `json:"MaxRequestTimeout" yaml:"MaxRequestTimeout"`
+	//TimeoutHeader 客户端指定超时时间(毫秒)的请求头，默认为X-Timeout-Ms
+	TimeoutHeader string `json:"TimeoutHeader" yaml:"TimeoutHeader"`
+	//PreserveHostHeader 是否将客户端请求的Host原样转发给下游主机，默认使用下游主机的地址作为Host
+	PreserveHostHeader bool `json:"PreserveHostHeader" yaml:"PreserveHostHeader"`
+	//HostHeaderOverride 转发给下游主机的Host，配置后优先于PreserveHostHeader
+	//https下游主机的TLS SNI及证书校验始终使用DownstreamHosts中的主机名，不受Host的影响
+	HostHeaderOverride string `json:"HostHeaderOverride" yaml:"HostHeaderOverride"`
+	//DefaultUserAgent 客户端未携带User-Agent时转发给下游主机的默认值，为空时不转发User-Agent
+	DefaultUserAgent string `json:"DefaultUserAgent" yaml:"DefaultUserAgent"`
+	//SNIHosts TLS握手时客户端指定的SNI主机名，配置后只有SNI匹配的请求才会进入该路由，仅适用于https模式
+	SNIHosts []string `json:"SNIHosts" yaml:"SNIHosts"`
+	//PassThroughErrors 是否原样返回下游主机的错误响应(状态码及内容)，下游主机不可达时返回统一的502
+	PassThroughErrors bool `json:"PassThroughErrors" yaml:"PassThroughErrors"`
+	//RewriteErrorBody 是否在下游主机错误响应的内容前追加"StatusCode error:"，默认不改写，不能与PassThroughErrors同时开启
+	RewriteErrorBody bool `json:"RewriteErrorBody" yaml:"RewriteErrorBody"`
+	//RewriteErrorStatuses 需要改写响应内容的状态码，为空时改写所有非200的响应(与之前的默认行为一致)
+	RewriteErrorStatuses []int `json:"RewriteErrorStatuses" yaml:"RewriteErrorStatuses"`
+	//MaxIdleConns 路由转发请求的最大空闲连接数，默认为100，配置了任一连接池参数时路由使用独立的连接池
+	MaxIdleConns int `json:"MaxIdleConns" yaml:"MaxIdleConns"`
+	//MaxIdleConnsPerHost 每个下游主机的最大空闲连接数，默认为2
+	MaxIdleConnsPerHost int `json:"MaxIdleConnsPerHost" yaml:"MaxIdleConnsPerHost"`
+	//IdleConnTimeout 空闲连接超时时间(毫秒)，默认为90000
+	IdleConnTimeout uint `json:"IdleConnTimeout" yaml:"IdleConnTimeout"`
+	//DialTimeout 连接下游主机的超时时间(毫秒)，默认为30000
+	DialTimeout uint `json:"DialTimeout" yaml:"DialTimeout"`
+	//ResponseHeaderTimeout 发送请求后等待下游主机响应头的超时时间(毫秒)，为0时不限制，超时的请求可以重试
+	ResponseHeaderTimeout uint `json:"ResponseHeaderTimeout" yaml:"ResponseHeaderTimeout"`
+	//HTTP2 https下游主机是否通过TLS ALPN协商HTTP/2，主机不支持时自动回退到HTTP/1.1
+	HTTP2 bool `json:"HTTP2" yaml:"HTTP2"`
+	//H2C http下游主机是否使用明文HTTP/2(h2c)，不会回退到HTTP/1.1，只适用于确定支持h2c的内部主机
+	H2C bool `json:"H2C" yaml:"H2C"`
+	//GRPC 是否为gRPC路由：http下游主机使用h2c、https下游主机使用HTTP/2，响应立即Flush并原样转发trailers，
+	//不改写响应内容，代理产生的错误以gRPC状态返回；开启后http监听地址同时接受h2c请求
+	GRPC bool `json:"GRPC" yaml:"GRPC"`
+	//FlushInterval 转发响应内容时定期Flush的间隔(毫秒)，-1表示每次写入后立即Flush，为0时不定期Flush
+	//适用于分块传输、日志跟踪等长时间的流式响应，事件流(text/event-stream)总是立即Flush
+	FlushInterval int `json:"FlushInterval" yaml:"FlushInterval"`
+	//MaxBodySize 请求内容的最大字节数，超过时返回413，为0时使用全局配置max_body_size，小于0时不限制
+	MaxBodySize int64 `json:"MaxBodySize" yaml:"MaxBodySize"`
+	//MaxConcurrent 路由的最大并发请求数，为0时不限制
+	MaxConcurrent uint `json:"MaxConcurrent" yaml:"MaxConcurrent"`
+	//MaxConnsPerHost 每个下游主机的最大在途请求数，达到时选择其他主机，均已达到时返回503
+	//为0时使用全局配置max_conns_per_host，小于0时不限制
+	MaxConnsPerHost int `json:"MaxConnsPerHost" yaml:"MaxConnsPerHost"`
+	//MaxClientShare 单个客户端IP最多可占用的并发比例(0~1)，为0时不限制
+	MaxClientShare float64 `json:"MaxClientShare" yaml:"MaxClientShare"`
+	//QueueTimeout 超出并发限制时请求排队的最长等待时间(毫秒)，默认1000
+	QueueTimeout uint `json:"QueueTimeout" yaml:"QueueTimeout"`
+	//RateLimitRPS 路由按客户端IP限流，每个客户端每秒允许的请求数，超出时返回429，为0时不限流
+	//与全局配置rate_limit相互独立，同时配置时请求需要同时满足两者
+	RateLimitRPS uint `json:"RateLimitRPS" yaml:"RateLimitRPS"`
+	//RateLimitBurst 路由每个客户端允许的突发请求数，为0时等于RateLimitRPS
+	RateLimitBurst uint `json:"RateLimitBurst" yaml:"RateLimitBurst"`
+	//HedgeDelay 幂等请求超过该时间(毫秒)未返回时向其他主机发送对冲请求，为0时不对冲
+	HedgeDelay uint `json:"HedgeDelay" yaml:"HedgeDelay"`
+	//HedgeMaxAttempts 对冲时最多并行的请求数(包括首次请求)，默认为2
+	HedgeMaxAttempts uint `json:"HedgeMaxAttempts" yaml:"HedgeMaxAttempts"`
+	//HedgeBudgetPercent 对冲请求最多占总请求数的百分比，默认为10
+	HedgeBudgetPercent uint `json:"HedgeBudgetPercent" yaml:"HedgeBudgetPercent"`
+	//RequiredScopes 开启JWT认证时，令牌中必须包含的权限(scope/scp)
+	RequiredScopes []string `json:"RequiredScopes" yaml:"RequiredScopes"`
+	//RequiredClaims 开启JWT认证时，令牌中必须包含且值相等的声明，与全局配置合并
+	RequiredClaims map[string]string `json:"RequiredClaims" yaml:"RequiredClaims"`
+	//BasicAuth Basic认证配置，为空时不开启
+	BasicAuth *BasicAuth `json:"BasicAuth" yaml:"BasicAuth"`
+	//APIKey API Key认证配置，为空时不开启
+	APIKey *APIKey `json:"APIKey" yaml:"APIKey"`
+	//ClientCert 客户端证书认证配置，需要tls.client_auth为verify_if_given或require_and_verify，为空时不开启
+	ClientCert *ClientCert `json:"ClientCert" yaml:"ClientCert"`
+	//FallbackResponse 路由的所有下游主机均不可用时返回的响应(例如维护页面)，为空时返回503或502
+	FallbackResponse *FallbackResponse `json:"FallbackResponse" yaml:"FallbackResponse"`
+	//ErrorPages 按状态码配置的错误页面，用于代理产生的错误及下游主机的错误响应，未配置的状态码返回不包含内部错误信息的默认页面
+	ErrorPages map[int]*ErrorPage `json:"ErrorPages" yaml:"ErrorPages"`
+	//CORS 跨域资源共享配置，为空时不设置Access-Control-*响应头
+	CORS *CORS `json:"CORS" yaml:"CORS"`
+	//IPFilter 客户端IP过滤规则，为空时不限制
+	IPFilter *IPFilter `json:"IPFilter" yaml:"IPFilter"`
+	//HealthCheckType 健康检查方式，tcp只检查能否建立连接，http发送GET请求检查响应状态码
+	//为空时配置了HealthCheckPath或主机为http/https协议时使用http，其他主机(例如unix:)使用tcp
+	HealthCheckType string `json:"HealthCheckType" yaml:"HealthCheckType"`
+	//HealthCheckPath http健康检查请求的路径(例如/healthz)，为空时为/
+	HealthCheckPath string `json:"HealthCheckPath" yaml:"HealthCheckPath"`
+	//HealthCheckExpectStatus 健康检查期望的响应状态码，默认为200
+	HealthCheckExpectStatus int `json:"HealthCheckExpectStatus" yaml:"HealthCheckExpectStatus"`
+	//HealthCheckTimeout 健康检查请求的超时时间(毫秒)，默认为3000
+	HealthCheckTimeout uint `json:"HealthCheckTimeout" yaml:"HealthCheckTimeout"`
+	//MaxRetries 幂等请求(GET/HEAD/OPTIONS/PUT/DELETE)连接下游主机失败时，重试其他主机的最大次数，为0时不重试
+	//只有连接失败及等待响应头超时(ResponseHeaderTimeout)会重试，下游主机返回的5xx默认原样返回
+	MaxRetries uint `json:"MaxRetries" yaml:"MaxRetries"`
+	//RetryOnStatuses 下游主机返回这些状态码(例如502、503)时也重试其他主机，最后一次尝试的响应原样返回
+	RetryOnStatuses []int `json:"RetryOnStatuses" yaml:"RetryOnStatuses"`
+	//RetryOnGRPCStatuses 下游主机返回这些gRPC状态码时重试其他主机，并计入熔断及被动健康检查的失败次数，
+	//gRPC路由未配置时为UNAVAILABLE(14)及RESOURCE_EXHAUSTED(8)，配置为[]时不按gRPC状态码重试；
+	//gRPC请求为POST，需要配置BufferRequestBody才会重试，且只有下游主机以只有响应头的响应返回的状态码可以重试
+	RetryOnGRPCStatuses []int `json:"RetryOnGRPCStatuses" yaml:"RetryOnGRPCStatuses"`
+	//RetryBudget 重试的总时间预算(毫秒)，超过后不再重试，为0时不限制
+	RetryBudget uint `json:"RetryBudget" yaml:"RetryBudget"`
+	//RetryBaseDelay 第一次重试前等待的时间(毫秒)，之后每次重试翻倍，为0时立即重试
+	RetryBaseDelay uint `json:"RetryBaseDelay" yaml:"RetryBaseDelay"`
+	//RetryMaxDelay 重试前最长的等待时间(毫秒)，为0时不限制
+	RetryMaxDelay uint `json:"RetryMaxDelay" yaml:"RetryMaxDelay"`
+	//RetryJitter 重试等待时间随机减少的最大比例(0~1)，避免多个请求同时重试，为0时不随机
+	RetryJitter float64 `json:"RetryJitter" yaml:"RetryJitter"`
+	//BufferRequestBody 开启重试时将不超过该字节数的请求内容缓冲在内存中，重试时重新发送，非幂等请求(例如POST)也会重试，
+	//超过该大小的请求不缓冲也不重试，为0时不缓冲
+	BufferRequestBody int64 `json:"BufferRequestBody" yaml:"BufferRequestBody"`
+	//BreakerFailureThreshold 熔断：主机在BreakerWindow时间内失败(5xx或连接失败)该次数后熔断，为0时不开启
+	BreakerFailureThreshold uint `json:"BreakerFailureThreshold" yaml:"BreakerFailureThreshold"`
+	//BreakerWindow 熔断失败次数的统计时间窗口(毫秒)，默认为10000
+	BreakerWindow uint `json:"BreakerWindow" yaml:"BreakerWindow"`
+	//BreakerOpenTimeout 熔断后经过该时间(毫秒)允许一个探测请求，探测成功后恢复，默认为30000
+	BreakerOpenTimeout uint `json:"BreakerOpenTimeout" yaml:"BreakerOpenTimeout"`
+	//PassiveMaxFails 被动健康检查：主机连续失败(5xx或连接失败)该次数后被摘除，为0时不开启
+	PassiveMaxFails uint `json:"PassiveMaxFails" yaml:"PassiveMaxFails"`
+	//PassiveEjectDuration 被动健康检查摘除主机的时长(毫秒)，默认为30000
+	PassiveEjectDuration uint `json:"PassiveEjectDuration" yaml:"PassiveEjectDuration"`
+	//RequestHeaders 转发给下游主机前修改的请求头，在代理设置X-Forwarded-*等请求头之后生效
+	RequestHeaders *HeaderRules `json:"RequestHeaders" yaml:"RequestHeaders"`
+	//ResponseHeaders 返回给客户端前修改的下游主机响应头，例如删除Server及X-Powered-By
+	ResponseHeaders *HeaderRules `json:"ResponseHeaders" yaml:"ResponseHeaders"`
+	//Mirror 将部分请求复制到镜像主机，用于使用线上流量测试新版本，只返回下游主机的响应
+	Mirror *Mirror `json:"Mirror" yaml:"Mirror"`
+	//StickySession 基于Cookie的会话保持，为空时不开启
+	StickySession *StickySession `json:"StickySession" yaml:"StickySession"`
+	//ResponseRewrites 返回给客户端前按顺序改写下游主机响应头及响应内容的规则，例如将Location中的内部地址替换为对外地址
+	ResponseRewrites []ResponseRewrite `json:"ResponseRewrites" yaml:"ResponseRewrites"`
+	//ResponseRewriteMaxBody 改写响应内容的最大字节数(默认1MB)，超过的响应原样转发，为-1时不改写响应内容
+	ResponseRewriteMaxBody int64 `json:"ResponseRewriteMaxBody" yaml:"ResponseRewriteMaxBody"`
+}
+
+//Mirror 镜像流量配置
+type Mirror struct {
+	//Host 镜像主机，例如http://localhost:9001
+	Host string `json:"Host" yaml:"Host"`
+	//Percent 镜像的请求百分比(1~100)
+	Percent uint `json:"Percent" yaml:"Percent"`
+	//Timeout 镜像请求的超时时间(毫秒)，默认为1000
+	Timeout uint `json:"Timeout" yaml:"Timeout"`
+	//MaxBodySize 镜像请求内容的最大字节数，超过时不镜像，默认为1MB
+	MaxBodySize int64 `json:"MaxBodySize" yaml:"MaxBodySize"`
+}
+
+//StickySession 会话保持配置，首次请求由负载均衡器选择主机后通过Cookie记录，之后的请求转发到同一主机
+type StickySession struct {
+	//CookieName 记录主机的Cookie名称，默认为PROXY_AFFINITY
+	CookieName string `json:"CookieName" yaml:"CookieName"`
+	//Secret 计算Cookie值的密钥，Cookie中只保存主机地址的HMAC，不保存主机地址本身
+	Secret string `json:"Secret" yaml:"Secret"`
+	//MaxAge Cookie的有效期(秒)，为0时为会话Cookie
+	MaxAge uint `json:"MaxAge" yaml:"MaxAge"`
+}
+
+//CORS 跨域资源共享配置
+type CORS struct {
+	//AllowedOrigins 允许的来源，"*"表示允许所有来源，"*.example.com"表示允许example.com的所有子域名
+	AllowedOrigins []string `json:"AllowedOrigins" yaml:"AllowedOrigins"`
+	//AllowedMethods 允许的请求方法，为空时允许常用的请求方法
+	AllowedMethods []string `json:"AllowedMethods" yaml:"AllowedMethods"`
+	//AllowedHeaders 允许的请求头，为空时允许预检请求中声明的所有请求头
+	AllowedHeaders []string `json:"AllowedHeaders" yaml:"AllowedHeaders"`
+	//MaxAge 预检请求结果的缓存时间(秒)，为0时不设置
+	MaxAge int `json:"MaxAge" yaml:"MaxAge"`
+}
+
+//BasicAuth 路由的Basic认证配置
+type BasicAuth struct {
+	//Realm WWW-Authenticate中的realm，默认为proxy
+	Realm string `json:"Realm" yaml:"Realm"`
+	//Users 用户名及bcrypt哈希后的密码，可通过htpasswd -nbB生成
+	Users map[string]string `json:"Users" yaml:"Users"`
+}
+
+//BackendGroup 按权重分配流量的主机组
+type BackendGroup struct {
+	//Name 主机组名称，例如stable、canary
+	Name string `json:"Name" yaml:"Name"`
+	//Weight 主机组的流量权重，例如canary为5、stable为95，为0时不分配请求
+	Weight int `json:"Weight" yaml:"Weight"`
+	//DownstreamHosts 主机组中的下游主机
+	DownstreamHosts []string `json:"DownstreamHosts" yaml:"DownstreamHosts"`
+	//DownstreamWeights 组内主机的权重，与DownstreamHosts一一对应，未配置时每个主机的权重为1
+	DownstreamWeights []int `json:"DownstreamWeights" yaml:"DownstreamWeights"`
+}
+
+//ClientCert 路由的客户端证书认证配置
+type ClientCert struct {
+	//AllowedSubjects 允许访问的证书主体(CN或SAN)，为空时允许所有通过CA校验的证书
+	AllowedSubjects []string `json:"AllowedSubjects" yaml:"AllowedSubjects"`
+}
+
+//APIKey 路由的API Key认证配置
+type APIKey struct {
+	//Header 携带API Key的请求头，默认为X-API-Key
+	Header string `json:"Header" yaml:"Header"`
+	//QueryParam 携带API Key的查询参数，为空时只从请求头读取
+	QueryParam string `json:"QueryParam" yaml:"QueryParam"`
+	//Keys API Key及其对应的客户端标识，客户端标识会记录到访问日志并通过X-Client-ID转发给下游主机
+	Keys map[string]string `json:"Keys" yaml:"Keys"`
+	//KeysFile 保存API Key的文件，每行为"Key 客户端标识"，#开头的行为注释，支持${ENV}形式引用环境变量
+	KeysFile string `json:"KeysFile" yaml:"KeysFile"`
+}
+
+//LoadKeys 合并Keys及KeysFile中的API Key，相同的Key以文件中的为准
+func (a *APIKey) LoadKeys() (map[string]string, error) {
+	keys := make(map[string]string, len(a.Keys))
+	for key, clientID := range a.Keys {
+		keys[key] = clientID
+	}
+	if a.KeysFile == "" {
+		return keys, nil
+	}
+	path := os.ExpandEnv(a.KeysFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取API Key文件 %s 失败: %s", path, err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("API Key文件 %s 第 %d 行格式不正确，应为\"Key 客户端标识\"", path, i+1)
+		}
+		keys[fields[0]] = fields[1]
+	}
+	return keys, nil
+}
+
+//FallbackResponse 所有下游主机均不可用时返回的静态响应或重定向
+type FallbackResponse struct {
+	//Status 响应状态码，默认为503，配置RedirectURL时默认为302
+	Status int `json:"Status" yaml:"Status"`
+	//Body 响应内容
+	Body string `json:"Body" yaml:"Body"`
+	//ContentType 响应内容类型，默认为text/html; charset=utf-8
+	ContentType string `json:"ContentType" yaml:"ContentType"`
+	//RedirectURL 重定向的地址，配置后忽略Body
+	RedirectURL string `json:"RedirectURL" yaml:"RedirectURL"`
+}
+
+//ErrorPage 错误页面模板，根据客户端的Accept选择HTML或JSON格式，模板中可以引用{{.Status}}及{{.StatusText}}
+type ErrorPage struct {
+	//HTML HTML格式的页面模板
+	HTML string `json:"HTML" yaml:"HTML"`
+	//HTMLFile HTML模板文件，配置后忽略HTML
+	HTMLFile string `json:"HTMLFile" yaml:"HTMLFile"`
+	//JSON JSON格式的页面模板
+	JSON string `json:"JSON" yaml:"JSON"`
+	//JSONFile JSON模板文件，配置后忽略JSON
+	JSONFile string `json:"JSONFile" yaml:"JSONFile"`
+}
+
+//LoadTemplates 获取HTML及JSON模板，配置了模板文件时从文件中读取
+func (p *ErrorPage) LoadTemplates() (html string, json string, err error) {
+	load := func(text, file string) (string, error) {
+		if file == "" {
+			return text, nil
+		}
+		path := os.ExpandEnv(file)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("读取错误页面模板文件 %s 失败: %s", path, err)
+		}
+		return string(data), nil
+	}
+	if html, err = load(p.HTML, p.HTMLFile); err != nil {
+		return "", "", err
+	}
+	if json, err = load(p.JSON, p.JSONFile); err != nil {
+		return "", "", err
+	}
+	return html, json, nil
+}
+
+//IPFilter 客户端IP过滤规则
+type IPFilter struct {
+	//Allow 允许访问的CIDR(例如10.0.0.0/8)或IP，为空时允许所有不在Deny中的IP
+	Allow []string `json:"Allow" yaml:"Allow"`
+	//Deny 拒绝访问的CIDR或IP，优先于Allow
+	Deny []string `json:"Deny" yaml:"Deny"`
+}
+
+//HeaderRules 请求头或响应头的修改规则，按Remove、Set、Add的顺序生效
+type HeaderRules struct {
+	//Set 设置的头部，覆盖已有的值
+	Set map[string]string `json:"Set" yaml:"Set"`
+	//Add 追加的头部，保留已有的值
+	Add map[string]string `json:"Add" yaml:"Add"`
+	//Remove 删除的头部
+	Remove []string `json:"Remove" yaml:"Remove"`
+}
+
+//ResponseRewrite 响应的正则替换规则，替换内容中可以使用$1等引用分组
+type ResponseRewrite struct {
+	//Regex 匹配的正则表达式
+	Regex string `json:"Regex" yaml:"Regex"`
+	//Replacement 替换的内容
+	Replacement string `json:"Replacement" yaml:"Replacement"`
+	//Headers 需要改写的响应头，为空且未配置ContentTypes时默认改写Location及Link
+	Headers []string `json:"Headers" yaml:"Headers"`
+	//ContentTypes 需要改写响应内容的内容类型(按前缀匹配，例如application/json)，为空时不改写响应内容
+	ContentTypes []string `json:"ContentTypes" yaml:"ContentTypes"`
 }
 
 //ValidationAlgorithm 验证算法是否支持
@@ -37,6 +379,390 @@ func (r *Routing) ValidationAlgorithm() error {
 	return nil
 }
 
+//ValidationWeights 验证主机权重配置是否正确
+func (r *Routing) ValidationWeights() error {
+	if len(r.DownstreamWeights) == 0 {
+		return nil
+	}
+	if len(r.DownstreamWeights) != len(r.DownstreamHosts) {
+		return fmt.Errorf("路由 \"%s\" 的DownstreamWeights数量必须与DownstreamHosts一致", r.UpstreamPathTemplate)
+	}
+	for _, w := range r.DownstreamWeights {
+		if w < 1 {
+			return fmt.Errorf("路由 \"%s\" 的DownstreamWeights必须大于0", r.UpstreamPathTemplate)
+		}
+	}
+	return nil
+}
+
+//ValidationBackendGroups 验证主机组的名称不重复、至少有一个主机组的权重大于0，且每个主机只属于一个主机组
+func (r *Routing) ValidationBackendGroups() error {
+	if len(r.BackendGroups) == 0 {
+		return nil
+	}
+	if len(r.DownstreamHosts) > 0 {
+		return fmt.Errorf("路由 \"%s\" 配置了BackendGroups, 不能同时配置DownstreamHosts", r.UpstreamPathTemplate)
+	}
+	names := make(map[string]bool, len(r.BackendGroups))
+	hosts := make(map[string]bool)
+	total := 0
+	for _, g := range r.BackendGroups {
+		if g.Name == "" || names[g.Name] {
+			return fmt.Errorf("路由 \"%s\" 的BackendGroups名称不能为空或重复", r.UpstreamPathTemplate)
+		}
+		names[g.Name] = true
+		if g.Weight < 0 {
+			return fmt.Errorf("路由 \"%s\" 的主机组 %s 的Weight不能为负数", r.UpstreamPathTemplate, g.Name)
+		}
+		total += g.Weight
+		if len(g.DownstreamHosts) == 0 {
+			return fmt.Errorf("路由 \"%s\" 的主机组 %s 至少要配置一个下游主机", r.UpstreamPathTemplate, g.Name)
+		}
+		if len(g.DownstreamWeights) > 0 && len(g.DownstreamWeights) != len(g.DownstreamHosts) {
+			return fmt.Errorf("路由 \"%s\" 的主机组 %s 的DownstreamWeights数量必须与DownstreamHosts一致", r.UpstreamPathTemplate, g.Name)
+		}
+		for _, w := range g.DownstreamWeights {
+			if w < 1 {
+				return fmt.Errorf("路由 \"%s\" 的主机组 %s 的DownstreamWeights必须大于0", r.UpstreamPathTemplate, g.Name)
+			}
+		}
+		for _, h := range g.DownstreamHosts {
+			if hosts[h] {
+				return fmt.Errorf("路由 \"%s\" 的主机 %s 属于多个主机组", r.UpstreamPathTemplate, h)
+			}
+			hosts[h] = true
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("路由 \"%s\" 至少要有一个主机组的Weight大于0", r.UpstreamPathTemplate)
+	}
+	return nil
+}
+
+//AllDownstreamHosts 获取路由的所有下游主机及权重，配置了BackendGroups时按主机组的顺序合并所有主机组的主机
+func (r *Routing) AllDownstreamHosts() ([]string, []int) {
+	if len(r.BackendGroups) == 0 {
+		return r.DownstreamHosts, r.DownstreamWeights
+	}
+	var hosts []string
+	var weights []int
+	for _, g := range r.BackendGroups {
+		for i, h := range g.DownstreamHosts {
+			weight := 1
+			if i < len(g.DownstreamWeights) {
+				weight = g.DownstreamWeights[i]
+			}
+			hosts = append(hosts, h)
+			weights = append(weights, weight)
+		}
+	}
+	return hosts, weights
+}
+
+//ValidationTimeout 验证超时时间配置是否正确
+func (r *Routing) ValidationTimeout() error {
+	if r.MaxRequestTimeout > 0 && r.MaxRequestTimeout < r.RequestTimeout {
+		return fmt.Errorf("路由 \"%s\" 的MaxRequestTimeout不能小于RequestTimeout", r.UpstreamPathTemplate)
+	}
+	return nil
+}
+
+//ValidationConcurrency 验证并发限制配置是否正确
+func (r *Routing) ValidationConcurrency() error {
+	if r.MaxClientShare < 0 || r.MaxClientShare > 1 {
+		return fmt.Errorf("路由 \"%s\" 的MaxClientShare必须在0到1之间", r.UpstreamPathTemplate)
+	}
+	return nil
+}
+
+//ValidationTransport 验证连接池配置是否正确
+func (r *Routing) ValidationTransport() error {
+	if r.MaxIdleConns < 0 || r.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("路由 \"%s\" 的MaxIdleConns和MaxIdleConnsPerHost不能为负数", r.UpstreamPathTemplate)
+	}
+	if r.FlushInterval < -1 {
+		return fmt.Errorf("路由 \"%s\" 的FlushInterval只能为-1(立即Flush)、0或正数", r.UpstreamPathTemplate)
+	}
+	return nil
+}
+
+//HasTransportOptions 是否配置了连接池或HTTP/2参数
+func (r *Routing) HasTransportOptions() bool {
+	return r.MaxIdleConns > 0 || r.MaxIdleConnsPerHost > 0 || r.IdleConnTimeout > 0 || r.DialTimeout > 0 || r.ResponseHeaderTimeout > 0 || r.HTTP2 || r.H2C || r.GRPC
+}
+
+//ValidationErrorBody 验证错误响应改写配置是否正确
+func (r *Routing) ValidationErrorBody() error {
+	if r.RewriteErrorBody && r.PassThroughErrors {
+		return fmt.Errorf("路由 \"%s\" 的RewriteErrorBody不能与PassThroughErrors同时开启", r.UpstreamPathTemplate)
+	}
+	if len(r.RewriteErrorStatuses) > 0 && !r.RewriteErrorBody {
+		return fmt.Errorf("路由 \"%s\" 配置了RewriteErrorStatuses, 需要同时开启RewriteErrorBody", r.UpstreamPathTemplate)
+	}
+	for _, status := range r.RewriteErrorStatuses {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("路由 \"%s\" 的RewriteErrorStatuses包含无效的HTTP状态码 %d", r.UpstreamPathTemplate, status)
+		}
+	}
+	return nil
+}
+
+const (
+	//MatchPrefix 按路径前缀匹配路由
+	MatchPrefix = "prefix"
+	//MatchExact 按完整路径匹配路由
+	MatchExact = "exact"
+	//MatchRegex 按正则表达式匹配完整路径
+	MatchRegex = "regex"
+)
+
+//PathMatchType 获取路由的匹配方式，未配置时为前缀匹配
+func (r *Routing) PathMatchType() string {
+	if r.MatchType == "" {
+		return MatchPrefix
+	}
+	return strings.ToLower(r.MatchType)
+}
+
+//CompileMatch 编译regex匹配方式的正则表达式，正则表达式需要匹配完整路径，其他匹配方式返回nil
+func (r *Routing) CompileMatch() (*regexp.Regexp, error) {
+	switch r.PathMatchType() {
+	case MatchPrefix, MatchExact:
+		return nil, nil
+	case MatchRegex:
+		re, err := regexp.Compile("^(?:" + r.UpstreamPathTemplate + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("路由 \"%s\" 的UpstreamPathTemplate不是有效的正则表达式: %s", r.UpstreamPathTemplate, err)
+		}
+		return re, nil
+	}
+	return nil, fmt.Errorf("路由 \"%s\" 的MatchType \"%s\" 不正确, 支持prefix、exact和regex", r.UpstreamPathTemplate, r.MatchType)
+}
+
+//CompileRewrite 编译路径重写的正则表达式，未配置RewriteRegex时返回nil
+func (r *Routing) CompileRewrite() (*regexp.Regexp, error) {
+	if r.RewriteRegex == "" {
+		if r.RewriteReplacement != "" {
+			return nil, fmt.Errorf("路由 \"%s\" 配置了RewriteReplacement, 需要同时配置RewriteRegex", r.UpstreamPathTemplate)
+		}
+		return nil, nil
+	}
+	re, err := regexp.Compile(r.RewriteRegex)
+	if err != nil {
+		return nil, fmt.Errorf("路由 \"%s\" 的RewriteRegex不正确: %s", r.UpstreamPathTemplate, err)
+	}
+	return re, nil
+}
+
+//CompileResponseRewrites 按顺序编译响应改写规则的正则表达式，并验证改写的响应头名称是否正确
+func (r *Routing) CompileResponseRewrites() ([]*regexp.Regexp, error) {
+	if r.ResponseRewriteMaxBody < -1 {
+		return nil, fmt.Errorf("路由 \"%s\" 的ResponseRewriteMaxBody不能小于-1", r.UpstreamPathTemplate)
+	}
+	res := make([]*regexp.Regexp, 0, len(r.ResponseRewrites))
+	for i, rw := range r.ResponseRewrites {
+		if rw.Regex == "" {
+			return nil, fmt.Errorf("路由 \"%s\" 的第%d条ResponseRewrites未配置Regex", r.UpstreamPathTemplate, i+1)
+		}
+		re, err := regexp.Compile(rw.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("路由 \"%s\" 的第%d条ResponseRewrites的Regex不正确: %s", r.UpstreamPathTemplate, i+1, err)
+		}
+		for _, name := range rw.Headers {
+			if !httpguts.ValidHeaderFieldName(name) {
+				return nil, fmt.Errorf("路由 \"%s\" 的第%d条ResponseRewrites中的头部名称 \"%s\" 不正确", r.UpstreamPathTemplate, i+1, name)
+			}
+		}
+		for _, contentType := range rw.ContentTypes {
+			if strings.TrimSpace(contentType) == "" {
+				return nil, fmt.Errorf("路由 \"%s\" 的第%d条ResponseRewrites的ContentTypes不能包含空值", r.UpstreamPathTemplate, i+1)
+			}
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+//ValidationGRPC 验证gRPC路由没有配置改写响应内容的功能
+func (r *Routing) ValidationGRPC() error {
+	if !r.GRPC {
+		return nil
+	}
+	if r.RewriteErrorBody || len(r.ErrorPages) > 0 {
+		return fmt.Errorf("路由 \"%s\" 为gRPC路由，不能配置RewriteErrorBody或ErrorPages", r.UpstreamPathTemplate)
+	}
+	for _, rw := range r.ResponseRewrites {
+		if len(rw.ContentTypes) > 0 {
+			return fmt.Errorf("路由 \"%s\" 为gRPC路由，ResponseRewrites不能改写响应内容", r.UpstreamPathTemplate)
+		}
+	}
+	return nil
+}
+
+//ValidationAllowedMethods 验证允许的请求方法名称是否正确
+func (r *Routing) ValidationAllowedMethods() error {
+	for _, m := range r.AllowedMethods {
+		if !httpguts.ValidHeaderFieldName(m) {
+			return fmt.Errorf("路由 \"%s\" 的AllowedMethods \"%s\" 不正确", r.UpstreamPathTemplate, m)
+		}
+	}
+	return nil
+}
+
+//ValidationCacheMethods 验证可以缓存的请求方法，PUT、DELETE等写操作的响应不能缓存
+func (r *Routing) ValidationCacheMethods() error {
+	for _, m := range r.CacheMethods {
+		switch m {
+		case http.MethodGet, http.MethodHead, http.MethodPost:
+		default:
+			return fmt.Errorf("路由 \"%s\" 的CacheMethods \"%s\" 不支持, 只能缓存GET、HEAD及POST请求", r.UpstreamPathTemplate, m)
+		}
+	}
+	return nil
+}
+
+//ValidationRetry 验证重试的退避等待配置
+func (r *Routing) ValidationRetry() error {
+	if r.RetryJitter < 0 || r.RetryJitter > 1 {
+		return fmt.Errorf("路由 \"%s\" 的RetryJitter必须在0到1之间", r.UpstreamPathTemplate)
+	}
+	for _, status := range r.RetryOnStatuses {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("路由 \"%s\" 的RetryOnStatuses中的 %d 不是有效的HTTP状态码", r.UpstreamPathTemplate, status)
+		}
+	}
+	for _, code := range r.RetryOnGRPCStatuses {
+		if code < 1 || code > 16 {
+			return fmt.Errorf("路由 \"%s\" 的RetryOnGRPCStatuses中的 %d 不是有效的gRPC错误状态码", r.UpstreamPathTemplate, code)
+		}
+	}
+	if r.RetryMaxDelay > 0 && r.RetryMaxDelay < r.RetryBaseDelay {
+		return fmt.Errorf("路由 \"%s\" 的RetryMaxDelay不能小于RetryBaseDelay", r.UpstreamPathTemplate)
+	}
+	return nil
+}
+
+//ValidationMirror 验证镜像主机地址及镜像百分比
+func (r *Routing) ValidationMirror() error {
+	if r.Mirror == nil {
+		return nil
+	}
+	u, err := url.Parse(r.Mirror.Host)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("路由 \"%s\" 的镜像主机 \"%s\" 不正确，需要包含http或https协议", r.UpstreamPathTemplate, r.Mirror.Host)
+	}
+	if r.Mirror.Percent < 1 || r.Mirror.Percent > 100 {
+		return fmt.Errorf("路由 \"%s\" 的镜像百分比Percent必须在1到100之间", r.UpstreamPathTemplate)
+	}
+	if r.Mirror.MaxBodySize < 0 {
+		return fmt.Errorf("路由 \"%s\" 的镜像请求内容大小MaxBodySize不能为负数", r.UpstreamPathTemplate)
+	}
+	return nil
+}
+
+//ValidationStickySession 验证会话保持配置了密钥及正确的Cookie名称
+func (r *Routing) ValidationStickySession() error {
+	if r.StickySession == nil {
+		return nil
+	}
+	if r.StickySession.Secret == "" {
+		return fmt.Errorf("路由 \"%s\" 开启StickySession时需要配置Secret", r.UpstreamPathTemplate)
+	}
+	if name := r.StickySession.CookieName; name != "" && !httpguts.ValidHeaderFieldName(name) {
+		return fmt.Errorf("路由 \"%s\" 的StickySession的CookieName \"%s\" 不正确", r.UpstreamPathTemplate, name)
+	}
+	return nil
+}
+
+//ValidationFallback 验证所有主机不可用时的响应配置是否正确
+func (r *Routing) ValidationFallback() error {
+	f := r.FallbackResponse
+	if f == nil || f.Status == 0 {
+		return nil
+	}
+	if f.RedirectURL != "" && (f.Status < 300 || f.Status > 399) {
+		return fmt.Errorf("路由 \"%s\" 的FallbackResponse配置了RedirectURL, Status必须是3xx", r.UpstreamPathTemplate)
+	}
+	if f.Status < 100 || f.Status > 599 {
+		return fmt.Errorf("路由 \"%s\" 的FallbackResponse.Status不是有效的HTTP状态码", r.UpstreamPathTemplate)
+	}
+	return nil
+}
+
+//ValidationErrorPages 验证错误页面的状态码是否为4xx或5xx，且至少配置了一种格式的模板
+func (r *Routing) ValidationErrorPages() error {
+	for status, page := range r.ErrorPages {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("路由 \"%s\" 的ErrorPages状态码 %d 不正确, 只支持4xx及5xx", r.UpstreamPathTemplate, status)
+		}
+		if page == nil || (page.HTML == "" && page.HTMLFile == "" && page.JSON == "" && page.JSONFile == "") {
+			return fmt.Errorf("路由 \"%s\" 的ErrorPages状态码 %d 未配置页面模板", r.UpstreamPathTemplate, status)
+		}
+	}
+	return nil
+}
+
+//ValidationHeaders 验证请求头及响应头修改规则中的头部名称是否正确
+func (r *Routing) ValidationHeaders() error {
+	for field, rules := range map[string]*HeaderRules{"RequestHeaders": r.RequestHeaders, "ResponseHeaders": r.ResponseHeaders} {
+		if rules == nil {
+			continue
+		}
+		names := append([]string(nil), rules.Remove...)
+		values := make([]string, 0, len(rules.Set)+len(rules.Add))
+		for _, m := range []map[string]string{rules.Set, rules.Add} {
+			for name, value := range m {
+				names = append(names, name)
+				values = append(values, value)
+			}
+		}
+		for _, name := range names {
+			if !httpguts.ValidHeaderFieldName(name) {
+				return fmt.Errorf("路由 \"%s\" 的%s中的头部名称 \"%s\" 不正确", r.UpstreamPathTemplate, field, name)
+			}
+		}
+		for _, value := range values {
+			if !httpguts.ValidHeaderFieldValue(value) {
+				return fmt.Errorf("路由 \"%s\" 的%s中的头部值 %q 不正确", r.UpstreamPathTemplate, field, value)
+			}
+		}
+	}
+	return nil
+}
+
+//ValidationHealthCheck 验证健康检查配置是否正确
+func (r *Routing) ValidationHealthCheck() error {
+	switch strings.ToLower(r.HealthCheckType) {
+	case "", "http":
+	case "tcp":
+		if r.HealthCheckPath != "" {
+			return fmt.Errorf("路由 \"%s\" 的HealthCheckType为tcp时不能配置HealthCheckPath", r.UpstreamPathTemplate)
+		}
+	default:
+		return fmt.Errorf("路由 \"%s\" 的HealthCheckType \"%s\" 不正确, 支持tcp和http", r.UpstreamPathTemplate, r.HealthCheckType)
+	}
+	if r.HealthCheckPath != "" && !strings.HasPrefix(r.HealthCheckPath, "/") {
+		return fmt.Errorf("路由 \"%s\" 的HealthCheckPath必须以/开头", r.UpstreamPathTemplate)
+	}
+	if r.HealthCheckExpectStatus != 0 && (r.HealthCheckExpectStatus < 100 || r.HealthCheckExpectStatus > 599) {
+		return fmt.Errorf("路由 \"%s\" 的HealthCheckExpectStatus不是有效的HTTP状态码", r.UpstreamPathTemplate)
+	}
+	return nil
+}
+
+//MatchSNI 判断请求的TLS SNI主机名是否与路由配置匹配
+func (r *Routing) MatchSNI(req *http.Request) bool {
+	if req.TLS == nil {
+		return false
+	}
+	for _, host := range r.SNIHosts {
+		if strings.EqualFold(host, req.TLS.ServerName) {
+			return true
+		}
+	}
+	return false
+}
+
 //UpstreamPathParse 上游路径解析
 func (r *Routing) UpstreamPathParse() string {
 	//验证是否以/开头
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouting_MatchSNI(t *testing.T) {
+	r := route("/api/{url}", "GET")
+	r.SNIHosts = []string{"api.example.com", "API.example.org"}
+	request := func(serverName string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "https://api.example.com/api/a", nil)
+		req.TLS = &tls.ConnectionState{ServerName: serverName}
+		return req
+	}
+
+	//SNI主机名不区分大小写
+	assert.True(t, r.MatchSNI(request("api.example.com")))
+	assert.True(t, r.MatchSNI(request("Api.Example.Com")))
+	assert.True(t, r.MatchSNI(request("api.example.org")))
+	assert.False(t, r.MatchSNI(request("www.example.com")))
+	//客户端未发送SNI或不是TLS请求时不匹配，不使用Host请求头
+	assert.False(t, r.MatchSNI(request("")))
+	assert.False(t, r.MatchSNI(httptest.NewRequest(http.MethodGet, "http://api.example.com/api/a", nil)))
+}
+
+func TestConfig_ValidationSNIHosts(t *testing.T) {
+	sni := route("/api/{url}", "GET")
+	sni.SNIHosts = []string{"api.example.com"}
+
+	//SNI匹配仅适用于https模式
+	cfg := &Config{Schema: "http", HealthCheckInterval: 1, Routes: []Routing{sni}}
+	assert.Error(t, cfg.Validation())
+	cfg.Listeners = []Listener{{Address: ":80"}, {Address: ":443", Schema: "https", CertCrt: "a.crt", CertKey: "a.key"}}
+	assert.NoError(t, cfg.Validation())
+
+	//配置了SNI的路由不会遮挡之后相同前缀的路由
+	cfg.Routes = []Routing{sni, route("/api/{url}", "GET")}
+	assert.NoError(t, cfg.Validation())
+	cfg.Routes = []Routing{route("/api/{url}", "GET"), sni}
+	assert.Error(t, cfg.Validation())
+}
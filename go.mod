@@ -3,13 +3,26 @@ module proxy
 go 1.16
 
 require (
+	github.com/fsnotify/fsnotify v1.5.1
+	github.com/golang-jwt/jwt/v4 v4.3.0
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/jinzhu/configor v1.2.1
 	github.com/jinzhu/gorm v1.9.16
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
 	github.com/lestrrat-go/strftime v1.0.6 // indirect
+	github.com/prometheus/client_golang v1.12.1
 	github.com/starwander/GoFibonacciHeap v0.0.0-20190508061137-ba2e4f01000a
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli v1.22.9
+	go.opentelemetry.io/otel v1.4.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.4.1
+	go.opentelemetry.io/otel/sdk v1.4.1
+	go.opentelemetry.io/otel/trace v1.4.1
 	go.uber.org/zap v1.21.0
+	google.golang.org/grpc v1.44.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/net v0.0.0-20210525063256-abc453219eb5
+	golang.org/x/time v0.0.0-20220224211638-0e9765cccd65
+	gopkg.in/yaml.v3 v3.0.1
 )
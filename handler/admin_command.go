@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"proxy/util/logging"
+	"strings"
+	"sync"
+)
+
+//CommandServer 基于本地UNIX socket的文本管理命令接口，每行一条命令，例如:
+//  stat
+//  drain host 127.0.0.1:8080
+//  undrain host 127.0.0.1:8080
+//  reload
+type CommandServer struct {
+	mux    sync.RWMutex
+	routes []*RoutePrefixHandler
+	//Reload 重新加载配置，为nil时不支持reload命令
+	Reload func() error
+}
+
+//NewCommandServer 根据已注册的路由创建管理命令接口
+func NewCommandServer(routes []*RoutePrefixHandler) *CommandServer {
+	return &CommandServer{routes: routes}
+}
+
+//SetRoutes 配置重新加载后替换管理的路由
+func (cs *CommandServer) SetRoutes(routes []*RoutePrefixHandler) {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	cs.routes = routes
+}
+
+//currentRoutes 获取当前管理的路由
+func (cs *CommandServer) currentRoutes() []*RoutePrefixHandler {
+	cs.mux.RLock()
+	defer cs.mux.RUnlock()
+	return cs.routes
+}
+
+//ListenAndServe 监听本地UNIX socket并处理命令，socket文件权限为0600，只允许当前用户访问
+func (cs *CommandServer) ListenAndServe(path string) error {
+	listener, err := ListenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	logging.Infof("[%s] 管理命令接口启动成功，正在监听中....", path)
+	return cs.Serve(listener)
+}
+
+//Serve 处理listener上的连接，listener关闭时返回
+func (cs *CommandServer) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go cs.serve(conn)
+	}
+}
+
+//ListenUnix 监听本地UNIX socket，socket文件权限为0600，listener关闭时删除socket文件
+//path已存在时只删除遗留的socket文件，不是socket或仍有进程在监听时返回错误
+//socket先在权限为0700的临时目录中创建并设置权限，再移动到path，不会以宽松的权限暴露
+func ListenUnix(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	//移动后由socketListener删除最终路径的socket文件
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return &socketListener{Listener: listener, path: path}, nil
+}
+
+//removeStaleSocket 删除遗留的socket文件，path不存在时直接返回
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s 已存在且不是socket文件", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s 已有进程在监听", path)
+	}
+	return os.Remove(path)
+}
+
+//socketListener 关闭时删除socket文件的listener
+type socketListener struct {
+	net.Listener
+	path      string
+	closeOnce sync.Once
+}
+
+func (l *socketListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() {
+		_ = os.Remove(l.path)
+	})
+	return err
+}
+
+//serve 处理一个连接上的命令
+func (cs *CommandServer) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "quit" || line == "exit" {
+			return
+		}
+		if _, err := fmt.Fprintln(conn, cs.Exec(line)); err != nil {
+			return
+		}
+	}
+}
+
+//Exec 执行一条命令并返回结果，成功时以OK开头，失败时以ERR开头
+func (cs *CommandServer) Exec(line string) string {
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 1 && fields[0] == "stat":
+		return cs.stat()
+	case len(fields) == 3 && fields[0] == "drain" && fields[1] == "host":
+		return cs.eachHost(fields[2], (*RoutePrefixHandler).Drain)
+	case len(fields) == 3 && fields[0] == "undrain" && fields[1] == "host":
+		return cs.eachHost(fields[2], (*RoutePrefixHandler).Undrain)
+	case len(fields) == 1 && fields[0] == "reload":
+		if cs.Reload == nil {
+			return "ERR 不支持reload命令"
+		}
+		if err := cs.Reload(); err != nil {
+			return "ERR " + err.Error()
+		}
+		return "OK"
+	default:
+		return "ERR 未知命令, 支持的命令: stat | drain host <host> | undrain host <host> | reload"
+	}
+}
+
+//stat 输出每个路由的概要信息
+func (cs *CommandServer) stat() string {
+	var b strings.Builder
+	b.WriteString("OK")
+	for _, rh := range cs.currentRoutes() {
+		s := rh.Summary()
+		fmt.Fprintf(&b, "\n%s algorithm=%s hosts=%d alive=%d pending=%d drained=%d health=%s inflight=%d requests=%d errors=%d p99=%.2fms",
+			s.UpstreamPath, s.Algorithm, s.Hosts, s.AliveHosts, s.PendingHosts, s.DrainedHosts, s.HealthState,
+			s.Stats.Inflight, s.Stats.Requests, s.Stats.Errors, s.Stats.LatencyP99Ms)
+	}
+	return b.String()
+}
+
+//eachHost 对所有包含该主机的路由执行操作
+func (cs *CommandServer) eachHost(host string, action func(*RoutePrefixHandler, string) error) string {
+	matched := 0
+	for _, rh := range cs.currentRoutes() {
+		if err := action(rh, host); err == nil {
+			matched++
+		}
+	}
+	if matched == 0 {
+		return fmt.Sprintf("ERR 主机 %s 不存在", host)
+	}
+	return fmt.Sprintf("OK %d 个路由", matched)
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//dialCommand 连接管理命令接口，返回发送一条命令并读取lines行结果的方法
+func dialCommand(t *testing.T, path string) (exec func(line string, lines int) string, close func()) {
+	conn, err := net.Dial("unix", path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	reader := bufio.NewReader(conn)
+	exec = func(line string, lines int) string {
+		_, err := conn.Write([]byte(line + "\n"))
+		assert.NoError(t, err)
+		var result []string
+		for i := 0; i < lines; i++ {
+			text, err := reader.ReadString('\n')
+			if !assert.NoError(t, err) {
+				break
+			}
+			result = append(result, strings.TrimSuffix(text, "\n"))
+		}
+		return strings.Join(result, "\n")
+	}
+	return exec, func() { _ = conn.Close() }
+}
+
+func TestCommandServer(t *testing.T) {
+	rh := newTestRoute(t, "http://127.0.0.1:1", "http://127.0.0.1:2")
+	cs := NewCommandServer([]*RoutePrefixHandler{rh})
+	reloads := 0
+	cs.Reload = func() error {
+		reloads++
+		if reloads > 1 {
+			return errors.New("配置不正确")
+		}
+		return nil
+	}
+
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	listener, err := ListenUnix(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	go func() {
+		_ = cs.Serve(listener)
+	}()
+	info, err := os.Lstat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	exec, closeConn := dialCommand(t, path)
+	defer closeConn()
+	stat := exec("stat", 2)
+	assert.True(t, strings.HasPrefix(stat, "OK\n/api algorithm=round-robin hosts=2 alive=2 pending=0 drained=0"), stat)
+
+	assert.Equal(t, "OK 1 个路由", exec("drain host 127.0.0.1:1", 1))
+	assert.True(t, rh.IsDrained("127.0.0.1:1"))
+	assert.Contains(t, exec("stat", 2), "drained=1")
+	assert.Equal(t, "ERR 主机 127.0.0.1:3 不存在", exec("drain host 127.0.0.1:3", 1))
+	assert.Equal(t, "OK 1 个路由", exec("undrain host 127.0.0.1:1", 1))
+	assert.False(t, rh.IsDrained("127.0.0.1:1"))
+
+	assert.Equal(t, "OK", exec("reload", 1))
+	assert.Equal(t, "ERR 配置不正确", exec("reload", 1))
+	assert.True(t, strings.HasPrefix(exec("unknown", 1), "ERR 未知命令"))
+
+	//关闭后删除socket文件
+	assert.NoError(t, listener.Close())
+	_, err = os.Lstat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestListenUnix_ExistingPath(t *testing.T) {
+	dir := t.TempDir()
+
+	//不是socket的文件不会被删除
+	file := filepath.Join(dir, "config.yml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("port: 8080\n"), 0644))
+	_, err := ListenUnix(file)
+	assert.Error(t, err)
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "port: 8080\n", string(data))
+
+	//仍有进程在监听的socket不会被替换
+	path := filepath.Join(dir, "admin.sock")
+	listener, err := ListenUnix(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = ListenUnix(path)
+	assert.Error(t, err)
+	assert.NoError(t, listener.Close())
+
+	//遗留的socket文件被删除后重新监听
+	stale, err := net.Listen("unix", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.NoError(t, stale.Close())
+	listener, err = ListenUnix(path)
+	if assert.NoError(t, err) {
+		assert.NoError(t, listener.Close())
+	}
+
+	//临时目录已清理
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
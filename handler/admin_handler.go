@@ -0,0 +1,433 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/pprof"
+	"proxy/balancer"
+	"proxy/util/logging"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	//HealthStateHealthy 所有主机都存活
+	HealthStateHealthy = "healthy"
+	//HealthStateDegraded 部分主机存活
+	HealthStateDegraded = "degraded"
+	//HealthStateDown 没有存活的主机
+	HealthStateDown = "down"
+)
+
+//defaultPageLimit 路由列表默认每页数量
+const defaultPageLimit = 100
+
+//AdminHandler 管理接口处理程序
+type AdminHandler struct {
+	router *mux.Router
+	mux    sync.RWMutex
+	routes []*RoutePrefixHandler
+	//Config 获取当前生效的配置(已隐藏敏感字段)，为nil时/admin/config返回404
+	Config func() interface{}
+}
+
+//RouteSummary 路由概要信息
+type RouteSummary struct {
+	UpstreamPath   string
+	DownstreamPath string
+	Algorithm      string
+	Hosts          int
+	AliveHosts     int
+	PendingHosts   int
+	DrainedHosts   int
+	EjectedHosts   int
+	HealthState    string
+	Stats          RouteStats
+}
+
+//RouteList 路由列表查询结果
+type RouteList struct {
+	Total  int
+	Offset int
+	Limit  int
+	Routes []RouteSummary
+}
+
+//NewAdminHandler 根据已注册的路由创建管理接口处理程序
+func NewAdminHandler(routes []*RoutePrefixHandler) *AdminHandler {
+	ah := &AdminHandler{
+		router: mux.NewRouter(),
+		routes: routes,
+	}
+	ah.router.HandleFunc("/admin/routes", ah.listRoutes).Methods(http.MethodGet)
+	ah.router.HandleFunc("/admin/weights", ah.setWeight).Methods(http.MethodPut)
+	ah.router.HandleFunc("/admin/stats", ah.listStats).Methods(http.MethodGet)
+	ah.router.HandleFunc("/admin/health", ah.listHealth).Methods(http.MethodGet)
+	ah.router.HandleFunc("/admin/groups", ah.listGroups).Methods(http.MethodGet)
+	ah.router.HandleFunc("/admin/groups", ah.setGroupWeight).Methods(http.MethodPut)
+	ah.router.HandleFunc("/admin/hosts", ah.addHost).Methods(http.MethodPost)
+	ah.router.HandleFunc("/admin/hosts", ah.removeHost).Methods(http.MethodDelete)
+	ah.router.HandleFunc("/admin/drain", ah.drainHost).Methods(http.MethodPost)
+	ah.router.HandleFunc("/admin/drain", ah.undrainHost).Methods(http.MethodDelete)
+	ah.router.HandleFunc("/admin/config", ah.showConfig).Methods(http.MethodGet)
+	return ah
+}
+
+//EnablePprof 在管理接口上提供net/http/pprof的性能分析接口，filter用于限制可以访问的客户端
+func (ah *AdminHandler) EnablePprof(filter func(next http.Handler) http.Handler) {
+	router := ah.router.PathPrefix("/debug/pprof").Subrouter()
+	router.Use(filter)
+	router.HandleFunc("/cmdline", pprof.Cmdline)
+	router.HandleFunc("/profile", pprof.Profile)
+	router.HandleFunc("/symbol", pprof.Symbol)
+	router.HandleFunc("/trace", pprof.Trace)
+	//其他路径(heap、goroutine等)由Index按名称输出
+	router.PathPrefix("/").HandlerFunc(pprof.Index)
+}
+
+//SetRoutes 配置重新加载后替换管理的路由
+func (ah *AdminHandler) SetRoutes(routes []*RoutePrefixHandler) {
+	ah.mux.Lock()
+	defer ah.mux.Unlock()
+	ah.routes = routes
+}
+
+//currentRoutes 获取当前管理的路由
+func (ah *AdminHandler) currentRoutes() []*RoutePrefixHandler {
+	ah.mux.RLock()
+	defer ah.mux.RUnlock()
+	return ah.routes
+}
+
+//showConfig 输出当前生效的配置，用于确认环境变量替换、默认值及热加载的结果
+func (ah *AdminHandler) showConfig(w http.ResponseWriter, r *http.Request) {
+	if ah.Config == nil {
+		writeError(w, http.StatusNotFound, "未提供配置")
+		return
+	}
+	writeJSON(w, http.StatusOK, ah.Config())
+}
+
+//WeightRequest 调整主机权重的请求
+type WeightRequest struct {
+	Route  string
+	Host   string
+	Weight int
+}
+
+//setWeight 运行时调整主机权重
+func (ah *AdminHandler) setWeight(w http.ResponseWriter, r *http.Request) {
+	var req WeightRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "无效的请求内容: "+err.Error())
+		return
+	}
+	rh := ah.route(req.Route)
+	if rh == nil {
+		writeError(w, http.StatusNotFound, "路由 "+req.Route+" 不存在")
+		return
+	}
+	if err := rh.SetWeight(req.Host, req.Weight); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, balancer.ErrHostNotFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	logging.Infof("路由 %s 主机 %s 的权重已调整为 %d", req.Route, req.Host, req.Weight)
+	writeJSON(w, http.StatusOK, req)
+}
+
+//GroupWeightRequest 调整主机组流量权重的请求
+type GroupWeightRequest struct {
+	Route  string
+	Group  string
+	Weight int
+}
+
+//setGroupWeight 运行时调整主机组的流量权重，例如逐步增加灰度主机组的流量
+func (ah *AdminHandler) setGroupWeight(w http.ResponseWriter, r *http.Request) {
+	var req GroupWeightRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "无效的请求内容: "+err.Error())
+		return
+	}
+	rh := ah.route(req.Route)
+	if rh == nil {
+		writeError(w, http.StatusNotFound, "路由 "+req.Route+" 不存在")
+		return
+	}
+	if err := rh.SetGroupWeight(req.Group, req.Weight); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, balancer.ErrGroupNotFound) || errors.Is(err, ErrNoBackendGroups) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	logging.Infof("路由 %s 主机组 %s 的流量权重已调整为 %d", req.Route, req.Group, req.Weight)
+	writeJSON(w, http.StatusOK, RouteGroups{UpstreamPath: rh.UpstreamPath, Groups: rh.BackendGroups()})
+}
+
+//RouteGroups 路由各主机组的流量权重
+type RouteGroups struct {
+	UpstreamPath string
+	Groups       []balancer.GroupStat
+}
+
+//listGroups 查询配置了主机组的路由的流量权重，可通过route参数指定路由
+func (ah *AdminHandler) listGroups(w http.ResponseWriter, r *http.Request) {
+	route := r.URL.Query().Get("route")
+	result := make([]RouteGroups, 0)
+	for _, rh := range ah.currentRoutes() {
+		if route != "" && rh.UpstreamPath != route {
+			continue
+		}
+		if groups := rh.BackendGroups(); groups != nil {
+			result = append(result, RouteGroups{UpstreamPath: rh.UpstreamPath, Groups: groups})
+		}
+	}
+	if route != "" && len(result) == 0 {
+		writeError(w, http.StatusNotFound, "路由 "+route+" 不存在或未配置主机组")
+		return
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].UpstreamPath < result[j].UpstreamPath
+	})
+	writeJSON(w, http.StatusOK, result)
+}
+
+//HostRequest 添加、删除主机的请求
+type HostRequest struct {
+	Route string `json:"route"`
+	Host  string `json:"host"`
+}
+
+//addHost 运行时为路由添加主机
+func (ah *AdminHandler) addHost(w http.ResponseWriter, r *http.Request) {
+	var req HostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "无效的请求内容: "+err.Error())
+		return
+	}
+	rh := ah.route(req.Route)
+	if rh == nil {
+		writeError(w, http.StatusNotFound, "路由 "+req.Route+" 不存在")
+		return
+	}
+	if _, err := rh.AddHost(req.Host); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrHostAlreadyExists) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, req)
+}
+
+//removeHost 运行时删除路由的主机
+func (ah *AdminHandler) removeHost(w http.ResponseWriter, r *http.Request) {
+	var req HostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "无效的请求内容: "+err.Error())
+		return
+	}
+	rh := ah.route(req.Route)
+	if rh == nil {
+		writeError(w, http.StatusNotFound, "路由 "+req.Route+" 不存在")
+		return
+	}
+	if err := rh.RemoveHost(req.Host); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+//drainHost 将路由的主机置为维护状态，不再分配新的请求
+func (ah *AdminHandler) drainHost(w http.ResponseWriter, r *http.Request) {
+	ah.maintainHost(w, r, (*RoutePrefixHandler).Drain)
+}
+
+//undrainHost 取消路由的主机的维护状态
+func (ah *AdminHandler) undrainHost(w http.ResponseWriter, r *http.Request) {
+	ah.maintainHost(w, r, (*RoutePrefixHandler).Undrain)
+}
+
+//maintainHost 解析请求中的路由及主机，调用fn修改主机的维护状态
+func (ah *AdminHandler) maintainHost(w http.ResponseWriter, r *http.Request, fn func(*RoutePrefixHandler, string) error) {
+	var req HostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "无效的请求内容: "+err.Error())
+		return
+	}
+	rh := ah.route(req.Route)
+	if rh == nil {
+		writeError(w, http.StatusNotFound, "路由 "+req.Route+" 不存在")
+		return
+	}
+	if err := fn(rh, req.Host); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+//RouteHostStats 路由下各主机的负载均衡器状态
+type RouteHostStats struct {
+	UpstreamPath string
+	Algorithm    string
+	Hosts        []balancer.HostStat
+}
+
+//listStats 查询各路由负载均衡器中主机的负载快照，可通过route参数指定路由
+func (ah *AdminHandler) listStats(w http.ResponseWriter, r *http.Request) {
+	route := r.URL.Query().Get("route")
+	routes := ah.currentRoutes()
+	result := make([]RouteHostStats, 0, len(routes))
+	for _, rh := range routes {
+		if route != "" && rh.UpstreamPath != route {
+			continue
+		}
+		result = append(result, RouteHostStats{
+			UpstreamPath: rh.UpstreamPath,
+			Algorithm:    rh.Algorithm,
+			Hosts:        rh.bl.Stats(),
+		})
+	}
+	if route != "" && len(result) == 0 {
+		writeError(w, http.StatusNotFound, "路由 "+route+" 不存在")
+		return
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].UpstreamPath < result[j].UpstreamPath
+	})
+	writeJSON(w, http.StatusOK, result)
+}
+
+//listHealth 查询各路由主机的健康状态及最近的状态变化，可通过route参数指定路由
+func (ah *AdminHandler) listHealth(w http.ResponseWriter, r *http.Request) {
+	route := r.URL.Query().Get("route")
+	routes := ah.currentRoutes()
+	result := make([]RouteHealth, 0, len(routes))
+	for _, rh := range routes {
+		if route != "" && rh.UpstreamPath != route {
+			continue
+		}
+		result = append(result, rh.Health())
+	}
+	if route != "" && len(result) == 0 {
+		writeError(w, http.StatusNotFound, "路由 "+route+" 不存在")
+		return
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].UpstreamPath < result[j].UpstreamPath
+	})
+	writeJSON(w, http.StatusOK, result)
+}
+
+//route 根据上游请求路径获取路由
+func (ah *AdminHandler) route(upstreamPath string) *RoutePrefixHandler {
+	for _, rh := range ah.currentRoutes() {
+		if rh.UpstreamPath == upstreamPath {
+			return rh
+		}
+	}
+	return nil
+}
+
+//ServeHTTP 实现http.Handler
+func (ah *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ah.router.ServeHTTP(w, r)
+}
+
+//listRoutes 查询路由列表，支持按前缀(prefix)、健康状态(health)过滤及分页(offset、limit)
+func (ah *AdminHandler) listRoutes(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	health := query.Get("health")
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+
+	routes := ah.currentRoutes()
+	summaries := make([]RouteSummary, 0, len(routes))
+	for _, rh := range routes {
+		if prefix != "" && !strings.HasPrefix(rh.UpstreamPath, prefix) {
+			continue
+		}
+		summary := rh.Summary()
+		if health != "" && summary.HealthState != health {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].UpstreamPath < summaries[j].UpstreamPath
+	})
+
+	result := RouteList{Total: len(summaries), Offset: offset, Limit: limit, Routes: []RouteSummary{}}
+	if offset < len(summaries) {
+		end := offset + limit
+		if end > len(summaries) {
+			end = len(summaries)
+		}
+		result.Routes = summaries[offset:end]
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+//Summary 获取路由概要信息
+func (rh *RoutePrefixHandler) Summary() RouteSummary {
+	rh.mux.RLock()
+	defer rh.mux.RUnlock()
+
+	summary := RouteSummary{
+		UpstreamPath:   rh.UpstreamPath,
+		DownstreamPath: rh.DownstreamPath,
+		Algorithm:      rh.Algorithm,
+		Hosts:          len(rh.reverseProxyMap),
+		PendingHosts:   len(rh.pending),
+		DrainedHosts:   len(rh.drained),
+		EjectedHosts:   len(rh.ejected),
+		Stats:          rh.stats.snapshot(),
+	}
+	for _, alive := range rh.alive {
+		if alive {
+			summary.AliveHosts++
+		}
+	}
+	//预热中的主机还未接收请求，不计入健康状态
+	switch {
+	case summary.AliveHosts == 0:
+		summary.HealthState = HealthStateDown
+	case summary.AliveHosts < summary.Hosts-summary.PendingHosts:
+		summary.HealthState = HealthStateDegraded
+	default:
+		summary.HealthState = HealthStateHealthy
+	}
+	return summary
+}
+
+//writeError 输出JSON格式的错误信息
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"Error": message})
+}
+
+//writeJSON 输出JSON格式的响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
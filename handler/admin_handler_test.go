@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//newAdminRoutes 创建测试用的路由，/api/v2的主机全部不可用，/web的主机部分不可用
+func newAdminRoutes(t *testing.T) []*RoutePrefixHandler {
+	var routes []*RoutePrefixHandler
+	for _, path := range []string{"/web", "/api/v2", "/api/v1"} {
+		rh, err := NewRoutePrefixHandler("round-robin", path, "/", []string{"http://127.0.0.1:1", "http://127.0.0.1:2"}, nil)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(rh.Stop)
+		routes = append(routes, rh)
+	}
+	routes[0].SetAlive("127.0.0.1:1", false)
+	routes[1].SetAlive("127.0.0.1:1", false)
+	routes[1].SetAlive("127.0.0.1:2", false)
+	return routes
+}
+
+func TestAdminHandler_ListRoutes(t *testing.T) {
+	ah := NewAdminHandler(newAdminRoutes(t))
+	list := func(query string) RouteList {
+		rec := httptest.NewRecorder()
+		ah.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/routes"+query, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+		var result RouteList
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		return result
+	}
+	paths := func(result RouteList) []string {
+		var paths []string
+		for _, route := range result.Routes {
+			paths = append(paths, route.UpstreamPath)
+		}
+		return paths
+	}
+
+	//按上游路径排序
+	result := list("")
+	assert.Equal(t, 3, result.Total)
+	assert.Equal(t, defaultPageLimit, result.Limit)
+	assert.Equal(t, []string{"/api/v1", "/api/v2", "/web"}, paths(result))
+	web := result.Routes[2]
+	assert.Equal(t, 2, web.Hosts)
+	assert.Equal(t, 1, web.AliveHosts)
+	assert.Equal(t, HealthStateDegraded, web.HealthState)
+
+	//按前缀及健康状态过滤
+	assert.Equal(t, []string{"/api/v1", "/api/v2"}, paths(list("?prefix=/api")))
+	assert.Equal(t, []string{"/api/v2"}, paths(list("?health=down")))
+	assert.Equal(t, []string{"/api/v1"}, paths(list("?prefix=/api&health=healthy")))
+
+	//分页
+	result = list("?offset=1&limit=1")
+	assert.Equal(t, 3, result.Total)
+	assert.Equal(t, []string{"/api/v2"}, paths(result))
+	result = list("?offset=5")
+	assert.Equal(t, 3, result.Total)
+	assert.NotNil(t, result.Routes)
+	assert.Empty(t, result.Routes)
+	assert.Equal(t, []string{"/api/v1", "/api/v2", "/web"}, paths(list("?offset=-1&limit=0")))
+
+	//路由列表只支持GET
+	rec := httptest.NewRecorder()
+	ah.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/routes", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestSummary_PendingHosts(t *testing.T) {
+	rh := newTestRoute(t, "http://127.0.0.1:1", "http://127.0.0.1:2")
+
+	//预热中的主机不计入健康状态
+	rh.mux.Lock()
+	rh.pending["127.0.0.1:2"] = true
+	rh.setAliveLocked("127.0.0.1:2", false)
+	rh.mux.Unlock()
+	summary := rh.Summary()
+	assert.Equal(t, 1, summary.PendingHosts)
+	assert.Equal(t, HealthStateHealthy, summary.HealthState)
+
+	rh.SetAlive("127.0.0.1:1", false)
+	assert.Equal(t, HealthStateDown, rh.Summary().HealthState)
+}
@@ -1,51 +1,79 @@
 package handler
 
-import (
-	"fmt"
-	"net/http"
-	"net/url"
-	"proxy/util/logging"
-)
-
-//var (
-//	NoHostError                = errors.New("no host")
-//	ErrHostNotFound            = errors.New("host not found")
-//	InvalidTargetHost          = errors.New("invalid target host")
-//	ErrHostAlreadyExists       = errors.New("host already exists")
-//	AlgorithmNotSupportedError = errors.New("algorithm not supported")
-//)
-
-//registerHost 添加主机
-func (rh *RoutePrefixHandler) registerHost(w http.ResponseWriter, r *http.Request) {
-	_ = r.ParseForm()
-	var resultStr string
-	urlStr := r.Form["url"][0]
-	dest, err := url.Parse(urlStr)
-	if err != nil || dest.Scheme == "" || dest.Host == "" {
-		resultStr = fmt.Sprintf("无效的主机: %s", urlStr)
-	}else {
-		host := cleanHost(dest.Host)
-		if rh.reverseProxyMap[host] != nil {
-			resultStr = fmt.Sprintf("主机: %s 已存在", urlStr)
-		}else {
-			rh.bl.Add(host)
-			rh.alive[host] = true
-			rh.reverseProxyMap[host] = newSingleHostReverseProxy(dest, rh.UpstreamPath, rh.DownstreamPath)
-			logging.Infof("主机 %s 初始化成功", urlStr)
-			resultStr = fmt.Sprintf("主机 %s 初始化成功", urlStr)
-		}
-	}
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(resultStr))
+import "proxy/util/logging"
+
+//AddHost 添加主机并创建对应的反向代理，rawURL 为带协议的主机地址，例如 http://127.0.0.1:8080 或 unix:///var/run/app.sock
+//开启健康检查并配置了预热次数时，新主机需要先通过预热才会加入负载均衡器，此时返回的 warmup 为 true
+func (rh *RoutePrefixHandler) AddHost(rawURL string) (warmup bool, err error) {
+	dest, err := parseHost(rawURL)
+	if err != nil {
+		return false, err
+	}
+	host := hostName(dest)
+
+	rh.mux.Lock()
+	if rh.reverseProxyMap[host] != nil {
+		rh.mux.Unlock()
+		return false, ErrHostAlreadyExists
+	}
+	interval := rh.healthCheckInterval
+	warmup = interval > 0 && rh.Warmup > 0
+	rh.alive[host] = !warmup
+	if warmup {
+		rh.pending[host] = true
+	}
+	rh.inflight[host] = new(int64)
+	rh.schemes[host] = dest.Scheme
+	rh.reverseProxyMap[host] = rh.newSingleHostReverseProxy(dest)
+	rh.mux.Unlock()
+
+	if !warmup {
+		rh.bl.Add(host)
+	}
+	if interval > 0 {
+		go rh.healthCheck(host, interval)
+	}
+	if warmup {
+		logging.Infof("主机 %s 初始化成功, 正在预热", rawURL)
+	} else {
+		logging.Infof("主机 %s 初始化成功", rawURL)
+	}
+	return warmup, nil
 }
 
-func (rh *RoutePrefixHandler) unregisterHost(w http.ResponseWriter, r *http.Request) {
-	_ = r.ParseForm()
-	host := r.Form["host"][0]
-	if host != "" {
-		rh.bl.Remove(host)
+//RemoveHost 从负载均衡器中删除主机并删除对应的反向代理，host 可以是 ip:port、unix:套接字路径或带协议的主机地址
+//已分配到该主机的请求继续处理完成，主机的健康检查会在下一次检查时退出
+func (rh *RoutePrefixHandler) RemoveHost(host string) error {
+	host = normalizeHost(host)
+
+	rh.mux.Lock()
+	if rh.reverseProxyMap[host] == nil {
+		rh.mux.Unlock()
+		return ErrHostNotFound
 	}
+	delete(rh.reverseProxyMap, host)
+	delete(rh.alive, host)
+	delete(rh.pending, host)
+	delete(rh.drained, host)
+	delete(rh.inflight, host)
+	delete(rh.schemes, host)
+	delete(rh.fails, host)
+	delete(rh.ejected, host)
+	delete(rh.health, host)
+	delete(rh.weights, host)
+	rh.mux.Unlock()
 
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(fmt.Sprintf("主机: %s 删除成功", host)))
+	rh.bl.Remove(host)
+	//再次添加同名主机时不再处于维护状态
+	rh.bl.Undrain(host)
+	logging.Infof("主机 %s 删除成功", host)
+	return nil
+}
+
+//normalizeHost 将 ip:port、unix:套接字路径或带协议的主机地址转换为负载均衡器中的主机名
+func normalizeHost(host string) string {
+	if dest, err := parseHost(host); err == nil {
+		return hostName(dest)
+	}
+	return cleanHost(host)
 }
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"mime"
+	"net/http"
+	"proxy/util/logging"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+const (
+	errorFormatPlain = ""
+	errorFormatHTML  = "html"
+	errorFormatJSON  = "json"
+)
+
+//ErrorPage 错误页面，根据客户端的Accept选择HTML或JSON模板
+type ErrorPage struct {
+	html *htmltemplate.Template
+	json *texttemplate.Template
+}
+
+//errorPageData 错误页面模板可以引用的数据，不包含内部错误信息
+type errorPageData struct {
+	Status     int
+	StatusText string
+}
+
+//NewErrorPage 解析错误页面的HTML及JSON模板，为空的模板不使用
+func NewErrorPage(html, json string) (*ErrorPage, error) {
+	page := &ErrorPage{}
+	if html != "" {
+		t, err := htmltemplate.New("html").Parse(html)
+		if err != nil {
+			return nil, err
+		}
+		page.html = t
+	}
+	if json != "" {
+		t, err := texttemplate.New("json").Parse(json)
+		if err != nil {
+			return nil, err
+		}
+		page.json = t
+	}
+	return page, nil
+}
+
+//render 根据客户端期望的格式生成错误页面，返回内容类型及内容
+func (p *ErrorPage) render(format string, status int) (string, []byte, error) {
+	data := errorPageData{Status: status, StatusText: http.StatusText(status)}
+	var buf bytes.Buffer
+	if p.json != nil && (format == errorFormatJSON || p.html == nil) {
+		if err := p.json.Execute(&buf, data); err != nil {
+			return "", nil, err
+		}
+		return "application/json; charset=utf-8", buf.Bytes(), nil
+	}
+	if err := p.html.Execute(&buf, data); err != nil {
+		return "", nil, err
+	}
+	return "text/html; charset=utf-8", buf.Bytes(), nil
+}
+
+//errorFormat 根据Accept中text/html及application/json的优先级选择错误页面的格式，都不接受时返回纯文本
+func errorFormat(r *http.Request) string {
+	htmlQ, jsonQ := 0.0, 0.0
+	for _, v := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(v, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if v, err := strconv.ParseFloat(params["q"], 64); err == nil {
+				q = v
+			}
+			switch {
+			case mediaType == "text/html" && q > htmlQ:
+				htmlQ = q
+			case (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) && q > jsonQ:
+				jsonQ = q
+			}
+		}
+	}
+	switch {
+	case jsonQ > 0 && jsonQ > htmlQ:
+		return errorFormatJSON
+	case htmlQ > 0:
+		return errorFormatHTML
+	}
+	return errorFormatPlain
+}
+
+//renderError 生成状态码对应的错误页面，未配置错误页面时返回默认页面，只包含状态码及状态描述
+func (rh *RoutePrefixHandler) renderError(r *http.Request, status int) (string, []byte) {
+	format := errorFormat(r)
+	if page := rh.ErrorPages[status]; page != nil {
+		contentType, body, err := page.render(format, status)
+		if err == nil {
+			return contentType, body
+		}
+		logging.Errorf("路由 %s 生成状态码 %d 的错误页面失败: %s", rh.UpstreamPath, status, err)
+	}
+	text := http.StatusText(status)
+	switch format {
+	case errorFormatJSON:
+		return "application/json; charset=utf-8", []byte(`{"Status":` + strconv.Itoa(status) + `,"Error":` + strconv.Quote(text) + "}\n")
+	case errorFormatHTML:
+		title := htmltemplate.HTMLEscapeString(strconv.Itoa(status) + " " + text)
+		return "text/html; charset=utf-8", []byte("<!DOCTYPE html><html><head><title>" + title + "</title></head><body><h1>" + title + "</h1></body></html>\n")
+	}
+	return "text/plain; charset=utf-8", []byte(text + "\n")
+}
+
+//serveError 返回代理产生的错误，内部错误信息只记录在日志中
+func (rh *RoutePrefixHandler) serveError(w http.ResponseWriter, r *http.Request, status int) {
+	if rh.GRPC && isGRPC(r.Header) {
+		writeGRPCError(w, status)
+		return
+	}
+	contentType, body := rh.renderError(r, status)
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
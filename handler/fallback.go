@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+)
+
+//FallbackResponse 路由的所有下游主机均不可用时返回的静态响应或重定向，用于返回维护页面等
+type FallbackResponse struct {
+	//Status 响应状态码，默认为503，配置RedirectURL时默认为302
+	Status int
+	//Body 响应内容
+	Body string
+	//ContentType 响应内容类型，默认为text/html; charset=utf-8
+	ContentType string
+	//RedirectURL 重定向的地址，配置后忽略Body
+	RedirectURL string
+}
+
+//serve 输出降级响应
+func (f *FallbackResponse) serve(w http.ResponseWriter, r *http.Request) {
+	if f.RedirectURL != "" {
+		status := f.Status
+		if status == 0 {
+			status = http.StatusFound
+		}
+		http.Redirect(w, r, f.RedirectURL, status)
+		return
+	}
+	status := f.Status
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	contentType := f.ContentType
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(f.Body))
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//grpcContentType gRPC请求及响应的内容类型，可以带有+proto等后缀
+const grpcContentType = "application/grpc"
+
+//gRPC状态码
+const (
+	grpcUnknown           = 2
+	grpcDeadlineExceeded  = 4
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+//grpcStatusCodes 代理产生的错误状态码对应的gRPC状态码，未列出的状态码为Unknown
+//代理返回500表示下游主机不可达，与502、503一样对应Unavailable，客户端可以重试
+var grpcStatusCodes = map[int]int{
+	http.StatusBadRequest:            grpcInternal,
+	http.StatusUnauthorized:          grpcUnauthenticated,
+	http.StatusForbidden:             grpcPermissionDenied,
+	http.StatusNotFound:              grpcUnimplemented,
+	http.StatusRequestEntityTooLarge: grpcResourceExhausted,
+	http.StatusTooManyRequests:       grpcUnavailable,
+	http.StatusInternalServerError:   grpcUnavailable,
+	http.StatusBadGateway:            grpcUnavailable,
+	http.StatusServiceUnavailable:    grpcUnavailable,
+	http.StatusGatewayTimeout:        grpcDeadlineExceeded,
+}
+
+//isGRPC 根据Content-Type判断请求或响应是否为gRPC
+func isGRPC(h http.Header) bool {
+	contentType := h.Get("Content-Type")
+	if !strings.HasPrefix(contentType, grpcContentType) {
+		return false
+	}
+	rest := contentType[len(grpcContentType):]
+	return rest == "" || rest[0] == '+' || rest[0] == ';'
+}
+
+//DefaultRetryOnGRPCStatuses gRPC路由默认需要重试的gRPC状态码：Unavailable及ResourceExhausted
+var DefaultRetryOnGRPCStatuses = []int{grpcUnavailable, grpcResourceExhausted}
+
+//writeGRPCError 以只有响应头(Trailers-Only)的gRPC响应返回代理产生的错误，HTTP状态码始终为200
+//gRPC客户端只能将非200的响应映射为通用的状态码，无法区分超时、主机不可用等情况
+func writeGRPCError(w http.ResponseWriter, status int) {
+	code, ok := grpcStatusCodes[status]
+	if !ok {
+		code = grpcUnknown
+	}
+	writeGRPCStatus(w, code, http.StatusText(status))
+}
+
+//writeGRPCStatus 以只有响应头(Trailers-Only)的gRPC响应返回指定的gRPC状态
+func writeGRPCStatus(w http.ResponseWriter, code int, message string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", grpcContentType)
+	h.Set("Grpc-Status", strconv.Itoa(code))
+	h.Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
+
+//grpcStatus 获取响应头或trailers中的gRPC状态码
+func grpcStatus(h http.Header) (int, bool) {
+	value := h.Get("Grpc-Status")
+	if value == "" {
+		return 0, false
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return grpcUnknown, true
+	}
+	return code, true
+}
+
+//retryOnGRPCStatus 判断下游主机返回的gRPC状态码是否需要重试，这些状态码同时计入熔断及被动健康检查的失败次数
+func (rh *RoutePrefixHandler) retryOnGRPCStatus(code int) bool {
+	for _, c := range rh.RetryOnGRPCStatuses {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+//checkGRPCStatus 根据gRPC响应的状态记录主机的请求结果
+//只有响应头(Trailers-Only)的响应在ModifyResponse中即可获取状态，需要重试时返回retryGRPCStatusError；
+//其他响应的状态在trailers中，读取完响应内容后才记录，此时响应已经发送给客户端，不能重试
+func (rh *RoutePrefixHandler) checkGRPCStatus(host string, resp *http.Response, retry bool) error {
+	if code, ok := grpcStatus(resp.Header); ok {
+		failed := rh.retryOnGRPCStatus(code)
+		rh.reportResult(host, failed)
+		if failed && retry {
+			return &retryGRPCStatusError{code: code, message: resp.Header.Get("Grpc-Message")}
+		}
+		return nil
+	}
+	resp.Body = &grpcStatusBody{ReadCloser: resp.Body, rh: rh, host: host, resp: resp}
+	return nil
+}
+
+//grpcStatusBody 读取完gRPC响应内容后根据trailers中的状态记录主机的请求结果
+type grpcStatusBody struct {
+	io.ReadCloser
+	rh   *RoutePrefixHandler
+	host string
+	resp *http.Response
+	once sync.Once
+}
+
+func (b *grpcStatusBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		code, ok := grpcStatus(b.resp.Trailer)
+		b.once.Do(func() {
+			b.rh.reportResult(b.host, ok && b.rh.retryOnGRPCStatus(code))
+		})
+	} else if err != nil && b.resp.Request.Context().Err() == nil {
+		//下游主机重置了流，客户端取消的请求不计入主机失败次数
+		b.once.Do(func() {
+			b.rh.reportResult(b.host, true)
+		})
+	}
+	return n, err
+}
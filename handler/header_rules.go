@@ -0,0 +1,29 @@
+package handler
+
+import "net/http"
+
+//HeaderRules 请求头或响应头的修改规则，按Remove、Set、Add的顺序生效
+type HeaderRules struct {
+	//Set 设置的头部，覆盖已有的值
+	Set map[string]string
+	//Add 追加的头部，保留已有的值
+	Add map[string]string
+	//Remove 删除的头部
+	Remove []string
+}
+
+//apply 按规则修改头部，规则为nil时不做任何处理
+func (hr *HeaderRules) apply(h http.Header) {
+	if hr == nil {
+		return
+	}
+	for _, name := range hr.Remove {
+		h.Del(name)
+	}
+	for name, value := range hr.Set {
+		h.Set(name, value)
+	}
+	for name, value := range hr.Add {
+		h.Add(name, value)
+	}
+}
@@ -1,39 +1,243 @@
 package handler
 
 import (
+	"fmt"
+	"math/rand"
+	"net/http"
 	"proxy/util"
 	"proxy/util/logging"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	//HealthCheckTCP 只检查能否建立TCP(或Unix域套接字)连接
+	HealthCheckTCP = "tcp"
+	//HealthCheckHTTP 发送HTTP GET请求并检查响应状态码
+	HealthCheckHTTP = "http"
+)
+
+var (
+	probeLimiterMux sync.RWMutex
+	//probeLimiter 所有路由共享的健康检查并发限制，为nil时不限制
+	probeLimiter chan struct{}
+)
+
+//SetHealthCheckMaxConcurrent 设置同时进行中的健康检查的最大数量，所有路由共享，0表示不限制
+func SetHealthCheckMaxConcurrent(n uint) {
+	probeLimiterMux.Lock()
+	defer probeLimiterMux.Unlock()
+	if n == 0 {
+		probeLimiter = nil
+		return
+	}
+	probeLimiter = make(chan struct{}, n)
+}
+
+//acquireProbe 等待健康检查的并发名额，路由停止时返回false
+func acquireProbe(stop <-chan struct{}) (release func(), ok bool) {
+	probeLimiterMux.RLock()
+	limiter := probeLimiter
+	probeLimiterMux.RUnlock()
+	if limiter == nil {
+		return func() {}, true
+	}
+	select {
+	case limiter <- struct{}{}:
+		return func() { <-limiter }, true
+	case <-stop:
+		return nil, false
+	}
+}
+
+//staggerDelay 首次健康检查前等待的随机时间，范围为(0, period]，避免所有主机同时发起健康检查
+func staggerDelay(period time.Duration) time.Duration {
+	return period - time.Duration(rand.Int63n(int64(period)))
+}
+
 //HealthCheck 主机健康检查
 func (rh *RoutePrefixHandler) HealthCheck(interval uint) {
-	go func() {
-		for host := range rh.reverseProxyMap {
-			rh.healthCheck(host, interval)
+	rh.mux.Lock()
+	rh.healthCheckInterval = interval
+	hosts := make([]string, 0, len(rh.reverseProxyMap))
+	for host := range rh.reverseProxyMap {
+		hosts = append(hosts, host)
+	}
+	rh.mux.Unlock()
+
+	for _, host := range hosts {
+		go rh.healthCheck(host, interval)
+	}
+}
+
+//Precheck 使用与健康检查相同的方式并行探测一次路由的所有下游主机，返回无法连接的主机，不修改主机状态
+func (rh *RoutePrefixHandler) Precheck() []string {
+	rh.mux.RLock()
+	hosts := make([]string, 0, len(rh.reverseProxyMap))
+	for host := range rh.reverseProxyMap {
+		hosts = append(hosts, host)
+	}
+	rh.mux.RUnlock()
+
+	alive := make([]bool, len(hosts))
+	var wg sync.WaitGroup
+	for i := range hosts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release, ok := acquireProbe(rh.stop)
+			if !ok {
+				return
+			}
+			alive[i] = rh.probe(hosts[i])
+			release()
+		}(i)
+	}
+	wg.Wait()
+
+	var unreachable []string
+	for i, host := range hosts {
+		if !alive[i] {
+			unreachable = append(unreachable, host)
 		}
-	}()
+	}
+	sort.Strings(unreachable)
+	return unreachable
+}
+
+//Stop 停止路由的所有健康检查，用于服务关闭时退出健康检查协程，可以重复调用
+func (rh *RoutePrefixHandler) Stop() {
+	rh.stopOnce.Do(func() {
+		close(rh.stop)
+	})
 }
 
 //healthCheck 主机健康检查
 func (rh *RoutePrefixHandler) healthCheck(host string, interval uint) {
-	ticker := time.NewTicker(time.Duration(interval) * time.Second)
-	for range ticker.C {
-		isBackendAlive := util.IsBackendAlive(host)
+	period := time.Duration(interval) * time.Second
+	timer := time.NewTimer(staggerDelay(period))
+	defer timer.Stop()
+	//proxy 主机被删除(或删除后重新添加)时反向代理会变化，此时退出当前的健康检查
+	proxy := rh.reverseProxy(host)
+	//successes 预热中的主机连续健康检查成功的次数
+	var successes uint
+	for {
+		select {
+		case <-rh.stop:
+			return
+		case <-timer.C:
+		}
+		timer.Reset(period)
+		release, ok := acquireProbe(rh.stop)
+		if !ok {
+			return
+		}
+		isBackendAlive := rh.probe(host)
+		release()
+		rh.recordCheck(host)
+		if rh.reverseProxy(host) != proxy {
+			logging.Infof("主机 %s 已删除, 停止健康检查", host)
+			return
+		}
+		if rh.isPending(host) {
+			successes = rh.warmup(host, isBackendAlive, successes)
+			continue
+		}
 		if !isBackendAlive && rh.ReadAlive(host) {
 			logging.Errorf("连接主机 %s 失败, 已将状态置为不可用", host)
 
 			rh.SetAlive(host, false)
 			rh.bl.Remove(host)
+			rh.waitDrain(host)
 		} else if isBackendAlive && !rh.ReadAlive(host) {
 			logging.Infof("连接主机 %s 成功, 已将状态置为存活", host)
 
 			rh.SetAlive(host, true)
-			rh.bl.Add(host)
+			//维护中的主机恢复后不加入负载均衡器，等待取消维护；被动健康检查摘除的主机等待摘除到期
+			if !rh.IsDrained(host) && !rh.IsEjected(host) {
+				rh.bl.Add(host)
+			}
 		}
 	}
 }
 
+//probe 检查主机是否健康，按healthCheckType确定的方式检查：tcp只检查能否建立连接，
+//http向HealthCheckPath(为空时为/)发送GET请求并判断响应状态码
+func (rh *RoutePrefixHandler) probe(host string) bool {
+	rh.mux.RLock()
+	scheme := rh.schemes[host]
+	rh.mux.RUnlock()
+	if rh.healthCheckType(scheme) == HealthCheckTCP {
+		return util.IsBackendAlive(host)
+	}
+	path := rh.HealthCheckPath
+	if path == "" {
+		path = "/"
+	}
+	expectStatus := rh.HealthCheckExpectStatus
+	if expectStatus == 0 {
+		expectStatus = http.StatusOK
+	}
+	timeout := rh.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = util.ConnectionTimeout
+	}
+	if socket, ok := util.UnixSocketPath(host); ok {
+		return util.IsUnixSocketHealthy(socket, path, expectStatus, timeout)
+	}
+	target := fmt.Sprintf("%s://%s%s", scheme, host, path)
+	return util.IsBackendHealthy(target, expectStatus, timeout)
+}
+
+//healthCheckType 获取主机使用的健康检查方式，未配置HealthCheckType时http/https主机使用http，
+//其他主机(例如Unix域套接字)只检查连接，配置了HealthCheckPath时总是使用http
+func (rh *RoutePrefixHandler) healthCheckType(scheme string) string {
+	if rh.HealthCheckType != "" {
+		return strings.ToLower(rh.HealthCheckType)
+	}
+	if rh.HealthCheckPath != "" || scheme == "http" || scheme == "https" {
+		return HealthCheckHTTP
+	}
+	return HealthCheckTCP
+}
+
+//warmup 预热中的主机需要连续通过Warmup次健康检查后才加入负载均衡器，返回当前连续成功的次数
+func (rh *RoutePrefixHandler) warmup(host string, isBackendAlive bool, successes uint) uint {
+	if !isBackendAlive {
+		if successes > 0 {
+			logging.Warnf("预热主机 %s 健康检查失败, 重新开始预热", host)
+		}
+		return 0
+	}
+
+	successes++
+	if successes < rh.Warmup {
+		logging.Infof("预热主机 %s 健康检查成功 (%d/%d)", host, successes, rh.Warmup)
+		return successes
+	}
+
+	logging.Infof("预热主机 %s 已连续通过 %d 次健康检查, 已加入负载均衡", host, successes)
+	rh.mux.Lock()
+	delete(rh.pending, host)
+	rh.setAliveLocked(host, true)
+	drained := rh.drained[host]
+	rh.mux.Unlock()
+	if !drained {
+		rh.bl.Add(host)
+	}
+	return 0
+}
+
+//isPending 判断主机是否处于预热状态
+func (rh *RoutePrefixHandler) isPending(host string) bool {
+	rh.mux.RLock()
+	defer rh.mux.RUnlock()
+	return rh.pending[host]
+}
+
 // ReadAlive 获取主机存活状态
 func (rh *RoutePrefixHandler) ReadAlive(url string) bool {
 	rh.mux.RLock()
@@ -45,5 +249,67 @@ func (rh *RoutePrefixHandler) ReadAlive(url string) bool {
 func (rh *RoutePrefixHandler) SetAlive(url string, alive bool) {
 	rh.mux.Lock()
 	defer rh.mux.Unlock()
-	rh.alive[url] = alive
-}
\ No newline at end of file
+	rh.setAliveLocked(url, alive)
+}
+
+//Inflight 获取主机正在处理中的请求数
+func (rh *RoutePrefixHandler) Inflight(host string) int64 {
+	return atomic.LoadInt64(rh.inflightCounter(host))
+}
+
+//inflightCounter 获取主机在途请求计数器
+func (rh *RoutePrefixHandler) inflightCounter(host string) *int64 {
+	rh.mux.RLock()
+	counter, ok := rh.inflight[host]
+	rh.mux.RUnlock()
+	if ok {
+		return counter
+	}
+
+	rh.mux.Lock()
+	defer rh.mux.Unlock()
+	if counter, ok = rh.inflight[host]; !ok {
+		counter = new(int64)
+		rh.inflight[host] = counter
+	}
+	return counter
+}
+
+//waitDrain 主机被摘除后记录剩余的在途请求数，配置了DrainTimeout时等待在途请求完成
+func (rh *RoutePrefixHandler) waitDrain(host string) {
+	remaining := rh.Inflight(host)
+	logging.Infof("主机 %s 已摘除, 剩余在途请求数: %d", host, remaining)
+	if remaining == 0 || rh.DrainTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(rh.DrainTimeout)
+	for {
+		select {
+		case <-ticker.C:
+			if rh.Inflight(host) == 0 {
+				logging.Infof("主机 %s 在途请求已全部完成, 摘除完成", host)
+				return
+			}
+		case <-rh.stop:
+			return
+		case <-deadline:
+			logging.Warnf("主机 %s 等待在途请求完成超时, 剩余在途请求数: %d", host, rh.Inflight(host))
+			return
+		}
+	}
+}
+
+//HasAliveHost 判断路由是否至少有一个可以接收请求的主机(存活且未被摘除、维护或预热)
+func (rh *RoutePrefixHandler) HasAliveHost() bool {
+	rh.mux.RLock()
+	defer rh.mux.RUnlock()
+	for host, alive := range rh.alive {
+		if alive && !rh.ejected[host] && !rh.drained[host] && !rh.pending[host] {
+			return true
+		}
+	}
+	return false
+}
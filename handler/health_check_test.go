@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInflight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+	rh := newTestRoute(t, backend.URL)
+
+	//转发中的请求计入主机的在途请求数，完成后释放
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/a", nil))
+	}()
+	<-started
+	assert.Equal(t, int64(1), rh.Inflight(host))
+	close(release)
+	<-done
+	assert.Equal(t, int64(0), rh.Inflight(host))
+	assert.Equal(t, int64(0), rh.Inflight("127.0.0.1:1"))
+}
+
+func TestWaitDrain(t *testing.T) {
+	rh := newTestRoute(t, "http://127.0.0.1:1")
+	host := "127.0.0.1:1"
+
+	//没有在途请求时立即返回
+	rh.DrainTimeout = time.Second
+	start := time.Now()
+	rh.waitDrain(host)
+	assert.Less(t, int64(time.Since(start)), int64(50*time.Millisecond))
+
+	//等待在途请求完成
+	release := rh.acquire(host)
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		release()
+	}()
+	start = time.Now()
+	rh.waitDrain(host)
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, int64(elapsed), int64(150*time.Millisecond))
+	assert.Less(t, int64(elapsed), int64(time.Second))
+
+	//超过DrainTimeout后不再等待
+	rh.DrainTimeout = 200 * time.Millisecond
+	release = rh.acquire(host)
+	defer release()
+	start = time.Now()
+	rh.waitDrain(host)
+	elapsed = time.Since(start)
+	assert.GreaterOrEqual(t, int64(elapsed), int64(200*time.Millisecond))
+	assert.Less(t, int64(elapsed), int64(time.Second))
+	assert.Equal(t, int64(1), rh.Inflight(host))
+}
+
+//balanced 判断主机是否参与负载均衡
+func balanced(rh *RoutePrefixHandler, host string) bool {
+	for _, stat := range rh.bl.Stats() {
+		if stat.Name == host {
+			return stat.Alive
+		}
+	}
+	return false
+}
+
+func TestWarmup(t *testing.T) {
+	rh := newTestRoute(t, "http://127.0.0.1:1")
+	host := "127.0.0.1:1"
+	rh.Warmup = 2
+	rh.mux.Lock()
+	rh.pending[host] = true
+	rh.setAliveLocked(host, false)
+	rh.mux.Unlock()
+	rh.bl.Remove(host)
+
+	//健康检查失败时重新开始预热
+	assert.Equal(t, uint(1), rh.warmup(host, true, 0))
+	assert.Equal(t, uint(0), rh.warmup(host, false, 1))
+	assert.True(t, rh.isPending(host))
+	assert.False(t, balanced(rh, host))
+
+	//连续通过Warmup次健康检查后加入负载均衡器
+	assert.Equal(t, uint(1), rh.warmup(host, true, 0))
+	assert.Equal(t, uint(0), rh.warmup(host, true, 1))
+	assert.False(t, rh.isPending(host))
+	assert.True(t, rh.ReadAlive(host))
+	assert.True(t, balanced(rh, host))
+	assert.True(t, rh.HasAliveHost())
+}
+
+func TestAddHost_Warmup(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+	rh := newTestRoute(t, "http://127.0.0.1:1")
+
+	//未开启健康检查时立即加入负载均衡器
+	rh.Warmup = 1
+	warmup, err := rh.AddHost(backend.URL)
+	assert.NoError(t, err)
+	assert.False(t, warmup)
+	assert.True(t, balanced(rh, host))
+	assert.NoError(t, rh.RemoveHost(host))
+
+	//开启健康检查时通过预热后才加入负载均衡器
+	rh.mux.Lock()
+	rh.healthCheckInterval = 1
+	rh.mux.Unlock()
+	warmup, err = rh.AddHost(backend.URL)
+	assert.NoError(t, err)
+	assert.True(t, warmup)
+	assert.True(t, rh.isPending(host))
+	assert.False(t, balanced(rh, host))
+
+	deadline := time.Now().Add(3 * time.Second)
+	for rh.isPending(host) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, rh.isPending(host))
+	assert.True(t, balanced(rh, host))
+}
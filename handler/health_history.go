@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"sort"
+	"time"
+)
+
+//healthHistorySize 每个主机保留的最近健康状态变化次数
+const healthHistorySize = 32
+
+const (
+	//HostStateUp 主机存活
+	HostStateUp = "up"
+	//HostStateDown 主机不可用
+	HostStateDown = "down"
+)
+
+//HealthTransition 主机健康状态的一次变化
+type HealthTransition struct {
+	Time time.Time
+	From string
+	To   string
+}
+
+//HostHealth 主机的健康状态及最近的状态变化
+type HostHealth struct {
+	Host  string
+	Alive bool
+	//LastCheck 最后一次健康检查的时间，未进行过健康检查时为空
+	LastCheck *time.Time `json:",omitempty"`
+	//History 最近的状态变化，按时间先后排列
+	History []HealthTransition
+}
+
+//RouteHealth 路由下各主机的健康状态
+type RouteHealth struct {
+	UpstreamPath string
+	Hosts        []HostHealth
+}
+
+//healthHistory 主机健康状态变化的环形缓冲区，超过healthHistorySize时覆盖最早的记录
+type healthHistory struct {
+	lastCheck   time.Time
+	transitions [healthHistorySize]HealthTransition
+	next        int
+	count       int
+}
+
+//add 记录一次状态变化
+func (h *healthHistory) add(t HealthTransition) {
+	h.transitions[h.next] = t
+	h.next = (h.next + 1) % healthHistorySize
+	if h.count < healthHistorySize {
+		h.count++
+	}
+}
+
+//list 按时间先后获取记录的状态变化
+func (h *healthHistory) list() []HealthTransition {
+	result := make([]HealthTransition, 0, h.count)
+	start := (h.next - h.count + healthHistorySize) % healthHistorySize
+	for i := 0; i < h.count; i++ {
+		result = append(result, h.transitions[(start+i)%healthHistorySize])
+	}
+	return result
+}
+
+//hostState 将存活状态转换为HostStateUp或HostStateDown
+func hostState(alive bool) string {
+	if alive {
+		return HostStateUp
+	}
+	return HostStateDown
+}
+
+//healthHistoryLocked 获取主机的健康状态记录，不存在时创建，调用方需要持有rh.mux的写锁
+func (rh *RoutePrefixHandler) healthHistoryLocked(host string) *healthHistory {
+	h, ok := rh.health[host]
+	if !ok {
+		h = &healthHistory{}
+		rh.health[host] = h
+	}
+	return h
+}
+
+//setAliveLocked 设置主机存活状态，状态变化时记录到健康状态历史中，调用方需要持有rh.mux的写锁
+func (rh *RoutePrefixHandler) setAliveLocked(host string, alive bool) {
+	if old, ok := rh.alive[host]; ok && old != alive {
+		rh.healthHistoryLocked(host).add(HealthTransition{Time: time.Now(), From: hostState(old), To: hostState(alive)})
+	}
+	rh.alive[host] = alive
+}
+
+//recordCheck 记录主机最后一次健康检查的时间
+func (rh *RoutePrefixHandler) recordCheck(host string) {
+	rh.mux.Lock()
+	defer rh.mux.Unlock()
+	if rh.reverseProxyMap[host] != nil {
+		rh.healthHistoryLocked(host).lastCheck = time.Now()
+	}
+}
+
+//Health 获取路由下各主机的健康状态及最近的状态变化，按主机排序
+func (rh *RoutePrefixHandler) Health() RouteHealth {
+	rh.mux.RLock()
+	defer rh.mux.RUnlock()
+	result := RouteHealth{UpstreamPath: rh.UpstreamPath, Hosts: make([]HostHealth, 0, len(rh.reverseProxyMap))}
+	for host := range rh.reverseProxyMap {
+		hh := HostHealth{Host: host, Alive: rh.alive[host], History: []HealthTransition{}}
+		if h, ok := rh.health[host]; ok {
+			if !h.lastCheck.IsZero() {
+				lastCheck := h.lastCheck
+				hh.LastCheck = &lastCheck
+			}
+			hh.History = h.list()
+		}
+		result.Hosts = append(result.Hosts, hh)
+	}
+	sort.Slice(result.Hosts, func(i, j int) bool {
+		return result.Hosts[i].Host < result.Hosts[j].Host
+	})
+	return result
+}
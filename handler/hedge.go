@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"proxy/balancer"
+	"proxy/middleware"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//defaultHedgeMaxAttempts 对冲请求默认的最大并行请求数(包括首次请求)
+	defaultHedgeMaxAttempts = 2
+	//defaultHedgeBudgetPercent 对冲请求默认最多占总请求数的百分比
+	defaultHedgeBudgetPercent = 10
+)
+
+//hedgeMaxErrorBody 对冲时缓冲5xx响应内容的最大字节数，超过时不再等待其他请求，直接将该响应转发给客户端
+const hedgeMaxErrorBody = 64 << 10
+
+//hedgeResult 一次请求的结果
+type hedgeResult struct {
+	attempt int
+	host    string
+	latency time.Duration
+	writer  *hedgeWriter
+	//panic 转发时的panic，例如向客户端写入失败时ReverseProxy产生的http.ErrAbortHandler
+	panic interface{}
+}
+
+//hedgeable 判断请求是否可以对冲，只有配置了HedgeDelay且没有请求内容的幂等请求才会对冲
+//协议升级请求及事件流请求不对冲，它们的响应头会立即返回，对冲只会增加下游主机的连接数
+func (rh *RoutePrefixHandler) hedgeable(r *http.Request) bool {
+	if rh.HedgeDelay <= 0 || isUpgradeRequest(r) || acceptsEventStream(r) {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+		return false
+	}
+	return r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0
+}
+
+//allowHedge 判断是否还有对冲预算，避免对冲请求使流量翻倍
+func (rh *RoutePrefixHandler) allowHedge() bool {
+	budget := rh.HedgeBudgetPercent
+	if budget == 0 {
+		budget = defaultHedgeBudgetPercent
+	}
+	total := atomic.LoadUint64(&rh.stats.requests) + 1
+	hedged := atomic.LoadUint64(&rh.hedged)
+	return hedged*100 < total*uint64(budget)
+}
+
+//serveHedged 首次请求超过HedgeDelay未返回响应头时，向其他主机发送对冲请求
+//最先返回非5xx响应头的请求直接向客户端转发响应，并取消其他请求，响应内容不缓冲；
+//5xx响应缓冲不超过hedgeMaxErrorBody字节，所有请求都失败时返回最先完成的结果
+func (rh *RoutePrefixHandler) serveHedged(w http.ResponseWriter, r *http.Request, host string, info *RouteInfo) {
+	maxAttempts := rh.HedgeMaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultHedgeMaxAttempts
+	}
+
+	race := &hedgeRace{w: w, winner: -1, won: make(chan int, 1)}
+	results := make(chan hedgeResult, maxAttempts)
+	cancels := make([]context.CancelFunc, 0, maxAttempts)
+	used := map[string]bool{host: true}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	launch := func(attempt int, target string, attemptInfo *RouteInfo) {
+		ctx, cancel := context.WithCancel(r.Context())
+		cancels = append(cancels, cancel)
+		req := withRouteInfo(r.Clone(ctx), attemptInfo)
+		proxy := rh.reverseProxy(target)
+		go func() {
+			hw := &hedgeWriter{race: race, attempt: attempt, header: make(http.Header), status: http.StatusOK}
+			attemptStart := time.Now()
+			//转发在单独的goroutine中进行，panic需要交给处理请求的goroutine，否则会导致进程退出
+			defer func() {
+				results <- hedgeResult{attempt: attempt, host: target, latency: time.Since(attemptStart), writer: hw, panic: recover()}
+			}()
+			if attempt > 0 {
+				release := rh.acquire(target)
+				defer release()
+			}
+			if proxy != nil {
+				rh.forward(hw, req, target, proxy)
+			} else {
+				//主机在被选中后已被删除
+				rh.serveError(hw, req, http.StatusBadGateway)
+			}
+			//被取消的请求不上报延迟，避免拉低主机的延迟统计
+			if req.Context().Err() == nil {
+				rh.bl.Observe(target, time.Since(attemptStart))
+			}
+		}()
+	}
+	launch(0, host, info)
+
+	timer := time.NewTimer(rh.HedgeDelay)
+	defer timer.Stop()
+
+	var first *hedgeResult
+	pending, launched := 1, 1
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if launched >= int(maxAttempts) || race.decided() || !rh.allowHedge() {
+				continue
+			}
+			target, ok := rh.otherHost(r, used)
+			if !ok {
+				continue
+			}
+			used[target] = true
+			atomic.AddUint64(&rh.hedged, 1)
+			launch(launched, target, &RouteInfo{Route: info.Route, Host: target, OriginalPath: info.OriginalPath})
+			launched++
+			pending++
+			timer.Reset(rh.HedgeDelay)
+		case winner := <-race.won:
+			//已有请求开始向客户端转发响应，取消其他请求
+			for i, cancel := range cancels {
+				if i != winner {
+					cancel()
+				}
+			}
+		case result := <-results:
+			pending--
+			if result.panic != nil && result.panic != http.ErrAbortHandler {
+				panic(result.panic)
+			}
+			if result.attempt == race.winnerAttempt() {
+				info.Host = result.host
+				middleware.SetUpstreamLatency(r.Context(), result.latency)
+				if result.panic != nil {
+					panic(result.panic)
+				}
+				return
+			}
+			if result.panic != nil {
+				continue
+			}
+			if first == nil {
+				first = &result
+			}
+			//没有写入响应头的请求(例如下游主机返回空响应)与非5xx响应一样直接使用
+			if result.writer.status < http.StatusInternalServerError && rh.useHedgeResult(r, info, race, result) {
+				return
+			}
+		}
+	}
+	if first == nil || !rh.useHedgeResult(r, info, race, *first) {
+		rh.serveError(w, r, http.StatusBadGateway)
+	}
+}
+
+//useHedgeResult 将缓冲的对冲结果写入客户端，并记录实际返回结果的主机及其响应耗时，已有其他请求胜出时返回false
+func (rh *RoutePrefixHandler) useHedgeResult(r *http.Request, info *RouteInfo, race *hedgeRace, result hedgeResult) bool {
+	if !race.claim(result.writer) {
+		return false
+	}
+	info.Host = result.host
+	middleware.SetUpstreamLatency(r.Context(), result.latency)
+	_, _ = race.w.Write(result.writer.body.Bytes())
+	return true
+}
+
+//otherHost 为对冲或重试请求选择一个尚未使用的主机
+func (rh *RoutePrefixHandler) otherHost(r *http.Request, used map[string]bool) (string, bool) {
+	for i := 0; i < 2*len(used)+2; i++ {
+		key := fmt.Sprintf("%s?%s#hedge%d", r.URL.Path, r.URL.RawQuery, i)
+		host, err := balancer.BalanceCtx(r.Context(), rh.bl, key)
+		if err != nil {
+			return "", false
+		}
+		if !used[host] {
+			return host, true
+		}
+	}
+	return "", false
+}
+
+//hedgeRace 对冲请求的竞争状态，只有一个请求可以向客户端写入响应
+type hedgeRace struct {
+	mux sync.Mutex
+	w   http.ResponseWriter
+	//winner 向客户端转发响应的请求，-1表示尚未确定
+	winner int
+	won    chan int
+}
+
+//claim 尝试由请求向客户端转发响应，成功时写入响应头
+func (hr *hedgeRace) claim(hw *hedgeWriter) bool {
+	hr.mux.Lock()
+	defer hr.mux.Unlock()
+	if hr.winner >= 0 {
+		return false
+	}
+	hr.winner = hw.attempt
+	for k, v := range hw.header {
+		hr.w.Header()[k] = v
+	}
+	hr.w.WriteHeader(hw.status)
+	hr.won <- hw.attempt
+	return true
+}
+
+//decided 判断是否已有请求向客户端转发响应
+func (hr *hedgeRace) decided() bool {
+	return hr.winnerAttempt() >= 0
+}
+
+func (hr *hedgeRace) winnerAttempt() int {
+	hr.mux.Lock()
+	defer hr.mux.Unlock()
+	return hr.winner
+}
+
+//hedgeWriter 对冲请求的ResponseWriter：非5xx的响应直接转发给客户端，5xx响应先缓冲，其他请求胜出后丢弃响应
+type hedgeWriter struct {
+	race          *hedgeRace
+	attempt       int
+	header        http.Header
+	status        int
+	headerWritten bool
+	//direct 响应直接写入客户端
+	direct bool
+	//discard 其他请求已经胜出，丢弃响应内容
+	discard bool
+	body    bytes.Buffer
+}
+
+func (hw *hedgeWriter) Header() http.Header {
+	return hw.header
+}
+
+func (hw *hedgeWriter) WriteHeader(status int) {
+	//1xx响应由ReverseProxy转发，对冲时忽略
+	if hw.headerWritten || status < http.StatusOK {
+		return
+	}
+	hw.headerWritten = true
+	hw.status = status
+	if status >= http.StatusInternalServerError {
+		hw.discard = hw.race.decided()
+		return
+	}
+	hw.direct = hw.race.claim(hw)
+	hw.discard = !hw.direct
+}
+
+//Write 丢弃的响应返回成功，由取消请求的上下文中止转发，写入错误会使ReverseProxy产生panic
+func (hw *hedgeWriter) Write(b []byte) (int, error) {
+	if !hw.headerWritten {
+		hw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case hw.direct:
+		return hw.race.w.Write(b)
+	case hw.discard:
+		return len(b), nil
+	}
+	if hw.body.Len()+len(b) <= hedgeMaxErrorBody {
+		return hw.body.Write(b)
+	}
+	//5xx响应过大时不再缓冲，直接转发给客户端
+	if !hw.race.claim(hw) {
+		hw.discard = true
+		return len(b), nil
+	}
+	hw.direct = true
+	if _, err := hw.race.w.Write(hw.body.Bytes()); err != nil {
+		return 0, err
+	}
+	hw.body.Reset()
+	return hw.race.w.Write(b)
+}
+
+//Flush 直接转发的响应立即发送给客户端，例如gRPC及配置了FlushInterval的路由
+func (hw *hedgeWriter) Flush() {
+	if hw.direct {
+		if flusher, ok := hw.race.w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
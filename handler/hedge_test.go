@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//newHedgeHandler 创建开启对冲的路由，两个下游主机使用相同的处理函数
+func newHedgeHandler(t *testing.T, handler http.HandlerFunc) *RoutePrefixHandler {
+	a := httptest.NewServer(handler)
+	t.Cleanup(a.Close)
+	b := httptest.NewServer(handler)
+	t.Cleanup(b.Close)
+	rh := newTestRoute(t, a.URL, b.URL)
+	rh.HedgeDelay = 20 * time.Millisecond
+	rh.HedgeBudgetPercent = 100
+	return rh
+}
+
+func TestServeHedged_HedgeFires(t *testing.T) {
+	var hits int32
+	canceled := make(chan struct{})
+	rh := newHedgeHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		//首次请求一直不返回响应头，直到被取消
+		if atomic.AddInt32(&hits, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+				close(canceled)
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		_, _ = io.WriteString(w, "hedged")
+	})
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	rh.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/a", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "hedged", rec.Body.String())
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	//对冲请求胜出后取消首次请求
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("首次请求未被取消")
+	}
+}
+
+func TestServeHedged_NoHedgeAfterHeaders(t *testing.T) {
+	var hits int32
+	rh := newHedgeHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		//响应头立即返回，响应内容超过HedgeDelay才写完
+		_, _ = io.WriteString(w, "first ")
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		_, _ = io.WriteString(w, "second")
+	})
+
+	rec := httptest.NewRecorder()
+	rh.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/a", nil))
+	assert.Equal(t, "first second", rec.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&rh.hedged))
+}
+
+func TestServeHedged_LargeBody(t *testing.T) {
+	chunk := bytes.Repeat([]byte("a"), 256<<10)
+	release := make(chan struct{})
+	rh := newHedgeHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/error") {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write(chunk)
+			return
+		}
+		_, _ = w.Write(chunk)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		_, _ = w.Write(chunk)
+	})
+	proxy := httptest.NewServer(rh)
+	defer proxy.Close()
+	client := &http.Client{Timeout: 2 * time.Second}
+
+	//响应内容不缓冲，下游主机写完之前客户端就能收到已写入的部分
+	resp, err := client.Get(proxy.URL + "/api/a")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	_, err = io.ReadFull(resp.Body, make([]byte, len(chunk)))
+	assert.NoError(t, err)
+	close(release)
+	rest, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, len(chunk), len(rest))
+
+	//超过缓冲上限的5xx响应完整转发
+	resp, err = client.Get(proxy.URL + "/api/error")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, len(chunk), len(body))
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"errors"
+	"proxy/util"
+	"proxy/util/logging"
+	"strings"
+)
+
+//InheritHostState 将旧路由运行时的主机状态应用到重新加载配置后创建的路由，新路由需要在开始处理请求之前调用
+//继承通过管理接口添加及删除的主机、调整的主机及主机组权重、维护状态，以及健康检查已确认不可用的主机，
+//权重、维护及存活状态只继承新路由中仍然存在的主机
+func (rh *RoutePrefixHandler) InheritHostState(old *RoutePrefixHandler) {
+	old.mux.RLock()
+	var added, removed, drained, dead []string
+	for host := range old.reverseProxyMap {
+		if !old.configured[host] {
+			added = append(added, old.hostURL(host))
+		}
+		if old.drained[host] {
+			drained = append(drained, host)
+		}
+		//预热中的主机还未通过健康检查，不是已确认不可用的主机
+		if !old.alive[host] && !old.pending[host] {
+			dead = append(dead, host)
+		}
+	}
+	for host := range old.configured {
+		if old.reverseProxyMap[host] == nil {
+			removed = append(removed, host)
+		}
+	}
+	weights := make(map[string]int, len(old.weights))
+	for host, weight := range old.weights {
+		weights[host] = weight
+	}
+	groupWeights := make(map[string]int, len(old.groupWeights))
+	for group, weight := range old.groupWeights {
+		groupWeights[group] = weight
+	}
+	old.mux.RUnlock()
+
+	for _, rawURL := range added {
+		if _, err := rh.AddHost(rawURL); err != nil && !errors.Is(err, ErrHostAlreadyExists) {
+			logging.Warnf("路由 %s 重新添加主机 %s 失败: %s", rh.UpstreamPath, rawURL, err)
+		}
+	}
+	for _, host := range removed {
+		if rh.hasHost(host) {
+			_ = rh.RemoveHost(host)
+		}
+	}
+	for host, weight := range weights {
+		if !rh.hasHost(host) {
+			continue
+		}
+		if err := rh.SetWeight(host, weight); err != nil {
+			logging.Warnf("路由 %s 恢复主机 %s 的权重失败: %s", rh.UpstreamPath, host, err)
+		}
+	}
+	for group, weight := range groupWeights {
+		//新配置中已删除的主机组不再恢复
+		_ = rh.SetGroupWeight(group, weight)
+	}
+	for _, host := range drained {
+		_ = rh.Drain(host)
+	}
+	//未开启健康检查时主机不会再恢复，只在开启时继承不可用的状态，由健康检查确认恢复后重新加入
+	if rh.healthCheckInterval > 0 {
+		for _, host := range dead {
+			if rh.hasHost(host) {
+				rh.SetAlive(host, false)
+				rh.bl.Remove(host)
+			}
+		}
+	}
+}
+
+//hasHost 判断路由中是否存在主机
+func (rh *RoutePrefixHandler) hasHost(host string) bool {
+	rh.mux.RLock()
+	defer rh.mux.RUnlock()
+	return rh.reverseProxyMap[host] != nil
+}
+
+//hostURL 获取主机带协议的地址，可以用于AddHost重新添加主机，调用方需要持有rh.mux
+func (rh *RoutePrefixHandler) hostURL(host string) string {
+	scheme := rh.schemes[host]
+	if scheme == unixScheme {
+		return scheme + "://" + strings.TrimPrefix(host, util.UnixSocketPrefix)
+	}
+	return scheme + "://" + host
+}
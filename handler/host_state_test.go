@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInheritHostState(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	added := strings.TrimPrefix(backend.URL, "http://")
+	hosts := []string{"http://127.0.0.1:1", "http://127.0.0.1:2", "http://127.0.0.1:3", "http://127.0.0.1:4"}
+	old, err := NewRoutePrefixHandler("weighted-round-robin", "/api", "/", hosts, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer old.Stop()
+
+	//通过管理接口修改的主机状态
+	assert.NoError(t, old.SetWeight("127.0.0.1:1", 0))
+	assert.NoError(t, old.Drain("127.0.0.1:2"))
+	assert.NoError(t, old.RemoveHost("127.0.0.1:3"))
+	_, err = old.AddHost("unix:///var/run/app.sock")
+	assert.NoError(t, err)
+	_, err = old.AddHost(backend.URL)
+	assert.NoError(t, err)
+	old.SetAlive("127.0.0.1:4", false)
+
+	//新配置删除了127.0.0.1:2，新增了127.0.0.1:6
+	rh, err := NewRoutePrefixHandler("weighted-round-robin", "/api", "/", []string{"http://127.0.0.1:1", "http://127.0.0.1:3", "http://127.0.0.1:4", "http://127.0.0.1:6"}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer rh.Stop()
+	rh.healthCheckInterval = 1
+	rh.InheritHostState(old)
+
+	assert.ElementsMatch(t, []string{"127.0.0.1:1", "127.0.0.1:4", added, "127.0.0.1:6", "unix:/var/run/app.sock"}, rh.Hosts())
+	assert.Equal(t, "unix", rh.schemes["unix:/var/run/app.sock"])
+	assert.False(t, balanced(rh, "127.0.0.1:1"))
+	assert.Equal(t, 0, rh.weights["127.0.0.1:1"])
+	assert.False(t, rh.IsDrained("127.0.0.1:2"))
+	assert.False(t, rh.ReadAlive("127.0.0.1:4"))
+	assert.True(t, balanced(rh, added))
+	assert.True(t, balanced(rh, "127.0.0.1:6"))
+
+	//继承的修改在下一次重新加载时继续保留
+	next, err := NewRoutePrefixHandler("weighted-round-robin", "/api", "/", []string{"http://127.0.0.1:1", "http://127.0.0.1:3", "http://127.0.0.1:4", "http://127.0.0.1:6"}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer next.Stop()
+	next.InheritHostState(rh)
+	assert.Equal(t, rh.Hosts(), next.Hosts())
+	assert.False(t, balanced(next, "127.0.0.1:1"))
+	//未开启健康检查时不继承不可用的状态，主机不会再恢复
+	assert.True(t, next.ReadAlive("127.0.0.1:4"))
+}
+
+func TestInheritHostState_Drained(t *testing.T) {
+	old := newTestRoute(t, "http://127.0.0.1:1", "http://127.0.0.1:2")
+	assert.NoError(t, old.Drain("127.0.0.1:1"))
+
+	rh := newTestRoute(t, "http://127.0.0.1:1", "http://127.0.0.1:2")
+	rh.InheritHostState(old)
+	assert.True(t, rh.IsDrained("127.0.0.1:1"))
+	assert.False(t, balanced(rh, "127.0.0.1:1"))
+	assert.True(t, balanced(rh, "127.0.0.1:2"))
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"proxy/balancer"
+	"proxy/util/logging"
+	"sort"
+	"time"
+)
+
+//Drain 将主机置为维护状态：不再分配新的请求，已有的请求继续处理完成
+//主机保留在负载均衡器中，负载等统计不受影响，host 可以是 ip:port 或带协议的主机地址
+func (rh *RoutePrefixHandler) Drain(host string) error {
+	host = normalizeHost(host)
+	rh.mux.Lock()
+	if _, ok := rh.reverseProxyMap[host]; !ok {
+		rh.mux.Unlock()
+		return ErrHostNotFound
+	}
+	rh.drained[host] = true
+	rh.mux.Unlock()
+
+	rh.bl.Drain(host)
+	logging.Infof("主机 %s 已进入维护状态, 剩余在途请求数: %d", host, rh.Inflight(host))
+	return nil
+}
+
+//Undrain 取消主机的维护状态，主机存活时重新参与负载均衡，不重置负载等统计
+func (rh *RoutePrefixHandler) Undrain(host string) error {
+	host = normalizeHost(host)
+	rh.mux.Lock()
+	if _, ok := rh.reverseProxyMap[host]; !ok {
+		rh.mux.Unlock()
+		return ErrHostNotFound
+	}
+	delete(rh.drained, host)
+	alive := rh.alive[host] && !rh.ejected[host]
+	rh.mux.Unlock()
+
+	rh.bl.Undrain(host)
+	//维护期间被健康检查摘除后又恢复的主机未加入负载均衡器，这里重新加入
+	if alive {
+		rh.bl.Add(host)
+	}
+	logging.Infof("主机 %s 已取消维护状态", host)
+	return nil
+}
+
+//IsDrained 判断主机是否处于维护状态
+func (rh *RoutePrefixHandler) IsDrained(host string) bool {
+	rh.mux.RLock()
+	defer rh.mux.RUnlock()
+	return rh.drained[host]
+}
+
+//Hosts 获取路由的所有主机，按主机名排序
+func (rh *RoutePrefixHandler) Hosts() []string {
+	rh.mux.RLock()
+	hosts := make([]string, 0, len(rh.reverseProxyMap))
+	for host := range rh.reverseProxyMap {
+		hosts = append(hosts, host)
+	}
+	rh.mux.RUnlock()
+	sort.Strings(hosts)
+	return hosts
+}
+
+//RemoveHostGracefully 先将主机置为维护状态，等待主机的负载降为0或超过timeout后再删除主机及其反向代理
+//负载取在途请求数与负载均衡器统计的负载中的较大值，不统计负载的算法只使用在途请求数
+func (rh *RoutePrefixHandler) RemoveHostGracefully(host string, timeout time.Duration) error {
+	host = normalizeHost(host)
+	if err := rh.Drain(host); err != nil {
+		return err
+	}
+	rh.WaitIdle(host, timeout)
+	return rh.RemoveHost(host)
+}
+
+//WaitIdle 等待主机的负载降为0，超过timeout时记录剩余的在途请求数后返回
+func (rh *RoutePrefixHandler) WaitIdle(host string, timeout time.Duration) {
+	if rh.hostLoad(host) == 0 {
+		return
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-ticker.C:
+			if rh.hostLoad(host) == 0 {
+				return
+			}
+		case <-deadline:
+			logging.Warnf("主机 %s 等待在途请求完成超时, 剩余在途请求数: %d", host, rh.hostLoad(host))
+			return
+		}
+	}
+}
+
+//hostLoad 获取主机当前的负载
+func (rh *RoutePrefixHandler) hostLoad(host string) int64 {
+	load := rh.Inflight(host)
+	if l := balancer.LoadOf(rh.bl, host); l > load {
+		load = l
+	}
+	return load
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	rh := newTestRoute(t, "http://127.0.0.1:2", "http://127.0.0.1:1")
+	host := "127.0.0.1:1"
+	assert.Equal(t, []string{"127.0.0.1:1", "127.0.0.1:2"}, rh.Hosts())
+
+	assert.Equal(t, ErrHostNotFound, rh.Drain("127.0.0.1:3"))
+	assert.Equal(t, ErrHostNotFound, rh.Undrain("127.0.0.1:3"))
+
+	//维护中的主机保留在路由中但不再分配新的请求
+	assert.NoError(t, rh.Drain("http://"+host))
+	assert.True(t, rh.IsDrained(host))
+	assert.False(t, balanced(rh, host))
+	assert.Equal(t, []string{"127.0.0.1:1", "127.0.0.1:2"}, rh.Hosts())
+
+	//维护期间被摘除后又恢复的主机在取消维护时重新加入负载均衡
+	rh.SetAlive(host, false)
+	rh.bl.Remove(host)
+	rh.SetAlive(host, true)
+	assert.NoError(t, rh.Undrain(host))
+	assert.False(t, rh.IsDrained(host))
+	assert.True(t, balanced(rh, host))
+}
+
+func TestRemoveHostGracefully(t *testing.T) {
+	rh := newTestRoute(t, "http://127.0.0.1:1", "http://127.0.0.1:2")
+	host := "127.0.0.1:1"
+	assert.Equal(t, ErrHostNotFound, rh.RemoveHostGracefully("127.0.0.1:3", time.Second))
+
+	//等待在途请求完成后删除主机
+	release := rh.acquire(host)
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		release()
+	}()
+	start := time.Now()
+	assert.NoError(t, rh.RemoveHostGracefully(host, 5*time.Second))
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, int64(elapsed), int64(150*time.Millisecond))
+	assert.Less(t, int64(elapsed), int64(5*time.Second))
+	assert.Equal(t, []string{"127.0.0.1:2"}, rh.Hosts())
+	assert.False(t, rh.IsDrained(host))
+
+	//超过timeout后不再等待
+	host = "127.0.0.1:2"
+	release = rh.acquire(host)
+	defer release()
+	start = time.Now()
+	assert.NoError(t, rh.RemoveHostGracefully(host, 200*time.Millisecond))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Empty(t, rh.Hosts())
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"proxy/util"
+	"proxy/util/logging"
+	"time"
+)
+
+const (
+	//DefaultMirrorTimeout 镜像请求默认的超时时间
+	DefaultMirrorTimeout = time.Second
+	//DefaultMirrorMaxBody 镜像请求默认可以缓存的最大请求内容字节数
+	DefaultMirrorMaxBody = 1 << 20
+)
+
+//Mirror 将部分请求复制到镜像主机，只返回主要下游主机的响应，镜像请求的响应及错误均被忽略
+type Mirror struct {
+	//Percent 镜像的请求百分比(1~100)
+	Percent uint
+	//Timeout 镜像请求的超时时间，不受客户端断开的影响
+	Timeout time.Duration
+	//MaxBody 请求内容超过该字节数时不镜像
+	MaxBody int64
+	proxy   *httputil.ReverseProxy
+}
+
+//SetMirror 设置镜像主机，timeout及maxBody为0时使用默认值
+func (rh *RoutePrefixHandler) SetMirror(host string, percent uint, timeout time.Duration, maxBody int64) error {
+	target, err := url.Parse(host)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return ErrInvalidHost
+	}
+	if timeout <= 0 {
+		timeout = DefaultMirrorTimeout
+	}
+	if maxBody <= 0 {
+		maxBody = DefaultMirrorMaxBody
+	}
+	addr := cleanHost(target.Host)
+	rh.Mirror = &Mirror{
+		Percent: percent,
+		Timeout: timeout,
+		MaxBody: maxBody,
+		proxy: &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				req.URL.Scheme = target.Scheme
+				req.URL.Host = addr
+				req.Host = addr
+				rh.rewritePath(req.URL)
+				req.Header.Set(util.XProxy, ReverseProxy)
+				rh.RequestHeaders.apply(req.Header)
+			},
+			Transport: rh.transport,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				logging.Debugf("镜像请求 %s%s 失败: %s", addr, r.URL.Path, err)
+			},
+		},
+	}
+	return nil
+}
+
+//mirror 按比例将请求复制一份异步发送到镜像主机，返回转发给主要下游主机的请求
+//请求内容需要读取到内存中，超过MaxBody时不镜像，已读取的部分与剩余内容拼接后原样转发
+func (rh *RoutePrefixHandler) mirror(r *http.Request) *http.Request {
+	m := rh.Mirror
+	if m == nil || uint(rand.Intn(100)) >= m.Percent || isUpgradeRequest(r) {
+		return r
+	}
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > m.MaxBody {
+			return r
+		}
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, m.MaxBody+1))
+		if err != nil || int64(len(body)) > m.MaxBody {
+			r = r.Clone(r.Context())
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			return r
+		}
+		r = r.Clone(r.Context())
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	//镜像请求不随客户端请求结束而取消，只保留上下文中的值
+	ctx, cancel := context.WithTimeout(detachedContext{r.Context()}, m.Timeout)
+	out := r.Clone(ctx)
+	if body != nil {
+		out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	go func() {
+		defer cancel()
+		//ReverseProxy读取镜像响应失败时会panic(http.ErrAbortHandler)，不能影响主要请求
+		defer func() {
+			if err := recover(); err != nil {
+				logging.Debugf("镜像请求 %s 异常结束: %v", out.URL.Path, err)
+			}
+		}()
+		m.proxy.ServeHTTP(discardWriter{header: make(http.Header)}, out)
+	}()
+	return r
+}
+
+//detachedContext 只保留父上下文中的值，不继承取消及超时
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+//discardWriter 丢弃镜像响应的ResponseWriter
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w discardWriter) WriteHeader(int) {}
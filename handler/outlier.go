@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"proxy/balancer"
+	"proxy/util/logging"
+	"time"
+)
+
+const (
+	//defaultPassiveEjectDuration 被动健康检查摘除主机的默认时长
+	defaultPassiveEjectDuration = 30 * time.Second
+	//defaultBreakerWindow 熔断失败次数默认的统计时间窗口
+	defaultBreakerWindow = 10 * time.Second
+	//defaultBreakerOpenTimeout 熔断后默认经过该时间允许探测请求
+	defaultBreakerOpenTimeout = 30 * time.Second
+)
+
+//EnableCircuitBreaker 为每个主机开启熔断：window 时间内失败 failureThreshold 次后熔断，熔断 openTimeout 后允许一个探测请求
+//window、openTimeout 为0时使用默认值，需要在开始处理请求之前调用
+func (rh *RoutePrefixHandler) EnableCircuitBreaker(failureThreshold uint, window, openTimeout time.Duration) {
+	if window <= 0 {
+		window = defaultBreakerWindow
+	}
+	if openTimeout <= 0 {
+		openTimeout = defaultBreakerOpenTimeout
+	}
+	rh.bl = balancer.NewCircuitBreaker(rh.bl, failureThreshold, window, openTimeout)
+}
+
+//reportResult 记录主机的请求结果，开启熔断时上报给熔断器
+//被动健康检查：连续失败PassiveMaxFails次后将主机从负载均衡器中摘除，经过PassiveEjectDuration后恢复
+func (rh *RoutePrefixHandler) reportResult(host string, failed bool) {
+	if reporter, ok := rh.bl.(balancer.ResultReporter); ok {
+		reporter.Report(host, failed)
+	}
+	if rh.PassiveMaxFails == 0 {
+		return
+	}
+
+	rh.mux.Lock()
+	if !failed {
+		delete(rh.fails, host)
+		rh.mux.Unlock()
+		return
+	}
+	if rh.reverseProxyMap[host] == nil || rh.ejected[host] {
+		rh.mux.Unlock()
+		return
+	}
+	rh.fails[host]++
+	if rh.fails[host] < rh.PassiveMaxFails {
+		rh.mux.Unlock()
+		return
+	}
+	delete(rh.fails, host)
+	rh.ejected[host] = true
+	rh.mux.Unlock()
+
+	duration := rh.PassiveEjectDuration
+	if duration <= 0 {
+		duration = defaultPassiveEjectDuration
+	}
+	rh.bl.Remove(host)
+	logging.Warnf("主机 %s 连续 %d 次请求失败, 已摘除 %s", host, rh.PassiveMaxFails, duration)
+	time.AfterFunc(duration, func() {
+		rh.readmit(host)
+	})
+}
+
+//readmit 被动健康检查摘除的主机到期后恢复，主机仍然存活且不处于维护、预热状态时重新加入负载均衡器
+func (rh *RoutePrefixHandler) readmit(host string) {
+	rh.mux.Lock()
+	delete(rh.ejected, host)
+	admit := rh.reverseProxyMap[host] != nil && rh.alive[host] && !rh.drained[host] && !rh.pending[host]
+	rh.mux.Unlock()
+
+	if admit {
+		rh.bl.Add(host)
+		logging.Infof("主机 %s 摘除已到期, 重新加入负载均衡", host)
+	}
+}
+
+//IsEjected 判断主机是否被被动健康检查摘除
+func (rh *RoutePrefixHandler) IsEjected(host string) bool {
+	rh.mux.RLock()
+	defer rh.mux.RUnlock()
+	return rh.ejected[host]
+}
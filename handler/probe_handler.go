@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+)
+
+//ReadinessStatus 就绪检查的结果
+type ReadinessStatus struct {
+	Ready bool
+	//UnavailableRoutes 没有可用主机的路由
+	UnavailableRoutes []string
+}
+
+//LivenessHandler 代理自身的存活检查(/livez)，服务启动后总是返回200
+func LivenessHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+//ReadinessHandler 代理自身的就绪检查(/readyz)，每个路由都至少有一个可用主机时返回200，否则返回503并列出没有可用主机的路由
+//routes 在每次检查时调用，获取当前的路由
+func ReadinessHandler(routes func() []*RoutePrefixHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		status := ReadinessStatus{Ready: true, UnavailableRoutes: []string{}}
+		for _, rh := range routes() {
+			if !rh.HasAliveHost() {
+				status.Ready = false
+				status.UnavailableRoutes = append(status.UnavailableRoutes, rh.UpstreamPath)
+			}
+		}
+		code := http.StatusOK
+		if !status.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, status)
+	}
+}
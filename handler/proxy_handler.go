@@ -2,47 +2,358 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
 	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"proxy/middleware"
 	"proxy/util"
+	"proxy/util/logging"
 	"strconv"
 	"strings"
 	"time"
 )
 
-var transport = &http.Transport{
-	DialContext: (&net.Dialer{
-		Timeout:   30 * time.Second, //连接超时
+const (
+	//defaultMaxIdleConns 默认的最大空闲连接数
+	defaultMaxIdleConns = 100
+	//defaultIdleConnTimeout 默认的空闲连接超时时间
+	defaultIdleConnTimeout = 90 * time.Second
+	//defaultDialTimeout 默认的连接超时时间
+	defaultDialTimeout = 30 * time.Second
+)
+
+//transport 未配置连接池参数的路由共用的Transport
+var transport = newTransport(TransportOptions{})
+
+//TransportOptions 路由转发请求的连接池参数，为0时使用默认值
+type TransportOptions struct {
+	//MaxIdleConns 最大空闲连接数，默认为100
+	MaxIdleConns int
+	//MaxIdleConnsPerHost 每个主机的最大空闲连接数，默认为2
+	MaxIdleConnsPerHost int
+	//IdleConnTimeout 空闲连接超时时间，默认为90秒
+	IdleConnTimeout time.Duration
+	//DialTimeout 连接超时时间，默认为30秒
+	DialTimeout time.Duration
+	//ResponseHeaderTimeout 等待响应头的超时时间，为0时不限制，h2c连接不支持
+	ResponseHeaderTimeout time.Duration
+	//HTTP2 https下游主机通过TLS ALPN协商HTTP/2，主机不支持时自动回退到HTTP/1.1
+	HTTP2 bool
+	//H2C http下游主机使用明文HTTP/2(h2c prior knowledge)，不会协商或回退，主机必须支持h2c
+	//https下游主机仍通过ALPN协商，协议升级(WebSocket等)的请求始终使用HTTP/1.1
+	H2C bool
+	//socket 不为空时所有连接都建立到该Unix域套接字，用于unix:///path形式的下游主机
+	socket string
+}
+
+//newTransport 根据连接池参数创建Transport
+func newTransport(opts TransportOptions) http.RoundTripper {
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = defaultMaxIdleConns
+	}
+	if opts.IdleConnTimeout == 0 {
+		opts.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = defaultDialTimeout
+	}
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout, //连接超时
 		KeepAlive: 30 * time.Second, //长连接超时时间
-	}).DialContext,
-	MaxIdleConns:          100,              //最大空闲连接
-	IdleConnTimeout:       90 * time.Second, //空闲超时时间
-	TLSHandshakeTimeout:   10 * time.Second, //tls握手超时时间
-	ExpectContinueTimeout: 1 * time.Second,  //100-continue 超时时间
+	}
+	dial := dialer.DialContext
+	if opts.socket != "" {
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", opts.socket)
+		}
+	}
+	t := &http.Transport{
+		DialContext:           dial,
+		MaxIdleConns:          opts.MaxIdleConns,          //最大空闲连接
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,   //每个主机的最大空闲连接
+		IdleConnTimeout:       opts.IdleConnTimeout,       //空闲超时时间
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout, //等待响应头超时时间
+		TLSHandshakeTimeout:   10 * time.Second,           //tls握手超时时间
+		ExpectContinueTimeout: 1 * time.Second,            //100-continue 超时时间
+		//自定义DialContext后默认不再尝试HTTP/2，需要显式开启
+		ForceAttemptHTTP2: opts.HTTP2 || opts.H2C,
+	}
+	if !opts.H2C {
+		return t
+	}
+	return &h2cTransport{
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			//h2c不使用TLS，直接建立TCP连接
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(context.Background(), network, addr)
+			},
+		},
+		fallback: t,
+	}
+}
+
+//h2cTransport http下游主机使用h2c转发，https下游主机及协议升级请求使用fallback转发
+type h2cTransport struct {
+	h2c      *http2.Transport
+	fallback *http.Transport
+}
+
+func (t *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" && !isUpgradeRequest(req) {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.fallback.RoundTrip(req)
+}
+
+//ConfigureTransport 为路由创建独立的Transport(连接池)，需要在开始处理请求前调用
+func (rh *RoutePrefixHandler) ConfigureTransport(opts TransportOptions) {
+	t := newTransport(opts)
+	rh.mux.Lock()
+	defer rh.mux.Unlock()
+	rh.transport = t
+	rh.transportOptions = opts
+	for host, proxy := range rh.reverseProxyMap {
+		proxy.Transport = rh.hostTransport(host)
+	}
+}
+
+//SetFlushInterval 设置转发响应内容时定期Flush的间隔，负数表示每次写入后立即Flush，为0时不定期Flush
+//事件流(text/event-stream)及未知长度的响应由ReverseProxy立即Flush，不受该设置影响，需要在开始处理请求前调用
+func (rh *RoutePrefixHandler) SetFlushInterval(interval time.Duration) {
+	rh.mux.Lock()
+	defer rh.mux.Unlock()
+	rh.flushInterval = interval
+	for _, proxy := range rh.reverseProxyMap {
+		proxy.FlushInterval = interval
+	}
+}
+
+//hostTransport 获取转发到主机使用的Transport，Unix域套接字主机使用按路由连接池参数创建的独立Transport
+func (rh *RoutePrefixHandler) hostTransport(host string) http.RoundTripper {
+	socket, ok := util.UnixSocketPath(host)
+	if !ok {
+		return rh.transport
+	}
+	opts := rh.transportOptions
+	opts.socket = socket
+	return newTransport(opts)
+}
+
+//rewriteErrorBody 判断是否需要在下游主机的响应内容前追加"StatusCode error:"
+//只有开启了RewriteErrorBody才会改写，RewriteErrorStatuses为空时改写所有非200的响应，透传模式下原样返回
+func (rh *RoutePrefixHandler) rewriteErrorBody(status int) bool {
+	if !rh.RewriteErrorBody || rh.PassThroughErrors || status == http.StatusOK {
+		return false
+	}
+	if len(rh.RewriteErrorStatuses) == 0 {
+		return true
+	}
+	for _, s := range rh.RewriteErrorStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+//isEventStream 判断响应是否为服务器推送事件流(text/event-stream)
+func isEventStream(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+//acceptsEventStream 判断客户端是否请求服务器推送事件流，例如浏览器的EventSource
+func acceptsEventStream(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(v, ",") {
+			if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mediaType == "text/event-stream" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//isUpgradeRequest 判断是否为协议升级请求(Connection: Upgrade)，例如WebSocket
+func isUpgradeRequest(r *http.Request) bool {
+	if r == nil || r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//writeProxyError 转发下游主机失败时响应客户端，内部错误信息只记录在日志中
+func (rh *RoutePrefixHandler) writeProxyError(w http.ResponseWriter, r *http.Request, host string, err error) {
+	if errors.Is(err, middleware.ErrBodyTooLarge) {
+		rh.serveError(w, r, http.StatusRequestEntityTooLarge)
+		return
+	}
+	//超过路由的请求超时时间时返回504
+	if errors.Is(err, context.DeadlineExceeded) {
+		logging.Warnf("请求主机 %s 超时: %s", host, err)
+		rh.serveError(w, r, http.StatusGatewayTimeout)
+		return
+	}
+	logging.Errorf("请求主机 %s 失败: %s", host, err)
+	//透传模式下只有下游主机不可达时才由代理返回错误
+	if rh.PassThroughErrors {
+		rh.serveError(w, r, http.StatusBadGateway)
+		return
+	}
+	rh.serveError(w, r, http.StatusInternalServerError)
+}
+
+//rewritePath 重写转发给下游主机的路径，配置了RewriteRegex且路径匹配时使用正则替换，否则将UpstreamPath前缀替换为DownstreamPath
+//正则替换结果中"?"之后的部分作为查询参数，排在客户端请求的查询参数之前
+func (rh *RoutePrefixHandler) rewritePath(u *url.URL) {
+	if rh.RewriteRegex == nil || !rh.RewriteRegex.MatchString(u.Path) {
+		u.Path = strings.Replace(u.Path, rh.UpstreamPath, rh.DownstreamPath, 1)
+		return
+	}
+	path := rh.RewriteRegex.ReplaceAllString(u.Path, rh.RewriteReplacement)
+	if i := strings.Index(path, "?"); i >= 0 {
+		query := path[i+1:]
+		path = path[:i]
+		if u.RawQuery != "" {
+			query += "&" + u.RawQuery
+		}
+		u.RawQuery = query
+	}
+	u.Path = path
+	u.RawPath = ""
 }
 
 //newSingleHostReverseProxy 获取下游主机ReverseProxy
-func newSingleHostReverseProxy(targetUrl *url.URL,upstreamPath string,downstreamPath string)*httputil.ReverseProxy {
+func (rh *RoutePrefixHandler) newSingleHostReverseProxy(targetUrl *url.URL) *httputil.ReverseProxy {
+	host := hostName(targetUrl)
+	scheme, addr := targetUrl.Scheme, targetUrl.Host
+	//Unix域套接字主机通过独立的Transport连接，请求使用HTTP协议及固定的Host
+	if targetUrl.Scheme == unixScheme {
+		scheme, addr = "http", util.UnixSocketHost
+	}
 	director := func(req *http.Request) {
-		req.URL.Host = targetUrl.Host
-		req.URL.Scheme = targetUrl.Scheme
-		req.URL.Path = strings.Replace(req.URL.Path, upstreamPath, downstreamPath, 1)
+		req.URL.Host = addr
+		req.URL.Scheme = scheme
+		//直连客户端为可信代理时保留其转发的X-Forwarded-*，否则删除客户端可能伪造的值
+		//ReverseProxy会在X-Forwarded-For的末尾追加直连客户端的地址，形成完整的转发链
+		trusted := util.IsTrustedProxy(util.RemoteIP(req))
+		if !trusted {
+			req.Header.Del(util.XForwardedFor)
+		}
+		if !trusted || req.Header.Get(util.XForwardedProto) == "" {
+			proto := "http"
+			if req.TLS != nil {
+				proto = "https"
+			}
+			req.Header.Set(util.XForwardedProto, proto)
+		}
+		if !trusted || req.Header.Get(util.XForwardedHost) == "" {
+			req.Header.Set(util.XForwardedHost, req.Host)
+		}
+		//Host只影响请求头，Transport建立TLS连接时的SNI使用req.URL.Host
+		switch {
+		case rh.HostHeaderOverride != "":
+			req.Host = rh.HostHeaderOverride
+		case !rh.PreserveHostHeader:
+			req.Host = addr
+		}
+		rh.rewritePath(req.URL)
+		if info, ok := RouteInfoFromContext(req.Context()); ok {
+			info.RewrittenPath = req.URL.Path
+		}
 
-		if _, ok := req.Header["User-Agent"]; !ok {
-			req.Header.Set("User-Agent", "user-agent")
+		//客户端未携带User-Agent时，只有配置了默认值才转发，否则不转发User-Agent
+		if _, ok := req.Header["User-Agent"]; !ok && rh.DefaultUserAgent != "" {
+			req.Header.Set("User-Agent", rh.DefaultUserAgent)
 		}
 		req.Header.Set(util.XProxy, ReverseProxy)
+		if id, ok := middleware.RequestIDFromContext(req.Context()); ok {
+			req.Header.Set(util.XRequestID, id)
+		}
+		//只转发认证通过的subject及API Key对应的客户端标识，不信任客户端携带的值
+		req.Header.Del(util.XAuthSubject)
+		if subject, ok := middleware.AuthSubjectFromContext(req.Context()); ok {
+			req.Header.Set(util.XAuthSubject, subject)
+		}
+		req.Header.Del(util.XClientID)
+		if clientID, ok := middleware.ClientIDFromContext(req.Context()); ok {
+			req.Header.Set(util.XClientID, clientID)
+		}
 		req.Header.Set(util.XRealIP, util.GetIP(req))
+		//路由配置的请求头在代理设置的请求头之后生效，可以覆盖或删除它们
+		rh.RequestHeaders.apply(req.Header)
+		//注入W3C traceparent，未开启链路追踪时不做任何处理
+		otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
 	}
 
 	//更改内容
 	modifyFunc := func(resp *http.Response) error {
-		if resp.StatusCode != 200 {
+		trace.SpanFromContext(resp.Request.Context()).SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+		state, ok := retryStateFromContext(resp.Request.Context())
+		retry := ok && state.retryStatus
+		//gRPC响应的HTTP状态码始终为200，按gRPC状态码记录请求结果及重试
+		if resp.StatusCode == http.StatusOK && isGRPC(resp.Header) {
+			if err := rh.checkGRPCStatus(host, resp, retry); err != nil {
+				return err
+			}
+		} else {
+			rh.reportResult(host, resp.StatusCode >= http.StatusInternalServerError)
+			//状态码需要重试时丢弃响应，由ErrorHandler记录后外层选择其他主机重试
+			if retry && rh.retryOnStatus(resp.StatusCode) {
+				return &retryStatusError{status: resp.StatusCode}
+			}
+		}
+		rh.ResponseHeaders.apply(resp.Header)
+		rh.rewriteResponseHeaders(resp.Header)
+		//协议升级(WebSocket等)的响应内容是双向连接，不能读取或替换
+		if resp.StatusCode == http.StatusSwitchingProtocols || isUpgradeRequest(resp.Request) {
+			return nil
+		}
+		//事件流需要逐条推送给客户端，不能读取或替换，ReverseProxy对事件流的每次写入都会立即Flush
+		if isEventStream(resp.Header) {
+			return nil
+		}
+		//gRPC的响应内容是流式的，状态在trailers中，不能读取或替换
+		if rh.GRPC || isGRPC(resp.Header) {
+			return nil
+		}
+		//配置了错误页面的状态码使用错误页面替换下游主机的响应内容，透传模式下原样返回
+		if _, ok := rh.ErrorPages[resp.StatusCode]; ok && !rh.PassThroughErrors {
+			contentType, payload := rh.renderError(resp.Request, resp.StatusCode)
+			_ = resp.Body.Close()
+			resp.Body = ioutil.NopCloser(bytes.NewReader(payload))
+			resp.ContentLength = int64(len(payload))
+			resp.Header.Set("Content-Length", strconv.Itoa(len(payload)))
+			resp.Header.Set("Content-Type", contentType)
+			resp.Header.Del("Content-Encoding")
+			return nil
+		}
+		if err := rh.rewriteResponseBody(resp); err != nil {
+			return err
+		}
+		if rh.rewriteErrorBody(resp.StatusCode) {
 			//获取内容
 			oldPayload, err := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
 			if err != nil {
 				return err
 			}
@@ -57,12 +368,31 @@ func newSingleHostReverseProxy(targetUrl *url.URL,upstreamPath string,downstream
 
 	//错误回调 ：关闭real_server时测试，错误回调
 	errorHandler := func(w http.ResponseWriter, r *http.Request, err error) {
-		http.Error(w, "ErrorHandler error:"+err.Error(), 500)
+		recordSpanError(r, err)
+		//客户端取消、对冲请求被取消或请求内容超过限制时不计入主机失败次数
+		//需要重试的状态码已在ModifyResponse中计入
+		tooLarge := errors.Is(err, middleware.ErrBodyTooLarge)
+		var statusErr *retryStatusError
+		var grpcErr *retryGRPCStatusError
+		if r.Context().Err() == nil && !tooLarge && !errors.As(err, &statusErr) && !errors.As(err, &grpcErr) {
+			rh.reportResult(host, true)
+		}
+		if tooLarge {
+			rh.writeProxyError(w, r, host, err)
+			return
+		}
+		//可重试的请求只记录错误，由外层重试其他主机
+		if state, ok := retryStateFromContext(r.Context()); ok {
+			state.err = err
+			return
+		}
+		rh.writeProxyError(w, r, host, err)
 	}
 
 	return &httputil.ReverseProxy{
 		Director:       director,
-		Transport:      transport,
+		Transport:      rh.hostTransport(host),
+		FlushInterval:  rh.flushInterval,
 		ModifyResponse: modifyFunc,
 		ErrorHandler:   errorHandler,
 	}
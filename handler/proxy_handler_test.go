@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDefaultUserAgent(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Header["User-Agent"]; !ok {
+			w.Header().Set("X-No-User-Agent", "1")
+		}
+		_, _ = w.Write([]byte(r.UserAgent()))
+	}))
+	defer backend.Close()
+	rh := newTestRoute(t, backend.URL)
+
+	get := func(userAgent string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/a", nil)
+		if userAgent != "" {
+			r.Header.Set("User-Agent", userAgent)
+		}
+		rec := httptest.NewRecorder()
+		rh.ServeHTTP(rec, r)
+		return rec
+	}
+
+	//未配置默认值时不转发User-Agent，也不使用Go的默认值
+	rec := get("")
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, "1", rec.Header().Get("X-No-User-Agent"))
+	assert.Equal(t, "curl/7.68.0", get("curl/7.68.0").Body.String())
+
+	//客户端未携带User-Agent时使用默认值，携带时原样转发
+	rh.DefaultUserAgent = "proxy/1.0"
+	assert.Equal(t, "proxy/1.0", get("").Body.String())
+	assert.Equal(t, "curl/7.68.0", get("curl/7.68.0").Body.String())
+}
+
+func TestPassThroughErrors(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"title":"user not found"}`))
+	}))
+	defer backend.Close()
+	newHandler := func(passThrough bool, hosts ...string) *RoutePrefixHandler {
+		rh := newTestRoute(t, hosts...)
+		rh.RewriteErrorBody = true
+		rh.PassThroughErrors = passThrough
+		return rh
+	}
+	get := func(rh *RoutePrefixHandler) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rh.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users/1", nil))
+		return rec
+	}
+
+	//开启RewriteErrorBody时改写错误响应的内容
+	rec := get(newHandler(false, backend.URL))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, `StatusCode error:{"title":"user not found"}`, rec.Body.String())
+
+	//透传模式下原样返回下游主机的状态码、响应头及内容
+	rec = get(newHandler(true, backend.URL))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"title":"user not found"}`, rec.Body.String())
+
+	//下游主机不可达时返回502，不暴露内部错误
+	rec = get(newHandler(true, "http://127.0.0.1:1"))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "127.0.0.1")
+	assert.Equal(t, http.StatusInternalServerError, get(newHandler(false, "http://127.0.0.1:1")).Code)
+}
+
+func TestRegisterPathsForwarded(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+	rh, err := NewRoutePrefixHandler("round-robin", "/api", "/v1", []string{backend.URL}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer rh.Stop()
+
+	//路由上不再提供添加及删除主机的接口，主机只能通过管理接口修改
+	for _, path := range []string{"/api/register?url=http://127.0.0.1:1", "/api/unregister?host=" + backend.Listener.Addr().String()} {
+		rec := httptest.NewRecorder()
+		rh.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "register")
+	}
+	assert.Equal(t, []string{backend.Listener.Addr().String()}, rh.Hosts())
+}
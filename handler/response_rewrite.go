@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//DefaultResponseRewriteMaxBody 默认改写响应内容的最大字节数
+const DefaultResponseRewriteMaxBody = 1 << 20
+
+//defaultRewriteHeaders 规则未指定响应头及内容类型时默认改写的响应头
+var defaultRewriteHeaders = []string{"Location", "Link"}
+
+//ResponseRewrite 响应的正则替换规则
+type ResponseRewrite struct {
+	//Regex 匹配的正则表达式
+	Regex *regexp.Regexp
+	//Replacement 替换的内容，可以使用$1等引用分组
+	Replacement string
+	//Headers 需要改写的响应头，为空且未配置ContentTypes时改写Location及Link
+	Headers []string
+	//ContentTypes 需要改写响应内容的内容类型前缀，为空时不改写响应内容
+	ContentTypes []string
+}
+
+//headers 规则需要改写的响应头
+func (rw *ResponseRewrite) headers() []string {
+	if len(rw.Headers) == 0 && len(rw.ContentTypes) == 0 {
+		return defaultRewriteHeaders
+	}
+	return rw.Headers
+}
+
+//matchContentType 判断响应的内容类型是否需要改写
+func (rw *ResponseRewrite) matchContentType(contentType string) bool {
+	for _, t := range rw.ContentTypes {
+		if strings.HasPrefix(contentType, strings.ToLower(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+//rewriteResponseHeaders 按顺序改写响应头
+func (rh *RoutePrefixHandler) rewriteResponseHeaders(h http.Header) {
+	for i := range rh.ResponseRewrites {
+		rw := &rh.ResponseRewrites[i]
+		for _, name := range rw.headers() {
+			values := h.Values(name)
+			for j, v := range values {
+				values[j] = rw.Regex.ReplaceAllString(v, rw.Replacement)
+			}
+		}
+	}
+}
+
+//rewriteResponseBody 按顺序改写内容类型匹配的响应内容并重新计算Content-Length
+//压缩过的响应、超过ResponseRewriteMaxBody的响应原样转发，ResponseRewriteMaxBody为-1时不改写
+func (rh *RoutePrefixHandler) rewriteResponseBody(resp *http.Response) error {
+	maxBody := rh.ResponseRewriteMaxBody
+	if maxBody == 0 {
+		maxBody = DefaultResponseRewriteMaxBody
+	}
+	if maxBody < 0 || resp.Body == nil || resp.Body == http.NoBody {
+		return nil
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return nil
+	}
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	rules := make([]*ResponseRewrite, 0, len(rh.ResponseRewrites))
+	for i := range rh.ResponseRewrites {
+		if rw := &rh.ResponseRewrites[i]; rw.matchContentType(contentType) {
+			rules = append(rules, rw)
+		}
+	}
+	if len(rules) == 0 || resp.ContentLength > maxBody {
+		return nil
+	}
+	//长度未知的响应最多读取maxBody+1字节，超过时将已读取的内容与剩余内容拼接后原样转发
+	payload, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBody+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return err
+	}
+	if int64(len(payload)) > maxBody {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(payload), resp.Body), resp.Body}
+		return nil
+	}
+	_ = resp.Body.Close()
+	for _, rw := range rules {
+		payload = rw.Regex.ReplaceAll(payload, []byte(rw.Replacement))
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(payload))
+	resp.ContentLength = int64(len(payload))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(payload)))
+	return nil
+}
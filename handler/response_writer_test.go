@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := newStatusWriter(rec)
+	assert.Equal(t, http.StatusOK, sw.status)
+
+	sw.WriteHeader(http.StatusTeapot)
+	sw.Flush()
+	assert.Equal(t, http.StatusTeapot, sw.status)
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, http.ResponseWriter(rec), sw.Unwrap())
+
+	//原始的ResponseWriter不支持Hijack时返回错误
+	_, _, err := sw.Hijack()
+	assert.Error(t, err)
+}
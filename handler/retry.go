@@ -0,0 +1,325 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"proxy/middleware"
+	"proxy/util/logging"
+	"strings"
+	"time"
+)
+
+type retryStateKey struct{}
+
+//retryState 可重试请求的转发状态，转发失败时ErrorHandler只记录错误，由外层选择其他主机重试
+type retryState struct {
+	err error
+	//retryStatus 响应状态码在RetryOnStatuses中时是否重试，最后一次尝试时为false，响应原样返回
+	retryStatus bool
+}
+
+//responseHeaderTimeout http.Transport等待响应头超时的错误信息，net/http未导出该错误
+const responseHeaderTimeout = "timeout awaiting response headers"
+
+//statusClientClosedRequest 客户端在代理读取请求内容时断开，与nginx一样使用非标准的499状态码
+const statusClientClosedRequest = 499
+
+//clientAborted 判断读取请求内容失败是否因为客户端断开或取消了请求，而不是请求内容格式不正确(例如chunked编码错误)
+func clientAborted(r *http.Request, err error) bool {
+	return r.Context().Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+//retryStatusError 下游主机返回了RetryOnStatuses中的状态码
+type retryStatusError struct {
+	status int
+}
+
+func (e *retryStatusError) Error() string {
+	return fmt.Sprintf("下游主机返回状态码 %d", e.status)
+}
+
+//retryGRPCStatusError 下游主机以只有响应头的gRPC响应返回了RetryOnGRPCStatuses中的状态码
+type retryGRPCStatusError struct {
+	code    int
+	message string
+}
+
+func (e *retryGRPCStatusError) Error() string {
+	return fmt.Sprintf("下游主机返回gRPC状态码 %d: %s", e.code, e.message)
+}
+
+//retryableError 判断转发失败的错误是否可以重试：连接下游主机失败(包括域名解析失败)、等待响应头超时及需要重试的状态码(包括gRPC状态码)
+//请求发送后的其他错误(例如读取响应失败)时下游主机可能已经处理了请求，不重试
+func retryableError(err error) bool {
+	var statusErr *retryStatusError
+	if errors.As(err, &statusErr) {
+		return true
+	}
+	var grpcErr *retryGRPCStatusError
+	if errors.As(err, &grpcErr) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), responseHeaderTimeout)
+}
+
+//retryOnStatus 判断下游主机返回的状态码是否需要重试
+func (rh *RoutePrefixHandler) retryOnStatus(status int) bool {
+	for _, s := range rh.RetryOnStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+//canRetryStatus 判断本次转发返回需要重试的状态码时能否重试：未达到最大重试次数且还有其他可用的主机
+//不能重试时响应原样返回，不丢弃下游主机的响应内容
+func (rh *RoutePrefixHandler) canRetryStatus(host string, used map[string]bool, attempt uint) bool {
+	if len(rh.RetryOnStatuses) == 0 && len(rh.RetryOnGRPCStatuses) == 0 || attempt >= rh.MaxRetries {
+		return false
+	}
+	for _, stat := range rh.bl.Stats() {
+		if stat.Alive && stat.Name != host && !used[stat.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+//writeRetryError 不再重试时返回最后一次转发的错误，需要重试的状态码(例如超过RetryBudget时)由代理以相同的状态码返回
+func (rh *RoutePrefixHandler) writeRetryError(w http.ResponseWriter, r *http.Request, host string, err error) {
+	var statusErr *retryStatusError
+	if errors.As(err, &statusErr) {
+		rh.serveError(w, r, statusErr.status)
+		return
+	}
+	var grpcErr *retryGRPCStatusError
+	if errors.As(err, &grpcErr) {
+		writeGRPCStatus(w, grpcErr.code, grpcErr.message)
+		return
+	}
+	rh.writeProxyError(w, r, host, err)
+}
+
+//withRetryState 将重试状态保存到请求上下文中
+func withRetryState(r *http.Request, state *retryState) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), retryStateKey{}, state))
+}
+
+//retryStateFromContext 从请求上下文中获取重试状态
+func retryStateFromContext(ctx context.Context) (*retryState, bool) {
+	state, ok := ctx.Value(retryStateKey{}).(*retryState)
+	return state, ok
+}
+
+//retryable 判断请求是否可以重试，只有幂等请求且请求内容为空或可以重新读取(GetBody)时才会重试
+//buffered表示请求内容已缓冲在内存中，此时非幂等请求(例如POST)也可以重试
+func (rh *RoutePrefixHandler) retryable(r *http.Request, buffered bool) bool {
+	if rh.MaxRetries == 0 {
+		return false
+	}
+	if buffered {
+		return true
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 || r.GetBody != nil
+}
+
+//serveWithRetry 转发请求，下游主机连接失败、等待响应头超时或返回RetryOnStatuses中的状态码时选择其他主机重试，
+//最多重试MaxRetries次，总耗时不超过RetryBudget
+func (rh *RoutePrefixHandler) serveWithRetry(w http.ResponseWriter, r *http.Request, host string, proxy *httputil.ReverseProxy, info *RouteInfo) {
+	buffered := false
+	if rh.MaxRetries > 0 && rh.BufferRequestBody > 0 {
+		var err error
+		if r, buffered, err = rh.bufferBody(r); err != nil {
+			switch {
+			case errors.Is(err, middleware.ErrBodyTooLarge):
+				rh.writeProxyError(w, r, host, err)
+			case clientAborted(r, err):
+				//客户端已断开，响应无法送达，只记录状态码
+				logging.Infof("[%v]客户端在发送请求%s 的内容时断开: %s", r.RemoteAddr, r.URL.Path, err)
+				w.WriteHeader(statusClientClosedRequest)
+			default:
+				logging.Warnf("[%v]请求%s 的内容格式不正确: %s", r.RemoteAddr, r.URL.Path, err)
+				rh.serveError(w, r, http.StatusBadRequest)
+			}
+			return
+		}
+	}
+	if !rh.retryable(r, buffered) {
+		release := rh.acquire(host)
+		defer release()
+		proxyStart := time.Now()
+		rh.forward(w, withRouteInfo(r, info), host, proxy)
+		rh.observe(r, host, time.Since(proxyStart))
+		return
+	}
+
+	start := time.Now()
+	used := map[string]bool{}
+	for attempt := uint(0); ; attempt++ {
+		state := &retryState{retryStatus: rh.canRetryStatus(host, used, attempt)}
+		release := rh.acquire(host)
+		attemptStart := time.Now()
+		rh.forward(w, withRouteInfo(withRetryState(r, state), info), host, proxy)
+		release()
+		if state.err == nil {
+			rh.observe(r, host, time.Since(attemptStart))
+			return
+		}
+		if !retryableError(state.err) {
+			rh.writeProxyError(w, r, host, state.err)
+			return
+		}
+
+		used[host] = true
+		next, nextProxy, err := rh.nextAttempt(r, used, attempt, start)
+		if err != nil {
+			logging.Warnf("请求主机 %s 失败: %s, 不再重试: %s", host, state.err, err)
+			rh.writeRetryError(w, r, host, state.err)
+			return
+		}
+		if err := rh.waitRetry(r, attempt, start); err != nil {
+			logging.Warnf("请求主机 %s 失败: %s, 不再重试: %s", host, state.err, err)
+			rh.writeRetryError(w, r, host, state.err)
+			return
+		}
+		logging.Warnf("请求主机 %s 失败: %s, 重试主机 %s (%d/%d)", host, state.err, next, attempt+1, rh.MaxRetries)
+		host, proxy = next, nextProxy
+		info.Host = host
+		if r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				rh.writeProxyError(w, r, host, err)
+				return
+			}
+			r = r.Clone(r.Context())
+			r.Body = body
+		}
+	}
+}
+
+//RetryBackoff 重试前的指数退避等待时间，第n次重试等待Base*2^n，不超过Max
+//Jitter(0~1)为随机减少的最大比例，避免多个请求同时重试
+type RetryBackoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter float64
+}
+
+//Delay 获取第attempt(从0开始)次重试前的等待时间，Base为0时不等待
+func (b RetryBackoff) Delay(attempt uint) time.Duration {
+	if b.Base <= 0 {
+		return 0
+	}
+	delay := b.Base
+	for i := uint(0); i < attempt && (b.Max <= 0 || delay < b.Max) && delay < math.MaxInt64/2; i++ {
+		delay *= 2
+	}
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	}
+	if b.Jitter > 0 {
+		delay -= time.Duration(b.Jitter * rand.Float64() * float64(delay))
+	}
+	return delay
+}
+
+//waitRetry 重试前按RetryBackoff等待，等待后会超过请求的超时时间或RetryBudget时不再等待并返回错误
+func (rh *RoutePrefixHandler) waitRetry(r *http.Request, attempt uint, start time.Time) error {
+	delay := rh.RetryBackoff.Delay(attempt)
+	if delay <= 0 {
+		return nil
+	}
+	wakeup := time.Now().Add(delay)
+	if deadline, ok := r.Context().Deadline(); ok && !wakeup.Before(deadline) {
+		return fmt.Errorf("重试等待 %s 将超过请求的超时时间", delay)
+	}
+	if rh.RetryBudget > 0 && !wakeup.Before(start.Add(rh.RetryBudget)) {
+		return fmt.Errorf("重试等待 %s 将超过重试时间预算 %s", delay, rh.RetryBudget)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
+
+//nextAttempt 判断是否还可以重试，可以重试时选择一个尚未使用的主机
+func (rh *RoutePrefixHandler) nextAttempt(r *http.Request, used map[string]bool, attempt uint, start time.Time) (string, *httputil.ReverseProxy, error) {
+	if attempt >= rh.MaxRetries {
+		return "", nil, fmt.Errorf("已达到最大重试次数 %d", rh.MaxRetries)
+	}
+	if rh.RetryBudget > 0 && time.Since(start) >= rh.RetryBudget {
+		return "", nil, fmt.Errorf("已超过重试时间预算 %s", rh.RetryBudget)
+	}
+	if err := r.Context().Err(); err != nil {
+		return "", nil, err
+	}
+	host, ok := rh.otherHost(r, used)
+	if !ok {
+		return "", nil, fmt.Errorf("没有其他可用的主机")
+	}
+	proxy := rh.reverseProxy(host)
+	if proxy == nil {
+		return "", nil, fmt.Errorf("主机 %s 已被删除", host)
+	}
+	return host, proxy, nil
+}
+
+//bufferBody 将不超过BufferRequestBody字节的请求内容读取到内存中，并设置GetBody以便重试时重新发送
+//请求内容超过限制时不缓冲，已读取的部分与剩余内容拼接后原样转发，返回的buffered为false
+func (rh *RoutePrefixHandler) bufferBody(r *http.Request) (*http.Request, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		r = r.Clone(r.Context())
+		r.GetBody = func() (io.ReadCloser, error) {
+			return http.NoBody, nil
+		}
+		return r, true, nil
+	}
+	if r.ContentLength > rh.BufferRequestBody {
+		return r, false, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, rh.BufferRequestBody+1))
+	if err != nil {
+		return r, false, err
+	}
+	r = r.Clone(r.Context())
+	if int64(len(body)) > rh.BufferRequestBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return r, false, nil
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return r, true, nil
+}
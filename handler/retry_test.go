@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+//failingBody 返回部分请求内容后读取失败的请求内容
+type failingBody struct {
+	io.Reader
+	err error
+}
+
+func (b *failingBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *failingBody) Close() error {
+	return nil
+}
+
+func TestServeWithRetry_BodyReadError(t *testing.T) {
+	var hits int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+	rh := newTestRoute(t, backend.URL)
+	rh.MaxRetries = 1
+	rh.BufferRequestBody = 1024
+
+	post := func(body io.ReadCloser) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/a", nil)
+		req.Body = body
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		rh.ServeHTTP(rec, req)
+		return rec
+	}
+
+	//客户端在发送请求内容时断开
+	rec := post(&failingBody{strings.NewReader("trunc"), io.ErrUnexpectedEOF})
+	assert.Equal(t, statusClientClosedRequest, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	rec = post(&failingBody{strings.NewReader("trunc"), context.Canceled})
+	assert.Equal(t, statusClientClosedRequest, rec.Code)
+
+	//请求内容格式不正确
+	rec = post(&failingBody{strings.NewReader("1x"), errors.New("invalid byte in chunk length")})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&hits))
+
+	//完整的请求内容正常转发
+	rec = post(ioutil.NopCloser(strings.NewReader("complete")))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "complete", rec.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+}
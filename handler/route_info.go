@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+type routeInfoKey struct{}
+
+//RouteInfo 记录请求匹配到的路由及路径重写结果，便于排查路径重写配置问题
+type RouteInfo struct {
+	//Route 匹配到的路由(上游请求路径)
+	Route string
+	//Host 负载均衡器选择的下游主机
+	Host string
+	//OriginalPath 客户端请求的原始路径
+	OriginalPath string
+	//RewrittenPath 重写后转发到下游主机的路径
+	RewrittenPath string
+}
+
+//withRouteInfo 将路由信息保存到请求上下文中
+func withRouteInfo(r *http.Request, info *RouteInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routeInfoKey{}, info))
+}
+
+//RouteInfoFromContext 从请求上下文中获取路由信息
+func RouteInfoFromContext(ctx context.Context) (*RouteInfo, bool) {
+	info, ok := ctx.Value(routeInfoKey{}).(*RouteInfo)
+	return info, ok
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRouteInfo(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+	host := strings.TrimPrefix(backend.URL, "http://")
+	rh, err := NewRoutePrefixHandler("round-robin", "/api", "/v1", []string{backend.URL}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer rh.Stop()
+
+	_, ok := RouteInfoFromContext(context.Background())
+	assert.False(t, ok)
+
+	//转发时记录重写后的路径，与下游主机收到的路径一致
+	forward := func(path string) (*RouteInfo, string) {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		info := &RouteInfo{Route: rh.UpstreamPath, Host: host, OriginalPath: r.URL.Path}
+		r = withRouteInfo(r, info)
+		stored, ok := RouteInfoFromContext(r.Context())
+		assert.True(t, ok)
+		assert.Same(t, info, stored)
+		rec := httptest.NewRecorder()
+		rh.forward(rec, r, host, rh.reverseProxy(host))
+		return info, rec.Body.String()
+	}
+	info, path := forward("/api/users/1")
+	assert.Equal(t, "/v1/users/1", info.RewrittenPath)
+	assert.Equal(t, "/v1/users/1", path)
+	assert.Equal(t, "/api/users/1", info.OriginalPath)
+
+	rh.RewriteRegex = regexp.MustCompile(`^/api/users/([0-9]+)$`)
+	rh.RewriteReplacement = "/v2/accounts/$1"
+	info, path = forward("/api/users/1")
+	assert.Equal(t, "/v2/accounts/1", info.RewrittenPath)
+	assert.Equal(t, "/v2/accounts/1", path)
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//latencyWindowSize 计算延迟分位数时保留的最近请求数
+const latencyWindowSize = 1024
+
+//routeStats 路由的请求统计，延迟分位数基于最近latencyWindowSize个请求计算
+type routeStats struct {
+	inflight int64
+	requests uint64
+	errors   uint64
+
+	mux       sync.Mutex
+	latencies [latencyWindowSize]time.Duration
+	next      int
+	count     int
+}
+
+//RouteStats 路由请求统计快照
+type RouteStats struct {
+	Inflight     int64
+	Requests     uint64
+	Errors       uint64
+	LatencyP50Ms float64
+	LatencyP95Ms float64
+	LatencyP99Ms float64
+}
+
+//begin 请求开始
+func (s *routeStats) begin() {
+	atomic.AddInt64(&s.inflight, 1)
+}
+
+//end 请求结束，记录状态码与耗时，5xx视为错误
+func (s *routeStats) end(status int, elapsed time.Duration) {
+	atomic.AddInt64(&s.inflight, -1)
+	atomic.AddUint64(&s.requests, 1)
+	if status >= 500 {
+		atomic.AddUint64(&s.errors, 1)
+	}
+
+	s.mux.Lock()
+	s.latencies[s.next] = elapsed
+	s.next = (s.next + 1) % latencyWindowSize
+	if s.count < latencyWindowSize {
+		s.count++
+	}
+	s.mux.Unlock()
+}
+
+//snapshot 获取统计快照，分位数在这里计算，不影响请求处理
+func (s *routeStats) snapshot() RouteStats {
+	s.mux.Lock()
+	window := make([]time.Duration, s.count)
+	copy(window, s.latencies[:s.count])
+	s.mux.Unlock()
+
+	sort.Slice(window, func(i, j int) bool {
+		return window[i] < window[j]
+	})
+	return RouteStats{
+		Inflight:     atomic.LoadInt64(&s.inflight),
+		Requests:     atomic.LoadUint64(&s.requests),
+		Errors:       atomic.LoadUint64(&s.errors),
+		LatencyP50Ms: percentileMs(window, 0.50),
+		LatencyP95Ms: percentileMs(window, 0.95),
+		LatencyP99Ms: percentileMs(window, 0.99),
+	}
+}
+
+//percentileMs 获取已排序延迟的分位数(毫秒)
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return float64(sorted[idx]) / float64(time.Millisecond)
+}
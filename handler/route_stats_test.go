@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouteStats(t *testing.T) {
+	var s routeStats
+	assert.Equal(t, RouteStats{}, s.snapshot())
+
+	//请求结束前计入在途请求数
+	s.begin()
+	s.begin()
+	assert.Equal(t, int64(2), s.snapshot().Inflight)
+	s.end(http.StatusOK, 10*time.Millisecond)
+	s.end(http.StatusBadGateway, 20*time.Millisecond)
+	stats := s.snapshot()
+	assert.Equal(t, int64(0), stats.Inflight)
+	assert.Equal(t, uint64(2), stats.Requests)
+	//只有5xx计入错误数
+	assert.Equal(t, uint64(1), stats.Errors)
+
+	//1~100毫秒
+	s = routeStats{}
+	for i := 100; i >= 1; i-- {
+		s.begin()
+		s.end(http.StatusNotFound, time.Duration(i)*time.Millisecond)
+	}
+	stats = s.snapshot()
+	assert.Equal(t, uint64(0), stats.Errors)
+	assert.Equal(t, 50.0, stats.LatencyP50Ms)
+	assert.Equal(t, 95.0, stats.LatencyP95Ms)
+	assert.Equal(t, 99.0, stats.LatencyP99Ms)
+}
+
+func TestRouteStats_Window(t *testing.T) {
+	var s routeStats
+	//超过窗口大小后只使用最近的请求计算分位数
+	for i := 0; i < latencyWindowSize; i++ {
+		s.begin()
+		s.end(http.StatusOK, time.Second)
+	}
+	for i := 0; i < latencyWindowSize; i++ {
+		s.begin()
+		s.end(http.StatusOK, time.Millisecond)
+	}
+	stats := s.snapshot()
+	assert.Equal(t, uint64(2*latencyWindowSize), stats.Requests)
+	assert.Equal(t, 1.0, stats.LatencyP99Ms)
+}
+
+func TestRouteStats_ServeHTTP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+	rh := newTestRoute(t, backend.URL)
+
+	rh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/a", nil))
+	rh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/a?fail=1", nil))
+	stats := rh.Summary().Stats
+	assert.Equal(t, int64(0), stats.Inflight)
+	assert.Equal(t, uint64(2), stats.Requests)
+	assert.Equal(t, uint64(1), stats.Errors)
+	assert.Greater(t, stats.LatencyP99Ms, 0.0)
+}
@@ -1,18 +1,31 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"proxy/balancer"
+	"proxy/middleware"
+	"proxy/util"
 	"proxy/util/logging"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	ReverseProxy = "Balancer-Reverse-Proxy"
+
+	ErrHostNotFound      = errors.New("host not found")
+	ErrHostAlreadyExists = errors.New("host already exists")
+	ErrInvalidHost       = errors.New("invalid host")
+	ErrNoBackendGroups   = errors.New("route has no backend groups")
 )
 
 //RoutePrefixHandler 前缀路由处理程序
@@ -20,76 +33,491 @@ type RoutePrefixHandler struct {
 	mux sync.RWMutex
 	//bl 通过请求时的url，获取具体的负载均衡器
 	bl balancer.Balancer
+	//Algorithm 负载均衡算法
+	Algorithm string
+	//UseClientIPKey 是否使用客户端IP作为负载均衡的key，IP哈希算法默认开启
+	UseClientIPKey bool
+	//MaxConnsPerHost 每个下游主机的最大在途请求数，为0时不限制
+	MaxConnsPerHost int64
+	//GRPC 是否为gRPC路由，开启后不改写响应内容，代理产生的错误以gRPC状态返回
+	GRPC bool
+	//Mirror 将部分请求复制到镜像主机，为nil时不镜像
+	Mirror *Mirror
+	//StickySession 基于Cookie的会话保持，为nil时不开启
+	StickySession *StickySession
+	//TraceRequests 是否在DEBUG级别记录每个请求的路径、负载均衡的key、选择的主机、重写的路径、状态码及耗时
+	TraceRequests bool
 	//UpstreamPath 上游请求路径
 	UpstreamPath string
 	//DownstreamPath 下游请求路径
 	DownstreamPath string
+	//RewriteRegex 重写转发路径的正则表达式，为nil时将UpstreamPath前缀替换为DownstreamPath
+	RewriteRegex *regexp.Regexp
+	//RewriteReplacement 重写后的路径，可以引用RewriteRegex的捕获组，包含"?"时之后的部分作为查询参数
+	RewriteReplacement string
 	//alive 主机存活检测
 	alive map[string]bool
+	//health 主机最后一次健康检查的时间及最近的状态变化
+	health map[string]*healthHistory
 	//reverseProxyMap 根据负载均衡器返回的host，获取对应的反向代理
 	reverseProxyMap map[string]*httputil.ReverseProxy
-	//builtinHandler 内置处理程序
-	builtinHandler map[string]func(w http.ResponseWriter, r *http.Request)
+	//transport 转发请求使用的Transport，默认与其他路由共用
+	transport http.RoundTripper
+	//transportOptions 路由的连接池参数，用于为Unix域套接字主机创建Transport
+	transportOptions TransportOptions
+	//flushInterval 转发响应内容时定期Flush的间隔，负数表示每次写入后立即Flush，为0时不定期Flush
+	flushInterval time.Duration
+	//pending 预热中的主机，需要通过健康检查后才会加入负载均衡器
+	pending map[string]bool
+	//drained 维护中的主机，不再分配新的请求
+	drained map[string]bool
+	//configured 创建路由时配置的主机，用于区分运行时通过管理接口添加及删除的主机
+	configured map[string]bool
+	//weights 运行时通过管理接口调整的主机权重
+	weights map[string]int
+	//groupWeights 运行时通过管理接口调整的主机组流量权重
+	groupWeights map[string]int
+	//healthCheckInterval 健康检查间隔时间(秒)，为0时表示未开启健康检查
+	healthCheckInterval uint
+	//HealthCheckType 健康检查方式(tcp/http)，为空时配置了HealthCheckPath或主机为http/https协议时使用http，否则使用tcp
+	HealthCheckType string
+	//HealthCheckPath http健康检查请求的路径，为空时为/
+	HealthCheckPath string
+	//HealthCheckExpectStatus 健康检查期望的响应状态码，默认为200
+	HealthCheckExpectStatus int
+	//HealthCheckTimeout 健康检查请求的超时时间，默认为3秒
+	HealthCheckTimeout time.Duration
+	//MaxRetries 幂等请求连接下游主机失败时，重试其他主机的最大次数，为0时不重试
+	MaxRetries uint
+	//RetryOnStatuses 下游主机返回这些状态码时也重试其他主机，默认只有连接失败及等待响应头超时才重试
+	RetryOnStatuses []int
+	//RetryOnGRPCStatuses 下游主机返回这些gRPC状态码时重试其他主机，并计入熔断及被动健康检查的失败次数
+	//只有响应头(Trailers-Only)中的状态码可以重试，trailers中的状态码只计入失败次数
+	RetryOnGRPCStatuses []int
+	//RetryBudget 重试的总时间预算，超过后不再重试，为0时不限制
+	RetryBudget time.Duration
+	//RetryBackoff 重试前的退避等待时间，默认不等待
+	RetryBackoff RetryBackoff
+	//BufferRequestBody 开启重试时缓冲请求内容的最大字节数，缓冲后非幂等请求也可以重试，超过时不缓冲也不重试，为0时不缓冲
+	BufferRequestBody int64
+	//PassiveMaxFails 被动健康检查：主机连续失败(5xx或连接失败)该次数后被摘除，为0时不开启
+	PassiveMaxFails uint
+	//PassiveEjectDuration 被动健康检查摘除主机的时长，默认为30秒
+	PassiveEjectDuration time.Duration
+	//fails 每个主机连续失败的次数
+	fails map[string]uint
+	//ejected 被被动健康检查摘除的主机
+	ejected map[string]bool
+	//schemes 每个主机的协议，用于发送HTTP健康检查请求
+	schemes map[string]string
+	//stop 关闭后所有健康检查协程退出
+	stop     chan struct{}
+	stopOnce sync.Once
+	//Warmup 新添加的主机加入负载均衡器前需要连续通过健康检查的次数，为0时立即加入
+	Warmup uint
+	//inflight 每个主机正在处理中的请求数
+	inflight map[string]*int64
+	//DrainTimeout 主机被摘除后等待在途请求完成的最长时间，为0时不等待
+	DrainTimeout time.Duration
+	//RequestTimeout 请求的默认超时时间，超时后返回504，为0时不限制
+	RequestTimeout time.Duration
+	//MaxRequestTimeout 客户端通过TimeoutHeader可以指定的最大超时时间，为0时不允许客户端指定
+	MaxRequestTimeout time.Duration
+	//TimeoutHeader 客户端指定超时时间(毫秒)的请求头，默认为X-Timeout-Ms
+	TimeoutHeader string
+	//PreserveHostHeader 是否将客户端请求的Host原样转发给下游主机，默认使用下游主机的地址
+	PreserveHostHeader bool
+	//HostHeaderOverride 转发给下游主机的Host，配置后优先于PreserveHostHeader
+	//只影响请求的Host，https下游主机的TLS SNI及证书校验仍使用下游主机的地址
+	HostHeaderOverride string
+	//ErrorPages 按状态码配置的错误页面，未配置的状态码返回只包含状态码及状态描述的默认页面
+	ErrorPages map[int]*ErrorPage
+	//FallbackResponse 所有下游主机均不可用时返回的响应，为nil时返回503(主机均不可用)或502(没有主机)
+	FallbackResponse *FallbackResponse
+	//DefaultUserAgent 客户端未携带User-Agent时转发给下游主机的默认值，为空时不转发User-Agent
+	DefaultUserAgent string
+	//PassThroughErrors 是否原样返回下游主机的错误响应(状态码及内容)，开启后不改写响应内容
+	PassThroughErrors bool
+	//RewriteErrorBody 是否在下游主机错误响应的内容前追加"StatusCode error:"，默认不改写
+	RewriteErrorBody bool
+	//RewriteErrorStatuses 需要改写响应内容的状态码，为空时改写所有非200的响应
+	RewriteErrorStatuses []int
+	//RequestHeaders 转发给下游主机前修改的请求头，为nil时不修改
+	RequestHeaders *HeaderRules
+	//ResponseHeaders 返回给客户端前修改的下游主机响应头，为nil时不修改
+	ResponseHeaders *HeaderRules
+	//ResponseRewrites 返回给客户端前按顺序改写响应头及响应内容的规则
+	ResponseRewrites []ResponseRewrite
+	//ResponseRewriteMaxBody 改写响应内容的最大字节数，为0时使用默认值1MB，为-1时不改写响应内容
+	ResponseRewriteMaxBody int64
+	//HedgeDelay 幂等请求超过该时间未返回时向其他主机发送对冲请求，为0时不对冲
+	HedgeDelay time.Duration
+	//HedgeMaxAttempts 对冲时最多并行的请求数(包括首次请求)，默认为2
+	HedgeMaxAttempts uint
+	//HedgeBudgetPercent 对冲请求最多占总请求数的百分比，默认为10
+	HedgeBudgetPercent uint
+	//hedged 已发送的对冲请求数
+	hedged uint64
+	//stats 路由请求统计
+	stats routeStats
 }
 
 //NewRoutePrefixHandler 接收下游的主机信息，返回下游主机代理
-func NewRoutePrefixHandler(algorithm string,upstreamPath string,downstreamPath string, downstreamHosts []string) (*RoutePrefixHandler,error) {
+//weights 与downstreamHosts一一对应，为空时每个主机的权重为1
+func NewRoutePrefixHandler(algorithm string,upstreamPath string,downstreamPath string, downstreamHosts []string, weights []int) (*RoutePrefixHandler,error) {
 	var targetHosts []string
-	alive := make(map[string]bool)
-	reverseProxyMap := make(map[string]*httputil.ReverseProxy)
+	prefixHandler := &RoutePrefixHandler{
+		Algorithm:       algorithm,
+		UseClientIPKey:  balancer.UseClientIPKey(algorithm),
+		alive:           make(map[string]bool),
+		health:          make(map[string]*healthHistory),
+		inflight:        make(map[string]*int64),
+		pending:         make(map[string]bool),
+		drained:         make(map[string]bool),
+		configured:      make(map[string]bool),
+		weights:         make(map[string]int),
+		groupWeights:    make(map[string]int),
+		stop:            make(chan struct{}),
+		schemes:         make(map[string]string),
+		fails:           make(map[string]uint),
+		ejected:         make(map[string]bool),
+		UpstreamPath:    upstreamPath,
+		DownstreamPath:  downstreamPath,
+		reverseProxyMap: make(map[string]*httputil.ReverseProxy),
+		transport:       transport,
+	}
 
 	for _, dh := range downstreamHosts {
-		dest, err := url.Parse(dh)
-		if err != nil || dest.Scheme == "" || dest.Host == "" {
+		dest, err := parseHost(dh)
+		if err != nil {
 			return nil, err
 		}
-		host := cleanHost(dest.Host)
-		alive[host] = true
+		host := hostName(dest)
+		prefixHandler.alive[host] = true
+		prefixHandler.configured[host] = true
+		prefixHandler.inflight[host] = new(int64)
+		prefixHandler.schemes[host] = dest.Scheme
 		targetHosts = append(targetHosts, host)
-		reverseProxyMap[host] = newSingleHostReverseProxy(dest, upstreamPath, downstreamPath)
+		prefixHandler.reverseProxyMap[host] = prefixHandler.newSingleHostReverseProxy(dest)
 
-		logging.Infof("主机 %s 初始化成功", dh)
+		logging.Debugf("主机 %s 初始化成功", dh)
 	}
-	bl, err := balancer.Build(algorithm, targetHosts)
+	bl, err := balancer.BuildWithWeights(algorithm, targetHosts, weights)
 	if err != nil {
 		return nil, err
 	}
-
-	prefixHandler := &RoutePrefixHandler{
-		bl:              bl,
-		alive:           alive,
-		UpstreamPath:    upstreamPath,
-		DownstreamPath:  downstreamPath,
-		reverseProxyMap: reverseProxyMap,
-	}
-	prefixHandler.builtinHandler = map[string]func(w http.ResponseWriter, r *http.Request){
-		upstreamPath + "/register":   prefixHandler.registerHost,
-		upstreamPath + "/unregister": prefixHandler.unregisterHost,
-	}
+	prefixHandler.bl = bl
 	return prefixHandler, nil
 }
 
 //ServeHTTP 实现到http服务器的代理
 func (rh *RoutePrefixHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	//当前缀匹配进来之后，先判断是否请求内置的接口
-	handler := rh.builtinHandler[r.URL.Path]
-	if handler != nil {
-		handler(w, r)
-		return
+	start := time.Now()
+	sw := newStatusWriter(w)
+	rh.stats.begin()
+	r, span := rh.startServerSpan(r)
+	var info *RouteInfo
+	defer func() {
+		elapsed := time.Since(start)
+		rh.stats.end(sw.status, elapsed)
+		host := ""
+		if info != nil {
+			host = info.Host
+		}
+		endServerSpan(span, host, sw.status)
+		if rh.TraceRequests {
+			rh.traceRequest(r, info, sw.status, elapsed)
+		}
+	}()
+	w = sw
+
+	//会话保持的主机仍然可用时直接使用，否则由负载均衡器重新选择
+	sticky := ""
+	if rh.StickySession != nil {
+		sticky = rh.stickyHost(r)
+	}
+	host := sticky
+	var err error
+	if host == "" {
+		host, err = rh.balance(r)
 	}
-	//如果不是请求内置接口，则进行转发
-	key := fmt.Sprintf("%s?%s", r.URL.Path, r.URL.RawQuery)
-	host, err := rh.bl.Balance(key)
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
-		errStr := fmt.Sprintf("负载均衡器: %s", err.Error())
-		logging.Error(errStr)
-		_, _ = w.Write([]byte(errStr))
+		recordSpanError(r, err)
+		if rh.FallbackResponse != nil && (errors.Is(err, balancer.ErrAllHostsDown) || errors.Is(err, balancer.ErrNoHost)) {
+			logging.Warnf("路由 %s 的所有下游主机均不可用, 返回降级响应", rh.UpstreamPath)
+			rh.FallbackResponse.serve(sw, r)
+			return
+		}
+		if errors.Is(err, balancer.ErrAllHostsDown) {
+			logging.Warnf("服务不可用: 路由 %s 的所有下游主机均不可用", rh.UpstreamPath)
+			rh.serveError(w, r, http.StatusServiceUnavailable)
+			return
+		}
+		logging.Errorf("负载均衡器: %s", err.Error())
+		rh.serveError(w, r, http.StatusBadGateway)
 		return
 	}
+	if rh.hostFull(host) {
+		next, ok := rh.availableHost(r, host)
+		if !ok {
+			logging.Warnf("服务不可用: 路由 %s 的下游主机均已达到最大在途请求数 %d", rh.UpstreamPath, rh.MaxConnsPerHost)
+			rh.serveError(w, r, http.StatusServiceUnavailable)
+			return
+		}
+		host = next
+	}
+	if rh.StickySession != nil && host != sticky {
+		rh.StickySession.setCookie(w, r, host)
+	}
+	proxy := rh.reverseProxy(host)
+	if proxy == nil {
+		//主机在被负载均衡器选中后、转发前被删除
+		logging.Warnf("服务不可用: 主机 %s 已被删除", host)
+		rh.serveError(w, r, http.StatusServiceUnavailable)
+		return
+	}
+	//转发到下游主机的请求使用客户端请求的上下文，客户端断开时取消；配置了超时时间时下游请求在超时后取消
+	if timeout := rh.requestTimeout(r); timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	r = rh.mirror(r)
+
+	info = &RouteInfo{Route: rh.UpstreamPath, Host: host, OriginalPath: r.URL.Path}
+	if rh.hedgeable(r) {
+		release := rh.acquire(host)
+		defer release()
+		rh.serveHedged(w, r, host, info)
+	} else {
+		rh.serveWithRetry(w, r, host, proxy, info)
+	}
+	middleware.SetUpstreamHost(r.Context(), info.Host)
+}
+
+//traceRequest 在DEBUG级别记录请求的转发过程，info为nil表示没有选择到主机
+func (rh *RoutePrefixHandler) traceRequest(r *http.Request, info *RouteInfo, status int, elapsed time.Duration) {
+	host, rewritten := "-", "-"
+	if info != nil {
+		host, rewritten = info.Host, info.RewrittenPath
+	}
+	logging.Debugf("请求跟踪: 路由: %s 请求: %s %s 负载均衡key: %s 主机: %s 重写路径: %s 状态码: %d 耗时: %s",
+		rh.UpstreamPath, r.Method, r.URL.RequestURI(), rh.balanceKey(r), host, rewritten, status, elapsed)
+}
+
+//hostFull 判断主机的在途请求数是否已达到MaxConnsPerHost，在途请求数与负载均衡器的负载在acquire中同时增加
+//判断与增加不是原子操作，并发较高时在途请求数可能短暂超过限制
+func (rh *RoutePrefixHandler) hostFull(host string) bool {
+	return rh.MaxConnsPerHost > 0 && rh.Inflight(host) >= rh.MaxConnsPerHost
+}
+
+//availableHost 主机已达到最大在途请求数时重新选择一个未达到的主机
+func (rh *RoutePrefixHandler) availableHost(r *http.Request, full string) (string, bool) {
+	used := map[string]bool{full: true}
+	for {
+		host, ok := rh.otherHost(r, used)
+		if !ok {
+			return "", false
+		}
+		if !rh.hostFull(host) {
+			return host, true
+		}
+		used[host] = true
+	}
+}
+
+//acquire 增加主机的负载及在途请求数，返回的函数在请求完成后调用以释放
+func (rh *RoutePrefixHandler) acquire(host string) func() {
 	rh.bl.Inc(host)
-	defer rh.bl.Done(host)
-	rh.reverseProxyMap[host].ServeHTTP(w, r)
+	counter := rh.inflightCounter(host)
+	atomic.AddInt64(counter, 1)
+	return func() {
+		atomic.AddInt64(counter, -1)
+		rh.bl.Done(host)
+	}
+}
+
+//observe 上报主机的响应延迟给负载均衡器，并记录到请求上下文中供监控指标使用
+func (rh *RoutePrefixHandler) observe(r *http.Request, host string, latency time.Duration) {
+	rh.bl.Observe(host, latency)
+	middleware.SetUpstreamLatency(r.Context(), latency)
+}
+
+//SetWeight 运行时调整主机权重
+func (rh *RoutePrefixHandler) SetWeight(host string, weight int) error {
+	if err := rh.bl.SetWeight(host, weight); err != nil {
+		return err
+	}
+	rh.mux.Lock()
+	rh.weights[host] = weight
+	rh.mux.Unlock()
+	return nil
+}
+
+//SetReplicas 设置一致性哈希每个主机副本(虚拟节点)的数量，算法不支持时返回错误
+func (rh *RoutePrefixHandler) SetReplicas(replicas int) error {
+	bl := rh.bl
+	if cb, ok := bl.(*balancer.CircuitBreaker); ok {
+		bl = cb.Unwrap()
+	}
+	setter, ok := bl.(balancer.ReplicaSetter)
+	if !ok {
+		return fmt.Errorf("\"%s\" 算法不支持设置副本数量", rh.Algorithm)
+	}
+	setter.SetReplicas(replicas)
+	return nil
+}
+
+//SetBackendGroups 按权重将流量分配到多个主机组，先按权重选择主机组再由组内的负载均衡器选择主机
+//主机组中的主机需要已在创建路由时配置，需要在开启熔断之前调用
+func (rh *RoutePrefixHandler) SetBackendGroups(groups []balancer.SplitGroup) error {
+	normalized := make([]balancer.SplitGroup, 0, len(groups))
+	rh.mux.RLock()
+	for _, g := range groups {
+		hosts := make([]string, 0, len(g.Hosts))
+		for _, h := range g.Hosts {
+			host := normalizeHost(h)
+			if rh.reverseProxyMap[host] == nil {
+				rh.mux.RUnlock()
+				return fmt.Errorf("主机组 %s 的主机 %s 不存在", g.Name, h)
+			}
+			hosts = append(hosts, host)
+		}
+		g.Hosts = hosts
+		normalized = append(normalized, g)
+	}
+	rh.mux.RUnlock()
+
+	split, err := balancer.NewSplit(rh.Algorithm, normalized)
+	if err != nil {
+		return err
+	}
+	rh.bl = split
+	return nil
+}
+
+//split 获取按权重分配流量的负载均衡器，未配置主机组时返回nil
+func (rh *RoutePrefixHandler) split() *balancer.Split {
+	bl := rh.bl
+	if cb, ok := bl.(*balancer.CircuitBreaker); ok {
+		bl = cb.Unwrap()
+	}
+	split, _ := bl.(*balancer.Split)
+	return split
+}
+
+//SetGroupWeight 运行时调整主机组的流量权重，路由未配置主机组时返回错误
+func (rh *RoutePrefixHandler) SetGroupWeight(group string, weight int) error {
+	split := rh.split()
+	if split == nil {
+		return ErrNoBackendGroups
+	}
+	if err := split.SetGroupWeight(group, weight); err != nil {
+		return err
+	}
+	rh.mux.Lock()
+	rh.groupWeights[group] = weight
+	rh.mux.Unlock()
+	return nil
+}
+
+//BackendGroups 获取各主机组的流量权重，路由未配置主机组时返回nil
+func (rh *RoutePrefixHandler) BackendGroups() []balancer.GroupStat {
+	if split := rh.split(); split != nil {
+		return split.Groups()
+	}
+	return nil
+}
+
+//SetSlowStart 设置新添加或恢复的主机的慢启动时长，算法不支持时返回错误
+func (rh *RoutePrefixHandler) SetSlowStart(d time.Duration) error {
+	bl := rh.bl
+	if cb, ok := bl.(*balancer.CircuitBreaker); ok {
+		bl = cb.Unwrap()
+	}
+	setter, ok := bl.(balancer.SlowStartSetter)
+	if !ok {
+		return fmt.Errorf("\"%s\" 算法不支持慢启动", rh.Algorithm)
+	}
+	setter.SetSlowStart(d)
+	return nil
+}
+
+//SetLoadFactor 设置有界负载一致性哈希的负载上限系数，算法不支持时返回错误
+func (rh *RoutePrefixHandler) SetLoadFactor(epsilon float64) error {
+	bl := rh.bl
+	if cb, ok := bl.(*balancer.CircuitBreaker); ok {
+		bl = cb.Unwrap()
+	}
+	setter, ok := bl.(balancer.LoadFactorSetter)
+	if !ok {
+		return fmt.Errorf("\"%s\" 算法不支持设置负载上限系数", rh.Algorithm)
+	}
+	return setter.SetLoadFactor(epsilon)
+}
+
+//balanceKey 获取负载均衡的key，IP哈希算法使用客户端IP，其他算法使用请求路径及参数
+func (rh *RoutePrefixHandler) balanceKey(r *http.Request) string {
+	if rh.UseClientIPKey {
+		return util.GetIP(r)
+	}
+	return fmt.Sprintf("%s?%s", r.URL.Path, r.URL.RawQuery)
+}
+
+//balance 为请求选择下游主机
+//负载均衡器中已没有主机但路由配置了主机时(例如全部被健康检查摘除或处于维护状态)，返回包装了 balancer.ErrAllHostsDown 的错误
+func (rh *RoutePrefixHandler) balance(r *http.Request) (string, error) {
+	ctx, span := tracer.Start(r.Context(), "balance", trace.WithAttributes(algorithmAttribute.String(rh.Algorithm)))
+	defer span.End()
+	//客户端断开或请求超时后不再选择主机
+	host, err := balancer.BalanceCtx(ctx, rh.bl, rh.balanceKey(r))
+	span.SetAttributes(hostAttribute.String(host))
+	if errors.Is(err, balancer.ErrNoHost) {
+		rh.mux.RLock()
+		configured := len(rh.reverseProxyMap)
+		rh.mux.RUnlock()
+		if configured > 0 {
+			return "", fmt.Errorf("%w: 已配置 %d 个主机", balancer.ErrAllHostsDown, configured)
+		}
+	}
+	return host, err
+}
+
+//reverseProxy 获取主机对应的反向代理
+func (rh *RoutePrefixHandler) reverseProxy(host string) *httputil.ReverseProxy {
+	rh.mux.RLock()
+	defer rh.mux.RUnlock()
+	return rh.reverseProxyMap[host]
+}
+
+//unixScheme Unix域套接字下游主机的协议，例如unix:///var/run/app.sock
+const unixScheme = "unix"
+
+//parseHost 解析带协议的下游主机地址，Unix域套接字主机需要包含套接字的绝对路径
+func parseHost(rawURL string) (*url.URL, error) {
+	dest, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrInvalidHost
+	}
+	if dest.Scheme == unixScheme {
+		if dest.Host != "" || !strings.HasPrefix(dest.Path, "/") {
+			return nil, ErrInvalidHost
+		}
+		return dest, nil
+	}
+	if dest.Scheme == "" || dest.Host == "" {
+		return nil, ErrInvalidHost
+	}
+	return dest, nil
+}
+
+//hostName 获取主机在负载均衡器中的名称，TCP主机为ip:port，Unix域套接字主机为unix:套接字路径
+func hostName(dest *url.URL) string {
+	if dest.Scheme == unixScheme {
+		return util.UnixSocketPrefix + dest.Path
+	}
+	return cleanHost(dest.Host)
 }
 
 func cleanHost(in string) string {
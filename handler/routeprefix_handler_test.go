@@ -0,0 +1,17 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+//newTestRoute 创建/api前缀转发到下游主机/的轮询路由，测试结束时停止路由的健康检查
+func newTestRoute(t *testing.T, hosts ...string) *RoutePrefixHandler {
+	t.Helper()
+	rh, err := NewRoutePrefixHandler("round-robin", "/api", "/", hosts, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(rh.Stop)
+	return rh
+}
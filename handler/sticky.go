@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+)
+
+//DefaultStickyCookie 会话保持默认的Cookie名称
+const DefaultStickyCookie = "PROXY_AFFINITY"
+
+//StickySession 基于Cookie的会话保持，Cookie中保存主机地址的HMAC，客户端无法得知或伪造主机地址
+type StickySession struct {
+	//CookieName Cookie名称，为空时为PROXY_AFFINITY
+	CookieName string
+	//Secret 计算HMAC的密钥
+	Secret []byte
+	//MaxAge Cookie的有效期，为0时为会话Cookie
+	MaxAge time.Duration
+}
+
+//cookieName 获取Cookie名称
+func (s *StickySession) cookieName() string {
+	if s.CookieName == "" {
+		return DefaultStickyCookie
+	}
+	return s.CookieName
+}
+
+//token 计算主机对应的Cookie值
+func (s *StickySession) token(host string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(host))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//setCookie 设置记录主机的Cookie
+func (s *StickySession) setCookie(w http.ResponseWriter, r *http.Request, host string) {
+	cookie := &http.Cookie{
+		Name:     s.cookieName(),
+		Value:    s.token(host),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if s.MaxAge > 0 {
+		cookie.MaxAge = int(s.MaxAge / time.Second)
+	}
+	http.SetCookie(w, cookie)
+}
+
+//stickyHost 获取请求Cookie记录的主机，主机已删除、不参与负载均衡或Cookie无效时返回空字符串，由负载均衡器重新选择
+func (rh *RoutePrefixHandler) stickyHost(r *http.Request) string {
+	cookie, err := r.Cookie(rh.StickySession.cookieName())
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	for _, stat := range rh.bl.Stats() {
+		if !stat.Alive {
+			continue
+		}
+		if hmac.Equal([]byte(cookie.Value), []byte(rh.StickySession.token(stat.Name))) {
+			return stat.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//DefaultTimeoutHeader 客户端指定请求超时时间(毫秒)的默认请求头
+const DefaultTimeoutHeader = "X-Timeout-Ms"
+
+//requestTimeout 获取本次请求的超时时间
+//客户端可以通过TimeoutHeader指定超时时间，但会被限制在RequestTimeout和MaxRequestTimeout之间
+func (rh *RoutePrefixHandler) requestTimeout(r *http.Request) time.Duration {
+	timeout := rh.RequestTimeout
+	if rh.MaxRequestTimeout <= 0 {
+		return timeout
+	}
+
+	header := rh.TimeoutHeader
+	if header == "" {
+		header = DefaultTimeoutHeader
+	}
+	ms, err := strconv.ParseInt(r.Header.Get(header), 10, 64)
+	if err != nil || ms <= 0 {
+		return timeout
+	}
+
+	requested := time.Duration(ms) * time.Millisecond
+	if requested < timeout {
+		return timeout
+	}
+	if requested > rh.MaxRequestTimeout {
+		return rh.MaxRequestTimeout
+	}
+	return requested
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	rh := &RoutePrefixHandler{RequestTimeout: time.Second}
+	timeout := func(header, value string) time.Duration {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if value != "" {
+			r.Header.Set(header, value)
+		}
+		return rh.requestTimeout(r)
+	}
+
+	//未配置MaxRequestTimeout时忽略客户端指定的超时时间
+	assert.Equal(t, time.Second, timeout(DefaultTimeoutHeader, "5000"))
+
+	rh.MaxRequestTimeout = 3 * time.Second
+	assert.Equal(t, time.Second, timeout(DefaultTimeoutHeader, ""))
+	assert.Equal(t, 2*time.Second, timeout(DefaultTimeoutHeader, "2000"))
+	//限制在RequestTimeout和MaxRequestTimeout之间
+	assert.Equal(t, time.Second, timeout(DefaultTimeoutHeader, "500"))
+	assert.Equal(t, 3*time.Second, timeout(DefaultTimeoutHeader, "60000"))
+	//无效的值使用RequestTimeout
+	assert.Equal(t, time.Second, timeout(DefaultTimeoutHeader, "abc"))
+	assert.Equal(t, time.Second, timeout(DefaultTimeoutHeader, "-2000"))
+
+	//自定义请求头
+	rh.TimeoutHeader = "X-Deadline"
+	assert.Equal(t, time.Second, timeout(DefaultTimeoutHeader, "2000"))
+	assert.Equal(t, 2*time.Second, timeout("X-Deadline", "2000"))
+}
+
+func TestRequestTimeout_ServeHTTP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(150 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+	rh := newTestRoute(t, backend.URL)
+	rh.RequestTimeout = 50 * time.Millisecond
+	rh.MaxRequestTimeout = 500 * time.Millisecond
+
+	get := func(value string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/a", nil)
+		if value != "" {
+			r.Header.Set(DefaultTimeoutHeader, value)
+		}
+		rec := httptest.NewRecorder()
+		rh.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusGatewayTimeout, get(""))
+	//客户端延长超时时间后请求成功
+	assert.Equal(t, http.StatusOK, get("300"))
+}
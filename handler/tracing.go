@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httputil"
+)
+
+var (
+	//hostAttribute 负载均衡器选择的下游主机
+	hostAttribute = attribute.Key("proxy.upstream.host")
+	//algorithmAttribute 负载均衡算法
+	algorithmAttribute = attribute.Key("proxy.balancer.algorithm")
+)
+
+//tracer 未开启链路追踪时为空实现
+var tracer = otel.Tracer("proxy/handler")
+
+//startServerSpan 从请求头中提取上游的traceparent，开始一个服务端span，覆盖负载均衡及转发的整个过程
+func (rh *RoutePrefixHandler) startServerSpan(r *http.Request) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, rh.UpstreamPath,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(r.Method),
+			semconv.HTTPTargetKey.String(r.URL.RequestURI()),
+			semconv.HTTPRouteKey.String(rh.UpstreamPath),
+		))
+	return r.WithContext(ctx), span
+}
+
+//endServerSpan 记录响应状态码并结束服务端span
+func endServerSpan(span trace.Span, host string, status int) {
+	if host != "" {
+		span.SetAttributes(hostAttribute.String(host))
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+//forward 通过反向代理转发请求，每次转发(包括重试及对冲)对应一个客户端span，Director将其注入到转发给下游主机的请求头中
+func (rh *RoutePrefixHandler) forward(w http.ResponseWriter, r *http.Request, host string, proxy *httputil.ReverseProxy) {
+	ctx, span := tracer.Start(r.Context(), "upstream "+host,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(hostAttribute.String(host)))
+	defer span.End()
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+//recordSpanError 在请求对应的span上记录错误
+func recordSpanError(r *http.Request, err error) {
+	span := trace.SpanFromContext(r.Context())
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
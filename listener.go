@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"proxy/config"
+	"proxy/util/logging"
+	"time"
+)
+
+//serveListeners 在所有监听地址上启动服务，共用同一个处理程序，https监听地址使用tlsOpts配置TLS
+//启动前加载所有证书，证书不正确时不启动任何服务
+//maxHeaderBytes为请求头的最大字节数，超过时由net/http返回431，为0时使用net/http的默认值(1MB)
+//ctx结束或任意一个服务异常退出时关闭所有服务，返回第一个异常退出的错误
+func serveListeners(ctx context.Context, listeners []config.Listener, tlsOpts config.TLS, h http.Handler, maxHeaderBytes int, gracePeriod time.Duration) error {
+	servers := make([]*http.Server, 0, len(listeners))
+	httpsPort := httpsListenerPort(listeners)
+	for _, l := range listeners {
+		svr := &http.Server{Addr: l.Address, Handler: h, MaxHeaderBytes: maxHeaderBytes}
+		if l.Schema == "https" {
+			tlsConfig, err := tlsServerConfig(tlsOpts, l.CertCrt, l.CertKey)
+			if err != nil {
+				return fmt.Errorf("监听地址 %s 的TLS配置不正确: %s", l.Address, err)
+			}
+			svr.TLSConfig = tlsConfig
+		} else if l.RedirectHTTPS {
+			svr.Handler = httpsRedirect(httpsPort)
+		}
+		servers = append(servers, svr)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errCh := make(chan error, len(servers))
+	for i := range servers {
+		svr := servers[i]
+		listen := svr.ListenAndServe
+		if svr.TLSConfig != nil {
+			//证书已在TLSConfig中加载
+			listen = func() error {
+				return svr.ListenAndServeTLS("", "")
+			}
+		}
+		logging.Infof("[%s] proxy 启动成功(%s)，正在监听中....", svr.Addr, listeners[i].Schema)
+		go func() {
+			err := runServer(ctx, svr, gracePeriod, listen)
+			if err != nil {
+				logging.Errorf("[%s] 服务异常退出: %s", svr.Addr, err)
+			}
+			cancel()
+			errCh <- err
+		}()
+	}
+
+	var firstErr error
+	for range servers {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+//httpsListenerPort 获取第一个https监听地址的端口，用于http重定向到https
+func httpsListenerPort(listeners []config.Listener) string {
+	for _, l := range listeners {
+		if l.Schema != "https" {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(l.Address); err == nil && port != "" {
+			return port
+		}
+	}
+	return "443"
+}
+
+//httpsRedirect 将请求永久重定向到https监听地址，保留请求的Host、路径及查询参数
+//使用308，客户端重定向后不会改变请求方法及请求内容
+func httpsRedirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+//tlsServerConfig 根据TLS配置创建https监听地址的tls.Config，同时加载证书及客户端CA证书
+func tlsServerConfig(opts config.TLS, certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载证书 %s 及私钥 %s 失败: %s", certFile, keyFile, err)
+	}
+	minVersion, err := opts.MinTLSVersion()
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := opts.CipherSuiteIDs()
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := opts.ClientAuthType()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		ClientAuth:   clientAuth,
+	}
+	if opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端CA证书 %s 失败: %s", opts.ClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("客户端CA证书 %s 中没有有效的PEM证书", opts.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, nil
+}
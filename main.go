@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/urfave/cli"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"proxy/balancer"
 	"proxy/config"
 	"proxy/handler"
 	"proxy/middleware"
+	"proxy/util"
 	"proxy/util/logging"
-	"strconv"
+	"proxy/util/tracing"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var (
@@ -22,7 +34,7 @@ func init() {
 	cliApp = cli.NewApp()
 	cliApp.Name = "proxy-server"
 	cliApp.Version = "1.0.0"
-	cliApp.Usage = "负载均衡算法：['ip-hash','consistent-hash','p2c','random','round-robin','least-load','bounded']"
+	cliApp.Usage = "负载均衡算法：['ip-hash','consistent-hash','p2c','random','round-robin','least-load','bounded','weighted-round-robin','ewma','least-conn','maglev']"
 	cliApp.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:        "serverConfigFile",
@@ -46,23 +58,121 @@ func main() {
 		if err != nil {
 			return err
 		}
+		output, err := logging.Output(cfg.Log.Output)
+		if err != nil {
+			return err
+		}
+		if err := logging.Configure(cfg.Log.Level, output, cfg.Log.Format); err != nil {
+			return err
+		}
+		if cfg.TraceRequests && !strings.EqualFold(cfg.Log.Level, "DEBUG") {
+			logging.Warn("已开启trace_requests, 但日志级别不是DEBUG, 不会记录请求跟踪")
+		}
+
+		//开启链路追踪时设置全局的TracerProvider，服务关闭时导出剩余的span
+		if cfg.Tracing.Enabled {
+			shutdown, err := tracing.Setup(context.Background(), tracing.Options{
+				Endpoint:    cfg.Tracing.Endpoint,
+				ServiceName: cfg.Tracing.ServiceName,
+				Insecure:    cfg.Tracing.Insecure,
+			})
+			if err != nil {
+				return err
+			}
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := shutdown(ctx); err != nil {
+					logging.Errorf("导出链路追踪数据失败: %s", err)
+				}
+			}()
+			logging.Infof("链路追踪已开启，导出器地址: %s", cfg.Tracing.Endpoint)
+		}
 
-		muxHandler, err := NewMuxHandler(cfg.MaxAllowed, cfg.HealthCheck, cfg.HealthCheckInterval, cfg.Routes)
+		muxHandler, err := newReloadableHandler(cfg, files...)
 		if err != nil {
 			return err
 		}
+		//服务关闭后停止所有健康检查
+		defer muxHandler.Stop()
+		routes := muxHandler.Routes()
+		if cfg.PrecheckBackends {
+			if err := precheckBackends(routes, cfg.PrecheckFailFast); err != nil {
+				return err
+			}
+		}
+
+		//收到SIGINT或SIGTERM时优雅关闭服务
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		gracePeriod := time.Duration(cfg.ShutdownTimeout) * time.Second
+
+		//配置文件修改后自动重新加载路由，不需要重启服务
+		go func() {
+			if err := muxHandler.Watch(ctx); err != nil {
+				logging.Errorf("配置文件热加载异常退出: %s", err)
+			}
+		}()
 
-		svr := http.Server{
-			Addr:    ":" + strconv.Itoa(cfg.Port),
-			Handler: muxHandler,
+		//配置了管理端口时，在独立的端口上提供管理接口
+		if cfg.AdminPort > 0 {
+			adminHandler := handler.NewAdminHandler(routes)
+			adminHandler.Config = func() interface{} {
+				return muxHandler.Config().Redacted()
+			}
+			if cfg.Pprof.Enabled {
+				filter, err := pprofFilter(cfg.Pprof)
+				if err != nil {
+					return err
+				}
+				adminHandler.EnablePprof(filter)
+				logging.Infof("管理接口已开启pprof性能分析接口: /debug/pprof/")
+			}
+			muxHandler.OnReload(adminHandler.SetRoutes)
+			adminSvr := http.Server{
+				Addr:    cfg.AdminListenAddress(),
+				Handler: adminHandler,
+			}
+			if !isLoopback(cfg.AdminListenAddress()) {
+				logging.Warnf("管理接口监听在 %s，没有认证，能访问该地址的客户端都可以修改路由的主机", adminSvr.Addr)
+			}
+			go func() {
+				logging.Infof("[%s] 管理接口启动成功，正在监听中....", adminSvr.Addr)
+				if err := runServer(ctx, &adminSvr, gracePeriod, adminSvr.ListenAndServe); err != nil {
+					logging.Errorf("管理接口异常退出: %s", err)
+				}
+			}()
 		}
-		logging.Infof("[%s] proxy 启动成功，正在监听中....", svr.Addr)
 
-		if cfg.Schema == "http" {
-			return svr.ListenAndServe()
-		} else {
-			return svr.ListenAndServeTLS(cfg.CertCrt, cfg.CertKey)
+		//配置了监控指标的独立监听地址时，在该地址上提供监控指标，与代理流量分开
+		if cfg.Metrics.Enabled && cfg.Metrics.Address != "" {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle(metricsPath(cfg), middleware.MetricsHandler())
+			metricsSvr := http.Server{
+				Addr:    cfg.Metrics.Address,
+				Handler: metricsMux,
+			}
+			go func() {
+				logging.Infof("[%s] 监控指标接口启动成功，正在监听中....", metricsSvr.Addr)
+				if err := runServer(ctx, &metricsSvr, gracePeriod, metricsSvr.ListenAndServe); err != nil {
+					logging.Errorf("监控指标接口异常退出: %s", err)
+				}
+			}()
 		}
+
+		//配置了管理命令socket时，提供基于本地UNIX socket的文本管理命令接口
+		if cfg.AdminSocket != "" {
+			commandServer := handler.NewCommandServer(routes)
+			commandServer.Reload = muxHandler.Reload
+			muxHandler.OnReload(commandServer.SetRoutes)
+			go func() {
+				if err := commandServer.ListenAndServe(cfg.AdminSocket); err != nil {
+					logging.Errorf("管理命令接口异常退出: %s", err)
+				}
+			}()
+		}
+
+		return serveListeners(ctx, cfg.ServerListeners(), cfg.TLS, serverHandler(cfg, muxHandler), cfg.MaxHeaderBytes, gracePeriod)
 	}
 
 	//运行CLI应用程序
@@ -71,34 +181,510 @@ func main() {
 	}
 }
 
+//precheckBackends 启动时探测一次所有路由的下游主机，无法连接的主机输出警告，failFast为true时返回错误终止启动
+func precheckBackends(routes []*handler.RoutePrefixHandler, failFast bool) error {
+	var unreachable []string
+	for _, rh := range routes {
+		for _, host := range rh.Precheck() {
+			logging.Warnf("启动检查: 路由 %s 的下游主机 %s 无法连接", rh.UpstreamPath, host)
+			unreachable = append(unreachable, host)
+		}
+	}
+	if len(unreachable) > 0 && failFast {
+		return fmt.Errorf("启动检查: %d个下游主机无法连接: %s", len(unreachable), strings.Join(unreachable, ", "))
+	}
+	return nil
+}
+
+//serverHandler 获取监听地址使用的处理程序，有gRPC路由时http监听地址同时接受h2c(明文HTTP/2)请求
+//只在启动时根据配置判断，热加载新增的gRPC路由需要重启服务后才能通过h2c访问
+func serverHandler(cfg *config.Config, h http.Handler) http.Handler {
+	if !cfg.HasGRPC() {
+		return h
+	}
+	return h2c.NewHandler(h, &http2.Server{})
+}
+
+//pprofLoopback 未配置pprof的allow时只允许本机访问
+var pprofLoopback = []string{"127.0.0.1", "::1"}
+
+//pprofFilter 根据配置创建pprof接口的IP过滤中间件
+func pprofFilter(cfg config.Pprof) (func(next http.Handler) http.Handler, error) {
+	allow := cfg.Allow
+	if len(allow) == 0 {
+		allow = pprofLoopback
+	}
+	filter, err := middleware.IPFilterMiddleware(allow, cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("pprof的IP过滤配置不正确: %s", err)
+	}
+	return filter, nil
+}
+
+//isLoopback 判断监听地址是否只监听本机回环地址
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+//runServer 通过listen启动服务，ctx结束时优雅关闭：不再接收新的连接，并等待处理中的请求完成，最长等待gracePeriod
+func runServer(ctx context.Context, svr *http.Server, gracePeriod time.Duration, listen func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- listen()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	logging.Infof("[%s] 正在关闭服务，等待处理中的请求完成(最长 %s)....", svr.Addr, gracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := svr.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	logging.Infof("[%s] 服务已关闭", svr.Addr)
+	return nil
+}
+
+//metricsPath 监控指标的访问路径，未配置时为/metrics
+func metricsPath(cfg *config.Config) string {
+	if cfg.Metrics.Path == "" {
+		return "/metrics"
+	}
+	return cfg.Metrics.Path
+}
+
+//routerHandler 路由器，同时持有路由表停止时需要关闭的中间件资源，例如采样记录的输出文件
+type routerHandler struct {
+	*mux.Router
+	closers []io.Closer
+}
+
+//Close 关闭中间件持有的资源，重新加载配置后旧的路由表停止时调用
+func (h *routerHandler) Close() {
+	closeAll(h.closers)
+}
+
 // NewMuxHandler 创建路由处理器 ref: https://github.com/gorilla/mux
-func NewMuxHandler(maxAllowed uint,healthCheck bool,healthCheckInterval uint, routing []config.Routing) (*mux.Router,error) {
+func NewMuxHandler(cfg *config.Config) (_ *routerHandler, _ []*handler.RoutePrefixHandler, err error) {
 	muxRouter := mux.NewRouter()
-	muxRouter.Use(middleware.PanicsHandling)
-	if maxAllowed > 0 {
-		muxRouter.Use(middleware.MaxAllowedMiddleware(maxAllowed))
+	routes := make([]*handler.RoutePrefixHandler, 0, len(cfg.Routes))
+	var closers []io.Closer
+	//创建失败时停止已创建路由的健康检查并关闭中间件资源，避免协程及文件泄漏
+	defer func() {
+		if err != nil {
+			for _, rh := range routes {
+				rh.Stop()
+			}
+			closeAll(closers)
+		}
+	}()
+	if len(cfg.Routes) == 0 {
+		//未配置任何路由时，所有请求都会返回404，这里给出明确的警告
+		logging.Warn("未配置任何路由，所有请求都将返回404，请检查路由配置文件中的ReRoutes")
+	}
+	//可信代理在路由表替换时才生效，这里只检查配置是否正确
+	if _, err := util.ParseCIDRs(cfg.TrustedProxies); err != nil {
+		return nil, nil, fmt.Errorf("trusted_proxies配置不正确: %s", err)
 	}
+	if closers, err = useMiddlewares(muxRouter, cfg); err != nil {
+		return nil, nil, err
+	}
+	//未配置独立的监听地址时，在代理端口上提供监控指标
+	if cfg.Metrics.Enabled && cfg.Metrics.Address == "" {
+		muxRouter.Handle(metricsPath(cfg), middleware.MetricsHandler()).Methods(http.MethodGet)
+	}
+	//所有路由共用同一个缓存，只缓存路由的响应，不缓存监控指标及就绪检查
+	var cache *middleware.ResponseCache
+	if cfg.Cache.Enabled {
+		cache = middleware.NewResponseCache(middleware.CacheOptions{
+			MaxEntries:  cfg.Cache.MaxEntries,
+			DefaultTTL:  time.Duration(cfg.Cache.DefaultTTL) * time.Second,
+			MaxBodySize: cfg.Cache.MaxBodySize,
+		})
+	}
+	//存活及就绪检查注册在路由之前，不会被前缀为/的路由覆盖
+	muxRouter.HandleFunc("/livez", handler.LivenessHandler).Methods(http.MethodGet, http.MethodHead)
+	muxRouter.Handle("/readyz", handler.ReadinessHandler(func() []*handler.RoutePrefixHandler {
+		return routes
+	})).Methods(http.MethodGet, http.MethodHead)
 
-	for _, r := range routing {
+	for _, r := range cfg.Routes {
 		if err := r.ValidationAlgorithm(); err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if err := r.ValidationWeights(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationBackendGroups(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationTimeout(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationConcurrency(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationHealthCheck(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationErrorBody(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationTransport(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationFallback(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationHeaders(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationErrorPages(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationStickySession(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationMirror(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationRetry(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationGRPC(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationAllowedMethods(); err != nil {
+			return nil, nil, err
+		}
+		if err := r.ValidationCacheMethods(); err != nil {
+			return nil, nil, err
+		}
+		rewriteRegex, err := r.CompileRewrite()
+		if err != nil {
+			return nil, nil, err
+		}
+		var headerMatcher config.HeaderMatcher
+		if r.HeaderMatch != nil {
+			m, err := r.HeaderMatch.Compile()
+			if err != nil {
+				return nil, nil, err
+			}
+			headerMatcher = m
+		}
+		matchRegex, err := r.CompileMatch()
+		if err != nil {
+			return nil, nil, err
 		}
 		upstreamPath := r.UpstreamPathParse()
 		downstreamPath := r.DownstreamPathParse()
-		prefixHandler, err := handler.NewRoutePrefixHandler(r.Algorithm, upstreamPath, downstreamPath, r.DownstreamHosts)
+		downstreamHosts, downstreamWeights := r.AllDownstreamHosts()
+		prefixHandler, err := handler.NewRoutePrefixHandler(r.Algorithm, upstreamPath, downstreamPath, downstreamHosts, downstreamWeights)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(r.BackendGroups) > 0 {
+			groups := make([]balancer.SplitGroup, 0, len(r.BackendGroups))
+			for _, g := range r.BackendGroups {
+				groups = append(groups, balancer.SplitGroup{Name: g.Name, Weight: g.Weight, Hosts: g.DownstreamHosts, Weights: g.DownstreamWeights})
+			}
+			if err := prefixHandler.SetBackendGroups(groups); err != nil {
+				return nil, nil, fmt.Errorf("路由 \"%s\" 的BackendGroups配置不正确: %s", r.UpstreamPathTemplate, err)
+			}
+		}
+
+		routes = append(routes, prefixHandler)
+		if r.HasTransportOptions() {
+			prefixHandler.ConfigureTransport(handler.TransportOptions{
+				MaxIdleConns:          r.MaxIdleConns,
+				MaxIdleConnsPerHost:   r.MaxIdleConnsPerHost,
+				IdleConnTimeout:       time.Duration(r.IdleConnTimeout) * time.Millisecond,
+				DialTimeout:           time.Duration(r.DialTimeout) * time.Millisecond,
+				ResponseHeaderTimeout: time.Duration(r.ResponseHeaderTimeout) * time.Millisecond,
+				HTTP2:                 r.HTTP2 || r.GRPC,
+				H2C:                   r.H2C || r.GRPC,
+			})
+		}
+		if r.GRPC {
+			//gRPC流式响应的每条消息都需要立即发送
+			prefixHandler.SetFlushInterval(-1)
+			prefixHandler.GRPC = true
+		} else if r.FlushInterval != 0 {
+			prefixHandler.SetFlushInterval(time.Duration(r.FlushInterval) * time.Millisecond)
+		}
+		if r.Replicas > 0 {
+			if err := prefixHandler.SetReplicas(r.Replicas); err != nil {
+				return nil, nil, err
+			}
+		}
+		if r.SlowStartDuration > 0 {
+			if err := prefixHandler.SetSlowStart(time.Duration(r.SlowStartDuration) * time.Millisecond); err != nil {
+				return nil, nil, fmt.Errorf("路由 \"%s\" 的SlowStartDuration配置不正确: %s", r.UpstreamPathTemplate, err)
+			}
+		}
+		if r.LoadFactor != 0 {
+			if err := prefixHandler.SetLoadFactor(r.LoadFactor); err != nil {
+				return nil, nil, fmt.Errorf("路由 \"%s\" 的LoadFactor配置不正确: %s", r.UpstreamPathTemplate, err)
+			}
+		}
+		if r.BreakerFailureThreshold > 0 {
+			window := time.Duration(r.BreakerWindow) * time.Millisecond
+			openTimeout := time.Duration(r.BreakerOpenTimeout) * time.Millisecond
+			prefixHandler.EnableCircuitBreaker(r.BreakerFailureThreshold, window, openTimeout)
+		}
+		if r.BalanceByClientIP {
+			prefixHandler.UseClientIPKey = true
+		}
+		prefixHandler.TraceRequests = cfg.TraceRequests
+		if m := r.Mirror; m != nil {
+			if err := prefixHandler.SetMirror(m.Host, m.Percent, time.Duration(m.Timeout)*time.Millisecond, m.MaxBodySize); err != nil {
+				return nil, nil, fmt.Errorf("路由 \"%s\" 的镜像主机 \"%s\" 不正确", r.UpstreamPathTemplate, m.Host)
+			}
+		}
+		if st := r.StickySession; st != nil {
+			prefixHandler.StickySession = &handler.StickySession{
+				CookieName: st.CookieName,
+				Secret:     []byte(st.Secret),
+				MaxAge:     time.Duration(st.MaxAge) * time.Second,
+			}
+		}
+		prefixHandler.MaxConnsPerHost = int64(cfg.MaxConnsPerHost)
+		if r.MaxConnsPerHost != 0 {
+			prefixHandler.MaxConnsPerHost = int64(r.MaxConnsPerHost)
+		}
+		prefixHandler.RequestTimeout = time.Duration(r.RequestTimeout) * time.Millisecond
+		prefixHandler.MaxRequestTimeout = time.Duration(r.MaxRequestTimeout) * time.Millisecond
+		prefixHandler.TimeoutHeader = r.TimeoutHeader
+		prefixHandler.DefaultUserAgent = r.DefaultUserAgent
+		prefixHandler.PreserveHostHeader = r.PreserveHostHeader
+		prefixHandler.HostHeaderOverride = r.HostHeaderOverride
+		prefixHandler.RewriteRegex = rewriteRegex
+		prefixHandler.RewriteReplacement = r.RewriteReplacement
+		//regex路由的UpstreamPathTemplate不是路径前缀，未配置RewriteRegex时原样转发请求路径
+		if matchRegex != nil && rewriteRegex == nil {
+			prefixHandler.RewriteRegex = matchRegex
+			prefixHandler.RewriteReplacement = "$0"
+		}
+		if f := r.FallbackResponse; f != nil {
+			prefixHandler.FallbackResponse = &handler.FallbackResponse{
+				Status:      f.Status,
+				Body:        f.Body,
+				ContentType: f.ContentType,
+				RedirectURL: f.RedirectURL,
+			}
+		}
+		for status, page := range r.ErrorPages {
+			html, json, err := page.LoadTemplates()
+			if err != nil {
+				return nil, nil, fmt.Errorf("路由 \"%s\" 的ErrorPages配置不正确: %s", r.UpstreamPathTemplate, err)
+			}
+			errorPage, err := handler.NewErrorPage(html, json)
+			if err != nil {
+				return nil, nil, fmt.Errorf("路由 \"%s\" 的ErrorPages状态码 %d 的模板不正确: %s", r.UpstreamPathTemplate, status, err)
+			}
+			if prefixHandler.ErrorPages == nil {
+				prefixHandler.ErrorPages = make(map[int]*handler.ErrorPage, len(r.ErrorPages))
+			}
+			prefixHandler.ErrorPages[status] = errorPage
+		}
+		if h := r.RequestHeaders; h != nil {
+			prefixHandler.RequestHeaders = &handler.HeaderRules{Set: h.Set, Add: h.Add, Remove: h.Remove}
+		}
+		if h := r.ResponseHeaders; h != nil {
+			prefixHandler.ResponseHeaders = &handler.HeaderRules{Set: h.Set, Add: h.Add, Remove: h.Remove}
+		}
+		rewriteRes, err := r.CompileResponseRewrites()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		for i, rw := range r.ResponseRewrites {
+			prefixHandler.ResponseRewrites = append(prefixHandler.ResponseRewrites, handler.ResponseRewrite{
+				Regex:        rewriteRes[i],
+				Replacement:  rw.Replacement,
+				Headers:      rw.Headers,
+				ContentTypes: rw.ContentTypes,
+			})
 		}
+		prefixHandler.ResponseRewriteMaxBody = r.ResponseRewriteMaxBody
+		prefixHandler.PassThroughErrors = r.PassThroughErrors
+		prefixHandler.RewriteErrorBody = r.RewriteErrorBody
+		prefixHandler.RewriteErrorStatuses = r.RewriteErrorStatuses
+		prefixHandler.HedgeDelay = time.Duration(r.HedgeDelay) * time.Millisecond
+		prefixHandler.HedgeMaxAttempts = r.HedgeMaxAttempts
+		prefixHandler.HedgeBudgetPercent = r.HedgeBudgetPercent
+		prefixHandler.MaxRetries = r.MaxRetries
+		prefixHandler.RetryOnStatuses = r.RetryOnStatuses
+		prefixHandler.RetryOnGRPCStatuses = r.RetryOnGRPCStatuses
+		if r.GRPC && r.RetryOnGRPCStatuses == nil {
+			prefixHandler.RetryOnGRPCStatuses = handler.DefaultRetryOnGRPCStatuses
+		}
+		prefixHandler.RetryBudget = time.Duration(r.RetryBudget) * time.Millisecond
+		prefixHandler.RetryBackoff = handler.RetryBackoff{
+			Base:   time.Duration(r.RetryBaseDelay) * time.Millisecond,
+			Max:    time.Duration(r.RetryMaxDelay) * time.Millisecond,
+			Jitter: r.RetryJitter,
+		}
+		prefixHandler.BufferRequestBody = r.BufferRequestBody
+		prefixHandler.PassiveMaxFails = r.PassiveMaxFails
+		prefixHandler.PassiveEjectDuration = time.Duration(r.PassiveEjectDuration) * time.Millisecond
 
 		//每个UpstreamPathTemplate对应多个下游主机，这里判断是否做主机的健康检查
-		if healthCheck {
-			prefixHandler.HealthCheck(healthCheckInterval)
+		if cfg.HealthCheck {
+			prefixHandler.DrainTimeout = time.Duration(cfg.DrainTimeout) * time.Second
+			prefixHandler.Warmup = cfg.HealthCheckWarmup
+			prefixHandler.HealthCheckType = r.HealthCheckType
+			prefixHandler.HealthCheckPath = r.HealthCheckPath
+			prefixHandler.HealthCheckExpectStatus = r.HealthCheckExpectStatus
+			prefixHandler.HealthCheckTimeout = time.Duration(r.HealthCheckTimeout) * time.Millisecond
+			prefixHandler.HealthCheck(cfg.HealthCheckInterval)
 		}
 
 		//例如上游请求模板配置的是：/apig/config 当请求这个前缀时会匹配对应的RoutePrefixHandler去处理
-		muxRouter.PathPrefix(upstreamPath).Handler(prefixHandler).Methods(r.UpstreamHTTPMethod...)
+		var routeHandler http.Handler = prefixHandler
+		//缓存在认证之后，命中缓存的请求也需要通过认证
+		if cache != nil {
+			routeHandler = cache.Middleware(r.CacheMethods)(routeHandler)
+			for _, m := range r.CacheMethods {
+				if m == http.MethodPost {
+					logging.Warnf("路由 %s 开启了POST请求的响应缓存，只应用于只读的查询接口，下游主机需要通过Cache-Control指定max-age", r.UpstreamPathTemplate)
+				}
+			}
+		} else if len(r.CacheMethods) > 0 {
+			logging.Warnf("路由 %s 配置了CacheMethods，但未开启响应缓存cache.enabled，不会生效", r.UpstreamPathTemplate)
+		}
+		//路由未配置时使用全局的请求内容大小限制，在读取或缓存请求内容之前生效
+		maxBodySize := cfg.MaxBodySize
+		if r.MaxBodySize != 0 {
+			maxBodySize = r.MaxBodySize
+		}
+		routeHandler = middleware.MaxBodySizeMiddleware(maxBodySize)(routeHandler)
+		if cfg.JWT.Key != "" {
+			requiredClaims := make(map[string]string, len(cfg.JWT.RequiredClaims)+len(r.RequiredClaims))
+			for name, value := range cfg.JWT.RequiredClaims {
+				requiredClaims[name] = value
+			}
+			for name, value := range r.RequiredClaims {
+				requiredClaims[name] = value
+			}
+			jwtAuth, err := middleware.JWTAuthMiddleware(middleware.JWTOptions{
+				Algorithm:      cfg.JWT.Algorithm,
+				Key:            cfg.JWT.Key,
+				RequiredClaims: requiredClaims,
+				RequiredScopes: r.RequiredScopes,
+				PublicPaths:    cfg.JWT.PublicPaths,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			routeHandler = jwtAuth(routeHandler)
+		}
+		if r.BasicAuth != nil {
+			basicAuth, err := middleware.BasicAuthMiddleware(middleware.BasicAuthOptions{
+				Realm: r.BasicAuth.Realm,
+				Users: r.BasicAuth.Users,
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("路由 \"%s\" 的BasicAuth配置不正确: %s", r.UpstreamPathTemplate, err)
+			}
+			routeHandler = basicAuth(routeHandler)
+		}
+		if r.APIKey != nil {
+			keys, err := r.APIKey.LoadKeys()
+			if err != nil {
+				return nil, nil, fmt.Errorf("路由 \"%s\" 的APIKey配置不正确: %s", r.UpstreamPathTemplate, err)
+			}
+			apiKeyAuth, err := middleware.APIKeyMiddleware(middleware.APIKeyOptions{
+				Header:     r.APIKey.Header,
+				QueryParam: r.APIKey.QueryParam,
+				Keys:       keys,
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("路由 \"%s\" 的APIKey配置不正确: %s", r.UpstreamPathTemplate, err)
+			}
+			routeHandler = apiKeyAuth(routeHandler)
+		}
+		if r.ClientCert != nil {
+			routeHandler = middleware.ClientCertMiddleware(middleware.ClientCertOptions{
+				AllowedSubjects: r.ClientCert.AllowedSubjects,
+			})(routeHandler)
+		}
+		if r.MaxConcurrent > 0 {
+			queueTimeout := time.Duration(r.QueueTimeout) * time.Millisecond
+			routeHandler = middleware.FairQueueMiddleware(r.MaxConcurrent, r.MaxClientShare, queueTimeout)(routeHandler)
+		}
+		//路由的限流器只作用于该路由，在排队及认证之前，被IP过滤拒绝的请求不消耗令牌
+		if r.RateLimitRPS > 0 {
+			routeHandler = middleware.RateLimitMiddleware(int(r.RateLimitRPS), int(r.RateLimitBurst))(routeHandler)
+		}
+		//IP过滤在认证及排队之前，被拒绝的请求不占用并发名额
+		if r.IPFilter != nil {
+			ipFilter, err := middleware.IPFilterMiddleware(r.IPFilter.Allow, r.IPFilter.Deny)
+			if err != nil {
+				return nil, nil, fmt.Errorf("路由 \"%s\" 的IPFilter配置不正确: %s", r.UpstreamPathTemplate, err)
+			}
+			routeHandler = ipFilter(routeHandler)
+		}
+		//请求方法的限制在IP过滤之外，不允许的方法直接返回405，CORS的预检请求由外层的CORS处理
+		routeHandler = middleware.AllowedMethodsMiddleware(r.AllowedMethods)(routeHandler)
+		methods := r.UpstreamHTTPMethod
+		//CORS在最外层，预检请求及认证失败等错误响应也需要携带Access-Control-*响应头；预检请求使用OPTIONS方法，需要允许该方法匹配路由
+		if r.CORS != nil {
+			routeHandler = middleware.CORSMiddleware(middleware.CORSOptions{
+				AllowedOrigins: r.CORS.AllowedOrigins,
+				AllowedMethods: r.CORS.AllowedMethods,
+				AllowedHeaders: r.CORS.AllowedHeaders,
+				MaxAge:         r.CORS.MaxAge,
+			})(routeHandler)
+			if len(methods) > 0 {
+				methods = append(append([]string{}, methods...), http.MethodOptions)
+			}
+		}
+		route := muxRouter.NewRoute()
+		switch {
+		case matchRegex != nil:
+			route.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+				return matchRegex.MatchString(req.URL.Path)
+			})
+		case r.PathMatchType() == config.MatchExact:
+			exactPath := upstreamPath
+			route.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+				return req.URL.Path == exactPath
+			})
+		default:
+			route.PathPrefix(upstreamPath)
+		}
+		route.Handler(routeHandler).Methods(methods...)
+
+		//配置了请求头匹配条件时，只有满足条件的请求才会进入该路由
+		if headerMatcher != nil {
+			route.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+				return headerMatcher(req)
+			})
+		}
+		//配置了SNI主机名时，只有TLS握手的SNI匹配的请求才会进入该路由
+		if len(r.SNIHosts) > 0 {
+			sniRouting := r
+			route.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+				return sniRouting.MatchSNI(req)
+			})
+		}
 
-		logging.Infof("Url Path: %s  HTTPMethod:%s 注册成功", upstreamPath, r.UpstreamHTTPMethod)
+		logging.Debugf("Url Path: %s  MatchType:%s  HTTPMethod:%s 注册成功", upstreamPath, r.PathMatchType(), r.UpstreamHTTPMethod)
 	}
-	return muxRouter, nil
+	return &routerHandler{Router: muxRouter, closers: closers}, routes, nil
 }
\ No newline at end of file
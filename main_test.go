@@ -0,0 +1,2915 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"proxy/config"
+	"proxy/handler"
+	"proxy/util"
+	"proxy/util/logging"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//newTestMux 根据配置创建路由处理器，测试结束时停止路由的健康检查并关闭中间件持有的资源
+func newTestMux(t *testing.T, cfg *config.Config) (*routerHandler, []*handler.RoutePrefixHandler) {
+	t.Helper()
+	muxHandler, routes, err := NewMuxHandler(cfg)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		stopRoutes(routes)
+		muxHandler.Close()
+	})
+	return muxHandler, routes
+}
+
+//testRoute 创建转发GET请求的路由，upstream+"/{url}"转发到下游主机的"/{url}"
+func testRoute(upstream string, hosts ...string) config.Routing {
+	return config.Routing{
+		UpstreamHTTPMethod:     []string{http.MethodGet},
+		UpstreamPathTemplate:   upstream + "/{url}",
+		Algorithm:              "round-robin",
+		DownstreamPathTemplate: "/{url}",
+		DownstreamHosts:        hosts,
+	}
+}
+
+func TestRunServer_GracefulShutdown(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		_, _ = w.Write([]byte("slow"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Routes: []config.Routing{testRoute("/api", backend.URL)},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	svr := &http.Server{Handler: muxHandler}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(ctx, svr, 5*time.Second, func() error {
+			return svr.Serve(ln)
+		})
+	}()
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/api/slow")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		results <- result{status: resp.StatusCode, body: string(body)}
+	}()
+
+	//等待慢请求到达下游主机后触发关闭
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	res := <-results
+	assert.NoError(t, res.err)
+	assert.Equal(t, http.StatusOK, res.status)
+	assert.Equal(t, "slow", res.body)
+	assert.NoError(t, <-done)
+
+	//关闭后不再接收新的连接
+	_, err = http.Get("http://" + ln.Addr().String() + "/api/slow")
+	assert.Error(t, err)
+}
+
+func TestWebSocketProxy(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, message); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		MaxAllowed: 10,
+		Routes:     []config.Routing{testRoute("/ws", backend.URL)},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+	proxy := httptest.NewServer(muxHandler)
+	defer proxy.Close()
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http")+"/ws/echo", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	for _, message := range []string{"hello", "world"} {
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(message)))
+		messageType, reply, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, websocket.TextMessage, messageType)
+		assert.Equal(t, message, string(reply))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Metrics: config.Metrics{Enabled: true},
+		Routes:  []config.Routing{testRoute("/metrics-api", backend.URL)},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics-api/hello", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	host := strings.TrimPrefix(backend.URL, "http://")
+	assert.Contains(t, body, `proxy_requests_total{method="GET",route="/metrics-api"} 3`)
+	assert.Contains(t, body, `proxy_responses_total{code="200",route="/metrics-api"} 3`)
+	assert.Contains(t, body, `proxy_upstream_requests_total{host="`+host+`",route="/metrics-api"} 3`)
+	assert.Contains(t, body, `proxy_request_duration_seconds_count{route="/metrics-api"} 3`)
+	assert.Contains(t, body, `proxy_upstream_latency_seconds_count{host="`+host+`",route="/metrics-api"} 3`)
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+
+	traceparents := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Routes: []config.Routing{testRoute("/trace", backend.URL)},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/trace/hello", nil)
+	req.Header.Set("traceparent", parent)
+	rec := httptest.NewRecorder()
+	muxHandler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+
+	spans := recorder.Ended()
+	names := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		names[span.Name()] = span
+	}
+	host := strings.TrimPrefix(backend.URL, "http://")
+	server, ok := names["/trace"]
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	attrs := make(map[string]string)
+	for _, kv := range server.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	assert.Equal(t, host, attrs["proxy.upstream.host"])
+	assert.Equal(t, "418", attrs["http.status_code"])
+
+	balance, ok := names["balance"]
+	assert.True(t, ok)
+	assert.Equal(t, server.SpanContext().SpanID(), balance.Parent().SpanID())
+	upstream, ok := names["upstream "+host]
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, server.SpanContext().SpanID(), upstream.Parent().SpanID())
+	//转发给下游主机的traceparent为客户端span
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+upstream.SpanContext().SpanID().String()+"-01", <-traceparents)
+}
+
+func TestReloadableHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	serverFile := filepath.Join(dir, "config.yml")
+	routeFile := filepath.Join(dir, "routing.json")
+	writeRoutes := func(upstream string) {
+		routes := `{"ReRoutes": [{"UpstreamHttpMethod": ["GET"], "UpstreamPathTemplate": "` + upstream + `/{url}", "Algorithm": "round-robin",
+			"DownstreamPathTemplate": "/{url}", "DownstreamHosts": ["` + backend.URL + `"]}]}`
+		assert.NoError(t, ioutil.WriteFile(routeFile, []byte(routes), 0644))
+	}
+	assert.NoError(t, ioutil.WriteFile(serverFile, []byte("port: 8080\nhealth_check_interval: 3\n"), 0644))
+	writeRoutes("/old")
+
+	cfg, err := config.Read(true, serverFile, routeFile)
+	assert.NoError(t, err)
+	h, err := newReloadableHandler(cfg, serverFile, routeFile)
+	assert.NoError(t, err)
+	defer h.Stop()
+	var reloaded []*handler.RoutePrefixHandler
+	h.OnReload(func(routes []*handler.RoutePrefixHandler) {
+		reloaded = routes
+	})
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, get("/old/a"))
+
+	writeRoutes("/new")
+	assert.NoError(t, h.Reload())
+	assert.Equal(t, http.StatusNotFound, get("/old/a"))
+	assert.Equal(t, http.StatusOK, get("/new/a"))
+	if assert.Len(t, reloaded, 1) {
+		assert.Equal(t, "/new", reloaded[0].UpstreamPath)
+	}
+
+	//配置验证失败时继续使用当前配置
+	assert.NoError(t, ioutil.WriteFile(routeFile, []byte(`{"ReRoutes": [{"UpstreamHttpMethod": ["GET"], "UpstreamPathTemplate": "/bad/{url}", "Algorithm": "unknown",
+		"DownstreamPathTemplate": "/{url}", "DownstreamHosts": ["`+backend.URL+`"]}]}`), 0644))
+	assert.Error(t, h.Reload())
+	assert.Equal(t, http.StatusOK, get("/new/a"))
+	assert.Equal(t, http.StatusNotFound, get("/bad/a"))
+
+	//路径模板不正确时不能导致服务退出
+	writeRoutes("no-slash")
+	assert.Error(t, h.Reload())
+	assert.Equal(t, http.StatusOK, get("/new/a"))
+
+	//监听配置文件的变化并自动重新加载
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = h.Watch(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	writeRoutes("/watched")
+	deadline := time.Now().Add(5 * time.Second)
+	for get("/watched/a") != http.StatusOK && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, http.StatusOK, get("/watched/a"))
+	assert.Equal(t, http.StatusNotFound, get("/new/a"))
+}
+
+func TestReloadKeepsGlobalConfigOnFailure(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	serverFile := filepath.Join(dir, "config.yml")
+	routeFile := filepath.Join(dir, "routing.json")
+	writeRoutes := func(checkType string) {
+		routes := `{"ReRoutes": [{"UpstreamHttpMethod": ["GET"], "UpstreamPathTemplate": "/api/{url}", "Algorithm": "round-robin",
+			"DownstreamPathTemplate": "/{url}", "DownstreamHosts": ["` + backend.URL + `"], "HealthCheckType": "` + checkType + `"}]}`
+		assert.NoError(t, ioutil.WriteFile(routeFile, []byte(routes), 0644))
+	}
+	assert.NoError(t, ioutil.WriteFile(serverFile, []byte("port: 8080\nhealth_check_interval: 3\ntrusted_proxies: [\"10.0.0.0/8\"]\n"), 0644))
+	writeRoutes("http")
+
+	cfg, err := config.Read(true, serverFile, routeFile)
+	assert.NoError(t, err)
+	h, err := newReloadableHandler(cfg, serverFile, routeFile)
+	assert.NoError(t, err)
+	defer h.Stop()
+	defer func() {
+		_ = util.SetTrustedProxies(nil)
+	}()
+	assert.True(t, util.IsTrustedProxy("10.0.0.1"))
+
+	//路由创建失败时新的可信代理不生效
+	assert.NoError(t, ioutil.WriteFile(serverFile, []byte("port: 8080\nhealth_check_interval: 3\ntrusted_proxies: [\"192.168.0.0/16\"]\n"), 0644))
+	writeRoutes("unknown")
+	assert.Error(t, h.Reload())
+	assert.True(t, util.IsTrustedProxy("10.0.0.1"))
+	assert.False(t, util.IsTrustedProxy("192.168.0.1"))
+
+	writeRoutes("http")
+	assert.NoError(t, h.Reload())
+	assert.False(t, util.IsTrustedProxy("10.0.0.1"))
+	assert.True(t, util.IsTrustedProxy("192.168.0.1"))
+}
+
+func TestReloadKeepsHealthCheckMaxConcurrentOnFailure(t *testing.T) {
+	var inflight, maxInflight int64
+	healthz := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+		for {
+			peak := atomic.LoadInt64(&maxInflight)
+			if n <= peak || atomic.CompareAndSwapInt64(&maxInflight, peak, n) {
+				break
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+	})
+	hosts := make([]string, 0, 4)
+	for i := 0; i < 4; i++ {
+		backend := httptest.NewServer(healthz)
+		defer backend.Close()
+		hosts = append(hosts, `"`+backend.URL+`"`)
+	}
+
+	dir := t.TempDir()
+	serverFile := filepath.Join(dir, "config.yml")
+	routeFile := filepath.Join(dir, "routing.json")
+	writeRoutes := func(checkType string) {
+		routes := `{"ReRoutes": [{"UpstreamHttpMethod": ["GET"], "UpstreamPathTemplate": "/api/{url}", "Algorithm": "round-robin",
+			"DownstreamPathTemplate": "/{url}", "DownstreamHosts": [` + strings.Join(hosts, ",") + `], "HealthCheckPath": "/healthz", "HealthCheckType": "` + checkType + `"}]}`
+		assert.NoError(t, ioutil.WriteFile(routeFile, []byte(routes), 0644))
+	}
+	assert.NoError(t, ioutil.WriteFile(serverFile, []byte("port: 8080\nhealth_check: true\nhealth_check_interval: 1\nhealth_check_max_concurrent: 1\n"), 0644))
+	writeRoutes("http")
+
+	cfg, err := config.Read(true, serverFile, routeFile)
+	assert.NoError(t, err)
+	h, err := newReloadableHandler(cfg, serverFile, routeFile)
+	assert.NoError(t, err)
+	defer h.Stop()
+	defer handler.SetHealthCheckMaxConcurrent(0)
+
+	//路由创建失败时不解除健康检查的并发限制
+	assert.NoError(t, ioutil.WriteFile(serverFile, []byte("port: 8080\nhealth_check: true\nhealth_check_interval: 1\nhealth_check_max_concurrent: 0\n"), 0644))
+	writeRoutes("unknown")
+	assert.Error(t, h.Reload())
+	time.Sleep(2500 * time.Millisecond)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&maxInflight))
+}
+
+func TestReloadClosesSamplingSink(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	serverFile := filepath.Join(dir, "config.yml")
+	routeFile := filepath.Join(dir, "routing.json")
+	routes := `{"ReRoutes": [{"UpstreamHttpMethod": ["GET"], "UpstreamPathTemplate": "/api/{url}", "Algorithm": "round-robin",
+		"DownstreamPathTemplate": "/{url}", "DownstreamHosts": ["` + backend.URL + `"]}]}`
+	assert.NoError(t, ioutil.WriteFile(routeFile, []byte(routes), 0644))
+	//采样文件所在的目录不存在时自动创建
+	sampleFile := filepath.Join(dir, "logs", "sample.log")
+	assert.NoError(t, ioutil.WriteFile(serverFile, []byte("port: 8080\nhealth_check_interval: 3\nsampling:\n  rate: 1\n  file: "+sampleFile+"\n"), 0644))
+
+	cfg, err := config.Read(true, serverFile, routeFile)
+	assert.NoError(t, err)
+	h, err := newReloadableHandler(cfg, serverFile, routeFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	get := func(path string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	countLines := func() int {
+		data, err := ioutil.ReadFile(sampleFile)
+		assert.NoError(t, err)
+		return strings.Count(string(data), "\n")
+	}
+
+	//旧路由表停止时关闭采样输出，关闭前写入已采样的记录
+	get("/api/a")
+	assert.NoError(t, h.Reload())
+	assert.Equal(t, 1, countLines())
+
+	get("/api/b")
+	h.Stop()
+	assert.Equal(t, 2, countLines())
+}
+
+func TestReloadDrainsRemovedHosts(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//健康检查的请求直接返回
+		if r.URL.Path != "/a" {
+			return
+		}
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	dir := t.TempDir()
+	serverFile := filepath.Join(dir, "config.yml")
+	routeFile := filepath.Join(dir, "routing.json")
+	writeRoutes := func(hosts ...string) {
+		routes := `{"ReRoutes": [{"UpstreamHttpMethod": ["GET"], "UpstreamPathTemplate": "/api/{url}", "Algorithm": "round-robin",
+			"DownstreamPathTemplate": "/{url}", "DownstreamHosts": ["` + strings.Join(hosts, `","`) + `"]}]}`
+		assert.NoError(t, ioutil.WriteFile(routeFile, []byte(routes), 0644))
+	}
+	assert.NoError(t, ioutil.WriteFile(serverFile, []byte("port: 8080\nhealth_check_interval: 3\ndrain_timeout: 5\n"), 0644))
+	writeRoutes(slow.URL, fast.URL)
+
+	cfg, err := config.Read(true, serverFile, routeFile)
+	assert.NoError(t, err)
+	h, err := newReloadableHandler(cfg, serverFile, routeFile)
+	assert.NoError(t, err)
+	defer h.Stop()
+	old := h.Routes()[0]
+	slowHost := strings.TrimPrefix(slow.URL, "http://")
+	fastHost := strings.TrimPrefix(fast.URL, "http://")
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/a", nil))
+		return rec
+	}
+	//只有slow参与负载均衡时发送处理中的请求
+	assert.NoError(t, old.Drain(fastHost))
+	inflight := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		inflight <- get()
+	}()
+	<-started
+	assert.NoError(t, old.Undrain(fastHost))
+
+	//替换路由表前先将删除的主机置为维护状态，等待其处理中的请求完成，期间旧路由表不再选择该主机
+	writeRoutes(fast.URL)
+	reloaded := make(chan error, 1)
+	go func() {
+		reloaded <- h.Reload()
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for !old.IsDrained(slowHost) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, old.IsDrained(slowHost))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "fast", get().Body.String())
+	}
+	select {
+	case err := <-reloaded:
+		t.Fatalf("删除的主机还有处理中的请求时替换了路由表: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.Same(t, old, h.Routes()[0])
+
+	close(release)
+	rec := <-inflight
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "slow", rec.Body.String())
+	assert.NoError(t, <-reloaded)
+	assert.Equal(t, []string{fastHost}, h.Routes()[0].Hosts())
+	assert.Equal(t, "fast", get().Body.String())
+}
+
+func TestReloadStopsOldTableWhenIdle(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/a" {
+			return
+		}
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	dir := t.TempDir()
+	serverFile := filepath.Join(dir, "config.yml")
+	routeFile := filepath.Join(dir, "routing.json")
+	writeRoutes := func(host string) {
+		routes := `{"ReRoutes": [{"UpstreamHttpMethod": ["GET"], "UpstreamPathTemplate": "/api/{url}", "Algorithm": "round-robin",
+			"DownstreamPathTemplate": "/{url}", "DownstreamHosts": ["` + host + `"]}]}`
+		assert.NoError(t, ioutil.WriteFile(routeFile, []byte(routes), 0644))
+	}
+	sampleFile := filepath.Join(dir, "sample.log")
+	assert.NoError(t, ioutil.WriteFile(serverFile, []byte("port: 8080\nhealth_check_interval: 3\ndrain_timeout: 5\nsampling:\n  rate: 1\n  file: "+sampleFile+"\n"), 0644))
+	writeRoutes(slow.URL)
+
+	cfg, err := config.Read(true, serverFile, routeFile)
+	assert.NoError(t, err)
+	h, err := newReloadableHandler(cfg, serverFile, routeFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/a", nil))
+		return rec
+	}
+	inflight := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		inflight <- get()
+	}()
+	<-started
+
+	//路由的所有主机都被替换时不等待，新请求立即使用新的主机
+	writeRoutes(fast.URL)
+	assert.NoError(t, h.Reload())
+	assert.Equal(t, "fast", get().Body.String())
+
+	//旧路由表处理中的请求完成后才停止，采样输出关闭前写入该请求的记录
+	close(release)
+	rec := <-inflight
+	assert.Equal(t, "slow", rec.Body.String())
+	h.Stop()
+	countLines := func() int {
+		data, err := ioutil.ReadFile(sampleFile)
+		assert.NoError(t, err)
+		return strings.Count(string(data), "\n")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for countLines() < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, 2, countLines())
+}
+
+func TestReloadKeepsHostState(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("configured"))
+	}))
+	defer backend.Close()
+	added := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("added"))
+	}))
+	defer added.Close()
+
+	dir := t.TempDir()
+	serverFile := filepath.Join(dir, "config.yml")
+	routeFile := filepath.Join(dir, "routing.json")
+	routes := `{"ReRoutes": [{"UpstreamHttpMethod": ["GET"], "UpstreamPathTemplate": "/api/{url}", "Algorithm": "round-robin",
+		"DownstreamPathTemplate": "/{url}", "DownstreamHosts": ["` + backend.URL + `"]}]}`
+	assert.NoError(t, ioutil.WriteFile(routeFile, []byte(routes), 0644))
+	assert.NoError(t, ioutil.WriteFile(serverFile, []byte("port: 8080\nhealth_check_interval: 3\n"), 0644))
+
+	cfg, err := config.Read(true, serverFile, routeFile)
+	assert.NoError(t, err)
+	h, err := newReloadableHandler(cfg, serverFile, routeFile)
+	assert.NoError(t, err)
+	defer h.Stop()
+
+	//通过管理接口添加的主机及维护状态在重新加载后保留
+	old := h.Routes()[0]
+	_, err = old.AddHost(added.URL)
+	assert.NoError(t, err)
+	backendHost := strings.TrimPrefix(backend.URL, "http://")
+	assert.NoError(t, old.Drain(backendHost))
+	assert.NoError(t, h.Reload())
+	rh := h.Routes()[0]
+	assert.NotSame(t, old, rh)
+	assert.ElementsMatch(t, []string{backendHost, strings.TrimPrefix(added.URL, "http://")}, rh.Hosts())
+	assert.True(t, rh.IsDrained(backendHost))
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/a", nil))
+		assert.Equal(t, "added", rec.Body.String())
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+			_, _ = w.Write([]byte("slow"))
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	route := testRoute("/slow", backend.URL)
+	route.RequestTimeout = 100
+	cfg := &config.Config{
+		Routes: []config.Routing{route},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow/a", nil))
+	elapsed := time.Since(start)
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.True(t, elapsed >= 100*time.Millisecond, elapsed)
+	assert.True(t, elapsed < 500*time.Millisecond, elapsed)
+}
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat(`{"name":"proxy","value":12345}`, 100)
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, _ = gw.Write([]byte(large))
+	_ = gw.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(large))
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte(large))
+		case "/gzipped":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(gzipped.Bytes())
+		}
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Compression: config.Compression{Enabled: true, MinSize: 512},
+		Routes:      []config.Routing{testRoute("/c", backend.URL)},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path string, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/c/large", "gzip, deflate")
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Contains(t, rec.Header().Values("Vary"), "Accept-Encoding")
+	assert.Empty(t, rec.Header().Get("Content-Length"))
+	gr, err := gzip.NewReader(rec.Body)
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(gr)
+		assert.Equal(t, large, string(body))
+	}
+
+	rec = get("/c/large", "deflate")
+	assert.Equal(t, "deflate", rec.Header().Get("Content-Encoding"))
+	body, _ := ioutil.ReadAll(flate.NewReader(rec.Body))
+	assert.Equal(t, large, string(body))
+
+	rec = get("/c/large", "")
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, large, rec.Body.String())
+
+	rec = get("/c/small", "gzip")
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, `{"ok":true}`, rec.Body.String())
+
+	rec = get("/c/image", "gzip")
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, large, rec.Body.String())
+
+	//下游主机已压缩的响应不重复压缩
+	rec = get("/c/gzipped", "gzip")
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, gzipped.Bytes(), rec.Body.Bytes())
+}
+
+func TestMaxBodySize(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+
+	newRoute := func(upstream string, maxBodySize int64) config.Routing {
+		r := testRoute(upstream, backend.URL)
+		r.UpstreamHTTPMethod = []string{http.MethodPost}
+		r.MaxBodySize = maxBodySize
+		return r
+	}
+	cfg := &config.Config{
+		MaxBodySize: 10,
+		Routes:      []config.Routing{newRoute("/global", 0), newRoute("/large", 100), newRoute("/unlimited", -1)},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	post := func(path string, body string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	small, large := strings.Repeat("a", 10), strings.Repeat("a", 50)
+	assert.Equal(t, http.StatusOK, post("/global/a", small, false).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("/global/a", large, false).Code)
+	//未携带Content-Length时读取超过限制后返回413
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("/global/a", large, true).Code)
+	assert.Equal(t, http.StatusOK, post("/large/a", large, true).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("/large/a", strings.Repeat("a", 101), false).Code)
+	assert.Equal(t, http.StatusOK, post("/unlimited/a", strings.Repeat("a", 1000), true).Code)
+}
+
+func TestIPFilter(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	newRoute := func(upstream string, filter *config.IPFilter) config.Routing {
+		r := testRoute(upstream, backend.URL)
+		r.IPFilter = filter
+		return r
+	}
+	cfg := &config.Config{
+		Routes: []config.Routing{
+			newRoute("/internal", &config.IPFilter{Allow: []string{"10.0.0.0/8", "192.168.1.10"}, Deny: []string{"10.1.0.0/16"}}),
+			newRoute("/public", &config.IPFilter{Deny: []string{"203.0.113.0/24"}}),
+		},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path string, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, get("/internal/a", "10.2.3.4:1234"))
+	assert.Equal(t, http.StatusOK, get("/internal/a", "192.168.1.10:1234"))
+	assert.Equal(t, http.StatusForbidden, get("/internal/a", "10.1.2.3:1234"))
+	assert.Equal(t, http.StatusForbidden, get("/internal/a", "8.8.8.8:1234"))
+	assert.Equal(t, http.StatusOK, get("/public/a", "8.8.8.8:1234"))
+	assert.Equal(t, http.StatusForbidden, get("/public/a", "203.0.113.7:1234"))
+
+	cfg.Routes = []config.Routing{newRoute("/bad", &config.IPFilter{Allow: []string{"10.0.0.0/33"}})}
+	_, _, err := NewMuxHandler(cfg)
+	assert.Error(t, err)
+}
+
+func TestHostHeader(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer backend.Close()
+
+	newRoute := func(upstream string, preserve bool, override string) config.Routing {
+		r := testRoute(upstream, backend.URL)
+		r.PreserveHostHeader = preserve
+		r.HostHeaderOverride = override
+		return r
+	}
+	cfg := &config.Config{
+		Routes: []config.Routing{
+			newRoute("/default", false, ""),
+			newRoute("/preserve", true, ""),
+			newRoute("/override", true, "api.internal"),
+		},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://proxy.example.com"+path, nil)
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	assert.Equal(t, strings.TrimPrefix(backend.URL, "http://"), get("/default/a"))
+	assert.Equal(t, "proxy.example.com", get("/preserve/a"))
+	assert.Equal(t, "api.internal", get("/override/a"))
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	type forwarded struct {
+		xff, proto, host, realIP string
+	}
+	received := make(chan forwarded, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- forwarded{
+			xff:    r.Header.Get("X-Forwarded-For"),
+			proto:  r.Header.Get("X-Forwarded-Proto"),
+			host:   r.Header.Get("X-Forwarded-Host"),
+			realIP: r.Header.Get("X-Real-IP"),
+		}
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		TrustedProxies: []string{"10.0.0.0/8"},
+		Routes:         []config.Routing{testRoute("/xff", backend.URL)},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+	assert.NoError(t, applyGlobalConfig(cfg))
+	defer func() {
+		_ = util.SetTrustedProxies(nil)
+	}()
+
+	send := func(remoteAddr string, headers map[string]string) forwarded {
+		req := httptest.NewRequest(http.MethodGet, "http://proxy.example.com/xff/a", nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		muxHandler.ServeHTTP(httptest.NewRecorder(), req)
+		return <-received
+	}
+
+	//直连客户端携带的X-Forwarded-*不可信，被替换为实际的值
+	f := send("203.0.113.5:1234", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Host": "evil.example.com"})
+	assert.Equal(t, forwarded{xff: "203.0.113.5", proto: "http", host: "proxy.example.com", realIP: "203.0.113.5"}, f)
+
+	//可信代理转发的请求在转发链末尾追加代理的地址
+	f = send("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.5, 10.0.0.2", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "api.example.com"})
+	assert.Equal(t, forwarded{xff: "203.0.113.5, 10.0.0.2, 10.0.0.1", proto: "https", host: "api.example.com", realIP: "203.0.113.5"}, f)
+}
+
+func TestRewriteRegex(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Routes: []config.Routing{
+			{
+				UpstreamHTTPMethod:     []string{http.MethodGet},
+				UpstreamPathTemplate:   "/api/v1/{url}",
+				Algorithm:              "round-robin",
+				DownstreamPathTemplate: "/{url}",
+				DownstreamHosts:        []string{backend.URL},
+				RewriteRegex:           `^/api/v1/users/(\d+)$`,
+				RewriteReplacement:     "/internal/users?id=$1",
+			},
+			{
+				UpstreamHTTPMethod:     []string{http.MethodGet},
+				UpstreamPathTemplate:   "/prefix/{url}",
+				Algorithm:              "round-robin",
+				DownstreamPathTemplate: "/backend/{url}",
+				DownstreamHosts:        []string{backend.URL},
+			},
+		},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://proxy.example.com"+path, nil)
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	assert.Equal(t, "/internal/users?id=42", get("/api/v1/users/42"))
+	//客户端的查询参数排在重写结果的查询参数之后
+	assert.Equal(t, "/internal/users?id=42&fields=name", get("/api/v1/users/42?fields=name"))
+	//不匹配正则时使用前缀替换
+	assert.Equal(t, "/orders/7", get("/api/v1/orders/7"))
+	//未配置正则时使用前缀替换
+	assert.Equal(t, "/backend/a/b", get("/prefix/a/b"))
+
+	_, _, err := NewMuxHandler(&config.Config{Routes: []config.Routing{{
+		UpstreamPathTemplate:   "/bad/{url}",
+		Algorithm:              "round-robin",
+		DownstreamPathTemplate: "/{url}",
+		DownstreamHosts:        []string{backend.URL},
+		RewriteRegex:           "(",
+	}}})
+	assert.Error(t, err)
+}
+
+func TestProbes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	cfg := &config.Config{Routes: []config.Routing{testRoute("/a", backend.URL), testRoute("/b", backend.URL), testRoute("", backend.URL)}}
+	muxHandler, routes := newTestMux(t, cfg)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	assert.Equal(t, http.StatusOK, get("/livez").Code)
+	rec := get("/readyz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"Ready":true,"UnavailableRoutes":[]}`, rec.Body.String())
+
+	//路由/b的所有主机不可用时返回503
+	routes[1].SetAlive(strings.TrimPrefix(backend.URL, "http://"), false)
+	rec = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"Ready":false,"UnavailableRoutes":["/b"]}`, rec.Body.String())
+	assert.Equal(t, http.StatusOK, get("/livez").Code)
+}
+
+func TestCache(t *testing.T) {
+	var hits int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/vary":
+			w.Header().Set("Vary", "Accept-Language")
+			_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+			return
+		case "/large":
+			_, _ = w.Write(bytes.Repeat([]byte("a"), 2048))
+			return
+		default:
+			w.Header().Set("Cache-Control", "max-age=30")
+		}
+		_, _ = w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer backend.Close()
+
+	route := testRoute("/cache", backend.URL)
+	route.UpstreamHTTPMethod = []string{http.MethodGet, http.MethodPost}
+	cfg := &config.Config{
+		Cache:  config.Cache{Enabled: true, MaxEntries: 10, DefaultTTL: 60, MaxBodySize: 1024},
+		Routes: []config.Routing{route},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	send := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec
+	}
+	backendHits := func() int32 {
+		return atomic.LoadInt32(&hits)
+	}
+
+	rec := send(http.MethodGet, "/cache/a?x=1", nil)
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	rec = send(http.MethodGet, "/cache/a?x=1", nil)
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "0", rec.Header().Get("Age"))
+	assert.Equal(t, "/a?x=1", rec.Body.String())
+	assert.Equal(t, int32(1), backendHits())
+
+	//查询参数不同时分别缓存
+	assert.Equal(t, "MISS", send(http.MethodGet, "/cache/a?x=2", nil).Header().Get("X-Cache"))
+	assert.Equal(t, int32(2), backendHits())
+	//客户端要求no-cache时重新获取
+	assert.Equal(t, "MISS", send(http.MethodGet, "/cache/a?x=1", map[string]string{"Cache-Control": "no-cache"}).Header().Get("X-Cache"))
+	assert.Equal(t, int32(3), backendHits())
+	//只缓存GET请求
+	send(http.MethodPost, "/cache/a?x=1", nil)
+	assert.Equal(t, int32(4), backendHits())
+
+	//no-store及超过大小限制的响应不缓存
+	for _, path := range []string{"/cache/no-store", "/cache/large"} {
+		send(http.MethodGet, path, nil)
+		assert.Equal(t, "MISS", send(http.MethodGet, path, nil).Header().Get("X-Cache"), path)
+	}
+	assert.Equal(t, int32(8), backendHits())
+
+	//Vary声明的请求头不同时不使用缓存
+	assert.Equal(t, "zh", send(http.MethodGet, "/cache/vary", map[string]string{"Accept-Language": "zh"}).Body.String())
+	rec = send(http.MethodGet, "/cache/vary", map[string]string{"Accept-Language": "en"})
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "en", rec.Body.String())
+	rec = send(http.MethodGet, "/cache/vary", map[string]string{"Accept-Language": "en"})
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "en", rec.Body.String())
+}
+
+func TestCacheAuthenticatedClients(t *testing.T) {
+	var hits int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=30")
+		_, _ = w.Write([]byte(r.Header.Get("X-Client-ID")))
+	}))
+	defer backend.Close()
+
+	route := testRoute("/api", backend.URL)
+	route.APIKey = &config.APIKey{Keys: map[string]string{"k1": "billing", "k2": "reporting"}}
+	cfg := &config.Config{
+		Cache:  config.Cache{Enabled: true, MaxEntries: 10, DefaultTTL: 60, MaxBodySize: 1024},
+		Routes: []config.Routing{route},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/report", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec
+	}
+	//不同API Key的客户端不能共用缓存的响应
+	assert.Equal(t, "billing", get("k1").Body.String())
+	rec := get("k2")
+	assert.Equal(t, "reporting", rec.Body.String())
+	assert.Empty(t, rec.Header().Get("X-Cache"))
+	assert.Equal(t, "billing", get("k1").Body.String())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+}
+
+func TestCachePostRequests(t *testing.T) {
+	var hits int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Cache-Control", "max-age=30")
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+
+	route := config.Routing{
+		UpstreamHTTPMethod:     []string{http.MethodPost},
+		UpstreamPathTemplate:   "/graphql",
+		Algorithm:              "round-robin",
+		DownstreamPathTemplate: "/graphql",
+		DownstreamHosts:        []string{backend.URL},
+		CacheMethods:           []string{http.MethodPost},
+	}
+	cfg := &config.Config{
+		Cache:  config.Cache{Enabled: true, MaxEntries: 10, DefaultTTL: 60, MaxBodySize: 1024},
+		Routes: []config.Routing{route},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, "MISS", post(`{"query":"a"}`).Header().Get("X-Cache"))
+	assert.Equal(t, "MISS", post(`{"query":"b"}`).Header().Get("X-Cache"))
+	rec := post(`{"query":"a"}`)
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, `{"query":"a"}`, rec.Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	//写操作的请求方法不能缓存
+	route.CacheMethods = []string{http.MethodPut}
+	cfg.Routes = []config.Routing{route}
+	_, _, err := NewMuxHandler(cfg)
+	assert.Error(t, err)
+}
+
+func TestCachePostMaxBodySize(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Cache-Control", "max-age=30")
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Cache: config.Cache{Enabled: true, MaxEntries: 10, DefaultTTL: 60, MaxBodySize: 1024},
+		Routes: []config.Routing{{
+			UpstreamHTTPMethod:     []string{http.MethodPost},
+			UpstreamPathTemplate:   "/graphql",
+			Algorithm:              "round-robin",
+			DownstreamPathTemplate: "/graphql",
+			DownstreamHosts:        []string{backend.URL},
+			CacheMethods:           []string{http.MethodPost},
+			MaxBodySize:            16,
+		}},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	//超过路由限制的请求内容在缓存读取请求内容计算缓存键之前被拒绝
+	body := strings.NewReader(strings.Repeat("x", 512))
+	rec := httptest.NewRecorder()
+	muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", body))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, 512, body.Len())
+
+	//未知长度的请求内容最多读取到路由的限制
+	body = strings.NewReader(strings.Repeat("x", 512))
+	req := httptest.NewRequest(http.MethodPost, "/graphql", body)
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	muxHandler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Greater(t, body.Len(), 512-64)
+	assert.Empty(t, rec.Header().Get("X-Cache"))
+}
+
+func TestH2C(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	newRoute := func(upstream string, h2c bool) config.Routing {
+		r := testRoute(upstream, backend.URL)
+		r.H2C = h2c
+		return r
+	}
+	cfg := &config.Config{Routes: []config.Routing{newRoute("/h1", false), newRoute("/h2c", true)}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Body.String()
+	}
+	assert.Equal(t, "HTTP/1.1", get("/h1/a"))
+	assert.Equal(t, "HTTP/2.0", get("/h2c/a"))
+}
+
+func TestBasicAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Auth-Subject")))
+	}))
+	defer backend.Close()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	newRoute := func(upstream string, auth *config.BasicAuth) config.Routing {
+		r := testRoute(upstream, backend.URL)
+		r.BasicAuth = auth
+		return r
+	}
+	cfg := &config.Config{Routes: []config.Routing{
+		newRoute("/admin", &config.BasicAuth{Realm: "admin", Users: map[string]string{"alice": string(hash)}}),
+		newRoute("/ops", &config.BasicAuth{Users: map[string]string{"bob": string(hash)}}),
+	}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path, username, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := get("/admin/a", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Basic realm="admin", charset="UTF-8"`, rec.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, get("/admin/a", "alice", "wrong").Code)
+	//不同路由的用户相互独立
+	assert.Equal(t, http.StatusUnauthorized, get("/admin/a", "bob", "secret").Code)
+	rec = get("/admin/a", "alice", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "alice", rec.Body.String())
+	assert.Equal(t, http.StatusOK, get("/ops/a", "bob", "secret").Code)
+
+	_, _, err = NewMuxHandler(&config.Config{Routes: []config.Routing{
+		newRoute("/bad", &config.BasicAuth{Users: map[string]string{"alice": "plain"}}),
+	}})
+	assert.Error(t, err)
+}
+
+func TestAPIKey(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Client-ID") + " " + r.Header.Get("X-API-Key") + " " + r.URL.RawQuery))
+	}))
+	defer backend.Close()
+
+	route := testRoute("/api", backend.URL)
+	route.APIKey = &config.APIKey{
+		QueryParam: "api_key",
+		Keys:       map[string]string{"k1": "billing", "k2": "reporting"},
+	}
+	cfg := &config.Config{Routes: []config.Routing{route}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusUnauthorized, get("/api/a", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, get("/api/a", map[string]string{"X-API-Key": "wrong"}).Code)
+
+	//API Key不转发给下游主机，客户端携带的X-Client-ID被替换
+	rec := get("/api/a", map[string]string{"X-API-Key": "k1", "X-Client-ID": "spoofed"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "billing  ", rec.Body.String())
+	rec = get("/api/a?api_key=k2&x=1", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "reporting  x=1", rec.Body.String())
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	order, err := middlewareOrder(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultMiddlewareOrder, order)
+
+	//未配置panics时放在最外层
+	order, err = middlewareOrder([]string{"rate_limit", "access_log"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"panics", "rate_limit", "access_log"}, order)
+
+	//显式配置panics时使用配置的位置
+	order, err = middlewareOrder([]string{"request_id", "panics"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"request_id", "panics"}, order)
+
+	_, err = middlewareOrder([]string{"unknown"})
+	assert.Error(t, err)
+	_, err = middlewareOrder([]string{"access_log", "access_log"})
+	assert.Error(t, err)
+
+	_, _, err = NewMuxHandler(&config.Config{Middlewares: []string{"unknown"}})
+	assert.Error(t, err)
+}
+
+func TestMiddlewares(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Request-ID")))
+	}))
+	defer backend.Close()
+
+	newConfig := func(middlewares ...string) *config.Config {
+		return &config.Config{
+			Middlewares: middlewares,
+			Routes:      []config.Routing{testRoute("/mw", backend.URL)},
+		}
+	}
+	get := func(cfg *config.Config) string {
+		muxHandler, _ := newTestMux(t, cfg)
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/mw/a", nil))
+		return rec.Body.String()
+	}
+	//未在middlewares中配置的中间件不生效
+	assert.NotEmpty(t, get(newConfig()))
+	assert.NotEmpty(t, get(newConfig("request_id")))
+	assert.Empty(t, get(newConfig("access_log")))
+}
+
+func TestFallbackResponse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	newRoute := func(upstream string, fallback *config.FallbackResponse) config.Routing {
+		r := testRoute(upstream, backend.URL)
+		r.FallbackResponse = fallback
+		return r
+	}
+	cfg := &config.Config{Routes: []config.Routing{
+		newRoute("/default", nil),
+		newRoute("/page", &config.FallbackResponse{Body: "<h1>维护中</h1>"}),
+		newRoute("/redirect", &config.FallbackResponse{RedirectURL: "https://status.example.com"}),
+	}}
+	muxHandler, routes := newTestMux(t, cfg)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	assert.Equal(t, "backend", get("/page/a").Body.String())
+
+	//所有主机均不可用
+	for _, rh := range routes {
+		assert.NoError(t, rh.Drain(strings.TrimPrefix(backend.URL, "http://")))
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, get("/default/a").Code)
+	rec := get("/page/a")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "<h1>维护中</h1>", rec.Body.String())
+	rec = get("/redirect/a")
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "https://status.example.com", rec.Header().Get("Location"))
+
+	//删除所有主机后同样返回降级响应
+	assert.NoError(t, routes[1].RemoveHost(strings.TrimPrefix(backend.URL, "http://")))
+	assert.Equal(t, "<h1>维护中</h1>", get("/page/a").Body.String())
+
+	_, _, err := NewMuxHandler(&config.Config{Routes: []config.Routing{
+		newRoute("/bad", &config.FallbackResponse{RedirectURL: "https://status.example.com", Status: 200}),
+	}})
+	assert.Error(t, err)
+}
+
+func TestBoundedLoadFactor(t *testing.T) {
+	newConfig := func(algorithm string, loadFactor float64) *config.Config {
+		return &config.Config{Routes: []config.Routing{{
+			UpstreamHTTPMethod:     []string{http.MethodGet},
+			UpstreamPathTemplate:   "/bounded/{url}",
+			Algorithm:              algorithm,
+			LoadFactor:             loadFactor,
+			DownstreamPathTemplate: "/{url}",
+			DownstreamHosts:        []string{"http://127.0.0.1:1", "http://127.0.0.1:2"},
+		}}}
+	}
+	newTestMux(t, newConfig("bounded", 0.5))
+	_, _, err := NewMuxHandler(newConfig("bounded", -1))
+	assert.Error(t, err)
+	_, _, err = NewMuxHandler(newConfig("round-robin", 0.5))
+	assert.Error(t, err)
+}
+
+func TestBufferRequestBody(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+	//已关闭的端口，连接失败后重试其他主机
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	newRoute := func(upstream string, buffer int64) config.Routing {
+		r := testRoute(upstream, down.URL, backend.URL)
+		r.UpstreamHTTPMethod = []string{http.MethodPost}
+		r.MaxRetries = 1
+		r.BufferRequestBody = buffer
+		return r
+	}
+	cfg := &config.Config{Routes: []config.Routing{newRoute("/buffered", 16), newRoute("/unbuffered", 0)}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+	//轮询依次选择两个主机，每次请求都有一次会先选中不可用的主机
+	for i := 0; i < 2; i++ {
+		rec := post("/buffered/a", "hello")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "hello", rec.Body.String())
+	}
+	codes := map[int]int{}
+	for i := 0; i < 2; i++ {
+		codes[post("/buffered/a", strings.Repeat("a", 17)).Code]++
+		codes[post("/unbuffered/a", "hello").Code]++
+	}
+	//超过缓冲大小及未开启缓冲的POST请求不重试
+	assert.Equal(t, 2, codes[http.StatusOK])
+	assert.Equal(t, 2, codes[http.StatusInternalServerError])
+}
+
+func TestHeaderRules(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.0")
+		w.Header().Set("X-Powered-By", "PHP")
+		w.Header().Set("X-Upstream", "a")
+		_, _ = w.Write([]byte(r.Header.Get("X-Internal-Token") + "|" + strings.Join(r.Header.Values("X-Tag"), ",") + "|" + r.Header.Get("Cookie")))
+	}))
+	defer backend.Close()
+
+	route := testRoute("/headers", backend.URL)
+	route.RequestHeaders = &config.HeaderRules{
+		Set:    map[string]string{"X-Internal-Token": "secret"},
+		Add:    map[string]string{"X-Tag": "proxy"},
+		Remove: []string{"Cookie"},
+	}
+	route.ResponseHeaders = &config.HeaderRules{
+		Add:    map[string]string{"X-Upstream": "b"},
+		Remove: []string{"Server", "X-Powered-By"},
+	}
+	muxHandler, _ := newTestMux(t, &config.Config{Routes: []config.Routing{route}})
+
+	req := httptest.NewRequest(http.MethodGet, "/headers/a", nil)
+	req.Header.Set("X-Internal-Token", "forged")
+	req.Header.Set("X-Tag", "client")
+	req.Header.Set("Cookie", "session=1")
+	rec := httptest.NewRecorder()
+	muxHandler.ServeHTTP(rec, req)
+	assert.Equal(t, "secret|client,proxy|", rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Server"))
+	assert.Empty(t, rec.Header().Get("X-Powered-By"))
+	assert.Equal(t, []string{"a", "b"}, rec.Header().Values("X-Upstream"))
+
+	route.RequestHeaders = &config.HeaderRules{Set: map[string]string{"Bad Header": "x"}}
+	_, _, err := NewMuxHandler(&config.Config{Routes: []config.Routing{route}})
+	assert.Error(t, err)
+}
+
+func TestUnixSocketBackend(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host + r.URL.Path))
+	}))
+	backend.Listener = listener
+	backend.Start()
+	defer backend.Close()
+
+	route := testRoute("/unix", "unix://"+socket)
+	route.HealthCheckPath = "/healthz"
+	cfg := &config.Config{Routes: []config.Routing{route}}
+	muxHandler, routes := newTestMux(t, cfg)
+
+	rec := httptest.NewRecorder()
+	muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unix/a", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "localhost/a", rec.Body.String())
+	assert.True(t, routes[0].ReadAlive("unix:"+socket))
+
+	assert.True(t, util.IsBackendAlive("unix:"+socket))
+	assert.True(t, util.IsUnixSocketHealthy(socket, "/healthz", http.StatusOK, time.Second))
+	assert.NoError(t, routes[0].RemoveHost("unix://"+socket))
+	backend.Close()
+	assert.False(t, util.IsBackendAlive("unix:"+socket))
+}
+
+func TestServerSentEvents(t *testing.T) {
+	next := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		//非200的事件流也不能被改写或缓冲
+		w.WriteHeader(http.StatusAccepted)
+		for i := 0; i < 2; i++ {
+			_, _ = fmt.Fprintf(w, "data: event-%d\n\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	route := testRoute("/events", backend.URL)
+	route.RewriteErrorBody = true
+	route.HedgeDelay = 1000
+	cfg := &config.Config{
+		AccessLog:   true,
+		Compression: config.Compression{Enabled: true, MinSize: 1},
+		Routes:      []config.Routing{route},
+	}
+	muxHandler, _ := newTestMux(t, cfg)
+	proxy := httptest.NewServer(muxHandler)
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/events/stream", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept-Encoding", "gzip")
+	//缓冲时下游主机不会结束响应，需要超时退出
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}, Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+	lines := make(chan string)
+	go func() {
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			if line != "\n" {
+				lines <- line
+			}
+		}
+	}()
+	//下游主机在收到通知前不会发送下一个事件，缓冲时第一个事件无法到达客户端
+	for i := 0; i < 2; i++ {
+		select {
+		case line := <-lines:
+			assert.Equal(t, fmt.Sprintf("data: event-%d\n", i), line)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("事件 %d 未及时到达客户端", i)
+		}
+		select {
+		case next <- struct{}{}:
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	next := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//已知长度的响应ReverseProxy默认在结束时才Flush
+		w.Header().Set("Content-Length", "10")
+		_, _ = w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-next:
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = w.Write([]byte("-last"))
+	}))
+	defer backend.Close()
+
+	newConfig := func(flushInterval int) *config.Config {
+		return &config.Config{Routes: []config.Routing{{
+			UpstreamHTTPMethod:     []string{http.MethodGet},
+			UpstreamPathTemplate:   "/stream/{url}",
+			Algorithm:              "round-robin",
+			DownstreamPathTemplate: "/{url}",
+			DownstreamHosts:        []string{backend.URL},
+			FlushInterval:          flushInterval,
+		}}}
+	}
+	muxHandler, _ := newTestMux(t, newConfig(-1))
+	proxy := httptest.NewServer(muxHandler)
+	defer proxy.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(proxy.URL + "/stream/a")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	first := make([]byte, 5)
+	_, err = io.ReadFull(resp.Body, first)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(first))
+	close(next)
+	rest, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "-last", string(rest))
+
+	_, _, err = NewMuxHandler(newConfig(-2))
+	assert.Error(t, err)
+}
+
+func TestHealthCheckMaxConcurrent(t *testing.T) {
+	var inflight, maxInflight, probes int64
+	healthz := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+		for {
+			peak := atomic.LoadInt64(&maxInflight)
+			if n <= peak || atomic.CompareAndSwapInt64(&maxInflight, peak, n) {
+				break
+			}
+		}
+		atomic.AddInt64(&probes, 1)
+		time.Sleep(100 * time.Millisecond)
+	})
+	hosts := make([]string, 0, 4)
+	for i := 0; i < 4; i++ {
+		backend := httptest.NewServer(healthz)
+		defer backend.Close()
+		hosts = append(hosts, backend.URL)
+	}
+
+	route := testRoute("/probe", hosts...)
+	route.HealthCheckPath = "/healthz"
+	cfg := &config.Config{
+		HealthCheck:              true,
+		HealthCheckInterval:      1,
+		HealthCheckMaxConcurrent: 1,
+		Routes:                   []config.Routing{route},
+	}
+	newTestMux(t, cfg)
+	assert.NoError(t, applyGlobalConfig(cfg))
+	defer handler.SetHealthCheckMaxConcurrent(0)
+
+	//首次健康检查分散在一个间隔内，每个主机至少检查一次
+	time.Sleep(1500 * time.Millisecond)
+	assert.GreaterOrEqual(t, atomic.LoadInt64(&probes), int64(len(hosts)))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&maxInflight))
+}
+
+func TestAdminHealthHistory(t *testing.T) {
+	rh, err := handler.NewRoutePrefixHandler("round-robin", "/health", "/", []string{"http://127.0.0.1:1"}, nil)
+	assert.NoError(t, err)
+	defer rh.Stop()
+	host := "127.0.0.1:1"
+	for i := 0; i < 40; i++ {
+		rh.SetAlive(host, i%2 == 1)
+	}
+	admin := handler.NewAdminHandler([]*handler.RoutePrefixHandler{rh})
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/health?route=/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result []handler.RouteHealth
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Len(t, result, 1)
+	assert.Len(t, result[0].Hosts, 1)
+	hh := result[0].Hosts[0]
+	assert.Equal(t, host, hh.Host)
+	assert.True(t, hh.Alive)
+	assert.Nil(t, hh.LastCheck)
+	//历史记录有上限，保留最近的状态变化
+	assert.Len(t, hh.History, 32)
+	last := hh.History[len(hh.History)-1]
+	assert.Equal(t, handler.HostStateDown, last.From)
+	assert.Equal(t, handler.HostStateUp, last.To)
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/health?route=/missing", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestErrorPages(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "panic: database connection lost", http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	newRoute := func(path string, host string) config.Routing {
+		r := testRoute(path, host)
+		r.ErrorPages = map[int]*config.ErrorPage{
+			http.StatusServiceUnavailable: {HTML: "<h1>{{.Status}} {{.StatusText}}</h1>", JSON: `{"code":{{.Status}}}`},
+		}
+		return r
+	}
+	cfg := &config.Config{Routes: []config.Routing{
+		newRoute("/pages", backend.URL),
+		newRoute("/dead", "http://127.0.0.1:1"),
+	}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec
+	}
+	//下游主机的错误响应被替换为配置的错误页面
+	rec := get("/pages/a", "text/html,application/xhtml+xml")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "<h1>503 Service Unavailable</h1>", rec.Body.String())
+	rec = get("/pages/a", "application/json, text/html;q=0.5")
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"code":503}`, rec.Body.String())
+
+	//代理产生的错误返回默认页面，不包含内部错误信息
+	rec = get("/dead/a", "")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "Internal Server Error\n", rec.Body.String())
+	rec = get("/dead/a", "application/json")
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"Status":500,"Error":"Internal Server Error"}`, rec.Body.String())
+	rec = get("/dead/a", "text/html")
+	assert.Contains(t, rec.Body.String(), "<h1>500 Internal Server Error</h1>")
+
+	cfg.Routes[0].ErrorPages[http.StatusOK] = &config.ErrorPage{HTML: "ok"}
+	_, _, err := NewMuxHandler(cfg)
+	assert.Error(t, err)
+	delete(cfg.Routes[0].ErrorPages, http.StatusOK)
+	cfg.Routes[0].ErrorPages[http.StatusBadGateway] = &config.ErrorPage{HTML: "{{.Status"}
+	_, _, err = NewMuxHandler(cfg)
+	assert.Error(t, err)
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	listeners := []config.Listener{{Address: ":8080"}, {Address: ":8443", Schema: "https"}}
+	assert.Equal(t, "8443", httpsListenerPort(listeners))
+
+	rec := httptest.NewRecorder()
+	httpsRedirect("8443").ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://example.com:8080/api/a?b=1", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+	assert.Equal(t, "https://example.com:8443/api/a?b=1", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	httpsRedirect("443").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/a", nil))
+	assert.Equal(t, "https://example.com/a", rec.Header().Get("Location"))
+}
+
+//writeTestCert 生成自签名证书，可以同时作为CA证书使用，返回证书及私钥文件路径
+func writeTestCert(t *testing.T, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, commonName+".crt"), filepath.Join(dir, commonName+".key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestTLSServerConfig(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, "proxy.test")
+	tlsConfig, err := tlsServerConfig(config.TLS{}, certFile, keyFile)
+	assert.NoError(t, err)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+
+	opts := config.TLS{
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		ClientCAFile: certFile,
+		ClientAuth:   "require_and_verify",
+	}
+	tlsConfig, err = tlsServerConfig(opts, certFile, keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+	assert.NotNil(t, tlsConfig.ClientCAs)
+
+	//证书与私钥不匹配时启动前返回错误
+	otherCert, _ := writeTestCert(t, "other.test")
+	_, err = tlsServerConfig(config.TLS{}, otherCert, keyFile)
+	assert.Error(t, err)
+	opts.ClientCAFile = keyFile
+	_, err = tlsServerConfig(opts, certFile, keyFile)
+	assert.Error(t, err)
+
+	listeners := []config.Listener{{Address: "127.0.0.1:0", Schema: "https", CertCrt: otherCert, CertKey: keyFile}}
+	err = serveListeners(context.Background(), listeners, config.TLS{}, http.NotFoundHandler(), 0, time.Second)
+	assert.Error(t, err)
+}
+
+func TestClientCert(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get(util.XAuthSubject)))
+	}))
+	defer backend.Close()
+
+	route := testRoute("/internal", backend.URL)
+	route.ClientCert = &config.ClientCert{AllowedSubjects: []string{"svc-a"}}
+	cfg := &config.Config{Routes: []config.Routing{route}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	serverCert, serverKey := writeTestCert(t, "proxy.test")
+	certA, keyA := writeTestCert(t, "svc-a")
+	certB, keyB := writeTestCert(t, "svc-b")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	var caPEM []byte
+	for _, f := range []string{certA, certB} {
+		data, err := ioutil.ReadFile(f)
+		assert.NoError(t, err)
+		caPEM = append(caPEM, data...)
+	}
+	assert.NoError(t, ioutil.WriteFile(caFile, caPEM, 0600))
+
+	tlsConfig, err := tlsServerConfig(config.TLS{ClientCAFile: caFile, ClientAuth: "verify_if_given"}, serverCert, serverKey)
+	assert.NoError(t, err)
+	proxy := httptest.NewUnstartedServer(muxHandler)
+	proxy.TLS = tlsConfig
+	proxy.StartTLS()
+	defer proxy.Close()
+
+	rootPEM, err := ioutil.ReadFile(serverCert)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(rootPEM)
+	get := func(certFile, keyFile string) *http.Response {
+		clientTLS := &tls.Config{RootCAs: roots, ServerName: "proxy.test"}
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			assert.NoError(t, err)
+			clientTLS.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/internal/a", nil)
+		//客户端伪造的subject不会被转发
+		req.Header.Set(util.XAuthSubject, "admin")
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	resp := get(certA, keyA)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "svc-a", string(body))
+
+	resp = get(certB, keyB)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = get("", "")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestMatchType(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name + " " + r.Method + " " + r.URL.Path))
+		}))
+	}
+	reads, writes := newBackend("reads"), newBackend("writes")
+	defer reads.Close()
+	defer writes.Close()
+
+	newRoute := func(method, matchType, path, downstream, host string) config.Routing {
+		r := testRoute("", host)
+		r.UpstreamHTTPMethod = []string{method}
+		r.UpstreamPathTemplate = path
+		r.MatchType = matchType
+		r.DownstreamPathTemplate = downstream
+		return r
+	}
+	cfg := &config.Config{Routes: []config.Routing{
+		newRoute(http.MethodPost, config.MatchExact, "/api/orders", "/orders", writes.URL),
+		newRoute(http.MethodGet, config.MatchExact, "/api/orders", "/orders", reads.URL),
+		newRoute(http.MethodGet, config.MatchRegex, `/api/orders/[0-9]+`, "/", reads.URL),
+	}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	assert.Equal(t, "writes POST /orders", do(http.MethodPost, "/api/orders").Body.String())
+	assert.Equal(t, "reads GET /orders", do(http.MethodGet, "/api/orders").Body.String())
+	//regex路由原样转发请求路径
+	assert.Equal(t, "reads GET /api/orders/42", do(http.MethodGet, "/api/orders/42").Body.String())
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/orders/abc").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/orders/42/items").Code)
+	assert.NotEqual(t, http.StatusOK, do(http.MethodDelete, "/api/orders").Code)
+
+	cfg.Routes[2].MatchType = "glob"
+	_, _, err := NewMuxHandler(cfg)
+	assert.Error(t, err)
+}
+
+func TestHeaderMatchCanary(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+	canary, stable := newBackend("canary"), newBackend("stable")
+	defer canary.Close()
+	defer stable.Close()
+
+	newRoute := func(host string, match *config.HeaderPredicate) config.Routing {
+		r := testRoute("/app", host)
+		r.HeaderMatch = match
+		return r
+	}
+	//灰度路由配置在默认路由之前
+	cfg := &config.Config{Routes: []config.Routing{
+		newRoute(canary.URL, &config.HeaderPredicate{Header: "X-Canary", Equals: "true"}),
+		newRoute(stable.URL, nil),
+	}}
+	assert.NoError(t, cfg.ValidationRoutes())
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(canaryHeader string) string {
+		req := httptest.NewRequest(http.MethodGet, "/app/a", nil)
+		if canaryHeader != "" {
+			req.Header.Set("X-Canary", canaryHeader)
+		}
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	assert.Equal(t, "canary", get("true"))
+	assert.Equal(t, "stable", get("false"))
+	assert.Equal(t, "stable", get(""))
+
+	//默认路由在前时灰度路由永远不会被匹配
+	cfg.Routes[0], cfg.Routes[1] = cfg.Routes[1], cfg.Routes[0]
+	assert.Error(t, cfg.ValidationRoutes())
+}
+
+func TestBackendGroups(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+	stable, canary := newBackend("stable"), newBackend("canary")
+	defer stable.Close()
+	defer canary.Close()
+
+	cfg := &config.Config{Routes: []config.Routing{{
+		UpstreamHTTPMethod:     []string{http.MethodGet},
+		UpstreamPathTemplate:   "/app/{url}",
+		Algorithm:              "round-robin",
+		DownstreamPathTemplate: "/{url}",
+		BackendGroups: []config.BackendGroup{
+			{Name: "stable", Weight: 100, DownstreamHosts: []string{stable.URL}},
+			{Name: "canary", Weight: 0, DownstreamHosts: []string{canary.URL}},
+		},
+	}}}
+	assert.NoError(t, cfg.ValidationRoutes())
+	muxHandler, routes := newTestMux(t, cfg)
+	admin := handler.NewAdminHandler(routes)
+
+	get := func() string {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app/a", nil))
+		return rec.Body.String()
+	}
+	setWeight := func(group string, weight int) int {
+		body := fmt.Sprintf(`{"Route":"/app","Group":%q,"Weight":%d}`, group, weight)
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/groups", strings.NewReader(body)))
+		return rec.Code
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "stable", get())
+	}
+	//通过管理接口将流量全部切换到canary
+	assert.Equal(t, http.StatusOK, setWeight("canary", 100))
+	assert.Equal(t, http.StatusOK, setWeight("stable", 0))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "canary", get())
+	}
+	assert.Equal(t, http.StatusNotFound, setWeight("missing", 1))
+	assert.Equal(t, http.StatusBadRequest, setWeight("canary", 0))
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/groups?route=/app", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"Name":"canary","Weight":100,"Percent":100`)
+
+	cfg.Routes[0].BackendGroups[1].DownstreamHosts = []string{stable.URL}
+	_, _, err := NewMuxHandler(cfg)
+	assert.Error(t, err)
+}
+
+func TestResponseRewrite(t *testing.T) {
+	internal := "http://backend.internal:8080"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			w.Header().Set("Link", "<"+internal+"/style.css>; rel=preload")
+			http.Redirect(w, r, internal+"/login", http.StatusFound)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"self":"`+internal+`/items/1"}`)
+		case "/chunked":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"self":"`)
+			w.(http.Flusher).Flush()
+			_, _ = io.WriteString(w, internal+`/items/2"}`)
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"self":"`+internal+`","padding":"`+strings.Repeat("x", 100)+`"}`)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(w, internal)
+		}
+	}))
+	defer backend.Close()
+
+	route := testRoute("/api", backend.URL)
+	route.ResponseRewrites = []config.ResponseRewrite{
+		{Regex: `^http://backend\.internal:8080`, Replacement: "https://api.example.com"},
+		{Regex: `http://backend\.internal:8080`, Replacement: "https://api.example.com", Headers: []string{"Link"}, ContentTypes: []string{"application/json"}},
+	}
+	route.ResponseRewriteMaxBody = 64
+	cfg := &config.Config{Routes: []config.Routing{route}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	//Location由默认规则改写，Link由指定了Headers的规则改写
+	rec := get("/api/redirect")
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "https://api.example.com/login", rec.Header().Get("Location"))
+	assert.Equal(t, "<https://api.example.com/style.css>; rel=preload", rec.Header().Get("Link"))
+
+	//改写后重新计算Content-Length，长度未知的响应同样改写
+	for path, want := range map[string]string{
+		"/api/json":    `{"self":"https://api.example.com/items/1"}`,
+		"/api/chunked": `{"self":"https://api.example.com/items/2"}`,
+	} {
+		rec = get(path)
+		assert.Equal(t, want, rec.Body.String(), path)
+		assert.Equal(t, strconv.Itoa(len(want)), rec.Header().Get("Content-Length"), path)
+	}
+
+	//超过ResponseRewriteMaxBody及内容类型不匹配的响应原样转发
+	rec = get("/api/large")
+	assert.Contains(t, rec.Body.String(), internal)
+	assert.Len(t, rec.Body.String(), len(`{"self":"`+internal+`","padding":""}`)+100)
+	rec = get("/api/plain")
+	assert.Equal(t, internal, rec.Body.String())
+}
+
+func TestPrecheckBackends(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	down := "127.0.0.1:1"
+
+	cfg := &config.Config{Routes: []config.Routing{testRoute("/api", backend.URL, "http://"+down)}}
+	_, routes := newTestMux(t, cfg)
+
+	assert.Equal(t, []string{down}, routes[0].Precheck())
+	//未开启precheck_fail_fast时只输出警告
+	assert.NoError(t, precheckBackends(routes, false))
+	err := precheckBackends(routes, true)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), down)
+	}
+}
+
+func TestTraceRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer backend.Close()
+
+	var buf bytes.Buffer
+	assert.NoError(t, logging.Configure("DEBUG", &buf, "text"))
+	defer func() {
+		_ = logging.Configure("", nil, "")
+	}()
+
+	cfg := &config.Config{TraceRequests: true, Routes: []config.Routing{{
+		UpstreamHTTPMethod:     []string{http.MethodGet},
+		UpstreamPathTemplate:   "/api/{url}",
+		Algorithm:              "round-robin",
+		DownstreamPathTemplate: "/v2/{url}",
+		DownstreamHosts:        []string{backend.URL},
+	}}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	rec := httptest.NewRecorder()
+	muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users?id=1", nil))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	var line string
+	for _, l := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(l, "请求跟踪") {
+			line = l
+		}
+	}
+	assert.Contains(t, line, "请求: GET /api/users?id=1")
+	assert.Contains(t, line, "负载均衡key: /api/users?id=1")
+	assert.Contains(t, line, "主机: "+strings.TrimPrefix(backend.URL, "http://"))
+	assert.Contains(t, line, "重写路径: /v2/users")
+	assert.Contains(t, line, "状态码: 202")
+}
+
+func TestAdminPprof(t *testing.T) {
+	cfg := &config.Config{Routes: []config.Routing{{
+		UpstreamHTTPMethod:     []string{http.MethodGet},
+		UpstreamPathTemplate:   "/{url}",
+		Algorithm:              "round-robin",
+		DownstreamPathTemplate: "/{url}",
+		DownstreamHosts:        []string{"http://127.0.0.1:1"},
+	}}}
+	muxHandler, routes := newTestMux(t, cfg)
+	admin := handler.NewAdminHandler(routes)
+	get := func(h http.Handler, path, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	//未开启时管理接口不提供pprof
+	assert.Equal(t, http.StatusNotFound, get(admin, "/debug/pprof/", "127.0.0.1:40000"))
+
+	filter, err := pprofFilter(config.Pprof{Enabled: true})
+	assert.NoError(t, err)
+	admin.EnablePprof(filter)
+	assert.Equal(t, http.StatusOK, get(admin, "/debug/pprof/", "127.0.0.1:40000"))
+	assert.Equal(t, http.StatusOK, get(admin, "/debug/pprof/goroutine", "[::1]:40000"))
+	assert.Equal(t, http.StatusOK, get(admin, "/debug/pprof/cmdline", "127.0.0.1:40000"))
+	//默认只允许本机访问
+	assert.Equal(t, http.StatusForbidden, get(admin, "/debug/pprof/heap", "10.0.0.1:40000"))
+	//代理端口不提供pprof，请求按路由转发
+	assert.NotEqual(t, http.StatusOK, get(muxHandler, "/debug/pprof/", "127.0.0.1:40000"))
+
+	_, err = pprofFilter(config.Pprof{Enabled: true, Allow: []string{"not-an-ip"}})
+	assert.Error(t, err)
+}
+
+func TestIsLoopback(t *testing.T) {
+	assert.True(t, isLoopback("127.0.0.1:9091"))
+	assert.True(t, isLoopback("[::1]:9091"))
+	assert.True(t, isLoopback("localhost:9091"))
+	assert.False(t, isLoopback("0.0.0.0:9091"))
+	assert.False(t, isLoopback(":9091"))
+	assert.False(t, isLoopback("10.0.0.1:9091"))
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	entered := make(chan string, 4)
+	release := make(chan struct{})
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entered <- name
+			<-release
+			_, _ = io.WriteString(w, name)
+		}))
+	}
+	a, b := newBackend("a"), newBackend("b")
+	defer a.Close()
+	defer b.Close()
+
+	cfg := &config.Config{MaxConnsPerHost: 1, Routes: []config.Routing{testRoute("/api", a.URL, b.URL)}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/x", nil))
+		return rec
+	}
+	results := make(chan *httptest.ResponseRecorder, 2)
+	//两个请求分别占用两个主机的唯一连接，即使轮询再次选中已占满的主机也会改为选择其他主机
+	for i := 0; i < 2; i++ {
+		go func() { results <- serve() }()
+		<-entered
+	}
+	rec := serve()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	close(release)
+	bodies := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		rec := <-results
+		assert.Equal(t, http.StatusOK, rec.Code)
+		bodies[rec.Body.String()] = true
+	}
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, bodies)
+	assert.Equal(t, http.StatusOK, serve().Code)
+}
+
+func TestStickySession(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name)
+		}))
+	}
+	a, b := newBackend("a"), newBackend("b")
+	defer a.Close()
+	defer b.Close()
+
+	route := testRoute("/app", a.URL, b.URL)
+	route.StickySession = &config.StickySession{Secret: "s3cret", MaxAge: 60}
+	cfg := &config.Config{Routes: []config.Routing{route}}
+	muxHandler, routes := newTestMux(t, cfg)
+
+	get := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/app/x", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec
+	}
+	affinity := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == handler.DefaultStickyCookie {
+				return c
+			}
+		}
+		return nil
+	}
+
+	//首次请求设置Cookie，Cookie中不包含主机地址
+	rec := get(nil)
+	first := rec.Body.String()
+	cookie := affinity(rec)
+	if !assert.NotNil(t, cookie) {
+		return
+	}
+	assert.Equal(t, 60, cookie.MaxAge)
+	assert.True(t, cookie.HttpOnly)
+	assert.NotContains(t, cookie.Value, "127.0.0.1")
+
+	//之后的请求转发到同一主机，不再设置Cookie
+	for i := 0; i < 4; i++ {
+		rec = get(cookie)
+		assert.Equal(t, first, rec.Body.String())
+		assert.Nil(t, affinity(rec))
+	}
+
+	//伪造的Cookie由负载均衡器重新选择主机并设置新的Cookie
+	rec = get(&http.Cookie{Name: handler.DefaultStickyCookie, Value: "forged"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotNil(t, affinity(rec))
+
+	//记录的主机删除后重新选择其他主机
+	removed := a.URL
+	if first == "b" {
+		removed = b.URL
+	}
+	assert.NoError(t, routes[0].RemoveHost(removed))
+	rec = get(cookie)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, first, rec.Body.String())
+	if next := affinity(rec); assert.NotNil(t, next) {
+		assert.NotEqual(t, cookie.Value, next.Value)
+	}
+}
+
+func TestUpstreamContextCancellation(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	upstreamErr := make(chan error, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		select {
+		case <-r.Context().Done():
+			upstreamErr <- r.Context().Err()
+		case <-time.After(5 * time.Second):
+			upstreamErr <- nil
+		}
+	}))
+	defer backend.Close()
+
+	newRoute := func(path string, timeout uint) config.Routing {
+		r := testRoute(path, backend.URL)
+		r.RequestTimeout = timeout
+		return r
+	}
+	cfg := &config.Config{Routes: []config.Routing{newRoute("/slow", 0), newRoute("/timeout", 50)}}
+	muxHandler, _ := newTestMux(t, cfg)
+	proxy := httptest.NewServer(muxHandler)
+	defer proxy.Close()
+
+	//客户端断开后下游请求被取消
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, proxy.URL+"/slow/a", nil)
+	go func() {
+		<-entered
+		cancel()
+	}()
+	_, err := http.DefaultClient.Do(req)
+	assert.Error(t, err)
+	select {
+	case err := <-upstreamErr:
+		assert.Error(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("客户端断开后下游请求未被取消")
+	}
+
+	//超过路由的超时时间后下游请求被取消，客户端收到504
+	start := time.Now()
+	resp, err := http.Get(proxy.URL + "/timeout/a")
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	}
+	<-entered
+	select {
+	case err := <-upstreamErr:
+		assert.Error(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("超时后下游请求未被取消")
+	}
+	assert.Less(t, int64(time.Since(start)), int64(3*time.Second))
+}
+
+func TestMirror(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = io.WriteString(w, "primary:"+string(body))
+	}))
+	defer primary.Close()
+	type mirrored struct {
+		path string
+		body string
+	}
+	received := make(chan mirrored, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- mirrored{path: r.URL.Path, body: string(body)}
+		//镜像主机的慢响应及错误不影响客户端
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	newRoute := func(path, mirror string) config.Routing {
+		r := testRoute(path, primary.URL)
+		r.UpstreamHTTPMethod = []string{http.MethodPost}
+		r.DownstreamPathTemplate = "/v2/{url}"
+		r.Mirror = &config.Mirror{Host: mirror, Percent: 100}
+		return r
+	}
+	cfg := &config.Config{Routes: []config.Routing{
+		newRoute("/shadow", shadow.URL),
+		newRoute("/dead", "http://127.0.0.1:1"),
+	}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	post := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"id":1}`)))
+		return rec
+	}
+	start := time.Now()
+	rec := post("/shadow/orders")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `primary:{"id":1}`, rec.Body.String())
+	assert.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	select {
+	case m := <-received:
+		assert.Equal(t, "/v2/orders", m.path)
+		assert.Equal(t, `{"id":1}`, m.body)
+	case <-time.After(time.Second):
+		t.Fatal("镜像主机未收到请求")
+	}
+
+	rec = post("/dead/orders")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `primary:{"id":1}`, rec.Body.String())
+
+	cfg.Routes[0].Mirror.Percent = 0
+	_, _, err := NewMuxHandler(cfg)
+	assert.Error(t, err)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	backoff := handler.RetryBackoff{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	//每次重试等待时间翻倍，不超过Max
+	expected := []time.Duration{10, 20, 40, 50, 50, 50}
+	for attempt, want := range expected {
+		assert.Equal(t, want*time.Millisecond, backoff.Delay(uint(attempt)), "attempt %d", attempt)
+	}
+	assert.Equal(t, time.Duration(0), handler.RetryBackoff{}.Delay(3))
+	//没有上限时不会溢出
+	assert.Greater(t, int64(handler.RetryBackoff{Base: time.Millisecond}.Delay(100)), int64(time.Hour))
+
+	//随机减少的比例不超过Jitter
+	backoff.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := backoff.Delay(1)
+		assert.GreaterOrEqual(t, int64(d), int64(10*time.Millisecond))
+		assert.LessOrEqual(t, int64(d), int64(20*time.Millisecond))
+		assert.LessOrEqual(t, int64(backoff.Delay(10)), int64(50*time.Millisecond))
+	}
+
+	//两个主机均不可用，重试前等待退避时间；等待会超过请求超时时间时直接返回
+	downA := httptest.NewServer(http.NotFoundHandler())
+	downA.Close()
+	downB := httptest.NewServer(http.NotFoundHandler())
+	downB.Close()
+	newRoute := func(upstream string, timeout uint) config.Routing {
+		r := testRoute(upstream, downA.URL, downB.URL)
+		r.MaxRetries = 1
+		r.RetryBaseDelay = 100
+		r.RequestTimeout = timeout
+		return r
+	}
+	cfg := &config.Config{Routes: []config.Routing{newRoute("/backoff", 0), newRoute("/deadline", 50)}}
+	muxHandler, _ := newTestMux(t, cfg)
+	elapsed := func(path string) time.Duration {
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		return time.Since(start)
+	}
+	assert.GreaterOrEqual(t, int64(elapsed("/backoff/a")), int64(100*time.Millisecond))
+	assert.Less(t, int64(elapsed("/deadline/a")), int64(100*time.Millisecond))
+
+	cfg.Routes[0].RetryJitter = 2
+	_, _, err := NewMuxHandler(cfg)
+	assert.Error(t, err)
+}
+
+//grpcEchoServer 原样返回请求内容的gRPC服务
+type grpcEchoServer struct {
+	grpc_testing.UnimplementedTestServiceServer
+}
+
+func (s *grpcEchoServer) UnaryCall(ctx context.Context, req *grpc_testing.SimpleRequest) (*grpc_testing.SimpleResponse, error) {
+	_ = grpc.SetTrailer(ctx, metadata.Pairs("x-echo-trailer", "done"))
+	if st := req.GetResponseStatus(); st != nil {
+		return nil, status.Error(codes.Code(st.GetCode()), st.GetMessage())
+	}
+	return &grpc_testing.SimpleResponse{Payload: req.GetPayload()}, nil
+}
+
+func TestGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	grpc_testing.RegisterTestServiceServer(server, &grpcEchoServer{})
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	newRoute := func(host string) config.Routing {
+		r := testRoute("/grpc.testing.TestService", host)
+		r.UpstreamHTTPMethod = []string{http.MethodPost}
+		r.DownstreamPathTemplate = "/grpc.testing.TestService/{url}"
+		r.GRPC = true
+		return r
+	}
+	call := func(host string, req *grpc_testing.SimpleRequest) (*grpc_testing.SimpleResponse, metadata.MD, error) {
+		cfg := &config.Config{Routes: []config.Routing{newRoute(host)}}
+		muxHandler, _ := newTestMux(t, cfg)
+		proxy := httptest.NewServer(serverHandler(cfg, muxHandler))
+		defer proxy.Close()
+
+		conn, err := grpc.Dial(strings.TrimPrefix(proxy.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		assert.NoError(t, err)
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var trailer metadata.MD
+		resp, err := grpc_testing.NewTestServiceClient(conn).UnaryCall(ctx, req, grpc.Trailer(&trailer))
+		return resp, trailer, err
+	}
+
+	backend := "http://" + lis.Addr().String()
+	payload := &grpc_testing.Payload{Body: []byte("hello")}
+	resp, trailer, err := call(backend, &grpc_testing.SimpleRequest{Payload: payload})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), resp.GetPayload().GetBody())
+	assert.Equal(t, []string{"done"}, trailer.Get("x-echo-trailer"))
+
+	//下游返回的gRPC错误及trailers原样转发
+	_, trailer, err = call(backend, &grpc_testing.SimpleRequest{
+		ResponseStatus: &grpc_testing.EchoStatus{Code: int32(codes.NotFound), Message: "no such user"},
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "no such user", status.Convert(err).Message())
+	assert.Equal(t, []string{"done"}, trailer.Get("x-echo-trailer"))
+
+	//代理产生的错误以gRPC状态返回
+	_, _, err = call("http://127.0.0.1:1", &grpc_testing.SimpleRequest{Payload: payload})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestGRPCStatusRetry(t *testing.T) {
+	//newBackend 返回指定gRPC状态的h2c下游主机，trailersOnly为false时先返回响应内容，状态在trailers中
+	var hits int32
+	newBackend := func(code string, trailersOnly bool) *httptest.Server {
+		return httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			_, _ = ioutil.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/grpc")
+			if trailersOnly {
+				w.Header().Set("Grpc-Status", code)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Header().Set("Trailer", "Grpc-Status")
+			_, _ = w.Write([]byte("message"))
+			w.Header().Set("Grpc-Status", code)
+		}), &http2.Server{}))
+	}
+	ok := newBackend("0", false)
+	defer ok.Close()
+	unavailable := newBackend("14", true)
+	defer unavailable.Close()
+	invalid := newBackend("3", true)
+	defer invalid.Close()
+	streamFailed := newBackend("14", false)
+	defer streamFailed.Close()
+
+	newRoute := func(path string, hosts ...string) config.Routing {
+		r := testRoute(path, hosts...)
+		r.UpstreamHTTPMethod = []string{http.MethodPost}
+		r.GRPC = true
+		r.MaxRetries = 1
+		r.BufferRequestBody = 1024
+		return r
+	}
+	custom := newRoute("/custom", invalid.URL, ok.URL)
+	custom.RetryOnGRPCStatuses = []int{3}
+	passive := newRoute("/passive", streamFailed.URL, ok.URL)
+	passive.PassiveMaxFails = 1
+	cfg := &config.Config{Routes: []config.Routing{
+		newRoute("/default", unavailable.URL, ok.URL),
+		newRoute("/invalid", invalid.URL, ok.URL),
+		custom,
+		passive,
+	}}
+	muxHandler, routes := newTestMux(t, cfg)
+
+	call := func(path string) string {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("request"))
+		req.Header.Set("Content-Type", "application/grpc")
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		resp := rec.Result()
+		_, _ = ioutil.ReadAll(resp.Body)
+		if code := resp.Header.Get("Grpc-Status"); code != "" {
+			return code
+		}
+		return resp.Trailer.Get("Grpc-Status")
+	}
+	//默认重试UNAVAILABLE，不重试INVALID_ARGUMENT
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "0", call("/default/a"))
+	}
+	codes := map[string]int{}
+	for i := 0; i < 2; i++ {
+		codes[call("/invalid/a")]++
+	}
+	assert.Equal(t, map[string]int{"0": 1, "3": 1}, codes)
+	//需要重试的gRPC状态码可以配置
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "0", call("/custom/a"))
+	}
+
+	//trailers中的状态码已随响应发送给客户端，不重试，但计入被动健康检查的失败次数
+	atomic.StoreInt32(&hits, 0)
+	codes = map[string]int{}
+	for i := 0; i < 2; i++ {
+		codes[call("/passive/a")]++
+	}
+	assert.Equal(t, map[string]int{"0": 1, "14": 1}, codes)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+	assert.True(t, routes[3].IsEjected(strings.TrimPrefix(streamFailed.URL, "http://")))
+	assert.False(t, routes[3].IsEjected(strings.TrimPrefix(ok.URL, "http://")))
+
+	//只有gRPC错误状态码可以配置
+	custom.RetryOnGRPCStatuses = []int{0}
+	assert.Error(t, custom.ValidationRetry())
+}
+
+func TestAllowedMethods(t *testing.T) {
+	var hits int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		_, _ = io.WriteString(w, r.Method)
+	}))
+	defer backend.Close()
+
+	route := testRoute("/public", backend.URL)
+	route.UpstreamHTTPMethod = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodDelete}
+	route.AllowedMethods = []string{"get", http.MethodHead}
+	cfg := &config.Config{Routes: []config.Routing{route}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	rec := do(http.MethodGet, "/public/users/1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.MethodGet, rec.Body.String())
+	assert.Equal(t, http.StatusOK, do(http.MethodHead, "/public/users/1").Code)
+
+	//前缀路由下的任意路径都受限制，被拒绝的请求不转发给下游主机
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		rec = do(method, "/public/users/1/orders")
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code, method)
+		assert.Equal(t, "GET, HEAD", rec.Header().Get("Allow"), method)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	cfg.Routes[0].AllowedMethods = []string{"GET POST"}
+	_, _, err := NewMuxHandler(cfg)
+	assert.Error(t, err)
+}
+
+func TestAdminConfig(t *testing.T) {
+	assert.NoError(t, os.Setenv("PROXY_TEST_JWT_KEY", "jwt-secret"))
+	defer os.Unsetenv("PROXY_TEST_JWT_KEY")
+	dir := t.TempDir()
+	serverFile := filepath.Join(dir, "config.yml")
+	routeFile := filepath.Join(dir, "routing.json")
+	writeRoutes := func(upstream string) {
+		routes := `{"ReRoutes": [{"UpstreamHttpMethod": ["GET"], "UpstreamPathTemplate": "` + upstream + `/{url}", "Algorithm": "round-robin",
+			"DownstreamPathTemplate": "/{url}", "DownstreamHosts": ["http://127.0.0.1:1"],
+			"BasicAuth": {"Users": {"admin": "$2y$05$2C2zo5b0l4AB1x5cBy2nEeTSLDzKZZkv6cpvwzE9r5fsRYc4/bsqG"}}}]}`
+		assert.NoError(t, ioutil.WriteFile(routeFile, []byte(routes), 0644))
+	}
+	assert.NoError(t, ioutil.WriteFile(serverFile, []byte("port: ${PROXY_TEST_PORT:-9090}\nhealth_check: false\nhealth_check_interval: 3\njwt:\n  key: ${PROXY_TEST_JWT_KEY}\n"), 0644))
+	writeRoutes("/old")
+
+	cfg, err := config.Read(true, serverFile, routeFile)
+	assert.NoError(t, err)
+	h, err := newReloadableHandler(cfg, serverFile, routeFile)
+	assert.NoError(t, err)
+	defer h.Stop()
+	admin := handler.NewAdminHandler(h.Routes())
+	admin.Config = func() interface{} {
+		return h.Config().Redacted()
+	}
+
+	get := func() (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+		var body map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+	code, body := get()
+	assert.Equal(t, http.StatusOK, code)
+	//环境变量的默认值及配置的默认值均已生效
+	assert.Equal(t, float64(9090), body["port"])
+	assert.Equal(t, "http", body["schema"])
+	assert.Equal(t, "[REDACTED]", body["jwt"].(map[string]interface{})["key"])
+	route := body["ReRoutes"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "/old/{url}", route["UpstreamPathTemplate"])
+	assert.Equal(t, map[string]interface{}{"admin": "[REDACTED]"}, route["BasicAuth"].(map[string]interface{})["Users"])
+
+	//热加载后输出新的配置
+	writeRoutes("/new")
+	assert.NoError(t, h.Reload())
+	_, body = get()
+	route = body["ReRoutes"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "/new/{url}", route["UpstreamPathTemplate"])
+}
+
+func TestRouteRateLimit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	newRoute := func(path string, rps, burst uint) config.Routing {
+		r := testRoute(path, backend.URL)
+		r.RateLimitRPS = rps
+		r.RateLimitBurst = burst
+		return r
+	}
+	cfg := &config.Config{Routes: []config.Routing{newRoute("/limited", 1, 2), newRoute("/open", 0, 0)}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusOK, get("/limited/a", "10.0.0.1:40000").Code)
+	assert.Equal(t, http.StatusOK, get("/limited/b", "10.0.0.1:40000").Code)
+	rec := get("/limited/c", "10.0.0.1:40000")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	//其他客户端及其他路由不受影响
+	assert.Equal(t, http.StatusOK, get("/limited/a", "10.0.0.2:40000").Code)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, get("/open/a", "10.0.0.1:40000").Code)
+	}
+}
+
+func TestHealthCheckType(t *testing.T) {
+	//TCP端口可以连接，但HTTP健康检查返回503
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+	//只接受连接、不处理HTTP的Unix域套接字服务
+	socket := filepath.Join(t.TempDir(), "raw.sock")
+	lis, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	newRoute := func(path, checkType string, hosts ...string) config.Routing {
+		r := testRoute(path, hosts...)
+		r.HealthCheckType = checkType
+		return r
+	}
+	unhealthyHost := strings.TrimPrefix(unhealthy.URL, "http://")
+	cfg := &config.Config{HealthCheck: true, HealthCheckInterval: 60, Routes: []config.Routing{
+		newRoute("/default", "", unhealthy.URL, "unix://"+socket),
+		newRoute("/tcp", "tcp", unhealthy.URL),
+		newRoute("/http", "HTTP", "unix://"+socket),
+	}}
+	_, routes := newTestMux(t, cfg)
+
+	//http主机默认使用http健康检查，Unix域套接字主机默认只检查连接
+	assert.Equal(t, []string{unhealthyHost}, routes[0].Precheck())
+	assert.Empty(t, routes[1].Precheck())
+	assert.Equal(t, []string{util.UnixSocketPrefix + socket}, routes[2].Precheck())
+
+	cfg.Routes = []config.Routing{newRoute("/bad", "icmp", unhealthy.URL)}
+	_, _, err = NewMuxHandler(cfg)
+	assert.Error(t, err)
+	tcpWithPath := newRoute("/bad", "tcp", unhealthy.URL)
+	tcpWithPath.HealthCheckPath = "/healthz"
+	cfg.Routes = []config.Routing{tcpWithPath}
+	_, _, err = NewMuxHandler(cfg)
+	assert.Error(t, err)
+}
+
+func TestRequestSizeLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	cfg := &config.Config{MaxHeaderBytes: 1024, MaxURLLength: 64, Routes: []config.Routing{testRoute("/api", backend.URL)}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	//先获取一个空闲端口再启动服务
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := lis.Addr().String()
+	_ = lis.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serveListeners(ctx, []config.Listener{{Address: addr, Schema: "http"}}, config.TLS{}, muxHandler, cfg.MaxHeaderBytes, time.Second)
+	}()
+	//不复用连接，避免空闲的keep-alive连接导致关闭服务时等待超时
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	defer func() {
+		client.CloseIdleConnections()
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	get := func(path string, header string) int {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+		assert.NoError(t, err)
+		if header != "" {
+			req.Header.Set("X-Large", header)
+		}
+		resp, err := client.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, get("/api/a?q=1", ""))
+	assert.Equal(t, http.StatusRequestURITooLong, get("/api/a?q="+strings.Repeat("x", 64), ""))
+	//net/http在MaxHeaderBytes之外还允许4096字节的缓冲
+	assert.Equal(t, http.StatusOK, get("/api/a", strings.Repeat("x", 512)))
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, get("/api/a", strings.Repeat("x", 16384)))
+}
+
+func TestRetryOnStatuses(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer ok.Close()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, "maintenance")
+	}))
+	defer unavailable.Close()
+	unavailable2 := httptest.NewServer(unavailable.Config.Handler)
+	defer unavailable2.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	newRoute := func(path string, statuses []int, hosts ...string) config.Routing {
+		r := testRoute(path, hosts...)
+		r.MaxRetries = 1
+		r.RetryOnStatuses = statuses
+		return r
+	}
+	timeout := newRoute("/timeout", nil, slow.URL, ok.URL)
+	timeout.ResponseHeaderTimeout = 50
+	cfg := &config.Config{Routes: []config.Routing{
+		newRoute("/default", nil, unavailable.URL, ok.URL),
+		newRoute("/status", []int{http.StatusServiceUnavailable}, unavailable.URL, ok.URL),
+		newRoute("/exhausted", []int{http.StatusServiceUnavailable}, unavailable.URL, unavailable2.URL),
+		timeout,
+	}}
+	muxHandler, _ := newTestMux(t, cfg)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		muxHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	//轮询依次选择两个主机，默认不重试下游主机返回的5xx
+	codes := map[int]int{}
+	for i := 0; i < 2; i++ {
+		codes[get("/default/a").Code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusOK: 1, http.StatusServiceUnavailable: 1}, codes)
+
+	for i := 0; i < 2; i++ {
+		rec := get("/status/a")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "ok", rec.Body.String())
+		rec = get("/timeout/a")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "ok", rec.Body.String())
+	}
+	//最后一次尝试的响应原样返回
+	for i := 0; i < 2; i++ {
+		rec := get("/exhausted/a")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "maintenance", rec.Body.String())
+	}
+
+	cfg.Routes = []config.Routing{newRoute("/bad", []int{700}, ok.URL)}
+	_, _, err := NewMuxHandler(cfg)
+	assert.Error(t, err)
+}
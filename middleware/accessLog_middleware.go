@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"proxy/util"
+	"proxy/util/logging"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//AccessLogJSON JSON格式的访问日志
+	AccessLogJSON = "json"
+	//AccessLogLogfmt logfmt格式(key=value)的访问日志
+	AccessLogLogfmt = "logfmt"
+)
+
+//accessLogEntry 一条访问日志
+type accessLogEntry struct {
+	Time       string `json:"time"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	ClientIP   string `json:"client_ip"`
+	Upstream   string `json:"upstream"`
+	Status     int    `json:"status"`
+	Size       int64  `json:"size"`
+	DurationMs int64  `json:"duration_ms"`
+	RequestID  string `json:"request_id,omitempty"`
+	ClientID   string `json:"client_id,omitempty"`
+}
+
+//AccessLogMiddleware 记录每个请求的访问日志：请求方法、路径、客户端IP、下游主机、状态码、响应大小及耗时，
+//format 为json或logfmt，为空时使用json
+func AccessLogMiddleware(format string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r, holder := withUpstreamHolder(r)
+			rec := newResponseRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			entry := accessLogEntry{
+				Time:       start.Format(time.RFC3339),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				ClientIP:   util.GetIP(r),
+				Upstream:   holder.get(),
+				Status:     rec.status,
+				Size:       rec.size,
+				DurationMs: time.Since(start).Milliseconds(),
+				RequestID:  r.Header.Get(util.XRequestID),
+				ClientID:   holder.getClientID(),
+			}
+			if id, ok := RequestIDFromContext(r.Context()); ok {
+				entry.RequestID = id
+			}
+			if format == AccessLogLogfmt {
+				logging.Info(entry.logfmt())
+			} else {
+				logging.Info(entry.json())
+			}
+		})
+	}
+}
+
+func (e *accessLogEntry) json() string {
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+func (e *accessLogEntry) logfmt() string {
+	fields := []string{
+		"time=" + logfmtValue(e.Time),
+		"method=" + logfmtValue(e.Method),
+		"path=" + logfmtValue(e.Path),
+		"client_ip=" + logfmtValue(e.ClientIP),
+		"upstream=" + logfmtValue(e.Upstream),
+		"status=" + strconv.Itoa(e.Status),
+		"size=" + strconv.FormatInt(e.Size, 10),
+		"duration_ms=" + strconv.FormatInt(e.DurationMs, 10),
+	}
+	if e.RequestID != "" {
+		fields = append(fields, "request_id="+logfmtValue(e.RequestID))
+	}
+	if e.ClientID != "" {
+		fields = append(fields, "client_id="+logfmtValue(e.ClientID))
+	}
+	return strings.Join(fields, " ")
+}
+
+//logfmtValue 值为空或包含空格、引号、等号时加引号
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \"=\t\n") {
+		return strconv.Quote(v)
+	}
+	return v
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"proxy/util"
+	"proxy/util/logging"
+	"strings"
+)
+
+//AllowedMethodsMiddleware 只允许指定方法的请求，其它方法返回405并在Allow响应头中列出允许的方法
+//与路由匹配的UpstreamHttpMethod无关，前缀路由下的所有路径同样生效；HEAD需要单独列出，methods为空时不限制
+func AllowedMethodsMiddleware(methods []string) func(next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(methods))
+	list := make([]string, 0, len(methods))
+	for _, m := range methods {
+		m = strings.ToUpper(m)
+		if !allowed[m] {
+			allowed[m] = true
+			list = append(list, m)
+		}
+	}
+	allow := strings.Join(list, ", ")
+
+	return func(next http.Handler) http.Handler {
+		if len(allowed) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allowed[r.Method] {
+				logging.Warnf("[%s]请求%s 的方法 %s 不被允许", util.GetIP(r), r.URL.Path, r.Method)
+				w.Header().Set("Allow", allow)
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"proxy/util/logging"
+)
+
+//DefaultAPIKeyHeader 未配置时携带API Key的请求头
+const DefaultAPIKeyHeader = "X-API-Key"
+
+type clientIDKey struct{}
+
+//APIKeyOptions API Key认证配置
+type APIKeyOptions struct {
+	//Header 携带API Key的请求头，默认为X-API-Key
+	Header string
+	//QueryParam 携带API Key的查询参数，为空时只从请求头读取
+	QueryParam string
+	//Keys API Key及其对应的客户端标识
+	Keys map[string]string
+}
+
+//APIKeyMiddleware 校验请求头或查询参数中的API Key，缺少或无效时返回401
+//认证通过后将客户端标识保存到请求上下文中，并从请求中删除API Key，避免转发给下游主机
+func APIKeyMiddleware(opts APIKeyOptions) (func(next http.Handler) http.Handler, error) {
+	if len(opts.Keys) == 0 {
+		return nil, errors.New("API Key认证至少要配置一个Key")
+	}
+	header := opts.Header
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+	keys := make(map[string][]byte, len(opts.Keys))
+	for key := range opts.Keys {
+		if key == "" {
+			return nil, errors.New("API Key不能为空")
+		}
+		keys[key] = []byte(key)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided := r.Header.Get(header)
+			fromQuery := false
+			if provided == "" && opts.QueryParam != "" {
+				provided = r.URL.Query().Get(opts.QueryParam)
+				fromQuery = provided != ""
+			}
+			if provided == "" {
+				http.Error(w, "缺少API Key", http.StatusUnauthorized)
+				return
+			}
+			//与所有Key进行常量时间比较，避免通过响应时间猜测Key
+			clientID, ok := "", false
+			for key, b := range keys {
+				if subtle.ConstantTimeCompare(b, []byte(provided)) == 1 {
+					clientID, ok = opts.Keys[key], true
+				}
+			}
+			if !ok {
+				logging.Debugf("[%v]请求%s API Key无效", r.RemoteAddr, r.URL.Path)
+				http.Error(w, "API Key无效", http.StatusUnauthorized)
+				return
+			}
+
+			r.Header.Del(header)
+			if fromQuery {
+				query := r.URL.Query()
+				query.Del(opts.QueryParam)
+				r.URL.RawQuery = query.Encode()
+			}
+			setClientID(r.Context(), clientID)
+			r = r.WithContext(context.WithValue(r.Context(), clientIDKey{}, clientID))
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+//ClientIDFromContext 获取API Key认证通过的客户端标识
+func ClientIDFromContext(ctx context.Context) (string, bool) {
+	clientID, ok := ctx.Value(clientIDKey{}).(string)
+	return clientID, ok
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"proxy/util/logging"
+	"strconv"
+)
+
+//defaultBasicAuthRealm 未配置Realm时WWW-Authenticate中的realm
+const defaultBasicAuthRealm = "proxy"
+
+//BasicAuthOptions Basic认证配置
+type BasicAuthOptions struct {
+	//Realm WWW-Authenticate中的realm，默认为proxy
+	Realm string
+	//Users 用户名及bcrypt哈希后的密码
+	Users map[string]string
+}
+
+//BasicAuthMiddleware 校验请求头Authorization中的Basic用户名及密码，密码使用bcrypt哈希比较
+//认证失败时返回401及WWW-Authenticate，认证通过后将用户名作为subject保存到请求上下文中
+func BasicAuthMiddleware(opts BasicAuthOptions) (func(next http.Handler) http.Handler, error) {
+	if len(opts.Users) == 0 {
+		return nil, errors.New("Basic认证至少要配置一个用户")
+	}
+	users := make(map[string][]byte, len(opts.Users))
+	cost := bcrypt.MinCost
+	for name, hash := range opts.Users {
+		c, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return nil, fmt.Errorf("用户 %s 的密码不是有效的bcrypt哈希: %s", name, err)
+		}
+		if c > cost {
+			cost = c
+		}
+		users[name] = []byte(hash)
+	}
+	realm := opts.Realm
+	if realm == "" {
+		realm = defaultBasicAuthRealm
+	}
+	challenge := "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+	//用户不存在时与相同cost的哈希比较，使响应时间与密码错误时一致，避免通过响应时间探测用户名
+	dummy, err := bcrypt.GenerateFromPassword([]byte("dummy"), cost)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			if !ok {
+				basicUnauthorized(w, challenge)
+				return
+			}
+			//用户名使用常量时间比较，bcrypt比较密码本身也是常量时间的
+			hash, exists := dummy, false
+			for name, h := range users {
+				if subtle.ConstantTimeCompare([]byte(name), []byte(username)) == 1 {
+					hash, exists = h, true
+				}
+			}
+			if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !exists {
+				logging.Debugf("[%v]请求%s 用户 %s Basic认证失败", r.RemoteAddr, r.URL.Path, username)
+				basicUnauthorized(w, challenge)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), authSubjectKey{}, username))
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+//basicUnauthorized 返回401，要求客户端提供Basic认证信息
+func basicUnauthorized(w http.ResponseWriter, challenge string) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
@@ -0,0 +1,400 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//DefaultCacheMaxEntries 默认缓存的最大响应数
+	DefaultCacheMaxEntries = 1000
+	//DefaultCacheTTL 响应未指定max-age时默认的缓存时间
+	DefaultCacheTTL = 60 * time.Second
+	//DefaultCacheMaxBodySize 默认可缓存的最大响应内容字节数
+	DefaultCacheMaxBodySize = 1 << 20
+)
+
+//XCache 标识响应是否来自缓存的响应头，值为HIT或MISS
+const XCache = "X-Cache"
+
+//CacheOptions 响应缓存配置
+type CacheOptions struct {
+	//MaxEntries 缓存的最大响应数，超过时淘汰最久未使用的响应
+	MaxEntries int
+	//DefaultTTL 响应未通过Cache-Control指定max-age时的缓存时间
+	DefaultTTL time.Duration
+	//MaxBodySize 可缓存的最大响应内容字节数，超过的响应(包括流式响应)直接转发，不缓存；同时限制计算POST请求缓存key时读取的请求内容
+	MaxBodySize int
+}
+
+//defaultCacheMethods 未配置时可以缓存的请求方法
+var defaultCacheMethods = []string{http.MethodGet, http.MethodHead}
+
+//cacheableStatuses 可以缓存的响应状态码
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+//ResponseCache 在内存中缓存响应，按请求方法、Host、路径及查询参数区分，使用LRU淘汰，所有路由共用同一个缓存
+//遵循响应的Cache-Control(no-store、no-cache、private、max-age、s-maxage)及Vary，
+//命中时返回缓存的响应并设置Age及X-Cache: HIT，未命中时设置X-Cache: MISS，携带认证信息的请求不使用缓存
+type ResponseCache struct {
+	opts  CacheOptions
+	store *lruCache
+}
+
+//NewResponseCache 创建响应缓存，未配置的选项使用默认值
+func NewResponseCache(opts CacheOptions) *ResponseCache {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultCacheMaxEntries
+	}
+	if opts.DefaultTTL <= 0 {
+		opts.DefaultTTL = DefaultCacheTTL
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultCacheMaxBodySize
+	}
+	return &ResponseCache{opts: opts, store: newLRUCache(opts.MaxEntries)}
+}
+
+//CacheMiddleware 创建响应缓存并返回只缓存GET及HEAD请求的缓存中间件
+func CacheMiddleware(opts CacheOptions) func(next http.Handler) http.Handler {
+	return NewResponseCache(opts).Middleware(nil)
+}
+
+//Middleware 创建使用该缓存的中间件，methods为可缓存的请求方法，为空时缓存GET及HEAD请求
+//POST请求按Content-Type及请求内容的SHA-256区分，请求内容超过MaxBodySize时不缓存；
+//POST请求的响应只有通过Cache-Control明确指定max-age或s-maxage时才缓存，不使用DefaultTTL，避免缓存写操作的响应
+func (c *ResponseCache) Middleware(methods []string) func(next http.Handler) http.Handler {
+	if len(methods) == 0 {
+		methods = defaultCacheMethods
+	}
+	cacheable := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		cacheable[m] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			//携带认证信息的请求响应因人而异，协议升级的请求无法缓存
+			if !cacheable[r.Method] || hasCredentials(r) || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			reqDirectives := parseCacheControl(r.Header.Get("Cache-Control"))
+			if _, ok := reqDirectives["no-store"]; ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := r.Method + " " + r.Host + r.URL.RequestURI()
+			defaultTTL := c.opts.DefaultTTL
+			if r.Method == http.MethodPost {
+				var suffix string
+				var ok bool
+				if r, suffix, ok = c.bodyKey(r); !ok {
+					next.ServeHTTP(w, r)
+					return
+				}
+				key += suffix
+				defaultTTL = 0
+			}
+			//no-cache要求重新向下游主机获取，获取后仍然可以缓存
+			if _, ok := reqDirectives["no-cache"]; !ok {
+				if entry := c.store.get(key, r); entry != nil {
+					entry.serve(w)
+					return
+				}
+			}
+
+			w.Header().Set(XCache, "MISS")
+			cw := &cacheWriter{ResponseWriter: w, status: http.StatusOK, maxBody: c.opts.MaxBodySize}
+			next.ServeHTTP(cw, r)
+			if entry := cw.entry(r, defaultTTL); entry != nil {
+				c.store.set(key, entry)
+			}
+		})
+	}
+}
+
+//bodyKey 读取不超过MaxBodySize的请求内容，返回由Content-Type及内容的SHA-256组成的缓存key后缀
+//读取后重新设置请求内容，转发时发送完整的请求内容；请求内容超过限制或读取失败时返回false，不使用缓存
+func (c *ResponseCache) bodyKey(r *http.Request) (*http.Request, string, bool) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > int64(c.opts.MaxBodySize) {
+			return r, "", false
+		}
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, int64(c.opts.MaxBodySize)+1))
+		if err != nil || len(body) > c.opts.MaxBodySize {
+			//已读取的部分重新放回请求内容，未读取的部分及读取错误交给下游处理
+			r.Body = &teeReadCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+			return r, "", false
+		}
+		_ = r.Body.Close()
+		r = r.Clone(r.Context())
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		r.ContentLength = int64(len(body))
+	}
+	sum := sha256.Sum256(body)
+	return r, " " + r.Header.Get("Content-Type") + " " + hex.EncodeToString(sum[:]), true
+}
+
+//hasCredentials 判断请求是否携带认证信息：Authorization请求头、通过认证的API Key或客户端证书，
+//API Key在认证后会从请求中删除，需要通过认证中间件保存在context中的客户端标识判断
+func hasCredentials(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" {
+		return true
+	}
+	if _, ok := ClientIDFromContext(r.Context()); ok {
+		return true
+	}
+	if _, ok := AuthSubjectFromContext(r.Context()); ok {
+		return true
+	}
+	return r.TLS != nil && len(r.TLS.PeerCertificates) > 0
+}
+
+//cacheEntry 缓存的响应
+type cacheEntry struct {
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+	//vary 响应Vary声明的请求头及缓存时请求中的值
+	vary map[string]string
+}
+
+//matches 判断请求声明的Vary请求头与缓存时的请求是否一致
+func (e *cacheEntry) matches(r *http.Request) bool {
+	for name, value := range e.vary {
+		if strings.Join(r.Header.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+//serve 输出缓存的响应，Age为响应缓存的秒数
+func (e *cacheEntry) serve(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range e.header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(e.stored)/time.Second)))
+	h.Set(XCache, "HIT")
+	w.WriteHeader(e.status)
+	_, _ = w.Write(e.body)
+}
+
+//lruCache 线程安全的LRU响应缓存
+type lruCache struct {
+	mux        sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type cacheItem struct {
+	key   string
+	entry *cacheEntry
+}
+
+func newLRUCache(maxEntries int) *lruCache {
+	return &lruCache{maxEntries: maxEntries, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+//get 获取未过期且Vary请求头一致的缓存，过期的缓存直接删除
+func (c *lruCache) get(key string, r *http.Request) *cacheEntry {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*cacheItem).entry
+	if time.Now().After(entry.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil
+	}
+	if !entry.matches(r) {
+		return nil
+	}
+	c.ll.MoveToFront(el)
+	return entry
+}
+
+//set 保存缓存，超过最大数量时淘汰最久未使用的缓存
+func (c *lruCache) set(key string, entry *cacheEntry) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheItem).entry = entry
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheItem{key: key, entry: entry})
+	for c.ll.Len() > c.maxEntries {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheItem).key)
+	}
+}
+
+//parseCacheControl 解析Cache-Control，返回指令及其值(没有值时为空字符串)
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, arg = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), "\"")
+		}
+		directives[strings.ToLower(strings.TrimSpace(name))] = arg
+	}
+	return directives
+}
+
+//responseTTL 根据响应的Cache-Control计算缓存时间，返回0表示不缓存
+func responseTTL(h http.Header, defaultTTL time.Duration) time.Duration {
+	directives := parseCacheControl(h.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[d]; ok {
+			return 0
+		}
+	}
+	//共享缓存优先使用s-maxage
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[d]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultTTL
+}
+
+//cacheWriter 转发响应的同时复制响应内容用于缓存，超过maxBody后停止复制并放弃缓存
+type cacheWriter struct {
+	http.ResponseWriter
+	status        int
+	headerWritten bool
+	//header 写入响应头时的快照
+	header  http.Header
+	body    bytes.Buffer
+	maxBody int
+	//skip 响应不可缓存，不再复制响应内容
+	skip bool
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.headerWritten {
+		return
+	}
+	cw.headerWritten = true
+	cw.status = status
+	cw.header = cw.Header().Clone()
+	//Content-Length超过限制及事件流等流式响应直接转发
+	if n, err := strconv.ParseInt(cw.header.Get("Content-Length"), 10, 64); err == nil && n > int64(cw.maxBody) {
+		cw.skip = true
+	}
+	if strings.HasPrefix(cw.header.Get("Content-Type"), "text/event-stream") {
+		cw.skip = true
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if !cw.headerWritten {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.skip {
+		if cw.body.Len()+len(b) > cw.maxBody {
+			cw.skip = true
+			cw.body = bytes.Buffer{}
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+//entry 响应结束后生成缓存，响应不可缓存时返回nil
+func (cw *cacheWriter) entry(r *http.Request, defaultTTL time.Duration) *cacheEntry {
+	if cw.skip || !cw.headerWritten || !cacheableStatuses[cw.status] || cw.header.Get("Set-Cookie") != "" {
+		return nil
+	}
+	ttl := responseTTL(cw.header, defaultTTL)
+	if ttl <= 0 {
+		return nil
+	}
+	vary := make(map[string]string)
+	for _, v := range cw.header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				vary[http.CanonicalHeaderKey(name)] = strings.Join(r.Header.Values(name), ",")
+			}
+		}
+	}
+	cw.header.Del(XCache)
+	now := time.Now()
+	return &cacheEntry{
+		status:  cw.status,
+		header:  cw.header,
+		body:    cw.body.Bytes(),
+		stored:  now,
+		expires: now.Add(ttl),
+		vary:    vary,
+	}
+}
+
+func (cw *cacheWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *cacheWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not implement http.Hijacker")
+	}
+	//连接被接管后无法缓存响应
+	cw.skip = true
+	return h.Hijack()
+}
+
+//Unwrap 供http.ResponseController获取原始的ResponseWriter
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
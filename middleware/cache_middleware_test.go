@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCache_ClientCertificate(t *testing.T) {
+	var hits int32
+	h := CacheMiddleware(CacheOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=30")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	get := func(cert bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/a", nil)
+		if cert {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	//携带客户端证书的请求既不使用也不写入缓存
+	assert.Empty(t, get(true).Header().Get(XCache))
+	assert.Equal(t, "MISS", get(false).Header().Get(XCache))
+	assert.Empty(t, get(true).Header().Get(XCache))
+	assert.Equal(t, "HIT", get(false).Header().Get(XCache))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+}
+
+func TestCache_PostBodyKey(t *testing.T) {
+	var hits int32
+	h := NewResponseCache(CacheOptions{MaxBodySize: 64}).Middleware([]string{http.MethodGet, http.MethodPost})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path != "/nocache" {
+			w.Header().Set("Cache-Control", "max-age=30")
+		}
+		_, _ = w.Write(body)
+	}))
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "https://example.com"+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	//请求内容不同的POST请求不会命中彼此的缓存
+	rec := post("/graphql", `{"query":"a"}`)
+	assert.Equal(t, "MISS", rec.Header().Get(XCache))
+	assert.Equal(t, `{"query":"a"}`, rec.Body.String())
+	rec = post("/graphql", `{"query":"b"}`)
+	assert.Equal(t, "MISS", rec.Header().Get(XCache))
+	assert.Equal(t, `{"query":"b"}`, rec.Body.String())
+
+	//请求内容相同的POST请求命中缓存
+	rec = post("/graphql", `{"query":"a"}`)
+	assert.Equal(t, "HIT", rec.Header().Get(XCache))
+	assert.Equal(t, `{"query":"a"}`, rec.Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	//下游主机未指定max-age时不缓存POST请求的响应
+	post("/nocache", "x")
+	assert.NotEqual(t, "HIT", post("/nocache", "x").Header().Get(XCache))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&hits))
+
+	//请求内容超过MaxBodySize时不缓存，下游主机收到完整的请求内容
+	large := strings.Repeat("a", 100)
+	rec = post("/graphql", large)
+	assert.Empty(t, rec.Header().Get(XCache))
+	assert.Equal(t, large, rec.Body.String())
+	rec = post("/graphql", large)
+	assert.Empty(t, rec.Header().Get(XCache))
+	assert.Equal(t, int32(6), atomic.LoadInt32(&hits))
+
+	//GET请求仍按URL缓存
+	assert.Equal(t, "MISS", post("/a", "").Header().Get(XCache))
+}
+
+func TestCache_Methods(t *testing.T) {
+	var hits int32
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=30")
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(h http.Handler, method string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "https://example.com/a", strings.NewReader("q")))
+		return rec.Header().Get(XCache)
+	}
+
+	//默认缓存GET及HEAD请求，不缓存POST请求
+	h := CacheMiddleware(CacheOptions{})(backend)
+	assert.Equal(t, "MISS", serve(h, http.MethodHead))
+	assert.Equal(t, "HIT", serve(h, http.MethodHead))
+	assert.Empty(t, serve(h, http.MethodPost))
+	assert.Empty(t, serve(h, http.MethodPost))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+
+	//只配置POST时不缓存GET请求
+	h = NewResponseCache(CacheOptions{}).Middleware([]string{http.MethodPost})(backend)
+	assert.Empty(t, serve(h, http.MethodGet))
+	assert.Equal(t, "MISS", serve(h, http.MethodPost))
+	assert.Equal(t, "HIT", serve(h, http.MethodPost))
+}
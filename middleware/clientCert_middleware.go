@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"proxy/util/logging"
+)
+
+//ClientCertOptions 客户端证书认证配置
+type ClientCertOptions struct {
+	//AllowedSubjects 允许访问的证书主体，与证书的CN或SAN(DNS、邮箱、URI)中的任意一个相同即可，为空时允许所有通过校验的证书
+	AllowedSubjects []string
+}
+
+//ClientCertMiddleware 校验TLS握手时客户端提供并通过CA校验的证书，证书主体需要在AllowedSubjects中
+//没有通过校验的证书时返回401，证书主体不在AllowedSubjects中时返回403，认证通过后将证书主体作为subject保存到请求上下文中
+func ClientCertMiddleware(opts ClientCertOptions) func(next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(opts.AllowedSubjects))
+	for _, subject := range opts.AllowedSubjects {
+		allowed[subject] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			//VerifiedChains只有在tls.client_auth为verify_if_given或require_and_verify且证书通过CA校验时才不为空
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+				logging.Debugf("[%v]请求%s 没有通过校验的客户端证书", r.RemoteAddr, r.URL.Path)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			cert := r.TLS.VerifiedChains[0][0]
+			subject, ok := certSubject(cert, allowed)
+			if !ok {
+				logging.Debugf("[%v]请求%s 客户端证书 %s 不在允许的主体中", r.RemoteAddr, r.URL.Path, cert.Subject.CommonName)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), authSubjectKey{}, subject))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//certSubject 获取证书中在allowed中的主体，依次检查CN及SAN，allowed为空时返回CN
+func certSubject(cert *x509.Certificate, allowed map[string]bool) (string, bool) {
+	if len(allowed) == 0 {
+		return cert.Subject.CommonName, true
+	}
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	for _, name := range names {
+		if name != "" && allowed[name] {
+			return name, true
+		}
+	}
+	return "", false
+}
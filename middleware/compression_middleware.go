@@ -0,0 +1,245 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//DefaultCompressionMinSize 默认压缩的最小响应大小(字节)
+const DefaultCompressionMinSize = 1024
+
+//incompressibleTypes 已压缩或压缩效果差的内容类型前缀，以及需要实时推送的流式内容
+var incompressibleTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2",
+	"application/x-7z-compressed", "application/x-rar-compressed", "application/zstd",
+	"application/octet-stream", "application/pdf", "application/grpc",
+	"text/event-stream",
+}
+
+var (
+	gzipPool = sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}}
+	flatePool = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+)
+
+//CompressionMiddleware 根据客户端的Accept-Encoding使用gzip或deflate压缩响应
+//已经过压缩(携带Content-Encoding)、不适合压缩的内容类型及小于minSize字节的响应不压缩，minSize为0时使用默认值1024
+func CompressionMiddleware(minSize int) func(next http.Handler) http.Handler {
+	if minSize <= 0 {
+		minSize = DefaultCompressionMinSize
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize, status: http.StatusOK}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+//negotiateEncoding 根据Accept-Encoding选择压缩算法，优先使用gzip，不支持时返回空字符串
+func negotiateEncoding(accept string) string {
+	var gzipOK, deflateOK bool
+	for _, part := range strings.Split(accept, ",") {
+		name, q := part, ""
+		if i := strings.Index(part, ";"); i >= 0 {
+			name, q = part[:i], strings.TrimSpace(part[i+1:])
+		}
+		//q=0表示客户端不接受该编码
+		if strings.HasPrefix(q, "q=") {
+			if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip", "*":
+			gzipOK = true
+		case "deflate":
+			deflateOK = true
+		}
+	}
+	if gzipOK {
+		return "gzip"
+	}
+	if deflateOK {
+		return "deflate"
+	}
+	return ""
+}
+
+//compressible 根据响应头判断是否可以压缩
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return false
+		}
+	}
+	return true
+}
+
+//compressWriter 缓存响应内容直到超过minSize后再决定是否压缩的ResponseWriter
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+	//headerWritten 是否已调用WriteHeader
+	headerWritten bool
+	//decided 是否已决定是否压缩，决定后不再缓存
+	decided bool
+	buf     bytes.Buffer
+	//compressor 为nil时表示不压缩
+	compressor io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.headerWritten {
+		return
+	}
+	cw.headerWritten = true
+	cw.status = status
+	//没有响应内容的状态码不需要压缩
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.passThrough(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.headerWritten {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		h := cw.Header()
+		if !compressible(h) {
+			cw.passThrough(false)
+		} else if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < cw.minSize {
+			cw.passThrough(true)
+		} else {
+			cw.buf.Write(b)
+			if cw.buf.Len() >= cw.minSize {
+				cw.startCompression()
+			}
+			return len(b), nil
+		}
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+//passThrough 不压缩，写入响应头及已缓存的内容，vary表示响应内容较小时才不压缩，缓存需要区分Accept-Encoding
+func (cw *compressWriter) passThrough(vary bool) {
+	cw.decided = true
+	if vary {
+		cw.Header().Add("Vary", "Accept-Encoding")
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if cw.buf.Len() > 0 {
+		_, _ = cw.ResponseWriter.Write(cw.buf.Bytes())
+		cw.buf.Reset()
+	}
+}
+
+//startCompression 开始压缩，写入压缩后的响应头及已缓存的内容
+func (cw *compressWriter) startCompression() {
+	cw.decided = true
+	h := cw.Header()
+	h.Set("Content-Encoding", cw.encoding)
+	h.Add("Vary", "Accept-Encoding")
+	h.Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if cw.encoding == "gzip" {
+		gw := gzipPool.Get().(*gzip.Writer)
+		gw.Reset(cw.ResponseWriter)
+		cw.compressor = gw
+	} else {
+		fw := flatePool.Get().(*flate.Writer)
+		fw.Reset(cw.ResponseWriter)
+		cw.compressor = fw
+	}
+	_, _ = cw.compressor.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+}
+
+//Close 结束响应，小于minSize的响应原样写入，压缩时写入剩余的压缩数据
+func (cw *compressWriter) Close() {
+	if !cw.decided {
+		if !cw.headerWritten {
+			//没有写入任何内容的响应由http.Server发送默认的响应头
+			return
+		}
+		cw.passThrough(true)
+		return
+	}
+	if cw.compressor == nil {
+		return
+	}
+	_ = cw.compressor.Close()
+	switch c := cw.compressor.(type) {
+	case *gzip.Writer:
+		gzipPool.Put(c)
+	case *flate.Writer:
+		flatePool.Put(c)
+	}
+	cw.compressor = nil
+}
+
+//Flush 流式响应无法预知大小，需要立即发送时可以压缩的内容直接开始压缩，并刷新已压缩的数据
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if !cw.headerWritten {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if !cw.decided {
+			if compressible(cw.Header()) {
+				cw.startCompression()
+			} else {
+				cw.passThrough(false)
+			}
+		}
+	}
+	if f, ok := cw.compressor.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not implement http.Hijacker")
+	}
+	return h.Hijack()
+}
+
+// Unwrap 供http.ResponseController获取原始的ResponseWriter
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//defaultCORSMethods 未配置AllowedMethods时允许的请求方法
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+//CORSOptions 跨域资源共享配置
+type CORSOptions struct {
+	//AllowedOrigins 允许的来源，"*"表示允许所有来源，"*.example.com"表示允许example.com的所有子域名
+	AllowedOrigins []string
+	//AllowedMethods 允许的请求方法，为空时允许常用的请求方法
+	AllowedMethods []string
+	//AllowedHeaders 允许的请求头，为空时允许预检请求中声明的所有请求头
+	AllowedHeaders []string
+	//MaxAge 预检请求结果的缓存时间(秒)，为0时不设置
+	MaxAge int
+}
+
+//CORSMiddleware 为允许的来源设置Access-Control-*响应头，预检请求(OPTIONS)直接返回204，不再转发给下游主机
+func CORSMiddleware(opts CORSOptions) func(next http.Handler) http.Handler {
+	methods := opts.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(opts.AllowedHeaders, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			if !originAllowed(opts.AllowedOrigins, origin) {
+				if preflight {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if !preflight {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+			if allowHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+			} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requested)
+			}
+			if opts.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(opts.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+//originAllowed 判断来源是否允许，支持"*"及"*.example.com"形式的后缀匹配
+func originAllowed(allowed []string, origin string) bool {
+	host := origin
+	if u, err := url.Parse(origin); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	for _, o := range allowed {
+		switch {
+		case o == "*":
+			return true
+		case strings.HasPrefix(o, "*."):
+			if strings.HasSuffix(strings.ToLower(host), strings.ToLower(o[1:])) {
+				return true
+			}
+		case strings.EqualFold(o, origin):
+			return true
+		}
+	}
+	return false
+}
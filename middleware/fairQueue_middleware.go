@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"proxy/util"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//defaultQueueTimeout 请求排队的默认最长等待时间
+const defaultQueueTimeout = time.Second
+
+//fairWaiter 排队中的请求
+type fairWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+//fairLimiter 按客户端IP公平排队的并发限制器
+//每个客户端最多占用perClient个并发名额，空出名额时按客户端轮流分配给排队中的请求，避免单个客户端占满路由的并发
+type fairLimiter struct {
+	mux          sync.Mutex
+	limit        int
+	perClient    int
+	active       int
+	clientActive map[string]int
+	queues       map[string][]*fairWaiter
+	//order 有请求排队的客户端，按顺序轮流分配名额
+	order []string
+}
+
+//FairQueueMiddleware 限制路由的最大并发数为limit，单个客户端最多占用limit*clientShare个名额(至少为1)，
+//超出时排队等待，等待超过queueTimeout(为0时使用默认值)返回503
+func FairQueueMiddleware(limit uint, clientShare float64, queueTimeout time.Duration) func(next http.Handler) http.Handler {
+	perClient := int(limit)
+	if clientShare > 0 && clientShare < 1 {
+		perClient = int(math.Ceil(float64(limit) * clientShare))
+	}
+	if queueTimeout <= 0 {
+		queueTimeout = defaultQueueTimeout
+	}
+	fl := &fairLimiter{
+		limit:        int(limit),
+		perClient:    perClient,
+		clientActive: make(map[string]int),
+		queues:       make(map[string][]*fairWaiter),
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := util.GetIP(r)
+			if !fl.acquire(r, client, queueTimeout) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(queueTimeout.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			defer fl.release(client)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//acquire 获取并发名额，排队超时或客户端断开时返回false
+func (fl *fairLimiter) acquire(r *http.Request, client string, timeout time.Duration) bool {
+	fl.mux.Lock()
+	waiter := &fairWaiter{ready: make(chan struct{})}
+	if len(fl.queues[client]) == 0 {
+		fl.order = append(fl.order, client)
+	}
+	fl.queues[client] = append(fl.queues[client], waiter)
+	fl.dispatch()
+	fl.mux.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	fl.mux.Lock()
+	defer fl.mux.Unlock()
+	//超时的同时已经分配到名额
+	if waiter.granted {
+		return true
+	}
+	fl.dequeue(client, waiter)
+	return false
+}
+
+//release 释放并发名额，并分配给排队中的请求
+func (fl *fairLimiter) release(client string) {
+	fl.mux.Lock()
+	defer fl.mux.Unlock()
+	fl.active--
+	if fl.clientActive[client]--; fl.clientActive[client] <= 0 {
+		delete(fl.clientActive, client)
+	}
+	fl.dispatch()
+}
+
+//dispatch 按客户端轮流将空闲名额分配给排队中的请求，已达到单客户端上限的客户端跳过
+func (fl *fairLimiter) dispatch() {
+	for fl.active < fl.limit {
+		dispatched := false
+		for i := 0; i < len(fl.order); i++ {
+			client := fl.order[i]
+			if fl.clientActive[client] >= fl.perClient {
+				continue
+			}
+			waiter := fl.queues[client][0]
+			fl.queues[client] = fl.queues[client][1:]
+			//分配后将该客户端移到队尾，保证轮流分配
+			fl.order = append(fl.order[:i], fl.order[i+1:]...)
+			if len(fl.queues[client]) > 0 {
+				fl.order = append(fl.order, client)
+			} else {
+				delete(fl.queues, client)
+			}
+			fl.grant(client)
+			waiter.granted = true
+			close(waiter.ready)
+			dispatched = true
+			break
+		}
+		if !dispatched {
+			return
+		}
+	}
+}
+
+//grant 为客户端分配一个名额
+func (fl *fairLimiter) grant(client string) {
+	fl.active++
+	fl.clientActive[client]++
+}
+
+//dequeue 将超时的请求移出队列
+func (fl *fairLimiter) dequeue(client string, waiter *fairWaiter) {
+	queue := fl.queues[client]
+	for i, w := range queue {
+		if w == waiter {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		fl.queues[client] = queue
+		return
+	}
+	delete(fl.queues, client)
+	for i, c := range fl.order {
+		if c == client {
+			fl.order = append(fl.order[:i], fl.order[i+1:]...)
+			break
+		}
+	}
+}
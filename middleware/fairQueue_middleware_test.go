@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestFairLimiter(limit, perClient int) *fairLimiter {
+	return &fairLimiter{
+		limit:        limit,
+		perClient:    perClient,
+		clientActive: make(map[string]int),
+		queues:       make(map[string][]*fairWaiter),
+	}
+}
+
+//queued 客户端排队中的请求数
+func (fl *fairLimiter) queued(client string) int {
+	fl.mux.Lock()
+	defer fl.mux.Unlock()
+	return len(fl.queues[client])
+}
+
+//waitQueued 等待客户端排队中的请求数达到n
+func waitQueued(t *testing.T, fl *fairLimiter, client string, n int) {
+	deadline := time.Now().Add(time.Second)
+	for fl.queued(client) != n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, n, fl.queued(client))
+}
+
+func TestFairLimiter_RoundRobinAcrossClients(t *testing.T) {
+	fl := newTestFairLimiter(1, 1)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.True(t, fl.acquire(req, "a", time.Second))
+
+	//客户端a先排队两个请求，客户端b之后排队一个请求
+	var mux sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	enqueue := func(client, name string, queued int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !assert.True(t, fl.acquire(req, client, 5*time.Second)) {
+				return
+			}
+			mux.Lock()
+			order = append(order, name)
+			mux.Unlock()
+			fl.release(client)
+		}()
+		waitQueued(t, fl, client, queued)
+	}
+	enqueue("a", "a2", 1)
+	enqueue("a", "a3", 2)
+	enqueue("b", "b1", 1)
+
+	//名额空出后按客户端轮流分配，b不需要等待a的所有请求完成
+	fl.release("a")
+	wg.Wait()
+	assert.Equal(t, []string{"a2", "b1", "a3"}, order)
+	assert.Equal(t, 0, fl.active)
+	assert.Empty(t, fl.clientActive)
+	assert.Empty(t, fl.order)
+}
+
+func TestFairLimiter_PerClientLimit(t *testing.T) {
+	fl := newTestFairLimiter(2, 1)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.True(t, fl.acquire(req, "a", time.Second))
+
+	//客户端a达到单客户端上限时排队，其他客户端仍可以使用剩余的名额
+	done := make(chan bool, 1)
+	go func() {
+		done <- fl.acquire(req, "a", 5*time.Second)
+	}()
+	waitQueued(t, fl, "a", 1)
+	assert.True(t, fl.acquire(req, "b", time.Second))
+
+	fl.release("b")
+	select {
+	case <-done:
+		t.Fatal("客户端a超过了单客户端上限")
+	case <-time.After(50 * time.Millisecond):
+	}
+	fl.release("a")
+	assert.True(t, <-done)
+	fl.release("a")
+	assert.Equal(t, 0, fl.active)
+}
+
+func TestFairQueueMiddleware_QueueTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := FairQueueMiddleware(1, 1, 50*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+	}))
+
+	slow := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		slow <- rec.Code
+	}()
+	<-started
+
+	//排队超时返回503
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	//占用的名额释放后新的请求不需要排队
+	close(release)
+	assert.Equal(t, http.StatusOK, <-slow)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestFairLimiter_ContextCanceled(t *testing.T) {
+	fl := newTestFairLimiter(1, 1)
+	assert.True(t, fl.acquire(httptest.NewRequest(http.MethodGet, "/", nil), "a", time.Second))
+
+	//客户端断开时移出队列，不占用名额
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	done := make(chan bool, 1)
+	go func() {
+		done <- fl.acquire(req, "b", 5*time.Second)
+	}()
+	waitQueued(t, fl, "b", 1)
+	cancel()
+	assert.False(t, <-done)
+	assert.Equal(t, 0, fl.queued("b"))
+	assert.Empty(t, fl.order)
+
+	fl.release("a")
+	assert.Equal(t, 0, fl.active)
+	assert.True(t, fl.acquire(httptest.NewRequest(http.MethodGet, "/", nil), "c", time.Second))
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"proxy/util"
+	"proxy/util/logging"
+)
+
+//IPFilterMiddleware 根据客户端IP过滤请求，allow及deny为CIDR(例如10.0.0.0/8)或单个IP
+//deny优先于allow，allow为空时允许所有不在deny中的IP，被拒绝时返回403
+func IPFilterMiddleware(allow []string, deny []string) (func(next http.Handler) http.Handler, error) {
+	allowNets, err := util.ParseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := util.ParseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientIP := util.GetIP(r)
+			if !ipAllowed(net.ParseIP(clientIP), allowNets, denyNets) {
+				logging.Warnf("[%s]请求%s 被IP过滤规则拒绝", clientIP, r.URL.Path)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+//ipAllowed 判断IP是否允许访问，无法解析的IP只有在allow为空且deny为空时才允许
+func ipAllowed(ip net.IP, allow []*net.IPNet, deny []*net.IPNet) bool {
+	if ip == nil {
+		return len(allow) == 0 && len(deny) == 0
+	}
+	for _, n := range deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, n := range allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
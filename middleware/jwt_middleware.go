@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"proxy/util/logging"
+	"strings"
+)
+
+type authSubjectKey struct{}
+
+//JWTOptions JWT认证配置
+type JWTOptions struct {
+	//Algorithm 签名算法，支持HS256/HS384/HS512/RS256/RS384/RS512，默认为HS256
+	Algorithm string
+	//Key HMAC算法为密钥，RSA算法为PEM格式的公钥
+	Key string
+	//RequiredClaims 令牌中必须包含且值相等的声明
+	RequiredClaims map[string]string
+	//RequiredScopes 令牌的scope(空格分隔的字符串)或scp(数组)中必须包含的权限
+	RequiredScopes []string
+	//PublicPaths 不需要认证的路径前缀
+	PublicPaths []string
+}
+
+//JWTAuthMiddleware 校验请求头Authorization中的Bearer令牌：签名、exp/nbf、必需的声明及权限，
+//令牌无效时返回401，缺少声明或权限时返回403，校验通过后将令牌的subject保存到请求上下文中
+func JWTAuthMiddleware(opts JWTOptions) (func(next http.Handler) http.Handler, error) {
+	algorithm := opts.Algorithm
+	if algorithm == "" {
+		algorithm = jwt.SigningMethodHS256.Alg()
+	}
+	key, err := parseJWTKey(algorithm, opts.Key)
+	if err != nil {
+		return nil, err
+	}
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		//只接受配置的签名算法，避免算法混淆攻击
+		if token.Method.Alg() != algorithm {
+			return nil, fmt.Errorf("不支持的签名算法 %s", token.Method.Alg())
+		}
+		return key, nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, path := range opts.PublicPaths {
+				if strings.HasPrefix(r.URL.Path, path) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			raw := r.Header.Get("Authorization")
+			if len(raw) < 7 || !strings.EqualFold(raw[:7], "Bearer ") {
+				unauthorized(w, "缺少Bearer令牌")
+				return
+			}
+			claims := jwt.MapClaims{}
+			if _, err := jwt.ParseWithClaims(strings.TrimSpace(raw[7:]), claims, keyFunc); err != nil {
+				logging.Debugf("[%v]请求%s 令牌校验失败: %s", r.RemoteAddr, r.URL.Path, err)
+				unauthorized(w, "令牌无效")
+				return
+			}
+			if err := checkClaims(claims, opts.RequiredClaims, opts.RequiredScopes); err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+
+			if subject, ok := claims["sub"].(string); ok && subject != "" {
+				r = r.WithContext(context.WithValue(r.Context(), authSubjectKey{}, subject))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+//AuthSubjectFromContext 获取JWT认证通过后的令牌subject
+func AuthSubjectFromContext(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(authSubjectKey{}).(string)
+	return subject, ok
+}
+
+//parseJWTKey 根据签名算法解析密钥
+func parseJWTKey(algorithm string, key string) (interface{}, error) {
+	if key == "" {
+		return nil, errors.New("JWT密钥不能为空")
+	}
+	switch algorithm {
+	case "HS256", "HS384", "HS512":
+		return []byte(key), nil
+	case "RS256", "RS384", "RS512":
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("JWT公钥不正确: %s", err)
+		}
+		return publicKey, nil
+	}
+	return nil, fmt.Errorf("不支持的JWT签名算法 \"%s\"", algorithm)
+}
+
+//checkClaims 检查令牌是否包含必需的声明及权限
+func checkClaims(claims jwt.MapClaims, requiredClaims map[string]string, requiredScopes []string) error {
+	for name, expected := range requiredClaims {
+		value, ok := claims[name]
+		if !ok || fmt.Sprint(value) != expected {
+			return fmt.Errorf("令牌缺少声明 %s", name)
+		}
+	}
+	if len(requiredScopes) == 0 {
+		return nil
+	}
+	scopes := make(map[string]bool)
+	if scope, ok := claims["scope"].(string); ok {
+		for _, s := range strings.Fields(scope) {
+			scopes[s] = true
+		}
+	}
+	if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			scopes[fmt.Sprint(s)] = true
+		}
+	}
+	for _, s := range requiredScopes {
+		if !scopes[s] {
+			return fmt.Errorf("令牌缺少权限 %s", s)
+		}
+	}
+	return nil
+}
+
+//unauthorized 返回401
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, message, http.StatusUnauthorized)
+}
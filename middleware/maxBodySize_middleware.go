@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+//ErrBodyTooLarge 请求内容超过了限制的大小
+var ErrBodyTooLarge = errors.New("请求内容超过了限制的大小")
+
+//MaxBodySizeMiddleware 限制请求内容的大小，Content-Length超过limit字节时直接返回413，
+//未携带Content-Length(分块传输)时读取超过limit字节后返回ErrBodyTooLarge，由转发的处理程序返回413，limit为0时不限制
+func MaxBodySizeMiddleware(limit int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &maxBodyReader{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//maxBodyReader 将http.MaxBytesReader超出限制的错误转换为ErrBodyTooLarge，便于使用errors.Is判断
+type maxBodyReader struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (mr *maxBodyReader) Read(p []byte) (int, error) {
+	n, err := mr.ReadCloser.Read(p)
+	mr.read += int64(n)
+	//MaxBytesReader读取了limit字节后再读取时返回错误
+	if err != nil && err != io.EOF && mr.read >= mr.limit {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
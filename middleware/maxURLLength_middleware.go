@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"proxy/util"
+	"proxy/util/logging"
+)
+
+//MaxURLLengthMiddleware 限制请求URL(路径及查询参数)的长度，超过limit字节时返回414，limit为0时不限制
+func MaxURLLengthMiddleware(limit int) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uri := r.RequestURI
+			if uri == "" {
+				uri = r.URL.RequestURI()
+			}
+			if len(uri) > limit {
+				logging.Warnf("[%s]请求URL长度 %d 超过了限制 %d", util.GetIP(r), len(uri), limit)
+				http.Error(w, http.StatusText(http.StatusRequestURITooLong), http.StatusRequestURITooLong)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_requests_total",
+		Help: "代理接收的请求总数",
+	}, []string{"route", "method"})
+	responsesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_responses_total",
+		Help: "按状态码统计的响应数",
+	}, []string{"route", "code"})
+	upstreamRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_upstream_requests_total",
+		Help: "按下游主机统计的请求数",
+	}, []string{"route", "host"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "proxy_request_duration_seconds",
+		Help:    "请求的处理耗时(秒)",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+	upstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "proxy_upstream_latency_seconds",
+		Help:    "下游主机的响应耗时(秒)",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "host"})
+
+	registerOnce sync.Once
+)
+
+//registerMetrics 向默认的Registry注册监控指标，只注册一次
+func registerMetrics() {
+	registerOnce.Do(func() {
+		prometheus.MustRegister(requestsTotal, responsesTotal, upstreamRequestsTotal, requestDuration, upstreamLatency)
+	})
+}
+
+//MetricsMiddleware 统计请求数、各状态码的响应数、各下游主机的请求数，以及请求耗时和下游主机响应耗时的分布
+//下游主机为负载均衡器最终选择的主机，由处理程序通过SetUpstreamHost记录
+func MetricsMiddleware(next http.Handler) http.Handler {
+	registerMetrics()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, holder := withUpstreamHolder(r)
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		route := routeTemplate(r)
+		requestsTotal.WithLabelValues(route, r.Method).Inc()
+		responsesTotal.WithLabelValues(route, strconv.Itoa(rec.status)).Inc()
+		requestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+		if host := holder.get(); host != "" {
+			upstreamRequestsTotal.WithLabelValues(route, host).Inc()
+			if latency, ok := holder.getLatency(); ok {
+				upstreamLatency.WithLabelValues(route, host).Observe(latency.Seconds())
+			}
+		}
+	})
+}
+
+//MetricsHandler 以Prometheus格式输出监控指标
+func MetricsHandler() http.Handler {
+	registerMetrics()
+	return promhttp.Handler()
+}
+
+//routeTemplate 获取请求匹配到的路由模板，用作指标的标签，避免使用原始路径导致标签数量过多
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unmatched"
+}
@@ -1,19 +1,53 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
+	"proxy/util"
 	"proxy/util/logging"
+	"runtime/debug"
 )
 
+//PanicsHandling 恢复处理请求时的panic，记录详细信息及调用栈，并向客户端返回不包含内部错误信息的502
 func PanicsHandling(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				logging.Errorf("[%v]请求%s?%s 异常: %v", r.RemoteAddr, r.URL.Path, r.URL.RawQuery, err)
-				w.WriteHeader(http.StatusBadGateway)
-				_, _ = w.Write([]byte(err.(error).Error()))
-			}
-		}()
-		next.ServeHTTP(w, r)
-	})
-}
\ No newline at end of file
+	return PanicsHandlingMiddleware(false)(next)
+}
+
+//PanicsHandlingMiddleware 恢复处理请求时的panic，exposeDetails为true(调试模式)时响应内容包含panic的信息，否则只返回通用的502
+func PanicsHandlingMiddleware(exposeDetails bool) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					//http.ErrAbortHandler用于中止已开始的响应，需要交给http.Server关闭连接
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+					message := panicMessage(err)
+					logging.Errorf("[%v]请求%s?%s 异常(请求ID: %s): %s\n%s", r.RemoteAddr, r.URL.Path, r.URL.RawQuery, r.Header.Get(util.XRequestID), message, debug.Stack())
+					body := http.StatusText(http.StatusBadGateway)
+					if exposeDetails {
+						body = message
+					}
+					w.WriteHeader(http.StatusBadGateway)
+					_, _ = w.Write([]byte(body))
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//panicMessage 将recover获取的任意类型的值转换为字符串
+func panicMessage(v interface{}) string {
+	switch e := v.(type) {
+	case error:
+		return e.Error()
+	case string:
+		return e
+	case fmt.Stringer:
+		return e.String()
+	default:
+		return fmt.Sprintf("%v", e)
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type panicValue struct {
+	code int
+}
+
+type stringer struct{}
+
+func (stringer) String() string {
+	return "stringer panic"
+}
+
+func TestPanicsHandling(t *testing.T) {
+	cases := []struct {
+		name    string
+		value   interface{}
+		message string
+	}{
+		{"error", errors.New("error panic"), "error panic"},
+		{"string", "string panic", "string panic"},
+		{"stringer", stringer{}, "stringer panic"},
+		{"non-error type", panicValue{code: 42}, "{42}"},
+		{"int", 42, "42"},
+	}
+	for _, c := range cases {
+		value := c.value
+		panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(value)
+		})
+
+		//默认不向客户端返回内部错误信息
+		rec := httptest.NewRecorder()
+		assert.NotPanics(t, func() {
+			PanicsHandling(panicking).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		}, c.name)
+		assert.Equal(t, http.StatusBadGateway, rec.Code, c.name)
+		assert.Equal(t, http.StatusText(http.StatusBadGateway), rec.Body.String(), c.name)
+
+		//调试模式返回panic的信息
+		rec = httptest.NewRecorder()
+		assert.NotPanics(t, func() {
+			PanicsHandlingMiddleware(true)(panicking).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		}, c.name)
+		assert.Equal(t, http.StatusBadGateway, rec.Code, c.name)
+		assert.Equal(t, c.message, rec.Body.String(), c.name)
+	}
+}
+
+func TestPanicsHandling_AbortHandler(t *testing.T) {
+	aborting := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		PanicsHandling(aborting).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"proxy/util"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//limiterIdleTimeout 客户端超过该时间没有请求时，删除其限流器
+const limiterIdleTimeout = 3 * time.Minute
+
+//clientLimiter 单个客户端的令牌桶
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+//ipRateLimiter 按客户端IP限流，定期删除空闲的限流器，避免内存无限增长
+type ipRateLimiter struct {
+	mux       sync.Mutex
+	rps       rate.Limit
+	burst     int
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+//RateLimitMiddleware 按客户端IP进行令牌桶限流，每个客户端每秒rps个请求，最多突发burst个(为0时等于rps)，
+//超出时返回429并通过Retry-After告知客户端需要等待的秒数
+func RateLimitMiddleware(rps int, burst int) func(next http.Handler) http.Handler {
+	if burst <= 0 {
+		burst = rps
+	}
+	rl := &ipRateLimiter{
+		rps:       rate.Limit(rps),
+		burst:     burst,
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rps <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if delay, ok := rl.allow(util.GetIP(r), time.Now()); !ok {
+				retryAfter := int(math.Ceil(delay.Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//allow 判断客户端的请求是否允许通过，不允许时返回需要等待的时间
+func (rl *ipRateLimiter) allow(client string, now time.Time) (time.Duration, bool) {
+	rl.mux.Lock()
+	if now.Sub(rl.lastSweep) >= limiterIdleTimeout {
+		rl.sweep(now)
+	}
+	cl, ok := rl.clients[client]
+	if !ok {
+		cl = &clientLimiter{limiter: rate.NewLimiter(rl.rps, rl.burst)}
+		rl.clients[client] = cl
+	}
+	cl.lastSeen = now
+	rl.mux.Unlock()
+
+	reservation := cl.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return limiterIdleTimeout, false
+	}
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		//不等待令牌，归还预留的令牌
+		reservation.CancelAt(now)
+		return delay, false
+	}
+	return 0, true
+}
+
+//sweep 删除空闲的限流器
+func (rl *ipRateLimiter) sweep(now time.Time) {
+	for client, cl := range rl.clients {
+		if now.Sub(cl.lastSeen) >= limiterIdleTimeout {
+			delete(rl.clients, client)
+		}
+	}
+	rl.lastSweep = now
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"proxy/util"
+)
+
+//maxRequestIDLength 客户端携带的请求ID最大长度，超出或包含不可见字符时重新生成
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+//RequestIDMiddleware 读取请求头X-Request-ID，不存在时生成UUID，保存到请求上下文及请求头中，并设置到响应头
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(util.XRequestID)
+		if !validRequestID(id) {
+			id = newUUID()
+		}
+		//请求头与外层中间件共享，外层(如PanicsHandling)可以通过请求头获取请求ID
+		r.Header.Set(util.XRequestID, id)
+		w.Header().Set(util.XRequestID, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+//RequestIDFromContext 获取请求上下文中的请求ID
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+//validRequestID 请求ID不能为空、不能过长且只能包含可见的ASCII字符，避免日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+//newUUID 生成随机的UUID(版本4)
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
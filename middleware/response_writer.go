@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// responseRecorder 记录响应状态码、大小，并按需截取响应内容的ResponseWriter
+// 实现了http.Flusher和http.Hijacker，保证流式响应和WebSocket不受影响
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+	//body 截取的响应内容，为nil时不截取
+	body *bytes.Buffer
+	//maxBody 最多截取的响应内容字节数
+	maxBody int
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.body != nil && rr.body.Len() < rr.maxBody {
+		remain := rr.maxBody - rr.body.Len()
+		if remain > len(b) {
+			remain = len(b)
+		}
+		rr.body.Write(b[:remain])
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.size += int64(n)
+	return n, err
+}
+
+func (rr *responseRecorder) Flush() {
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not implement http.Hijacker")
+	}
+	return h.Hijack()
+}
+
+// Unwrap 供http.ResponseController获取原始的ResponseWriter
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
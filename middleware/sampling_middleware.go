@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"proxy/util"
+	"proxy/util/logging"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//sampleQueueSize 等待写入采样记录的队列长度，队列满时丢弃采样记录，不影响客户端请求
+const sampleQueueSize = 1024
+
+//redacted 敏感字段替换后的内容
+const redacted = "***"
+
+//sensitiveHeaders 总是需要脱敏的请求头及响应头
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+//SampleRecord 采样的请求及响应记录
+type SampleRecord struct {
+	Time            time.Time           `json:"time"`
+	ClientIP        string              `json:"client_ip"`
+	Method          string              `json:"method"`
+	URL             string              `json:"url"`
+	RequestHeaders  map[string][]string `json:"request_headers"`
+	RequestBody     string              `json:"request_body"`
+	Status          int                 `json:"status"`
+	ResponseHeaders map[string][]string `json:"response_headers"`
+	ResponseBody    string              `json:"response_body"`
+	DurationMs      float64             `json:"duration_ms"`
+}
+
+//SampleSink 采样记录的输出，例如本地文件或消息队列
+type SampleSink interface {
+	Write(record *SampleRecord) error
+	//Close 关闭输出，采样停止后调用
+	Close() error
+}
+
+//fileSink 以JSON Lines格式将采样记录追加到本地文件
+type fileSink struct {
+	mux  sync.Mutex
+	file *os.File
+}
+
+//NewFileSink 创建输出到本地文件的采样记录输出，文件所在的目录不存在时自动创建
+func NewFileSink(path string) (SampleSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建采样文件目录 %s 失败: %s", filepath.Dir(path), err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开采样文件 %s 失败: %s", path, err)
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (s *fileSink) Write(record *SampleRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.file.Close()
+}
+
+//sampler 按比例采样请求及响应，异步脱敏后写入SampleSink
+type sampler struct {
+	rate      float64
+	maxBody   int
+	fields    []string
+	bodyRules []*regexp.Regexp
+	sink      SampleSink
+	queue     chan *SampleRecord
+	rnd       *rand.Rand
+	rndMux    sync.Mutex
+	//stop 关闭后写入队列中剩余的采样记录并退出
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+//SamplingMiddleware 按rate(0~1)的比例采样完整的请求及响应写入sink，
+//请求和响应内容最多截取maxBody字节，redactFields中的字段会在请求头、查询参数、JSON及表单内容中脱敏
+//返回的io.Closer停止采样并关闭sink，关闭后的请求不再采样
+func SamplingMiddleware(rate float64, maxBody int, redactFields []string, sink SampleSink) (func(next http.Handler) http.Handler, io.Closer) {
+	s := newSampler(rate, maxBody, redactFields, sink)
+	go s.run()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.sampled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			reqBody := &cappedBuffer{max: s.maxBody}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &teeReadCloser{Reader: io.TeeReader(r.Body, reqBody), Closer: r.Body}
+			}
+			rr := newResponseRecorder(w)
+			rr.body = &bytes.Buffer{}
+			rr.maxBody = s.maxBody
+			next.ServeHTTP(rr, r)
+
+			record := &SampleRecord{
+				Time:            start,
+				ClientIP:        util.GetIP(r),
+				Method:          r.Method,
+				URL:             r.URL.String(),
+				RequestHeaders:  r.Header.Clone(),
+				RequestBody:     reqBody.String(),
+				Status:          rr.status,
+				ResponseHeaders: rr.Header().Clone(),
+				ResponseBody:    rr.body.String(),
+				DurationMs:      float64(time.Since(start)) / float64(time.Millisecond),
+			}
+			select {
+			case s.queue <- record:
+			default:
+			}
+		})
+	}, s
+}
+
+//newSampler 创建采样器并编译脱敏规则
+func newSampler(rate float64, maxBody int, redactFields []string, sink SampleSink) *sampler {
+	s := &sampler{
+		rate:    rate,
+		maxBody: maxBody,
+		fields:  redactFields,
+		sink:    sink,
+		queue:   make(chan *SampleRecord, sampleQueueSize),
+		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, field := range redactFields {
+		quoted := regexp.QuoteMeta(field)
+		s.bodyRules = append(s.bodyRules,
+			regexp.MustCompile(`(?i)("`+quoted+`"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`),
+			regexp.MustCompile(`(?i)(^|&)(`+quoted+`=)[^&]*`),
+		)
+	}
+	return s
+}
+
+//sampled 判断本次请求是否被采样
+func (s *sampler) sampled() bool {
+	if s.rate <= 0 {
+		return false
+	}
+	select {
+	case <-s.stop:
+		return false
+	default:
+	}
+	s.rndMux.Lock()
+	defer s.rndMux.Unlock()
+	return s.rnd.Float64() < s.rate
+}
+
+//run 异步脱敏并写入采样记录，停止后写入队列中剩余的采样记录
+func (s *sampler) run() {
+	defer close(s.done)
+	for {
+		select {
+		case record := <-s.queue:
+			s.write(record)
+		case <-s.stop:
+			for {
+				select {
+				case record := <-s.queue:
+					s.write(record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+//write 脱敏后写入一条采样记录
+func (s *sampler) write(record *SampleRecord) {
+	s.redact(record)
+	if err := s.sink.Write(record); err != nil {
+		logging.Errorf("写入采样记录失败: %s", err)
+	}
+}
+
+//Close 停止采样，等待队列中的采样记录写入后关闭sink，可以重复调用
+func (s *sampler) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		s.closeErr = s.sink.Close()
+	})
+	return s.closeErr
+}
+
+//redact 脱敏采样记录中的敏感字段
+func (s *sampler) redact(record *SampleRecord) {
+	headers := append(append([]string{}, sensitiveHeaders...), s.fields...)
+	for _, h := range headers {
+		h = http.CanonicalHeaderKey(h)
+		if _, ok := record.RequestHeaders[h]; ok {
+			record.RequestHeaders[h] = []string{redacted}
+		}
+		if _, ok := record.ResponseHeaders[h]; ok {
+			record.ResponseHeaders[h] = []string{redacted}
+		}
+	}
+	if i := strings.Index(record.URL, "?"); i != -1 {
+		record.URL = record.URL[:i+1] + s.redactBody(record.URL[i+1:])
+	}
+	record.RequestBody = s.redactBody(record.RequestBody)
+	record.ResponseBody = s.redactBody(record.ResponseBody)
+}
+
+//redactBody 脱敏JSON及表单格式内容中的敏感字段，内容被截断时同样适用
+func (s *sampler) redactBody(body string) string {
+	for i, rule := range s.bodyRules {
+		if i%2 == 0 {
+			body = rule.ReplaceAllString(body, `$1"`+redacted+`"`)
+		} else {
+			body = rule.ReplaceAllString(body, `$1$2`+redacted)
+		}
+	}
+	return body
+}
+
+//cappedBuffer 最多保存max字节的缓冲区，超出部分丢弃
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if remain := b.max - b.Len(); remain > 0 {
+		if remain > len(p) {
+			remain = len(p)
+		}
+		b.Buffer.Write(p[:remain])
+	}
+	return len(p), nil
+}
+
+//teeReadCloser 读取请求内容时同时复制一份
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
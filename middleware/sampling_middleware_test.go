@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//memorySink 保存在内存中的采样记录输出
+type memorySink struct {
+	mux     sync.Mutex
+	records []*SampleRecord
+	closed  int
+}
+
+func (s *memorySink) Write(record *SampleRecord) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closed++
+	return nil
+}
+
+func TestSampling_RedactAndCap(t *testing.T) {
+	sink := &memorySink{}
+	mw, closer := SamplingMiddleware(1, 16, []string{"password"}, sink)
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		_, _ = w.Write([]byte(`{"password":"hunter2","data":"0123456789"}`))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/login?password=abc&user=bob", strings.NewReader("password=abc&user=bob"))
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	mw(backend).ServeHTTP(rec, req)
+	//客户端收到完整的响应
+	assert.Equal(t, `{"password":"hunter2","data":"0123456789"}`, rec.Body.String())
+	assert.Equal(t, "session=secret", rec.Header().Get("Set-Cookie"))
+
+	//关闭时写入队列中剩余的采样记录
+	assert.NoError(t, closer.Close())
+	if !assert.Len(t, sink.records, 1) {
+		return
+	}
+	record := sink.records[0]
+	assert.Equal(t, "/login?password=***&user=bob", record.URL)
+	assert.Equal(t, []string{"***"}, record.RequestHeaders["Authorization"])
+	assert.Equal(t, []string{"***"}, record.ResponseHeaders["Set-Cookie"])
+	assert.Equal(t, "password=***&use", record.RequestBody)
+	assert.Equal(t, `{"password":"***"`, record.ResponseBody)
+	assert.Equal(t, http.StatusOK, record.Status)
+}
+
+func TestSampling_Close(t *testing.T) {
+	sink := &memorySink{}
+	mw, closer := SamplingMiddleware(1, 64, nil, sink)
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil))
+	assert.NoError(t, closer.Close())
+	assert.NoError(t, closer.Close())
+	assert.Equal(t, 1, sink.closed)
+
+	//关闭后的请求正常处理但不再采样
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/b", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, sink.records, 1)
+}
+
+func TestFileSink(t *testing.T) {
+	//文件所在的目录不存在时自动创建
+	path := filepath.Join(t.TempDir(), "logs", "sample.log")
+	sink, err := NewFileSink(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	mw, closer := SamplingMiddleware(1, 64, nil, sink)
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/b", nil))
+	assert.NoError(t, closer.Close())
+
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+	var urls []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record SampleRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		urls = append(urls, record.URL)
+	}
+	assert.Equal(t, []string{"/a", "/b"}, urls)
+}
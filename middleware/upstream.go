@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type upstreamKey struct{}
+
+//upstreamHolder 保存请求最终转发的下游主机及其响应耗时，由后续的处理程序通过SetUpstreamHost、SetUpstreamLatency记录
+type upstreamHolder struct {
+	mux     sync.Mutex
+	host    string
+	latency time.Duration
+	//clientID API Key认证通过的客户端标识
+	clientID string
+}
+
+//SetUpstreamHost 记录请求最终转发的下游主机，供访问日志等中间件使用，未开启相关中间件时不做任何处理
+func SetUpstreamHost(ctx context.Context, host string) {
+	if holder, ok := ctx.Value(upstreamKey{}).(*upstreamHolder); ok {
+		holder.mux.Lock()
+		holder.host = host
+		holder.mux.Unlock()
+	}
+}
+
+//SetUpstreamLatency 记录下游主机的响应耗时，供监控指标等中间件使用，未开启相关中间件时不做任何处理
+func SetUpstreamLatency(ctx context.Context, latency time.Duration) {
+	if holder, ok := ctx.Value(upstreamKey{}).(*upstreamHolder); ok {
+		holder.mux.Lock()
+		holder.latency = latency
+		holder.mux.Unlock()
+	}
+}
+
+//setClientID 记录API Key认证通过的客户端标识，供访问日志使用
+func setClientID(ctx context.Context, clientID string) {
+	if holder, ok := ctx.Value(upstreamKey{}).(*upstreamHolder); ok {
+		holder.mux.Lock()
+		holder.clientID = clientID
+		holder.mux.Unlock()
+	}
+}
+
+//withUpstreamHolder 在请求上下文中保存下游主机记录，已存在时直接使用
+func withUpstreamHolder(r *http.Request) (*http.Request, *upstreamHolder) {
+	if holder, ok := r.Context().Value(upstreamKey{}).(*upstreamHolder); ok {
+		return r, holder
+	}
+	holder := &upstreamHolder{}
+	return r.WithContext(context.WithValue(r.Context(), upstreamKey{}, holder)), holder
+}
+
+func (h *upstreamHolder) get() string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.host
+}
+
+//getLatency 获取下游主机的响应耗时，未记录时返回false
+func (h *upstreamHolder) getLatency() (time.Duration, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.latency, h.host != "" && h.latency > 0
+}
+
+func (h *upstreamHolder) getClientID() string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.clientID
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"net/http"
+	"path/filepath"
+	"proxy/config"
+	"proxy/handler"
+	"proxy/util"
+	"proxy/util/logging"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//reloadDebounce 配置文件变化后等待该时间再重新加载，编辑器保存文件时通常会触发多个事件
+	reloadDebounce = 500 * time.Millisecond
+	//reloadDrainTimeout 未配置drain_timeout时，替换路由表前等待被删除主机的在途请求完成的最长时间
+	reloadDrainTimeout = 30 * time.Second
+	//idlePollInterval 被替换的路由表检查处理中的请求是否已完成的间隔
+	idlePollInterval = 100 * time.Millisecond
+)
+
+//routeTable 一次加载配置生成的路由表
+type routeTable struct {
+	//active 正在使用该路由表处理的请求数
+	active  int64
+	cfg     *config.Config
+	handler *routerHandler
+	routes  []*handler.RoutePrefixHandler
+}
+
+//stop 停止路由表中路由的健康检查并关闭中间件持有的资源
+func (t *routeTable) stop() {
+	stopRoutes(t.routes)
+	t.handler.Close()
+}
+
+//stopWhenIdle 被替换的路由表没有处理中的请求时立即停止，否则在后台等待处理中的请求完成后再停止，
+//避免关闭处理中的请求仍在使用的中间件资源(例如采样输出)
+func (t *routeTable) stopWhenIdle() {
+	if atomic.LoadInt64(&t.active) == 0 {
+		t.stop()
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(idlePollInterval)
+		defer ticker.Stop()
+		for atomic.LoadInt64(&t.active) > 0 {
+			<-ticker.C
+		}
+		t.stop()
+	}()
+}
+
+//applyGlobalConfig 应用对所有路由生效的全局配置，路由表创建成功后、替换当前路由表时调用，
+//验证失败的配置不会影响当前的路由表
+func applyGlobalConfig(cfg *config.Config) error {
+	//只信任可信代理转发的X-Forwarded-For及X-Real-IP
+	if err := util.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies配置不正确: %s", err)
+	}
+	//所有路由的健康检查共享并发限制，避免主机较多时同时发起大量健康检查
+	handler.SetHealthCheckMaxConcurrent(cfg.HealthCheckMaxConcurrent)
+	return nil
+}
+
+//reloadableHandler 持有当前的路由表，重新加载配置后原子替换，处理中的请求继续使用旧的路由表完成
+type reloadableHandler struct {
+	//mux 保证同一时间只有一个重新加载在执行
+	mux     sync.Mutex
+	current atomic.Value
+	files   []string
+	//onReload 路由表替换后的回调，用于更新管理接口持有的路由
+	onReload []func(routes []*handler.RoutePrefixHandler)
+}
+
+//newReloadableHandler 根据配置文件加载的配置创建路由表
+func newReloadableHandler(cfg *config.Config, files ...string) (*reloadableHandler, error) {
+	muxHandler, routes, err := NewMuxHandler(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyGlobalConfig(cfg); err != nil {
+		stopRoutes(routes)
+		muxHandler.Close()
+		return nil, err
+	}
+	h := &reloadableHandler{files: files}
+	h.current.Store(&routeTable{cfg: cfg, handler: muxHandler, routes: routes})
+	return h, nil
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := h.acquire()
+	defer atomic.AddInt64(&t.active, -1)
+	t.handler.ServeHTTP(w, r)
+}
+
+//acquire 获取当前的路由表并增加其处理中的请求数，增加后路由表已被替换时重新获取，
+//保证被替换的路由表处理中的请求数降为0后不会再有新的请求使用它
+func (h *reloadableHandler) acquire() *routeTable {
+	for {
+		t := h.table()
+		atomic.AddInt64(&t.active, 1)
+		if h.table() == t {
+			return t
+		}
+		atomic.AddInt64(&t.active, -1)
+	}
+}
+
+func (h *reloadableHandler) table() *routeTable {
+	return h.current.Load().(*routeTable)
+}
+
+//Routes 当前路由表的路由
+func (h *reloadableHandler) Routes() []*handler.RoutePrefixHandler {
+	return h.table().routes
+}
+
+//Config 当前路由表使用的配置
+func (h *reloadableHandler) Config() *config.Config {
+	return h.table().cfg
+}
+
+//OnReload 注册路由表替换后的回调
+func (h *reloadableHandler) OnReload(fn func(routes []*handler.RoutePrefixHandler)) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.onReload = append(h.onReload, fn)
+}
+
+//Reload 重新读取并验证配置文件，创建新的路由表后替换当前的路由表，旧路由表处理中的请求完成后停止
+//新路由继承旧路由运行时的主机状态，新配置中删除的主机在替换前先置为维护状态并等待其在途请求完成
+//配置验证或路由创建失败时返回错误，继续使用当前的路由表
+func (h *reloadableHandler) Reload() (err error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	//路由路径模板不正确时解析会panic，不能导致服务退出
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	cfg, err := config.Read(true, h.files...)
+	if err != nil {
+		return err
+	}
+	muxHandler, routes, err := NewMuxHandler(cfg)
+	if err != nil {
+		return err
+	}
+	if err := applyGlobalConfig(cfg); err != nil {
+		stopRoutes(routes)
+		muxHandler.Close()
+		return err
+	}
+	old := h.table()
+	inheritHostState(old.routes, routes)
+	drainTimeout := time.Duration(cfg.DrainTimeout) * time.Second
+	if drainTimeout <= 0 {
+		drainTimeout = reloadDrainTimeout
+	}
+	drainStaleHosts(old.routes, routes, drainTimeout)
+	h.current.Store(&routeTable{cfg: cfg, handler: muxHandler, routes: routes})
+	old.stopWhenIdle()
+	for _, fn := range h.onReload {
+		fn(routes)
+	}
+
+	if cfg.Port != old.cfg.Port || cfg.Schema != old.cfg.Schema || cfg.AdminPort != old.cfg.AdminPort {
+		logging.Warn("监听端口、协议及管理端口的修改需要重启服务才能生效")
+	}
+	logging.Infof("配置重新加载成功，共 %d 个路由", len(routes))
+	return nil
+}
+
+//routeKeys 以UpstreamPath及在相同UpstreamPath中的序号作为键，用于对应新旧路由
+func routeKeys(routes []*handler.RoutePrefixHandler) map[string]*handler.RoutePrefixHandler {
+	keys := make(map[string]*handler.RoutePrefixHandler, len(routes))
+	seen := make(map[string]int, len(routes))
+	for _, rh := range routes {
+		keys[fmt.Sprintf("%s#%d", rh.UpstreamPath, seen[rh.UpstreamPath])] = rh
+		seen[rh.UpstreamPath]++
+	}
+	return keys
+}
+
+//inheritHostState 新路由继承对应旧路由运行时的主机状态，例如通过管理接口调整的权重、添加的主机及维护状态
+func inheritHostState(oldRoutes, newRoutes []*handler.RoutePrefixHandler) {
+	oldKeys := routeKeys(oldRoutes)
+	for key, rh := range routeKeys(newRoutes) {
+		if old, ok := oldKeys[key]; ok {
+			rh.InheritHostState(old)
+		}
+	}
+}
+
+//drainStaleHosts 将新路由中已不存在的主机在旧路由中置为维护状态，并等待这些主机的在途请求完成或超过timeout，
+//替换路由表之前调用，等待期间旧路由表继续处理请求，但不再选择这些主机
+//旧路由的所有主机都被删除时(例如删除了整个路由)不置为维护状态，避免替换前没有可用的主机，
+//这些主机的请求随旧路由表一起处理完成
+func drainStaleHosts(oldRoutes, newRoutes []*handler.RoutePrefixHandler, timeout time.Duration) {
+	current := make(map[string]map[string]bool, len(newRoutes))
+	for _, rh := range newRoutes {
+		if current[rh.UpstreamPath] == nil {
+			current[rh.UpstreamPath] = make(map[string]bool)
+		}
+		for _, host := range rh.Hosts() {
+			current[rh.UpstreamPath][host] = true
+		}
+	}
+	var wg sync.WaitGroup
+	for _, rh := range oldRoutes {
+		hosts := rh.Hosts()
+		var stale []string
+		for _, host := range hosts {
+			if !current[rh.UpstreamPath][host] {
+				stale = append(stale, host)
+			}
+		}
+		if len(stale) == 0 || len(stale) == len(hosts) {
+			continue
+		}
+		for _, host := range stale {
+			if err := rh.Drain(host); err != nil {
+				continue
+			}
+			wg.Add(1)
+			go func(rh *handler.RoutePrefixHandler, host string) {
+				defer wg.Done()
+				rh.WaitIdle(host, timeout)
+			}(rh, host)
+		}
+	}
+	wg.Wait()
+}
+
+//Stop 停止当前路由的健康检查并关闭中间件持有的资源
+func (h *reloadableHandler) Stop() {
+	h.table().stop()
+}
+
+//stopRoutes 停止路由的健康检查
+func stopRoutes(routes []*handler.RoutePrefixHandler) {
+	for _, rh := range routes {
+		rh.Stop()
+	}
+}
+
+//Watch 监听配置文件的变化并重新加载，重新加载失败时记录日志并继续使用当前的配置，ctx结束时退出
+//监听的是配置文件所在的目录，编辑器通过重命名替换文件时也能收到事件
+func (h *reloadableHandler) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	files := make(map[string]bool, len(h.files))
+	for _, file := range h.files {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		files[path] = true
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			return err
+		}
+	}
+
+	timer := time.NewTimer(reloadDebounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("配置文件监听已关闭")
+			}
+			path, _ := filepath.Abs(event.Name)
+			if files[path] && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				timer.Reset(reloadDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("配置文件监听已关闭")
+			}
+			logging.Errorf("监听配置文件失败: %s", err)
+		case <-timer.C:
+			if err := h.Reload(); err != nil {
+				logging.Errorf("配置重新加载失败，继续使用当前配置: %s", err)
+			}
+		}
+	}
+}
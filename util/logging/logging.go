@@ -1,73 +1,167 @@
 package logging
 
 import (
+	"fmt"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"io"
 	"os"
+	"strings"
 	"time"
 )
 
 var logging *zap.SugaredLogger
 
+//encoderConfig 日志的基本格式，文本及JSON格式共用
+var encoderConfig = zapcore.EncoderConfig{
+	MessageKey:  "msg",
+	LevelKey:    "level",
+	EncodeLevel: zapcore.CapitalLevelEncoder,
+	TimeKey:     "ts",
+	EncodeTime: func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		enc.AppendString(t.Format("2006-01-02 15:04:05"))
+	},
+	CallerKey:    "file",
+	EncodeCaller: zapcore.ShortCallerEncoder,
+	EncodeDuration: func(d time.Duration, enc zapcore.PrimitiveArrayEncoder) {
+		enc.AppendInt64(int64(d) / 1000000)
+	},
+}
+
 func init() {
-	// 设置一些基本日志格式 具体含义还比较好理解，直接看zap源码也不难懂
-	encoder := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
-		MessageKey:  "msg",
-		LevelKey:    "level",
-		EncodeLevel: zapcore.CapitalLevelEncoder,
-		TimeKey:     "ts",
-		EncodeTime: func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
-			enc.AppendString(t.Format("2006-01-02 15:04:05"))
-		},
-		CallerKey:    "file",
-		EncodeCaller: zapcore.ShortCallerEncoder,
-		EncodeDuration: func(d time.Duration, enc zapcore.PrimitiveArrayEncoder) {
-			enc.AppendInt64(int64(d) / 1000000)
-		},
-	})
-
-	// 实现两个判断日志等级的interface
-	infoLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl >= zapcore.InfoLevel
-	})
-
-	errorLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl >= zapcore.ErrorLevel
-	})
-
-	// 获取 info、error日志文件的io.Writer 抽象 getWriter() 在下方实现
-	infoWriter := getWriter("./logs/info.log")
-	errorWriter := getWriter("./logs/error.log")
-
-	// 最后创建具体的Logger
-	core := zapcore.NewTee(
-		zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), infoLevel), //打印到控制台
-		zapcore.NewCore(encoder, zapcore.AddSync(infoWriter), infoLevel),
-		zapcore.NewCore(encoder, zapcore.AddSync(errorWriter), errorLevel),
-	)
+	// 默认使用INFO级别的文本格式，输出到控制台及./logs下的日志文件
+	if err := Configure("", nil, ""); err != nil {
+		panic(err)
+	}
+}
+
+//ParseLevel 解析日志级别，支持DEBUG/INFO/WARN/ERROR(不区分大小写)，为空时为INFO
+func ParseLevel(level string) (zapcore.Level, error) {
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		return zapcore.DebugLevel, nil
+	case "", "INFO":
+		return zapcore.InfoLevel, nil
+	case "WARN":
+		return zapcore.WarnLevel, nil
+	case "ERROR":
+		return zapcore.ErrorLevel, nil
+	}
+	return zapcore.InfoLevel, fmt.Errorf("\"%s\" 日志级别不正确，支持DEBUG、INFO、WARN及ERROR", level)
+}
+
+//newEncoder 根据格式创建编码器，支持text及json，为空时为text
+func newEncoder(format string) (zapcore.Encoder, error) {
+	switch strings.ToLower(format) {
+	case "", "text":
+		return zapcore.NewConsoleEncoder(encoderConfig), nil
+	case "json":
+		return zapcore.NewJSONEncoder(encoderConfig), nil
+	}
+	return nil, fmt.Errorf("\"%s\" 日志格式不正确，支持text及json", format)
+}
 
-	log := zap.New(core, zap.AddCaller()) // 需要传入 zap.AddCaller() 才会显示打日志点的文件名和行数, 有点小坑
+//Configure 设置日志级别、格式及输出，替换之前的配置，应在启动时调用
+//output为nil时输出到控制台，以及./logs下的info.log和error.log(只记录ERROR及以上级别)
+func Configure(level string, output io.Writer, format string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	encoder, err := newEncoder(format)
+	if err != nil {
+		return err
+	}
+
+	var core zapcore.Core
+	if output != nil {
+		core = zapcore.NewCore(encoder, zapcore.AddSync(output), lvl)
+	} else {
+		errorLevel := lvl
+		if errorLevel < zapcore.ErrorLevel {
+			errorLevel = zapcore.ErrorLevel
+		}
+		// 获取 info、error日志文件的io.Writer 抽象 getWriter() 在下方实现
+		infoWriter := getWriter("./logs/info.log")
+		errorWriter := getWriter("./logs/error.log")
+		core = zapcore.NewTee(
+			zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), lvl), //打印到控制台
+			zapcore.NewCore(encoder, zapcore.AddSync(infoWriter), lvl),
+			zapcore.NewCore(encoder, zapcore.AddSync(errorWriter), errorLevel),
+		)
+	}
+
+	// 需要传入 zap.AddCaller() 才会显示打日志点的文件名和行数, 跳过本包的封装函数
+	log := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
 	logging = log.Sugar()
+	return nil
+}
+
+//Output 根据配置获取日志输出，stdout及stderr为控制台，其他值为按小时分割的日志文件路径，为空时返回nil
+func Output(path string) (io.Writer, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	return newRotateWriter(path)
+}
+
+//levelLogger 兼容标准库log.Logger的Print系列方法，按固定级别输出到当前配置的日志
+type levelLogger struct {
+	level zapcore.Level
+}
+
+var (
+	//INFO INFO级别的日志
+	INFO = levelLogger{level: zapcore.InfoLevel}
+	//ERROR ERROR级别的日志
+	ERROR = levelLogger{level: zapcore.ErrorLevel}
+)
+
+func (l levelLogger) Print(args ...interface{}) {
+	l.log(fmt.Sprint(args...))
+}
+
+func (l levelLogger) Printf(template string, args ...interface{}) {
+	l.log(fmt.Sprintf(template, args...))
+}
+
+func (l levelLogger) Println(args ...interface{}) {
+	l.log(strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+//log 跳过Print系列方法，显示调用方的文件名和行数
+func (l levelLogger) log(msg string) {
+	if ce := logging.Desugar().WithOptions(zap.AddCallerSkip(1)).Check(l.level, msg); ce != nil {
+		ce.Write()
+	}
 }
 
 func getWriter(filename string) io.Writer {
+	hook, err := newRotateWriter(filename)
+	if err != nil {
+		panic(err)
+	}
+	return hook
+}
+
+func newRotateWriter(filename string) (io.Writer, error) {
 	// 生成rotatelogs的Logger 实际生成的文件名 info.log.YYmmddHH
 	// info.log是指向最新日志的链接
 	// 保存7天内的日志，每1小时(整点)分割一次日志
-	hook, err := rotatelogs.New(
+	return rotatelogs.New(
 		filename+".%Y%m%d%H", // 没有使用go风格反人类的format格式
 		//rotatelogs.WithLinkName(filename),
 		rotatelogs.WithMaxAge(time.Hour*24*7),
 		rotatelogs.WithRotationTime(time.Hour),
 	)
-
-	if err != nil {
-		panic(err)
-	}
-	return hook
 }
+
 func Debug(args ...interface{}) {
 	logging.Debug(args...)
 }
@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestConfigure(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, Configure("warn", &buf, "json"))
+	defer func() {
+		_ = Configure("", nil, "")
+	}()
+
+	Info("启动信息")
+	Warnf("主机 %s 无法连接", "127.0.0.1:1")
+	INFO.Printf("迁移信息")
+	ERROR.Print("迁移失败")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+		assert.Equal(t, "WARN", entry["level"])
+		assert.Equal(t, "主机 127.0.0.1:1 无法连接", entry["msg"])
+		//调用位置为调用方而不是本包的封装函数
+		assert.Contains(t, entry["file"], "logging_test.go")
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+		assert.Equal(t, "ERROR", entry["level"])
+		assert.Contains(t, entry["file"], "logging_test.go")
+	}
+
+	assert.Error(t, Configure("verbose", &buf, "json"))
+	assert.Error(t, Configure("info", &buf, "xml"))
+}
@@ -1,37 +1,134 @@
 package util
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	XRealIP       = http.CanonicalHeaderKey("X-Real-IP")
-	XProxy        = http.CanonicalHeaderKey("X-Proxy")
-	XForwardedFor = http.CanonicalHeaderKey("X-Forwarded-For")
+	XRealIP         = http.CanonicalHeaderKey("X-Real-IP")
+	XProxy          = http.CanonicalHeaderKey("X-Proxy")
+	XForwardedFor   = http.CanonicalHeaderKey("X-Forwarded-For")
+	XForwardedProto = http.CanonicalHeaderKey("X-Forwarded-Proto")
+	XForwardedHost  = http.CanonicalHeaderKey("X-Forwarded-Host")
+	XAuthSubject    = http.CanonicalHeaderKey("X-Auth-Subject")
+	XRequestID      = http.CanonicalHeaderKey("X-Request-ID")
+	XClientID       = http.CanonicalHeaderKey("X-Client-ID")
+)
+
+const (
+	// UnixSocketPrefix is the prefix of unix domain socket hosts, a downstream host unix:///var/run/app.sock is named unix:/var/run/app.sock
+	UnixSocketPrefix = "unix:"
+	// UnixSocketHost is the host of requests sent over unix domain sockets
+	UnixSocketHost = "localhost"
 )
 
 // ConnectionTimeout refers to connection timeout for health check
 var ConnectionTimeout = 3 * time.Second
 
+// trustedProxies holds the CIDRs of proxies whose X-Forwarded-For and X-Real-IP headers are trusted
+var trustedProxies atomic.Value
+
+// SetTrustedProxies sets the CIDRs (or single IPs) of trusted proxies, an empty list trusts no proxy
+func SetTrustedProxies(cidrs []string) error {
+	nets, err := ParseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	trustedProxies.Store(nets)
+	return nil
+}
+
+// IsTrustedProxy reports whether ip belongs to one of the trusted proxies
+func IsTrustedProxy(ip string) bool {
+	nets, _ := trustedProxies.Load().([]*net.IPNet)
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCIDRs parses a list of CIDRs, a single IP is treated as a network containing only that IP
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的IP地址 \"%s\"", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR \"%s\": %s", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// RemoteIP get the IP of the immediate peer
+func RemoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// ForwardedFor get all the addresses in the X-Forwarded-For headers, from the original client to the last proxy
+func ForwardedFor(r *http.Request) []string {
+	var chain []string
+	for _, v := range r.Header.Values(XForwardedFor) {
+		for _, ip := range strings.Split(v, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				chain = append(chain, ip)
+			}
+		}
+	}
+	return chain
+}
+
 // GetIP get client IP
+// X-Forwarded-For and X-Real-IP are only trusted when the immediate peer is a trusted proxy,
+// the X-Forwarded-For chain is walked from right to left skipping trusted proxies, so a client cannot spoof its IP
 func GetIP(r *http.Request) string {
-	clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
-	if len(r.Header.Get(XForwardedFor)) != 0 {
-		xff := r.Header.Get(XForwardedFor)
-		s := strings.Index(xff, ", ")
-		if s == -1 {
-			s = len(r.Header.Get(XForwardedFor))
+	clientIP := RemoteIP(r)
+	if !IsTrustedProxy(clientIP) {
+		return clientIP
+	}
+	if chain := ForwardedFor(r); len(chain) > 0 {
+		for i := len(chain) - 1; i >= 0; i-- {
+			if !IsTrustedProxy(chain[i]) {
+				return chain[i]
+			}
 		}
-		clientIP = xff[:s]
-	} else if len(r.Header.Get(XRealIP)) != 0 {
-		clientIP = r.Header.Get(XRealIP)
+		return chain[0]
+	}
+	if realIP := strings.TrimSpace(r.Header.Get(XRealIP)); realIP != "" {
+		return realIP
 	}
-
 	return clientIP
 }
 
@@ -48,13 +145,72 @@ func GetHost(url *url.URL) string {
 	return url.Host
 }
 
-// IsBackendAlive Attempt to establish a tcp connection to determine whether the site is alive
+// healthCheckClient is used by IsBackendHealthy, redirects are not followed so the status of the first response is checked
+var healthCheckClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// IsBackendHealthy Send an HTTP GET request to target and check whether the response status equals expectStatus
+func IsBackendHealthy(target string, expectStatus int, timeout time.Duration) bool {
+	return checkHealth(healthCheckClient, target, expectStatus, timeout)
+}
+
+// IsUnixSocketHealthy Send an HTTP GET request for path over the unix domain socket and check whether the response status equals expectStatus
+func IsUnixSocketHealthy(socket string, path string, expectStatus int, timeout time.Duration) bool {
+	client := &http.Client{
+		CheckRedirect: healthCheckClient.CheckRedirect,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	return checkHealth(client, "http://"+UnixSocketHost+path, expectStatus, timeout)
+}
+
+func checkHealth(client *http.Client, target string, expectStatus int, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+	return resp.StatusCode == expectStatus
+}
+
+// UnixSocketPath returns the socket path of a unix domain socket host such as unix:/var/run/app.sock
+func UnixSocketPath(host string) (string, bool) {
+	if !strings.HasPrefix(host, UnixSocketPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(host, UnixSocketPrefix), true
+}
+
+// IsBackendAlive Attempt to establish a tcp connection (or a unix domain socket connection for unix:/path hosts) to determine whether the site is alive
 func IsBackendAlive(host string) bool {
+	if socket, ok := UnixSocketPath(host); ok {
+		conn, err := net.DialTimeout("unix", socket, ConnectionTimeout)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}
 	addr, err := net.ResolveTCPAddr("tcp", host)
 	if err != nil {
 		return false
 	}
-	resolveAddr := fmt.Sprintf("%s:%d", addr.IP, addr.Port)
+	resolveAddr := net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port))
 	conn, err := net.DialTimeout("tcp", resolveAddr, ConnectionTimeout)
 	if err != nil {
 		return false
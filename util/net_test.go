@@ -0,0 +1,48 @@
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetIP(t *testing.T) {
+	assert.NoError(t, SetTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}))
+	defer func() {
+		_ = SetTrustedProxies(nil)
+	}()
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		expected   string
+	}{
+		{"direct client", "203.0.113.5:1234", nil, "", "203.0.113.5"},
+		{"direct client spoofing X-Forwarded-For", "203.0.113.5:1234", []string{"1.2.3.4"}, "", "203.0.113.5"},
+		{"direct client spoofing X-Real-IP", "203.0.113.5:1234", nil, "1.2.3.4", "203.0.113.5"},
+		{"one trusted proxy", "10.0.0.1:1234", []string{"203.0.113.5"}, "", "203.0.113.5"},
+		{"multi-hop chain", "10.0.0.1:1234", []string{"203.0.113.5, 192.168.1.1", "10.0.0.2"}, "", "203.0.113.5"},
+		{"spoofed chain through trusted proxy", "10.0.0.1:1234", []string{"1.2.3.4, 203.0.113.5"}, "", "203.0.113.5"},
+		{"trusted proxy with X-Real-IP", "10.0.0.1:1234", nil, "203.0.113.5", "203.0.113.5"},
+		{"trusted proxy without headers", "10.0.0.1:1234", nil, "", "10.0.0.1"},
+		{"all trusted", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remoteAddr
+		for _, v := range c.xff {
+			r.Header.Add(XForwardedFor, v)
+		}
+		if c.realIP != "" {
+			r.Header.Set(XRealIP, c.realIP)
+		}
+		assert.Equal(t, c.expected, GetIP(r), c.name)
+	}
+}
+
+func TestSetTrustedProxies_Invalid(t *testing.T) {
+	assert.Error(t, SetTrustedProxies([]string{"not-an-ip"}))
+	assert.Error(t, SetTrustedProxies([]string{"10.0.0.0/40"}))
+}
@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// Options 链路追踪配置
+type Options struct {
+	// Endpoint OTLP/HTTP导出器的地址，例如"localhost:4318"
+	Endpoint string
+	// ServiceName 上报的服务名称
+	ServiceName string
+	// Insecure 是否使用HTTP(不使用TLS)连接导出器
+	Insecure bool
+}
+
+// Setup 创建OTLP导出器并设置全局的TracerProvider及W3C traceparent传播器
+// 返回的函数在服务关闭时调用，将缓存的span全部导出
+// 未调用Setup时全局的TracerProvider为空实现，创建span及注入请求头都不会产生任何开销
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	exporterOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(opts.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}